@@ -0,0 +1,310 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// AccessListOptions enable the EIP-2930 access lists of the transactions sent by BoundContract.
+type AccessListOptions struct {
+	// RPCClient is the client of the node creating the access lists.
+	RPCClient *rpc.Client
+	// DisableTrace disables deriving the access list from debug_traceCall on the nodes
+	// without eth_createAccessList.
+	DisableTrace bool
+}
+
+// AccessListResult is the access list of a call, with the gas the call is estimated to
+// need with and without it.
+type AccessListResult struct {
+	AccessList types.AccessList
+	Gas        uint64
+	GasWithout uint64
+	// Traced is set if the list is derived from the prestate trace.
+	Traced bool
+}
+
+// Saves reports whether the access list lowers the gas of the call.
+func (r *AccessListResult) Saves() bool {
+	return len(r.AccessList) > 0 && r.Gas < r.GasWithout
+}
+
+// CreateAccessList creates the access list of the call on the pending state with
+// eth_createAccessList. Unless disabled, the nodes lacking the method derive the list from
+// the prestate tracer of debug_traceCall. The addresses warm anyway, the sender, the recipient,
+// the coinbase and the precompiles, are left out.
+func CreateAccessList(ctx context.Context, opts AccessListOptions, msg ethereum.CallMsg) (*AccessListResult, error) {
+	if opts.RPCClient == nil {
+		err := errors.New("no RPC client to create the access list with")
+		return nil, err
+	}
+	client := ethclient.NewClient(opts.RPCClient)
+	msg.Gas = 0
+	msg.AccessList = nil
+	res := new(AccessListResult)
+	var created struct {
+		AccessList types.AccessList `json:"accessList"`
+		Error      string           `json:"error"`
+	}
+	err := opts.RPCClient.CallContext(ctx, &created, "eth_createAccessList", toCallArg(msg), "pending")
+	switch {
+	case err == nil && len(created.Error) > 0:
+		err = fmt.Errorf("failed to create access list: %s", created.Error)
+		return nil, err
+	case err == nil:
+		res.AccessList = created.AccessList
+		sortAccessList(res.AccessList)
+	case isMethodNotFound(err) && !opts.DisableTrace:
+		if res.AccessList, err = traceAccessList(ctx, opts.RPCClient, client, msg); err != nil {
+			err = fmt.Errorf("failed to trace access list: %v", err)
+			return nil, err
+		}
+		res.Traced = true
+	default:
+		err = fmt.Errorf("failed to create access list: %v", err)
+		return nil, err
+	}
+	if res.GasWithout, err = client.EstimateGas(ctx, msg); err != nil {
+		err = fmt.Errorf("failed to estimate gas needed: %v", err)
+		return nil, err
+	}
+	if len(res.AccessList) == 0 {
+		res.Gas = res.GasWithout
+		return res, nil
+	}
+	msg.AccessList = res.AccessList
+	if res.Gas, err = client.EstimateGas(ctx, msg); err != nil {
+		err = fmt.Errorf("failed to estimate gas needed with access list: %v", err)
+		return nil, err
+	}
+	return res, nil
+}
+
+// prestateAccount is an account of the prestate tracer result.
+type prestateAccount struct {
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// traceAccessList derives the access list from the accounts and the storage slots the call
+// touches, according to the prestate tracer.
+func traceAccessList(ctx context.Context, rpcClient *rpc.Client,
+	client *ethclient.Client, msg ethereum.CallMsg) (types.AccessList, error) {
+
+	var prestate map[common.Address]*prestateAccount
+	config := map[string]interface{}{
+		"tracer": "prestateTracer",
+	}
+	if err := rpcClient.CallContext(ctx, &prestate, "debug_traceCall", toCallArg(msg), "pending", config); err != nil {
+		return nil, err
+	}
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	warm := map[common.Address]bool{
+		msg.From:      true,
+		head.Coinbase: true,
+	}
+	for _, addr := range vm.PrecompiledAddressesCancun {
+		warm[addr] = true
+	}
+	if msg.To != nil {
+		warm[*msg.To] = true
+	} else {
+		nonce, err := client.PendingNonceAt(ctx, msg.From)
+		if err != nil {
+			return nil, err
+		}
+		warm[crypto.CreateAddress(msg.From, nonce)] = true
+	}
+	list := types.AccessList{}
+	for addr, account := range prestate {
+		if warm[addr] {
+			continue
+		}
+		tuple := types.AccessTuple{
+			Address:     addr,
+			StorageKeys: []common.Hash{},
+		}
+		if account != nil {
+			for key := range account.Storage {
+				tuple.StorageKeys = append(tuple.StorageKeys, key)
+			}
+		}
+		list = append(list, tuple)
+	}
+	sortAccessList(list)
+	return list, nil
+}
+
+// sortAccessList orders the addresses and the storage keys of the list, which the nodes
+// return in random order.
+func sortAccessList(list types.AccessList) {
+	for _, tuple := range list {
+		keys := tuple.StorageKeys
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i][:], keys[j][:]) < 0
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].Address[:], list[j].Address[:]) < 0
+	})
+}
+
+// toCallArg encodes the call like ethclient does.
+func toCallArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["input"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.GasPrice != nil {
+		arg["gasPrice"] = (*hexutil.Big)(msg.GasPrice)
+	}
+	if msg.GasFeeCap != nil {
+		arg["maxFeePerGas"] = (*hexutil.Big)(msg.GasFeeCap)
+	}
+	if msg.GasTipCap != nil {
+		arg["maxPriorityFeePerGas"] = (*hexutil.Big)(msg.GasTipCap)
+	}
+	if msg.AccessList != nil {
+		arg["accessList"] = msg.AccessList
+	}
+	return arg
+}
+
+// isMethodNotFound reports whether the node doesn't serve the method.
+func isMethodNotFound(err error) bool {
+	rpcErr, ok := err.(rpc.Error)
+	return ok && rpcErr.ErrorCode() == -32601
+}
+
+type accessListKey struct{}
+
+// WithAccessList returns the context carrying the access list of the transaction. BoundContract
+// passes the access lists to the TransactFunc set with SetTransact this way, in opts.Context,
+// leaving the gas limit of opts to the func, which may ignore the list.
+func WithAccessList(ctx context.Context, list types.AccessList) context.Context {
+	return context.WithValue(ctx, accessListKey{}, list)
+}
+
+// AccessListFromContext returns the access list the transaction should carry, nil if none.
+func AccessListFromContext(ctx context.Context) types.AccessList {
+	if ctx == nil {
+		return nil
+	}
+	list, _ := ctx.Value(accessListKey{}).(types.AccessList)
+	return list
+}
+
+// SetAccessLists makes the contract create the access lists of its transactions, which are
+// attached if they save gas. Nil opts disable them.
+func (c *BoundContract) SetAccessLists(opts *AccessListOptions) {
+	c.accessLists = opts
+}
+
+// withAccessList returns the copy of opts carrying the access list of the call in its context,
+// or opts as is if the list doesn't save gas, along with the list created.
+func (c *BoundContract) withAccessList(opts *bind.TransactOpts,
+	contract *common.Address, input []byte) (*bind.TransactOpts, *AccessListResult, error) {
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	msg := ethereum.CallMsg{
+		From:      opts.From,
+		To:        contract,
+		Value:     opts.Value,
+		Data:      input,
+		GasPrice:  opts.GasPrice,
+		GasFeeCap: opts.GasFeeCap,
+		GasTipCap: opts.GasTipCap,
+	}
+	res, err := CreateAccessList(ctx, *c.accessLists, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !res.Saves() {
+		return opts, res, nil
+	}
+	listOpts := *opts
+	listOpts.Context = WithAccessList(ctx, res.AccessList)
+	return &listOpts, res, nil
+}
+
+// transactAccessList sends the transaction with the access list of opts.Context, like
+// bind.BoundContract does without one.
+func (c *BoundContract) transactAccessList(opts *bind.TransactOpts,
+	contract *common.Address, input []byte) (*types.Transaction, error) {
+
+	client, ok := c.client.(TxBackend)
+	if !ok {
+		err := errors.New("backend does not support access list transactions")
+		return nil, err
+	}
+	b := NewTxBuilder(opts.From).
+		Value(opts.Value).
+		Data(input).
+		Gas(opts.GasLimit).
+		AccessList(AccessListFromContext(opts.Context))
+	if contract != nil {
+		b.To(*contract)
+	}
+	if opts.Nonce != nil {
+		b.Nonce(opts.Nonce.Uint64())
+	}
+	switch {
+	case opts.GasPrice != nil:
+		b.GasPrice(opts.GasPrice)
+	case opts.GasFeeCap != nil || opts.GasTipCap != nil:
+		b.FeeCaps(opts.GasTipCap, opts.GasFeeCap)
+	}
+	if err := b.Fill(opts.Context, client); err != nil {
+		return nil, err
+	}
+	tx, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Signer == nil {
+		err = errors.New("no signer to authorize the transaction with")
+		return nil, err
+	}
+	signed, err := opts.Signer(opts.From, tx)
+	if err != nil {
+		return nil, err
+	}
+	if opts.NoSend {
+		return signed, nil
+	}
+	if err := client.SendTransaction(opts.Context, signed); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
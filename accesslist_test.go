@@ -0,0 +1,194 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+func TestAccessList(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	opts, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(err)
+	storage := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	reader := common.HexToAddress("0x2000000000000000000000000000000000000001")
+	// the node serves eth_createAccessList over IPC, the simulated client doesn't expose it
+	ipcPath := filepath.Join(t.TempDir(), "sim.ipc")
+	sim := simulated.NewBackend(types.GenesisAlloc{
+		opts.From: {Balance: big.NewInt(1e18)},
+		// sums the slots 0, 1 and 2
+		storage: {
+			Code: common.FromHex("6000546001540160025401600052602060" + "00f3"),
+			Storage: map[common.Hash]common.Hash{
+				common.BigToHash(big.NewInt(1)): common.BigToHash(big.NewInt(1)),
+			},
+		},
+		// static calls the storage
+		reader: {
+			Code: common.FromHex("6020600060006000" + "73" + storage.Hex()[2:] + "5afa5000"),
+		},
+	}, simulated.WithBlockGasLimit(8000000), func(nodeConf *node.Config, ethConf *ethconfig.Config) {
+		nodeConf.IPCPath = ipcPath
+	})
+	defer sim.Close()
+	client := sim.Client()
+	ctx := context.Background()
+	rpcClient, err := rpc.Dial(ipcPath)
+	require.NoError(err)
+	defer rpcClient.Close()
+	listOpts := &AccessListOptions{RPCClient: rpcClient}
+
+	res, err := CreateAccessList(ctx, *listOpts, ethereum.CallMsg{From: opts.From, To: &reader})
+	require.NoError(err)
+	require.False(res.Traced)
+	require.Equal(types.AccessList{{
+		Address: storage,
+		StorageKeys: []common.Hash{
+			common.BigToHash(big.NewInt(0)),
+			common.BigToHash(big.NewInt(1)),
+			common.BigToHash(big.NewInt(2)),
+		},
+	}}, res.AccessList)
+	require.True(res.Saves())
+	require.Less(res.Gas, res.GasWithout)
+
+	bound, err := BindContract(client, &sol.Contract{Name: "Reader", ABI: []byte("[]"), Address: reader})
+	require.NoError(err)
+	bound.SetAccessLists(listOpts)
+	tx, err := bound.Transfer(opts)
+	require.NoError(err)
+	require.Equal(uint8(types.DynamicFeeTxType), tx.Type())
+	require.Equal(res.AccessList, tx.AccessList())
+	require.Equal(res.Gas, tx.Gas())
+	sim.Commit()
+	receipt, err := bind.WaitMined(ctx, client, tx)
+	require.NoError(err)
+	require.Equal(types.ReceiptStatusSuccessful, receipt.Status)
+
+	// the custom transact func gets the list in the context, the gas limit is left to it
+	var list types.AccessList
+	var gas uint64
+	bound.SetTransact(func(opts *bind.TransactOpts, contract *common.Address, input []byte) (*types.Transaction, error) {
+		list, gas = AccessListFromContext(opts.Context), opts.GasLimit
+		return nil, nil
+	})
+	_, err = bound.Transfer(opts)
+	require.NoError(err)
+	require.Equal(res.AccessList, list)
+	require.Zero(gas)
+
+	// the func ignoring the list gets the gas estimated without it
+	bound.SetTransact(func(opts *bind.TransactOpts, contract *common.Address, input []byte) (*types.Transaction, error) {
+		return bound.BoundContract.RawTransact(opts, input)
+	})
+	tx, err = bound.Transfer(opts)
+	require.NoError(err)
+	require.Empty(tx.AccessList())
+	require.Equal(res.GasWithout, tx.Gas())
+	sim.Commit()
+	receipt, err = bind.WaitMined(ctx, client, tx)
+	require.NoError(err)
+	require.Equal(types.ReceiptStatusSuccessful, receipt.Status)
+
+	bound.SetTransact(func(opts *bind.TransactOpts, contract *common.Address, input []byte) (*types.Transaction, error) {
+		list, gas = AccessListFromContext(opts.Context), opts.GasLimit
+		return nil, nil
+	})
+
+	// the list without the savings is not attached
+	bound.SetAddress(common.HexToAddress("0x1000000000000000000000000000000000000001"))
+	_, err = bound.Transfer(opts)
+	require.NoError(err)
+	require.Nil(list)
+	require.Zero(gas)
+}
+
+func TestTraceAccessList(t *testing.T) {
+	require := require.New(t)
+	from := common.HexToAddress("0x01000000000000000000000000000000000000f0")
+	to := common.HexToAddress("0x01000000000000000000000000000000000000f1")
+	coinbase := common.HexToAddress("0x01000000000000000000000000000000000000f2")
+	token := common.HexToAddress("0x01000000000000000000000000000000000000a2")
+	other := common.HexToAddress("0x01000000000000000000000000000000000000a1")
+
+	node := rpctest.NewMockNode()
+	node.Handle("debug_traceCall", func(req rpctest.Request) (interface{}, error) {
+		var config struct {
+			Tracer string `json:"tracer"`
+		}
+		if err := json.Unmarshal(req.Params[2], &config); err != nil {
+			return nil, err
+		}
+		require.Equal("prestateTracer", config.Tracer)
+		return map[common.Address]interface{}{
+			from:                        map[string]interface{}{"balance": "0x1"},
+			to:                          map[string]interface{}{"storage": map[common.Hash]common.Hash{{}: {}}},
+			coinbase:                    map[string]interface{}{"balance": "0x0"},
+			common.HexToAddress("0x01"): map[string]interface{}{"balance": "0x0"},
+			token: map[string]interface{}{"storage": map[common.Hash]common.Hash{
+				common.HexToHash("0x05"): {},
+				common.HexToHash("0x03"): {},
+			}},
+			other: map[string]interface{}{"balance": "0x0"},
+		}, nil
+	})
+	node.Handle("eth_getBlockByNumber", func(req rpctest.Request) (interface{}, error) {
+		return &types.Header{
+			Number:     big.NewInt(1),
+			Difficulty: new(big.Int),
+			Coinbase:   coinbase,
+		}, nil
+	})
+	node.Handle("eth_estimateGas", func(req rpctest.Request) (interface{}, error) {
+		var call map[string]json.RawMessage
+		if err := json.Unmarshal(req.Params[0], &call); err != nil {
+			return nil, err
+		}
+		if _, ok := call["accessList"]; ok {
+			return hexutil.Uint64(50000), nil
+		}
+		return hexutil.Uint64(50300), nil
+	})
+	rpcClient, closeFn := dialRPC(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	msg := ethereum.CallMsg{From: from, To: &to, Data: []byte{1}}
+
+	res, err := CreateAccessList(ctx, AccessListOptions{RPCClient: rpcClient}, msg)
+	require.NoError(err)
+	require.True(res.Traced)
+	require.Equal(types.AccessList{
+		{Address: other, StorageKeys: []common.Hash{}},
+		{Address: token, StorageKeys: []common.Hash{common.HexToHash("0x03"), common.HexToHash("0x05")}},
+	}, res.AccessList)
+	require.Equal(uint64(50000), res.Gas)
+	require.Equal(uint64(50300), res.GasWithout)
+	require.True(res.Saves())
+	require.Len(node.Requests("eth_createAccessList"), 1)
+
+	_, err = CreateAccessList(ctx, AccessListOptions{RPCClient: rpcClient, DisableTrace: true}, msg)
+	require.EqualError(err, "failed to create access list: the method eth_createAccessList does not exist/is not available")
+}
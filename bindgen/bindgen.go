@@ -0,0 +1,321 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package bindgen generates typed Go wrappers of contracts, built on top of ethfw.BoundContract.
+package bindgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// Generate returns the Go source of the package pkg with the wrappers of the contracts.
+// Each wrapper embeds *ethfw.BoundContract, so its setters keep working. Contract methods
+// named like the methods of ethfw.BoundContract get a "0" suffix.
+func Generate(pkg string, contracts []*sol.Contract) ([]byte, error) {
+	if len(contracts) == 0 {
+		err := errors.New("bindgen: no contracts to generate")
+		return nil, err
+	}
+	data := &tmplData{
+		Package: pkg,
+	}
+	for _, c := range contracts {
+		tc, err := newTmplContract(c)
+		if err != nil {
+			return nil, err
+		}
+		data.Contracts = append(data.Contracts, tc)
+		if len(tc.Events) > 0 {
+			data.HasEvents = true
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := bindTemplate.Execute(buf, data); err != nil {
+		err = fmt.Errorf("bindgen: failed to execute template: %v", err)
+		return nil, err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		err = fmt.Errorf("bindgen: failed to format generated code: %v\n%s", err, buf.Bytes())
+		return nil, err
+	}
+	return code, nil
+}
+
+type tmplData struct {
+	Package   string
+	Contracts []*tmplContract
+	HasEvents bool
+}
+
+type tmplContract struct {
+	Type        string
+	Source      *sol.Contract
+	ABI         string
+	Constructor *tmplMethod
+	Calls       []*tmplMethod
+	Transacts   []*tmplMethod
+	Events      []*tmplEvent
+}
+
+type tmplMethod struct {
+	Name       string
+	Original   abi.Method
+	Inputs     []tmplArg
+	Outputs    []tmplArg
+	Structured bool
+}
+
+type tmplEvent struct {
+	Name     string
+	Original abi.Event
+	Fields   []tmplArg
+	Indexed  []tmplArg
+}
+
+type tmplArg struct {
+	Name string
+	ABI  string
+	Type string
+}
+
+// reservedNames are the methods promoted from ethfw.BoundContract into the wrappers.
+var reservedNames = func() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(&ethfw.BoundContract{})
+	for i := 0; i < t.NumMethod(); i++ {
+		names[t.Method(i).Name] = true
+	}
+	return names
+}()
+
+func newTmplContract(c *sol.Contract) (*tmplContract, error) {
+	parsed, err := abi.JSON(bytes.NewReader(c.ABI))
+	if err != nil {
+		err = fmt.Errorf("bindgen: failed to parse ABI of %s: %v", c.Name, err)
+		return nil, err
+	}
+	tc := &tmplContract{
+		Type:   capitalise(c.Name),
+		Source: c,
+		ABI:    string(c.ABI),
+	}
+	if len(c.Bin) > 0 {
+		tc.Constructor = &tmplMethod{
+			Original: parsed.Constructor,
+			Inputs:   bindArgs(parsed.Constructor.Inputs, "arg"),
+		}
+	}
+	for _, name := range sortedKeys(parsed.Methods) {
+		m := parsed.Methods[name]
+		tm := &tmplMethod{
+			Name:     methodName(m.Name),
+			Original: m,
+			Inputs:   bindArgs(m.Inputs, "arg"),
+			Outputs:  bindArgs(m.Outputs, "ret"),
+		}
+		if m.IsConstant() {
+			tm.Structured = len(m.Outputs) > 1
+			for i, out := range m.Outputs {
+				if len(out.Name) == 0 {
+					tm.Structured = false
+					break
+				}
+				tm.Outputs[i].Name = abi.ToCamelCase(out.Name)
+			}
+			tc.Calls = append(tc.Calls, tm)
+			continue
+		}
+		tc.Transacts = append(tc.Transacts, tm)
+	}
+	for _, name := range sortedEventKeys(parsed.Events) {
+		e := parsed.Events[name]
+		te := &tmplEvent{
+			Name:     abi.ToCamelCase(e.Name),
+			Original: e,
+		}
+		for i, in := range e.Inputs {
+			field := tmplArg{
+				Name: abi.ToCamelCase(in.Name),
+				ABI:  in.Name,
+				Type: bindType(in.Type),
+			}
+			if len(in.Name) == 0 {
+				field.Name = fmt.Sprintf("Arg%d", i)
+			}
+			if in.Indexed {
+				if isHashedTopic(in.Type) {
+					// dynamic indexed values are stored as their hashes
+					field.Type = "common.Hash"
+				}
+				topic := tmplArg{
+					Name: paramName(in.Name, "arg", i),
+					ABI:  in.Name,
+					Type: field.Type,
+				}
+				if in.Type.T == abi.StringTy || in.Type.T == abi.BytesTy {
+					// these are hashed when the filter topics are made
+					topic.Type = bindType(in.Type)
+				}
+				te.Indexed = append(te.Indexed, topic)
+			}
+			te.Fields = append(te.Fields, field)
+		}
+		tc.Events = append(tc.Events, te)
+	}
+	return tc, nil
+}
+
+func methodName(name string) string {
+	name = abi.ToCamelCase(name)
+	if reservedNames[name] {
+		return name + "0"
+	}
+	return name
+}
+
+func bindArgs(args abi.Arguments, prefix string) []tmplArg {
+	bound := make([]tmplArg, 0, len(args))
+	for i, arg := range args {
+		bound = append(bound, tmplArg{
+			Name: paramName(arg.Name, prefix, i),
+			ABI:  arg.Name,
+			Type: bindType(arg.Type),
+		})
+	}
+	return bound
+}
+
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true,
+	"defer": true, "else": true, "fallthrough": true, "for": true, "func": true, "go": true,
+	"goto": true, "if": true, "import": true, "interface": true, "map": true, "package": true,
+	"range": true, "return": true, "select": true, "struct": true, "switch": true, "type": true,
+	"var": true,
+}
+
+// generatedNames are the packages and local variables used by the generated code.
+var generatedNames = map[string]bool{
+	"big": true, "strings": true, "bind": true, "common": true, "types": true,
+	"event": true, "ethfw": true, "sol": true, "opts": true, "client": true, "sink": true,
+	"out": true, "ret": true, "err": true, "logs": true, "log": true, "sub": true, "item": true,
+	"ev": true, "events": true, "collected": true, "bound": true, "address": true, "tx": true,
+	"src": true, "quit": true,
+}
+
+// paramName returns a Go identifier for the argument, which doesn't clash with
+// the keywords and the identifiers used by the generated code.
+func paramName(name, prefix string, i int) string {
+	name = strings.TrimLeft(name, "_")
+	if len(name) == 0 {
+		return fmt.Sprintf("%s%d", prefix, i)
+	}
+	name = decapitalise(abi.ToCamelCase(name))
+	if goKeywords[name] || generatedNames[name] {
+		return name + "_"
+	}
+	return name
+}
+
+func isHashedTopic(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	}
+	return false
+}
+
+// bindType returns the Go type of the ABI type, as it's packed and unpacked by go-ethereum.
+func bindType(t abi.Type) string {
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		prefix := "int"
+		if t.T == abi.UintTy {
+			prefix = "uint"
+		}
+		switch t.Size {
+		case 8, 16, 32, 64:
+			return fmt.Sprintf("%s%d", prefix, t.Size)
+		}
+		return "*big.Int"
+	case abi.BoolTy:
+		return "bool"
+	case abi.StringTy:
+		return "string"
+	case abi.AddressTy:
+		return "common.Address"
+	case abi.BytesTy:
+		return "[]byte"
+	case abi.FixedBytesTy:
+		return fmt.Sprintf("[%d]byte", t.Size)
+	case abi.HashTy:
+		return "common.Hash"
+	case abi.FunctionTy:
+		return "[24]byte"
+	case abi.SliceTy:
+		return "[]" + bindType(*t.Elem)
+	case abi.ArrayTy:
+		return fmt.Sprintf("[%d]%s", t.Size, bindType(*t.Elem))
+	case abi.TupleTy:
+		fields := make([]string, 0, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			fields = append(fields, fmt.Sprintf("%s %s", abi.ToCamelCase(t.TupleRawNames[i]), bindType(*elem)))
+		}
+		return "struct{ " + strings.Join(fields, "; ") + " }"
+	default:
+		// fixed point types are not supported by go-ethereum
+		return "[]byte"
+	}
+}
+
+func capitalise(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func decapitalise(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func sortedKeys(methods map[string]abi.Method) []string {
+	keys := make([]string, 0, len(methods))
+	for k := range methods {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedEventKeys(events map[string]abi.Event) []string {
+	keys := make([]string, 0, len(events))
+	for k := range events {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var bindTemplate = template.Must(template.New("bind").Funcs(template.FuncMap{
+	"quote": func(s string) string {
+		return fmt.Sprintf("%q", s)
+	},
+	"printf": fmt.Sprintf,
+}).Parse(bindTemplateSource))
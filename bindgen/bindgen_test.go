@@ -0,0 +1,90 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package bindgen
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+func testContract() *sol.Contract {
+	abiJSON, err := ioutil.ReadFile("testdata/token.abi")
+	orPanic(err)
+	bin, err := ioutil.ReadFile("testdata/token.bin")
+	orPanic(err)
+	return &sol.Contract{
+		Name: "Token",
+		ABI:  abiJSON,
+		Bin:  strings.TrimSpace(string(bin)),
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	require := require.New(t)
+
+	code, err := Generate("token", []*sol.Contract{testContract()})
+	require.NoError(err)
+	f, err := parser.ParseFile(token.NewFileSet(), "token.go", code, 0)
+	require.NoError(err)
+	require.Equal("token", f.Name.Name)
+
+	src := string(code)
+	require.Contains(src, "func NewToken(client ethfw.Backend, address common.Address) (*Token, error)")
+	require.Contains(src, "func DeployToken(opts *bind.TransactOpts, client ethfw.Backend, name string, supply *big.Int)")
+	require.Contains(src, "func (_Token *Token) BalanceOf(opts *bind.CallOpts, owner common.Address) (*big.Int, error)")
+	require.Contains(src, "func (_Token *Token) Pair(opts *bind.CallOpts, id [32]byte) (common.Address, []int64, error)")
+	require.Contains(src, "func (_Token *Token) Transfer0(opts *bind.TransactOpts, to common.Address, value *big.Int) (*types.Transaction, error)")
+	require.Contains(src, "func (_Token *Token) FilterTransfer(opts *bind.FilterOpts, from []common.Address, to []common.Address) ([]*TokenTransfer, error)")
+	require.Contains(src, "func (_Token *Token) WatchNamed(opts *bind.WatchOpts, sink chan<- *TokenNamed, label []string, ids []common.Hash)")
+
+	// methods of ethfw.BoundContract are not shadowed
+	require.Contains(src, "func (_Token *Token) Address0(opts *bind.CallOpts) (common.Address, error)")
+	require.Contains(src, "func (_Token *Token) SetTransact0(opts *bind.TransactOpts, type_ uint8)")
+	require.NotContains(src, "func (_Token *Token) Address(")
+	require.NotContains(src, "func (_Token *Token) SetTransact(")
+
+	// the bindings take any backend, the simulated and failover ones included
+	require.NotContains(src, "go-ethereum/ethclient")
+}
+
+func TestGenerateNoContracts(t *testing.T) {
+	_, err := Generate("token", nil)
+	require.Error(t, err)
+}
+
+func TestGenerateBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated package")
+	}
+	require := require.New(t)
+
+	code, err := Generate("token", []*sol.Contract{testContract()})
+	require.NoError(err)
+	// the package must be within the module to resolve its imports
+	dir, err := ioutil.TempDir(".", "gentest")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "token.go"), code, 0644))
+
+	cmd := exec.Command("go", "vet", "./"+filepath.Base(dir))
+	out, err := cmd.CombinedOutput()
+	require.NoError(err, string(out))
+}
+
+func orPanic(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
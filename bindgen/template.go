@@ -0,0 +1,215 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package bindgen
+
+const bindTemplateSource = `// Code generated by ethfw-bindgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	{{- if .HasEvents}}
+	"github.com/ethereum/go-ethereum/event"
+	{{- end}}
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = bind.NewKeyedTransactor
+	_ = common.Big1
+	_ = types.BloomLookup
+)
+{{range $c := .Contracts}}
+// {{$c.Type}}ABI is the input ABI used to generate the binding from.
+const {{$c.Type}}ABI = {{quote $c.ABI}}
+
+// {{$c.Type}}Bin is the compiled bytecode used for deploying new contracts.
+const {{$c.Type}}Bin = {{quote $c.Source.Bin}}
+
+// {{$c.Type}}Source returns the contract the binding has been generated from.
+func {{$c.Type}}Source() *sol.Contract {
+	return &sol.Contract{
+		Name:            {{quote $c.Source.Name}},
+		SourcePath:      {{quote $c.Source.SourcePath}},
+		CompilerVersion: {{quote $c.Source.CompilerVersion}},
+		ABI:             []byte({{$c.Type}}ABI),
+		Bin:             {{$c.Type}}Bin,
+		RuntimeBin:      {{quote $c.Source.RuntimeBin}},
+	}
+}
+
+// {{$c.Type}} is a typed wrapper of the {{$c.Source.Name}} contract, it works with
+// the transact function, client and address set on the embedded BoundContract.
+type {{$c.Type}} struct {
+	*ethfw.BoundContract
+}
+
+// New{{$c.Type}} binds the {{$c.Source.Name}} contract deployed at the address.
+func New{{$c.Type}}(client ethfw.Backend, address common.Address) (*{{$c.Type}}, error) {
+	src := {{$c.Type}}Source()
+	src.Address = address
+	bound, err := ethfw.BindContract(client, src)
+	if err != nil {
+		return nil, err
+	}
+	return &{{$c.Type}}{BoundContract: bound}, nil
+}
+{{- if $c.Constructor}}
+
+// Deploy{{$c.Type}} deploys a new {{$c.Source.Name}} contract and binds it.
+func Deploy{{$c.Type}}(opts *bind.TransactOpts, client ethfw.Backend{{range $c.Constructor.Inputs}}, {{.Name}} {{.Type}}{{end}}) (common.Address, *types.Transaction, *{{$c.Type}}, error) {
+	bound, err := ethfw.BindContract(client, {{$c.Type}}Source())
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	address, tx, err := bound.DeployContract(opts{{range $c.Constructor.Inputs}}, {{.Name}}{{end}})
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &{{$c.Type}}{BoundContract: bound}, nil
+}
+{{- end}}
+{{- range $m := $c.Calls}}
+
+// {{$m.Name}} is a free data retrieval call binding the contract method {{printf "%#x" $m.Original.ID}}.
+//
+// Solidity: {{$m.Original.String}}
+func (_{{$c.Type}} *{{$c.Type}}) {{$m.Name}}(opts *bind.CallOpts{{range $m.Inputs}}, {{.Name}} {{.Type}}{{end}}) (
+	{{- if $m.Structured}}struct {
+	{{- range $m.Outputs}}
+	{{.Name}} {{.Type}}
+	{{- end}}
+}, {{else}}{{range $m.Outputs}}{{.Type}}, {{end}}{{end}}error) {
+	{{- if $m.Structured}}
+	ret := new(struct {
+	{{- range $m.Outputs}}
+		{{.Name}} {{.Type}}
+	{{- end}}
+	})
+	out := ret
+	{{- else if eq (len $m.Outputs) 0}}
+	var out interface{}
+	{{- else}}
+	var (
+	{{- range $i, $o := $m.Outputs}}
+		ret{{$i}} = new({{$o.Type}})
+	{{- end}}
+	)
+	{{- if eq (len $m.Outputs) 1}}
+	out := ret0
+	{{- else}}
+	out := &[]interface{}{
+	{{- range $i, $o := $m.Outputs}}
+		ret{{$i}},
+	{{- end}}
+	}
+	{{- end}}
+	{{- end}}
+	err := _{{$c.Type}}.BoundContract.CallIntoOpts(opts, {{quote $m.Original.Name}}, out{{range $m.Inputs}}, {{.Name}}{{end}})
+	return {{if $m.Structured}}*ret, {{else}}{{range $i, $o := $m.Outputs}}*ret{{$i}}, {{end}}{{end}}err
+}
+{{- end}}
+{{- range $m := $c.Transacts}}
+
+// {{$m.Name}} is a paid mutator transaction binding the contract method {{printf "%#x" $m.Original.ID}}.
+//
+// Solidity: {{$m.Original.String}}
+func (_{{$c.Type}} *{{$c.Type}}) {{$m.Name}}(opts *bind.TransactOpts{{range $m.Inputs}}, {{.Name}} {{.Type}}{{end}}) (*types.Transaction, error) {
+	return _{{$c.Type}}.BoundContract.Transact(opts, {{quote $m.Original.Name}}{{range $m.Inputs}}, {{.Name}}{{end}})
+}
+{{- end}}
+{{- range $e := $c.Events}}
+
+// {{$c.Type}}{{$e.Name}} represents a {{$e.Original.Name}} event raised by the {{$c.Source.Name}} contract.
+type {{$c.Type}}{{$e.Name}} struct {
+	{{- range $e.Fields}}
+	{{.Name}} {{.Type}}
+	{{- end}}
+	Raw types.Log // Blockchain specific contextual infos
+}
+
+// Filter{{$e.Name}} returns the {{$e.Original.Name}} events matching the filter,
+// nil indexed values match any.
+//
+// Solidity: {{$e.Original.String}}
+func (_{{$c.Type}} *{{$c.Type}}) Filter{{$e.Name}}(opts *bind.FilterOpts{{range $e.Indexed}}, {{.Name}} []{{.Type}}{{end}}) ([]*{{$c.Type}}{{$e.Name}}, error) {
+	{{- range $e.Indexed}}
+	var {{.Name}}Rule []interface{}
+	for _, item := range {{.Name}} {
+		{{.Name}}Rule = append({{.Name}}Rule, item)
+	}
+	{{- end}}
+	logs, sub, err := _{{$c.Type}}.BoundContract.FilterLogs(opts, {{quote $e.Original.Name}}{{range $e.Indexed}}, {{.Name}}Rule{{end}})
+	if err != nil {
+		return nil, err
+	}
+	collected, err := ethfw.CollectLogs(logs, sub)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*{{$c.Type}}{{$e.Name}}, 0, len(collected))
+	for _, log := range collected {
+		ev := new({{$c.Type}}{{$e.Name}})
+		if err := _{{$c.Type}}.BoundContract.UnpackLog(ev, {{quote $e.Original.Name}}, log); err != nil {
+			return nil, err
+		}
+		ev.Raw = log
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// Watch{{$e.Name}} subscribes to the {{$e.Original.Name}} events matching the filter,
+// nil indexed values match any.
+//
+// Solidity: {{$e.Original.String}}
+func (_{{$c.Type}} *{{$c.Type}}) Watch{{$e.Name}}(opts *bind.WatchOpts, sink chan<- *{{$c.Type}}{{$e.Name}}{{range $e.Indexed}}, {{.Name}} []{{.Type}}{{end}}) (event.Subscription, error) {
+	{{- range $e.Indexed}}
+	var {{.Name}}Rule []interface{}
+	for _, item := range {{.Name}} {
+		{{.Name}}Rule = append({{.Name}}Rule, item)
+	}
+	{{- end}}
+	logs, sub, err := _{{$c.Type}}.BoundContract.WatchLogs(opts, {{quote $e.Original.Name}}{{range $e.Indexed}}, {{.Name}}Rule{{end}})
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				ev := new({{$c.Type}}{{$e.Name}})
+				if err := _{{$c.Type}}.BoundContract.UnpackLog(ev, {{quote $e.Original.Name}}, log); err != nil {
+					return err
+				}
+				ev.Raw = log
+				select {
+				case sink <- ev:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+{{- end}}
+{{end}}`
@@ -0,0 +1,231 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	// revertSelector is the selector of Error(string), used by require and revert with a reason.
+	revertSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+	// panicSelector is the selector of Panic(uint256), used by assert and runtime errors.
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+)
+
+var panicReasons = map[uint64]string{
+	0x00: "generic panic",
+	0x01: "assert(false)",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "out-of-bounds array access; popping on an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+// RevertError is returned when a call has been reverted. Reason is set for the reverts
+// with a message and for panics, Data holds the raw revert data if the node returned it,
+// e.g. a custom error of the contract.
+type RevertError struct {
+	Reason string
+	// PanicCode is set for Panic(uint256) reverts.
+	PanicCode *big.Int
+	Data      []byte
+}
+
+func (e *RevertError) Error() string {
+	switch {
+	case len(e.Reason) > 0:
+		return "execution reverted: " + e.Reason
+	case len(e.Data) >= 4:
+		return fmt.Sprintf("execution reverted: custom error %#x", e.Data[:4])
+	default:
+		return "execution reverted"
+	}
+}
+
+// Selector returns the first 4 bytes of the revert data, which identify the error type.
+func (e *RevertError) Selector() []byte {
+	if len(e.Data) < 4 {
+		return nil
+	}
+	return e.Data[:4]
+}
+
+// DecodeRevert decodes the revert data of Error(string) and Panic(uint256). Other
+// non-empty data is returned as a RevertError without a reason.
+func DecodeRevert(data []byte) (*RevertError, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	e := &RevertError{
+		Data: data,
+	}
+	switch {
+	case bytes.HasPrefix(data, revertSelector):
+		reason, err := unpackRevertReason(data[4:])
+		if err != nil {
+			return e, true
+		}
+		e.Reason = reason
+	case bytes.HasPrefix(data, panicSelector) && len(data) == 36:
+		e.PanicCode = new(big.Int).SetBytes(data[4:])
+		reason, ok := panicReasons[e.PanicCode.Uint64()]
+		if !ok || !e.PanicCode.IsUint64() {
+			reason = "unknown panic"
+		}
+		e.Reason = fmt.Sprintf("panic: %s (%#x)", reason, e.PanicCode)
+	}
+	return e, true
+}
+
+func unpackRevertReason(data []byte) (string, error) {
+	stringType, _ := abi.NewType("string", "", nil)
+	args := abi.Arguments{{Type: stringType}}
+	values, err := args.Unpack(data)
+	if err != nil {
+		return "", err
+	}
+	return values[0].(string), nil
+}
+
+// revertData is implemented by the RPC errors that carry additional data.
+type revertData interface {
+	ErrorData() interface{}
+}
+
+// revertFromError returns the revert error from the error of eth_call, if it has been
+// caused by a revert.
+func revertFromError(err error) (*RevertError, bool) {
+	if de, ok := err.(revertData); ok {
+		if hexData, ok := de.ErrorData().(string); ok {
+			if data, decodeErr := hexutil.Decode(hexData); decodeErr == nil {
+				if revert, ok := DecodeRevert(data); ok {
+					return revert, true
+				}
+			}
+		}
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "execution reverted") {
+		return nil, false
+	}
+	revert := &RevertError{}
+	if sep := strings.Index(msg, ": "); sep >= 0 {
+		revert.Reason = msg[sep+2:]
+	}
+	return revert, true
+}
+
+// CallInto invokes the constant contract method with params as input values, at the latest
+// block, and decodes its outputs into out. Multiple outputs are decoded into a struct, by
+// the camel-cased names or abi tags of its fields, or into a *[]interface{} of pointers.
+// A single output is decoded into a pointer to its value, nil out discards the outputs.
+// Returns *RevertError if the call has been reverted.
+func (c *BoundContract) CallInto(ctx context.Context, method string, out interface{}, params ...interface{}) error {
+	return c.CallIntoOpts(&bind.CallOpts{Context: ctx}, method, out, params...)
+}
+
+// CallIntoOpts is like CallInto, but allows to set the sender and to call at a specific
+// block number or against the pending state.
+func (c *BoundContract) CallIntoOpts(opts *bind.CallOpts, method string, out interface{}, params ...interface{}) error {
+	if opts == nil {
+		opts = new(bind.CallOpts)
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	m, ok := c.abi.Methods[method]
+	if !ok {
+		err := fmt.Errorf("method %s not found in ABI of %s", method, c.contractName())
+		return err
+	}
+	input, err := c.abi.Pack(method, params...)
+	if err != nil {
+		err = fmt.Errorf("failed to pack %s params: %v", method, err)
+		return err
+	}
+	address := c.address
+	msg := ethereum.CallMsg{
+		From: opts.From,
+		To:   &address,
+		Data: input,
+	}
+	output, err := callContract(ctx, c.client, msg, opts)
+	if err != nil {
+		if revert, ok := revertFromError(err); ok {
+			return revert
+		}
+		return err
+	}
+	if len(output) == 0 {
+		if len(m.Outputs) == 0 {
+			return nil
+		}
+		var code []byte
+		if opts.Pending {
+			code, err = c.client.PendingCodeAt(ctx, address)
+		} else {
+			code, err = c.client.CodeAt(ctx, address, opts.BlockNumber)
+		}
+		if err != nil {
+			return err
+		} else if len(code) == 0 {
+			return bind.ErrNoCode
+		}
+		return &RevertError{}
+	}
+	return c.unpackCall(method, output, out)
+}
+
+// unpackCall decodes the non-empty output of the method into out.
+func (c *BoundContract) unpackCall(method string, output []byte, out interface{}) error {
+	if len(output)%32 == 4 && (bytes.HasPrefix(output, revertSelector) || bytes.HasPrefix(output, panicSelector)) {
+		// some nodes return the revert data as the result
+		revert, _ := DecodeRevert(output)
+		return revert
+	}
+	if out == nil {
+		return nil
+	}
+	if err := c.abi.UnpackIntoInterface(out, method, output); err != nil {
+		err = fmt.Errorf("failed to unpack %s outputs: %v", method, err)
+		return err
+	}
+	return nil
+}
+
+func (c *BoundContract) contractName() string {
+	if c.src == nil || len(c.src.Name) == 0 {
+		return c.address.Hex()
+	}
+	return c.src.Name
+}
+
+// callContract makes the call at the block of opts, or against the pending state
+// if the backend supports it.
+func callContract(ctx context.Context, backend Backend, msg ethereum.CallMsg, opts *bind.CallOpts) ([]byte, error) {
+	if !opts.Pending {
+		return backend.CallContract(ctx, msg, opts.BlockNumber)
+	}
+	pending, ok := backend.(bind.PendingContractCaller)
+	if !ok {
+		return nil, bind.ErrNoPendingState
+	}
+	return pending.PendingCallContract(ctx, msg)
+}
@@ -0,0 +1,149 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+const callTestABI = `[
+	{"type":"function","name":"balanceOf","constant":true,"inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"info","constant":true,"inputs":[],"outputs":[{"name":"name","type":"string"},{"name":"decimals","type":"uint8"}]},
+	{"type":"function","name":"fail","constant":true,"inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"overflow","constant":true,"inputs":[],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+// callTestOutputs returns the outputs of the test contract methods by name.
+func callTestOutputs(t *testing.T) (abi.ABI, map[string][]byte) {
+	parsed, err := abi.JSON(bytes.NewReader([]byte(callTestABI)))
+	require.NoError(t, err)
+	balance, err := parsed.Methods["balanceOf"].Outputs.Pack(big.NewInt(1000))
+	require.NoError(t, err)
+	info, err := parsed.Methods["info"].Outputs.Pack("Token", uint8(18))
+	require.NoError(t, err)
+	reason, err := abi.Arguments{{Type: parsed.Methods["info"].Outputs[0].Type}}.Pack("not allowed")
+	require.NoError(t, err)
+	outputs := map[string][]byte{
+		"balanceOf": balance,
+		"info":      info,
+		"fail":      append(append([]byte{}, revertSelector...), reason...),
+		"overflow":  append(append([]byte{}, panicSelector...), common.LeftPadBytes([]byte{0x11}, 32)...),
+	}
+	return parsed, outputs
+}
+
+func newCallTestContract(t *testing.T) (*BoundContract, *rpctest.MockNode, func()) {
+	parsed, outputs := callTestOutputs(t)
+	node := rpctest.NewMockNode()
+	node.Handle("eth_call", func(req rpctest.Request) (interface{}, error) {
+		var msg struct {
+			Data hexutil.Bytes `json:"input"`
+		}
+		if err := json.Unmarshal(req.Params[0], &msg); err != nil {
+			return nil, err
+		}
+		for name, m := range parsed.Methods {
+			if bytes.HasPrefix(msg.Data, m.ID) {
+				return hexutil.Bytes(outputs[name]), nil
+			}
+		}
+		return hexutil.Bytes{}, nil
+	})
+	client, closeFn := dial(t, node)
+	contract, err := BindContract(client, &sol.Contract{
+		Name:    "Token",
+		ABI:     []byte(callTestABI),
+		Address: common.HexToAddress("0x1000000000000000000000000000000000000001"),
+	})
+	require.NoError(t, err)
+	return contract, node, closeFn
+}
+
+func TestCallInto(t *testing.T) {
+	require := require.New(t)
+	contract, node, closeFn := newCallTestContract(t)
+	defer closeFn()
+	ctx := context.Background()
+
+	balance := new(big.Int)
+	err := contract.CallInto(ctx, "balanceOf", &balance, common.HexToAddress("0x2"))
+	require.NoError(err)
+	require.Equal(int64(1000), balance.Int64())
+
+	var info struct {
+		Name     string
+		Decimals uint8
+	}
+	require.NoError(contract.CallInto(ctx, "info", &info))
+	require.Equal("Token", info.Name)
+	require.Equal(uint8(18), info.Decimals)
+
+	var (
+		name     string
+		decimals uint8
+	)
+	require.NoError(contract.CallInto(ctx, "info", &[]interface{}{&name, &decimals}))
+	require.Equal("Token", name)
+	require.Equal(uint8(18), decimals)
+
+	err = contract.CallInto(ctx, "unknown", nil)
+	require.Error(err)
+
+	require.NoError(contract.CallIntoOpts(&bind.CallOpts{Pending: true}, "info", nil))
+	require.NoError(contract.CallIntoOpts(&bind.CallOpts{BlockNumber: big.NewInt(16)}, "info", nil))
+	var blocks []string
+	for _, call := range node.Requests("eth_call") {
+		var block string
+		require.NoError(json.Unmarshal(call.Params[1], &block))
+		blocks = append(blocks, block)
+	}
+	require.Equal([]string{"latest", "latest", "latest", "pending", "0x10"}, blocks)
+}
+
+func TestCallIntoRevert(t *testing.T) {
+	require := require.New(t)
+	contract, _, closeFn := newCallTestContract(t)
+	defer closeFn()
+	ctx := context.Background()
+
+	err := contract.CallInto(ctx, "fail", nil)
+	revert, ok := err.(*RevertError)
+	require.True(ok, "unexpected error: %v", err)
+	require.Equal("not allowed", revert.Reason)
+	require.Equal("execution reverted: not allowed", err.Error())
+
+	err = contract.CallInto(ctx, "overflow", nil)
+	revert, ok = err.(*RevertError)
+	require.True(ok, "unexpected error: %v", err)
+	require.Equal(int64(0x11), revert.PanicCode.Int64())
+	require.Contains(revert.Reason, "arithmetic underflow or overflow")
+}
+
+func TestDecodeRevert(t *testing.T) {
+	require := require.New(t)
+
+	_, ok := DecodeRevert(nil)
+	require.False(ok)
+
+	custom := []byte{0xde, 0xad, 0xbe, 0xef, 0x01}
+	revert, ok := DecodeRevert(custom)
+	require.True(ok)
+	require.Empty(revert.Reason)
+	require.Equal(custom[:4], revert.Selector())
+	require.Equal("execution reverted: custom error 0xdeadbeef", revert.Error())
+}
@@ -0,0 +1,143 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Command ethfw-bindgen generates typed Go wrappers of contracts, to be used in go:generate
+// directives like:
+//
+//	//go:generate ethfw-bindgen -sol Token.sol -pkg token -out token.go
+//
+// or from a compiled ABI and bytecode:
+//
+//	//go:generate ethfw-bindgen -abi Token.abi -bin Token.bin -type Token -pkg token -out token.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AtlantPlatform/ethfw/bindgen"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+var (
+	solFile    = flag.String("sol", "", "Solidity source file to compile and bind.")
+	solcPath   = flag.String("solc", "", "Path to solc, found in PATH by default.")
+	optimize   = flag.Int("optimize", 0, "Optimizer runs, zero disables the optimizer.")
+	remappings = flag.String("remappings", "", "Import remappings, separated by spaces.")
+	abiFile    = flag.String("abi", "", "ABI file to bind, instead of a source file.")
+	binFile    = flag.String("bin", "", "Bytecode file of the -abi contract, optional.")
+	typeName   = flag.String("type", "", "Contract name for -abi, or the only contract of -sol to bind.")
+	pkgName    = flag.String("pkg", "", "Package name of the generated code.")
+	outFile    = flag.String("out", "", "Output file, stdout by default.")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "ethfw-bindgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if len(*pkgName) == 0 {
+		return fmt.Errorf("-pkg is required")
+	}
+	var (
+		contracts []*sol.Contract
+		err       error
+	)
+	switch {
+	case len(*solFile) > 0 && len(*abiFile) > 0:
+		return fmt.Errorf("-sol and -abi are mutually exclusive")
+	case len(*solFile) > 0:
+		contracts, err = compileContracts()
+	case len(*abiFile) > 0:
+		contracts, err = loadContract()
+	default:
+		return fmt.Errorf("either -sol or -abi is required")
+	}
+	if err != nil {
+		return err
+	}
+	code, err := bindgen.Generate(*pkgName, contracts)
+	if err != nil {
+		return err
+	}
+	if len(*outFile) == 0 {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return ioutil.WriteFile(*outFile, code, 0644)
+}
+
+func compileContracts() ([]*sol.Contract, error) {
+	path := *solcPath
+	if len(path) == 0 {
+		var err error
+		if path, err = sol.WhichSolc(); err != nil {
+			return nil, err
+		}
+	}
+	compiler, err := sol.NewStandardJSONCompiler(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := sol.ParseRemappings(*remappings)
+	if err != nil {
+		return nil, err
+	}
+	compiler.(sol.Remapper).SetRemappings(parsed)
+	prefix, file := filepath.Split(*solFile)
+	compiled, err := compiler.Compile(prefix, file, *optimize)
+	if err != nil {
+		return nil, err
+	}
+	var contracts []*sol.Contract
+	for _, c := range compiled {
+		// skip the contracts of the imported files
+		if filepath.Clean(c.SourcePath) != filepath.Clean(file) {
+			continue
+		}
+		if len(*typeName) > 0 && c.Name != *typeName {
+			continue
+		}
+		contracts = append(contracts, c)
+	}
+	if len(contracts) == 0 {
+		return nil, fmt.Errorf("no contracts to bind in %s", *solFile)
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		return contracts[i].Name < contracts[j].Name
+	})
+	return contracts, nil
+}
+
+func loadContract() ([]*sol.Contract, error) {
+	abiJSON, err := ioutil.ReadFile(*abiFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &sol.Contract{
+		Name: *typeName,
+		ABI:  abiJSON,
+	}
+	if len(c.Name) == 0 {
+		base := filepath.Base(*abiFile)
+		c.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if len(*binFile) > 0 {
+		bin, err := ioutil.ReadFile(*binFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Bin = strings.TrimSpace(string(bin))
+	}
+	return []*sol.Contract{c}, nil
+}
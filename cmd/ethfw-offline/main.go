@@ -0,0 +1,259 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Command ethfw-offline sends transactions from a cold wallet. The bundle is prepared on the
+// online machine, signed on the offline one and broadcast back online:
+//
+//	ethfw-offline prepare -rpc http://localhost:8545 -from 0x.. -to 0x.. -abi Token.abi \
+//		-method transfer -args '["0x..", "100"]' -bundle bundle.json
+//	ethfw-offline sign -bundle bundle.json -keystore UTC--.. -password-file pass.txt
+//	ethfw-offline broadcast -rpc http://localhost:8545 -bundle bundle.json
+//
+// Running prepare again with the same -bundle appends the transaction to it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/deploy"
+	"github.com/AtlantPlatform/ethfw/gasmeter"
+	"github.com/AtlantPlatform/ethfw/offline"
+)
+
+const usage = `usage: ethfw-offline <command> [flags]
+
+commands:
+  prepare    prepare the transaction, adding it to the bundle
+  sign       sign the bundle with the keystore files
+  broadcast  validate the signed bundle and send it
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "prepare":
+		err = prepare(args)
+	case "sign":
+		err = sign(args)
+	case "broadcast":
+		err = broadcast(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ethfw-offline:", err)
+		os.Exit(1)
+	}
+}
+
+// listFlag is a flag that may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func prepare(args []string) error {
+	fs := flag.NewFlagSet("prepare", flag.ExitOnError)
+	var (
+		rpcURL     = fs.String("rpc", "http://localhost:8545", "JSON-RPC endpoint of the node.")
+		bundleFile = fs.String("bundle", "bundle.json", "Bundle file, created if it doesn't exist.")
+		from       = fs.String("from", "", "Account sending the transaction.")
+		to         = fs.String("to", "", "Recipient, a new contract is created if not set.")
+		value      = fs.String("value", "0", "Value sent, in wei.")
+		data       = fs.String("data", "", "Hex-encoded call data.")
+		abiFile    = fs.String("abi", "", "ABI file of the contract called.")
+		contract   = fs.String("contract", "", "Contract name shown to the signer, the -abi file name by default.")
+		method     = fs.String("method", "", "Method of the -abi contract to call.")
+		params     = fs.String("args", "[]", "JSON array of the -method arguments.")
+		gas        = fs.Uint64("gas", 0, "Gas limit, estimated if not set.")
+		gasStation = fs.String("gasstation", "", "Gas station URL, the node suggests the fees if not set.")
+		priority   = fs.String("priority", string(gasmeter.GasPriorityFast), "Gas station priority: safe, fast or fastest.")
+	)
+	fs.Parse(args)
+	if !common.IsHexAddress(*from) {
+		return errors.New("-from must be an address")
+	}
+	req := &offline.Request{
+		From: common.HexToAddress(*from),
+		Gas:  *gas,
+	}
+	if len(*to) > 0 {
+		if !common.IsHexAddress(*to) {
+			return errors.New("-to must be an address")
+		}
+		addr := common.HexToAddress(*to)
+		req.To = &addr
+	}
+	v, ok := new(big.Int).SetString(*value, 10)
+	if !ok {
+		return fmt.Errorf("invalid -value %s", *value)
+	}
+	req.Value = v
+	if len(*data) > 0 {
+		b, err := hexutil.Decode(*data)
+		if err != nil {
+			return fmt.Errorf("invalid -data: %v", err)
+		}
+		req.Data = b
+	}
+	if len(*abiFile) > 0 {
+		abiJSON, err := ioutil.ReadFile(*abiFile)
+		if err != nil {
+			return err
+		}
+		parsed, err := abi.JSON(strings.NewReader(string(abiJSON)))
+		if err != nil {
+			return fmt.Errorf("failed to parse ABI: %v", err)
+		}
+		req.ABI = &parsed
+		req.Contract = *contract
+		if len(req.Contract) == 0 {
+			base := filepath.Base(*abiFile)
+			req.Contract = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+		if len(*method) > 0 {
+			m, ok := parsed.Methods[*method]
+			if !ok {
+				return fmt.Errorf("no method %s in %s", *method, *abiFile)
+			}
+			var values []json.RawMessage
+			if err := json.Unmarshal([]byte(*params), &values); err != nil {
+				return fmt.Errorf("invalid -args: %v", err)
+			}
+			if req.Params, err = deploy.ConvertArgs(m.Inputs, values); err != nil {
+				return err
+			}
+			req.Method = *method
+		}
+	} else if len(*method) > 0 {
+		return errors.New("-method requires -abi")
+	}
+
+	client, err := ethclient.Dial(*rpcURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	p := offline.NewPreparer(client, nil, nil)
+	p.GasPriority = gasmeter.GasPriority(*priority)
+	if len(*gasStation) > 0 {
+		if p.GasStation, err = gasmeter.NewGasStation(*gasStation, time.Minute); err != nil {
+			return err
+		}
+	}
+	ctx := context.Background()
+	var bundle *offline.Bundle
+	if _, err := os.Stat(*bundleFile); err == nil {
+		if bundle, err = offline.ReadBundle(*bundleFile); err != nil {
+			return err
+		}
+		err = p.Append(ctx, bundle, req)
+	} else {
+		bundle, err = p.Prepare(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(bundle.Transactions[len(bundle.Transactions)-1])
+	return bundle.Write(*bundleFile)
+}
+
+func sign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	var (
+		keystores    listFlag
+		bundleFile   = fs.String("bundle", "bundle.json", "Bundle file to sign.")
+		passwordFile = fs.String("password-file", "", "File with the keystore password, empty password if not set.")
+		outFile      = fs.String("out", "", "Signed bundle file, -bundle is overwritten if not set.")
+	)
+	fs.Var(&keystores, "keystore", "Keystore file of a signing account, may be repeated.")
+	fs.Parse(args)
+	bundle, err := offline.ReadBundle(*bundleFile)
+	if err != nil {
+		return err
+	}
+	keys := ethfw.NewKeyCache()
+	for _, path := range keystores {
+		keyJSON, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var key struct {
+			Address string `json:"address"`
+		}
+		if err := json.Unmarshal(keyJSON, &key); err != nil || !common.IsHexAddress(key.Address) {
+			return fmt.Errorf("%s is not a keystore file", path)
+		}
+		keys.SetPath(common.HexToAddress(key.Address), path)
+	}
+	var password string
+	if len(*passwordFile) > 0 {
+		data, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	fmt.Printf("Signing %d transactions for chain %s:\n", len(bundle.Transactions), bundle.ChainID.ToInt())
+	for _, tx := range bundle.Transactions {
+		fmt.Println(tx)
+	}
+	if err := offline.Sign(context.Background(), bundle, keys, password); err != nil {
+		return err
+	}
+	if len(*outFile) == 0 {
+		*outFile = *bundleFile
+	}
+	return bundle.Write(*outFile)
+}
+
+func broadcast(args []string) error {
+	fs := flag.NewFlagSet("broadcast", flag.ExitOnError)
+	var (
+		rpcURL     = fs.String("rpc", "http://localhost:8545", "JSON-RPC endpoint of the node.")
+		bundleFile = fs.String("bundle", "bundle.json", "Signed bundle file to send.")
+	)
+	fs.Parse(args)
+	bundle, err := offline.ReadBundle(*bundleFile)
+	if err != nil {
+		return err
+	}
+	client, err := ethclient.Dial(*rpcURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	hashes, err := offline.Broadcast(context.Background(), client, bundle)
+	for _, hash := range hashes {
+		fmt.Println(hash.Hex())
+	}
+	return err
+}
@@ -16,7 +16,16 @@ import (
 	"github.com/AtlantPlatform/ethfw/sol"
 )
 
+// ContractDeployBin returns the init code of the contract: its decoded bytecode followed by
+// the packed constructor params.
 func ContractDeployBin(c *sol.Contract, params ...interface{}) ([]byte, error) {
+	if c.NeedsLinking() {
+		err := &sol.UnlinkedError{
+			Contract:  c.Name,
+			Libraries: c.Libraries(),
+		}
+		return nil, err
+	}
 	parsedABI, err := abi.JSON(bytes.NewReader(c.ABI))
 	if err != nil {
 		err = fmt.Errorf("failed to parse contract ABI: %v", err)
@@ -27,7 +36,7 @@ func ContractDeployBin(c *sol.Contract, params ...interface{}) ([]byte, error) {
 		err = fmt.Errorf("failed to pack contract params: %v", err)
 		return nil, err
 	}
-	bin := append([]byte(c.Bin), input...)
+	bin := append(common.FromHex(c.Bin), input...)
 	return bin, nil
 }
 
@@ -0,0 +1,35 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+func TestContractDeployBin(t *testing.T) {
+	require := require.New(t)
+	abi := []byte(`[{"type":"constructor","inputs":[{"name":"value","type":"uint256"}]}]`)
+	param := common.LeftPadBytes([]byte{42}, 32)
+
+	// the init code is the decoded bytecode, with or without the 0x prefix
+	for _, bin := range []string{"6080", "0x6080"} {
+		code, err := ContractDeployBin(&sol.Contract{Name: "C", ABI: abi, Bin: bin}, big.NewInt(42))
+		require.NoError(err)
+		require.Equal(append([]byte{0x60, 0x80}, param...), code)
+	}
+
+	_, err := ContractDeployBin(&sol.Contract{
+		Name: "C",
+		ABI:  abi,
+		Bin:  "6080" + sol.LibraryPlaceholder("Math.sol:Math"),
+	}, big.NewInt(42))
+	require.IsType(&sol.UnlinkedError{}, err)
+}
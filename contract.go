@@ -2,6 +2,7 @@ package ethfw
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 
@@ -11,22 +12,30 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// Backend is the node the contracts are bound to: *ethclient.Client, a simulated
+// backend, a mock or a client wrapper. It's a bind.ContractBackend and a bind.DeployBackend,
+// the pending calls check the backend for bind.PendingContractCaller.
+type Backend interface {
+	bind.ContractBackend
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
 type TransactFunc func(opts *bind.TransactOpts, contract *common.Address, input []byte) (*types.Transaction, error)
 
 type BoundContract struct {
 	*bind.BoundContract
 
-	transactFn TransactFunc
-	client     *ethclient.Client
-	address    common.Address
-	src        *sol.Contract
-	abi        abi.ABI
+	transactFn  TransactFunc
+	accessLists *AccessListOptions
+	client      Backend
+	address     common.Address
+	src         *sol.Contract
+	abi         abi.ABI
 }
 
-func BindContract(client *ethclient.Client, contract *sol.Contract) (*BoundContract, error) {
+func BindContract(client Backend, contract *sol.Contract) (*BoundContract, error) {
 	if contract == nil {
 		err := errors.New("contract must not be nil")
 		return nil, err
@@ -51,13 +60,13 @@ func (contract *BoundContract) SetTransact(fn TransactFunc) {
 	contract.transactFn = fn
 }
 
-func (contract *BoundContract) SetClient(client *ethclient.Client) {
+func (contract *BoundContract) SetClient(client Backend) {
 	contract.client = client
 	contract.BoundContract = bind.NewBoundContract(
 		contract.address, contract.abi, client, client, client)
 }
 
-func (contract *BoundContract) Client() *ethclient.Client {
+func (contract *BoundContract) Client() Backend {
 	return contract.client
 }
 
@@ -84,11 +93,19 @@ func (contract *BoundContract) ABI() abi.ABI {
 func (c *BoundContract) DeployContract(opts *bind.TransactOpts,
 	params ...interface{}) (common.Address, *types.Transaction, error) {
 
+	if c.src.NeedsLinking() {
+		err := &sol.UnlinkedError{
+			Contract:  c.src.Name,
+			Libraries: c.src.Libraries(),
+		}
+		return common.Address{}, nil, err
+	}
 	if c.transactFn == nil {
 		addr, tx, bound, err := bind.DeployContract(opts, c.abi, common.FromHex(c.src.Bin), c.client, params...)
 		if err != nil {
 			return addr, tx, err
 		}
+		c.address = addr
 		c.BoundContract = bound
 		return addr, tx, nil
 	}
@@ -110,7 +127,7 @@ func (c *BoundContract) DeployContract(opts *bind.TransactOpts,
 // Transact invokes the (paid) contract method with params as input values.
 func (c *BoundContract) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
 
-	if c.transactFn == nil {
+	if c.transactFn == nil && c.accessLists == nil {
 		return c.BoundContract.Transact(opts, method, params...)
 	}
 
@@ -118,14 +135,38 @@ func (c *BoundContract) Transact(opts *bind.TransactOpts, method string, params
 	if err != nil {
 		return nil, err
 	}
-	return c.transactFn(opts, &c.address, input)
+	return c.transact(opts, &c.address, input)
 }
 
 // Transfer initiates a plain transaction to move funds to the contract, calling
 // its default method if one is available.
 func (c *BoundContract) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
-	if c.transactFn == nil {
+	if c.transactFn == nil && c.accessLists == nil {
 		return c.BoundContract.Transfer(opts)
 	}
-	return c.transactFn(opts, &c.address, nil)
+	return c.transact(opts, &c.address, nil)
+}
+
+// transact sends the transaction with the access list if it saves gas, with transactFn if set.
+func (c *BoundContract) transact(opts *bind.TransactOpts,
+	contract *common.Address, input []byte) (*types.Transaction, error) {
+
+	if c.accessLists != nil {
+		listOpts, res, err := c.withAccessList(opts, contract, input)
+		if err != nil {
+			return nil, err
+		}
+		if c.transactFn == nil && listOpts != opts {
+			// the gas estimated with the list is only known to hold if the list is attached
+			if listOpts.GasLimit == 0 {
+				listOpts.GasLimit = res.Gas
+			}
+			return c.transactAccessList(listOpts, contract, input)
+		}
+		opts = listOpts
+	}
+	if c.transactFn == nil {
+		return c.BoundContract.RawTransact(opts, input)
+	}
+	return c.transactFn(opts, contract, input)
 }
@@ -0,0 +1,75 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// answerContract returns 42 to any call.
+var answerContract = &sol.Contract{
+	Name: "Answer",
+	ABI: []byte(`[
+		{"type":"function","name":"answer","inputs":[],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+		{"type":"event","name":"Answered","inputs":[{"name":"value","type":"uint256","indexed":false}]}
+	]`),
+	Bin: "600a600c600039600a6000f3" + "602a60005260206000f3",
+}
+
+func TestBindSimulatedBackend(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	opts, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(err)
+	sim := backends.NewSimulatedBackend(types.GenesisAlloc{
+		opts.From: {Balance: big.NewInt(1e18)},
+	}, 8000000)
+	defer sim.Close()
+
+	answer, err := BindContract(sim, answerContract)
+	require.NoError(err)
+	addr, tx, err := answer.DeployContract(opts)
+	require.NoError(err)
+	ctx := context.Background()
+
+	// the pending state has the contract before it's mined
+	var value *big.Int
+	require.NoError(answer.CallIntoOpts(&bind.CallOpts{Pending: true}, "answer", &value))
+	require.Equal(big.NewInt(42), value)
+	sim.Commit()
+	deployed, err := bind.WaitDeployed(ctx, answer.Client(), tx)
+	require.NoError(err)
+	require.Equal(addr, deployed)
+	value = nil
+	require.NoError(answer.CallInto(ctx, "answer", &value))
+	require.Equal(big.NewInt(42), value)
+}
+
+func TestBackendWithoutPendingState(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	client, closeFn := dial(t, node)
+	defer closeFn()
+
+	// the wrapper hides the client methods that are not in Backend
+	answer, err := BindContract(struct{ Backend }{client}, answerContract)
+	require.NoError(err)
+	_, err = answer.FilterEvents(context.Background(), "Answered", EventQuery{})
+	require.EqualError(err, "failed to get logs of blocks 0-0: the method eth_getLogs does not exist/is not available")
+	err = answer.CallIntoOpts(&bind.CallOpts{Pending: true}, "answer", new(*big.Int))
+	require.Equal(bind.ErrNoPendingState, err)
+}
@@ -0,0 +1,216 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Create2Factory is a contract that deploys init code with CREATE2.
+type Create2Factory struct {
+	Address common.Address
+	// Input returns the calldata of the factory call that deploys the init code.
+	Input func(salt [32]byte, initCode []byte) ([]byte, error)
+}
+
+// DeterministicDeploymentProxy is the CREATE2 factory deployed at the same address on most
+// chains, it takes the salt followed by the init code as calldata.
+var DeterministicDeploymentProxy = &Create2Factory{
+	Address: common.HexToAddress("0x4e59b44847b379578588920cA78FbF26c0B4956C"),
+	Input: func(salt [32]byte, initCode []byte) ([]byte, error) {
+		input := make([]byte, 0, len(salt)+len(initCode))
+		input = append(input, salt[:]...)
+		return append(input, initCode...), nil
+	},
+}
+
+// NewCreate2Factory returns the factory that deploys with the method of its ABI, which takes
+// the salt as bytes32 or uint256 and the init code as bytes, e.g. deploy(bytes32,bytes).
+func NewCreate2Factory(address common.Address, factoryABI abi.ABI, method string) (*Create2Factory, error) {
+	m, ok := factoryABI.Methods[method]
+	if !ok {
+		err := fmt.Errorf("method %s not found in factory ABI", method)
+		return nil, err
+	} else if len(m.Inputs) != 2 || m.Inputs[1].Type.T != abi.BytesTy {
+		err := fmt.Errorf("factory method %s must take the salt and the init code", m.Sig)
+		return nil, err
+	}
+	saltType := m.Inputs[0].Type
+	switch {
+	case saltType.T == abi.FixedBytesTy && saltType.Size == 32:
+	case saltType.T == abi.UintTy && saltType.Size == 256:
+	default:
+		err := fmt.Errorf("factory method %s must take the salt as bytes32 or uint256", m.Sig)
+		return nil, err
+	}
+	factory := &Create2Factory{
+		Address: address,
+		Input: func(salt [32]byte, initCode []byte) ([]byte, error) {
+			if saltType.T == abi.UintTy {
+				return factoryABI.Pack(method, new(big.Int).SetBytes(salt[:]), initCode)
+			}
+			return factoryABI.Pack(method, salt, initCode)
+		},
+	}
+	return factory, nil
+}
+
+// Create2Address returns the address of the contract deployed with CREATE2 by the deployer,
+// as defined by EIP-1014.
+func Create2Address(deployer common.Address, salt [32]byte, initCodeHash common.Hash) common.Address {
+	return crypto.CreateAddress2(deployer, salt, initCodeHash.Bytes())
+}
+
+// Create2Address returns the address of the contract deployed by the factory with the salt
+// and the constructor params. Nil factory selects DeterministicDeploymentProxy.
+func (c *BoundContract) Create2Address(factory *Create2Factory, salt [32]byte,
+	params ...interface{}) (common.Address, error) {
+
+	if factory == nil {
+		factory = DeterministicDeploymentProxy
+	}
+	initCode, err := ContractDeployBin(c.src, params...)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return Create2Address(factory.Address, salt, crypto.Keccak256Hash(initCode)), nil
+}
+
+// DeployCreate2 deploys the contract through the CREATE2 factory, so that it gets the same
+// address on every chain for the same salt and params, and binds the address. Nil factory
+// selects DeterministicDeploymentProxy. If the contract is already deployed at the address,
+// it is bound without sending a transaction and the returned transaction is nil.
+func (c *BoundContract) DeployCreate2(opts *bind.TransactOpts, factory *Create2Factory, salt [32]byte,
+	params ...interface{}) (common.Address, *types.Transaction, error) {
+
+	if factory == nil {
+		factory = DeterministicDeploymentProxy
+	}
+	initCode, err := ContractDeployBin(c.src, params...)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	addr := Create2Address(factory.Address, salt, crypto.Keccak256Hash(initCode))
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	code, err := c.client.PendingCodeAt(ctx, addr)
+	if err != nil {
+		err = fmt.Errorf("failed to get code at %s: %v", addr.Hex(), err)
+		return common.Address{}, nil, err
+	} else if len(code) > 0 {
+		c.SetAddress(addr)
+		return addr, nil, nil
+	}
+	input, err := factory.Input(salt, initCode)
+	if err != nil {
+		err = fmt.Errorf("failed to pack factory input: %v", err)
+		return common.Address{}, nil, err
+	}
+	// the factory is called the way the contract methods are, with the same transact func
+	// and access lists
+	caller := &BoundContract{
+		BoundContract: bind.NewBoundContract(factory.Address, abi.ABI{}, c.client, c.client, c.client),
+		transactFn:    c.transactFn,
+		accessLists:   c.accessLists,
+		client:        c.client,
+		address:       factory.Address,
+	}
+	tx, err := caller.transact(opts, &factory.Address, input)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	c.SetAddress(addr)
+	return addr, tx, nil
+}
+
+// MineCreate2Salt searches for the salt that gives the CREATE2 address starting with the hex
+// prefix, matched case-insensitively, using all CPUs. The salts are taken from base by
+// incrementing its last 8 bytes as a counter, so base may start with the deployer address
+// for the factories that require it. Every hex digit of the prefix takes 16 times longer.
+func MineCreate2Salt(ctx context.Context, deployer common.Address, initCodeHash common.Hash,
+	prefix string, base [32]byte) ([32]byte, common.Address, error) {
+
+	prefix = strings.ToLower(strings.TrimPrefix(prefix, "0x"))
+	if len(prefix) > 2*common.AddressLength {
+		err := fmt.Errorf("prefix %s is longer than an address", prefix)
+		return [32]byte{}, common.Address{}, err
+	}
+	nibbles := make([]byte, len(prefix))
+	for i := range prefix {
+		b, err := hex.DecodeString("0" + prefix[i:i+1])
+		if err != nil {
+			err = fmt.Errorf("prefix %s is not hex", prefix)
+			return [32]byte{}, common.Address{}, err
+		}
+		nibbles[i] = b[0]
+	}
+	matches := func(addr common.Address) bool {
+		for i, n := range nibbles {
+			b := addr[i/2]
+			if i%2 == 0 {
+				b >>= 4
+			}
+			if b&0x0f != n {
+				return false
+			}
+		}
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type found struct {
+		salt [32]byte
+		addr common.Address
+	}
+	results := make(chan found, 1)
+	workers := runtime.NumCPU()
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			salt := base
+			counter := binary.BigEndian.Uint64(salt[24:])
+			for n := uint64(0); ; n++ {
+				if n%4096 == 0 && ctx.Err() != nil {
+					return
+				}
+				binary.BigEndian.PutUint64(salt[24:], counter+uint64(w)+n*uint64(workers))
+				addr := crypto.CreateAddress2(deployer, salt, initCodeHash.Bytes())
+				if matches(addr) {
+					select {
+					case results <- found{salt, addr}:
+						cancel()
+					default:
+					}
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	select {
+	case res := <-results:
+		return res.salt, res.addr, nil
+	default:
+		return [32]byte{}, common.Address{}, ctx.Err()
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+func TestCreate2Address(t *testing.T) {
+	require := require.New(t)
+	// the examples of EIP-1014
+	require.Equal(
+		common.HexToAddress("0x4D1A2e2bB4F88F0250f26Ffff098B0b30B26BF38"),
+		Create2Address(common.Address{}, [32]byte{}, crypto.Keccak256Hash([]byte{0x00})),
+	)
+	require.Equal(
+		common.HexToAddress("0xB928f69Bb1D91Cd65274e3c79d8986362984fDA3"),
+		Create2Address(common.HexToAddress("0xdeadbeef00000000000000000000000000000000"),
+			[32]byte{}, crypto.Keccak256Hash([]byte{0x00})),
+	)
+	salt := common.HexToHash("0x00000000000000000000000000000000000000000000000000000000cafebabe")
+	require.Equal(
+		common.HexToAddress("0x60f3f640a8508fC6a86d45DF051962668E1e8AC7"),
+		Create2Address(common.HexToAddress("0x00000000000000000000000000000000deadbeef"),
+			salt, crypto.Keccak256Hash(common.FromHex("0xdeadbeef"))),
+	)
+}
+
+func TestMineCreate2Salt(t *testing.T) {
+	require := require.New(t)
+	initCodeHash := crypto.Keccak256Hash([]byte{0x00})
+	base := common.HexToHash("0x1000000000000000000000000000000000000000000000000000000000000000")
+
+	salt, addr, err := MineCreate2Salt(context.Background(), DeterministicDeploymentProxy.Address,
+		initCodeHash, "0xAb1", base)
+	require.NoError(err)
+	require.True(strings.HasPrefix(strings.ToLower(addr.Hex()), "0xab1"), addr.Hex())
+	require.Equal(Create2Address(DeterministicDeploymentProxy.Address, salt, initCodeHash), addr)
+	require.Equal(base[:24], salt[:24])
+
+	_, _, err = MineCreate2Salt(context.Background(), common.Address{}, initCodeHash, "xyz", base)
+	require.Error(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = MineCreate2Salt(ctx, common.Address{}, initCodeHash, "0000000000", base)
+	require.Equal(context.Canceled, err)
+}
+
+func TestDeployCreate2(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	opts, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(err)
+
+	node := rpctest.NewMockNode()
+	node.SetCode(DeterministicDeploymentProxy.Address, []byte{0x60})
+	node.SetNonce(opts.From, 7)
+	node.Handle("eth_getBlockByNumber", func(req rpctest.Request) (interface{}, error) {
+		return &types.Header{
+			Number:     big.NewInt(1),
+			Difficulty: new(big.Int),
+			BaseFee:    big.NewInt(1e9),
+		}, nil
+	})
+	node.Handle("eth_maxPriorityFeePerGas", func(req rpctest.Request) (interface{}, error) {
+		return (*hexutil.Big)(big.NewInt(1e8)), nil
+	})
+	client, closeFn := dial(t, node)
+	defer closeFn()
+
+	contract, err := BindContract(client, &sol.Contract{
+		Name: "Box",
+		ABI:  []byte(`[{"type":"constructor","inputs":[{"name":"value","type":"uint256"}]}]`),
+		Bin:  "6080604052",
+	})
+	require.NoError(err)
+	salt := common.HexToHash("0x01")
+	predicted, err := contract.Create2Address(nil, salt, big.NewInt(42))
+	require.NoError(err)
+
+	addr, tx, err := contract.DeployCreate2(opts, nil, salt, big.NewInt(42))
+	require.NoError(err)
+	require.Equal(predicted, addr)
+	require.Equal(addr, contract.Address())
+	sent := node.Transactions()
+	require.Len(sent, 1)
+	require.Equal(tx.Hash(), sent[0].Hash())
+	require.Equal(DeterministicDeploymentProxy.Address, *tx.To())
+	require.Equal(uint64(7), tx.Nonce())
+	require.Equal(uint8(types.DynamicFeeTxType), tx.Type())
+	require.Equal(big.NewInt(1e8), tx.GasTipCap())
+	initCode, err := ContractDeployBin(contract.Source(), big.NewInt(42))
+	require.NoError(err)
+	require.Equal(append(salt.Bytes(), initCode...), tx.Data())
+
+	// the transactions not to be sent are only signed
+	noSend := *opts
+	noSend.NoSend = true
+	_, tx, err = contract.DeployCreate2(&noSend, nil, common.HexToHash("0x02"), big.NewInt(42))
+	require.NoError(err)
+	require.NotNil(tx)
+	require.Len(node.Transactions(), 1)
+
+	// the deployment is skipped if the code is there
+	node.SetCode(addr, []byte{0x60})
+	addr, tx, err = contract.DeployCreate2(opts, nil, salt, big.NewInt(42))
+	require.NoError(err)
+	require.Equal(predicted, addr)
+	require.Nil(tx)
+	require.Len(node.Transactions(), 1)
+}
+
+func TestNewCreate2Factory(t *testing.T) {
+	require := require.New(t)
+	parsed, err := abi.JSON(strings.NewReader(`[
+		{"type":"function","name":"deploy","inputs":[{"name":"salt","type":"uint256"},{"name":"code","type":"bytes"}],"outputs":[{"name":"","type":"address"}]},
+		{"type":"function","name":"bad","inputs":[{"name":"code","type":"bytes"}],"outputs":[]}
+	]`))
+	require.NoError(err)
+	factory, err := NewCreate2Factory(common.HexToAddress("0x5"), parsed, "deploy")
+	require.NoError(err)
+	input, err := factory.Input(common.HexToHash("0x02"), []byte{0x60})
+	require.NoError(err)
+	expected, err := parsed.Pack("deploy", big.NewInt(2), []byte{0x60})
+	require.NoError(err)
+	require.Equal(expected, input)
+
+	_, err = NewCreate2Factory(common.HexToAddress("0x5"), parsed, "bad")
+	require.Error(err)
+	_, err = NewCreate2Factory(common.HexToAddress("0x5"), parsed, "missing")
+	require.Error(err)
+}
@@ -0,0 +1,257 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var bigT = reflect.TypeOf(new(big.Int))
+
+// resolveFunc returns the address of the deployment referenced by name.
+type resolveFunc func(name string) (common.Address, error)
+
+// ConvertArgs converts the JSON values into the Go values of the arguments, like the
+// arguments of the manifest, which may not reference deployments here.
+func ConvertArgs(args abi.Arguments, values []json.RawMessage) ([]interface{}, error) {
+	return convertArgs(args, values, func(name string) (common.Address, error) {
+		err := fmt.Errorf("unknown deployment %s", name)
+		return common.Address{}, err
+	})
+}
+
+// convertArgs converts the JSON values of the manifest into the Go values of the arguments.
+func convertArgs(args abi.Arguments, values []json.RawMessage, resolve resolveFunc) ([]interface{}, error) {
+	if len(values) != len(args) {
+		err := fmt.Errorf("expected %d arguments, got %d", len(args), len(values))
+		return nil, err
+	}
+	converted := make([]interface{}, len(values))
+	for i, raw := range values {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			err = fmt.Errorf("argument %d: %v", i, err)
+			return nil, err
+		}
+		arg, err := convertArg(args[i].Type, v, resolve)
+		if err != nil {
+			name := args[i].Name
+			if len(name) == 0 {
+				name = fmt.Sprint(i)
+			}
+			err = fmt.Errorf("argument %s: %v", name, err)
+			return nil, err
+		}
+		converted[i] = arg
+	}
+	return converted, nil
+}
+
+// convertArg converts the JSON value into the Go value of the ABI type. Numbers may be
+// given as JSON numbers or as decimal and 0x-prefixed hex strings, bytes as hex strings,
+// tuples as objects keyed by the component names. Addresses may reference deployments
+// as "$Name", strings starting with "$" are escaped as "$$".
+func convertArg(t abi.Type, v interface{}, resolve resolveFunc) (interface{}, error) {
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		n, err := convertInt(v)
+		if err != nil {
+			return nil, err
+		}
+		if t.T == abi.UintTy && n.Sign() < 0 {
+			err := fmt.Errorf("negative value %s for %s", n, t)
+			return nil, err
+		}
+		bits := t.Size
+		magnitude := n
+		if t.T == abi.IntTy {
+			bits--
+			if n.Sign() < 0 {
+				// -2^(bits) is the minimum
+				magnitude = new(big.Int).Not(n)
+			}
+		}
+		if magnitude.BitLen() > bits {
+			err := fmt.Errorf("value %s overflows %s", n, t)
+			return nil, err
+		}
+		if t.GetType() == bigT {
+			return n, nil
+		}
+		val := reflect.New(t.GetType()).Elem()
+		if t.T == abi.UintTy {
+			val.SetUint(n.Uint64())
+		} else {
+			val.SetInt(n.Int64())
+		}
+		return val.Interface(), nil
+	case abi.BoolTy:
+		b, ok := v.(bool)
+		if !ok {
+			err := fmt.Errorf("expected bool, got %v", v)
+			return nil, err
+		}
+		return b, nil
+	case abi.StringTy:
+		s, ok := v.(string)
+		if !ok {
+			err := fmt.Errorf("expected string, got %v", v)
+			return nil, err
+		}
+		if strings.HasPrefix(s, "$$") {
+			s = s[1:]
+		}
+		return s, nil
+	case abi.AddressTy:
+		s, ok := v.(string)
+		if !ok {
+			err := fmt.Errorf("expected address, got %v", v)
+			return nil, err
+		}
+		if strings.HasPrefix(s, "$") {
+			return resolve(s[1:])
+		} else if !common.IsHexAddress(s) {
+			err := fmt.Errorf("invalid address %s", s)
+			return nil, err
+		}
+		return common.HexToAddress(s), nil
+	case abi.BytesTy, abi.FixedBytesTy:
+		s, ok := v.(string)
+		if !ok {
+			err := fmt.Errorf("expected hex bytes, got %v", v)
+			return nil, err
+		}
+		data, err := hexutil.Decode(s)
+		if err != nil {
+			err = fmt.Errorf("invalid bytes %s: %v", s, err)
+			return nil, err
+		}
+		if t.T == abi.BytesTy {
+			return data, nil
+		} else if len(data) != t.Size {
+			err := fmt.Errorf("expected %d bytes for %s, got %d", t.Size, t, len(data))
+			return nil, err
+		}
+		val := reflect.New(t.GetType()).Elem()
+		reflect.Copy(val, reflect.ValueOf(data))
+		return val.Interface(), nil
+	case abi.SliceTy, abi.ArrayTy:
+		items, ok := v.([]interface{})
+		if !ok {
+			err := fmt.Errorf("expected array, got %v", v)
+			return nil, err
+		}
+		var val reflect.Value
+		if t.T == abi.SliceTy {
+			val = reflect.MakeSlice(t.GetType(), len(items), len(items))
+		} else if len(items) != t.Size {
+			err := fmt.Errorf("expected %d items for %s, got %d", t.Size, t, len(items))
+			return nil, err
+		} else {
+			val = reflect.New(t.GetType()).Elem()
+		}
+		for i, item := range items {
+			elem, err := convertArg(*t.Elem, item, resolve)
+			if err != nil {
+				err = fmt.Errorf("item %d: %v", i, err)
+				return nil, err
+			}
+			val.Index(i).Set(reflect.ValueOf(elem))
+		}
+		return val.Interface(), nil
+	case abi.TupleTy:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("expected object, got %v", v)
+			return nil, err
+		}
+		val := reflect.New(t.GetType()).Elem()
+		for i, name := range t.TupleRawNames {
+			field, ok := fields[name]
+			if !ok {
+				err := fmt.Errorf("missing tuple component %s", name)
+				return nil, err
+			}
+			elem, err := convertArg(*t.TupleElems[i], field, resolve)
+			if err != nil {
+				err = fmt.Errorf("component %s: %v", name, err)
+				return nil, err
+			}
+			val.FieldByName(abi.ToCamelCase(name)).Set(reflect.ValueOf(elem))
+		}
+		return val.Interface(), nil
+	default:
+		err := fmt.Errorf("unsupported type %s", t)
+		return nil, err
+	}
+}
+
+func convertInt(v interface{}) (*big.Int, error) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		err := fmt.Errorf("expected number, got %v", v)
+		return nil, err
+	}
+	// the hex numbers are 0x-prefixed, others are decimal, without the other Go prefixes
+	digits, base := strings.TrimPrefix(s, "-"), 10
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		digits, base = digits[2:], 16
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if ok && strings.HasPrefix(s, "-") {
+		n.Neg(n)
+	}
+	if !ok || strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		err := fmt.Errorf("invalid number %s", s)
+		return nil, err
+	}
+	return n, nil
+}
+
+// references returns the names of the deployments referenced in the JSON values,
+// skipping the escaped "$$" strings.
+func references(values []json.RawMessage) []string {
+	var names []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+				names = append(names, v[1:])
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	for _, raw := range values {
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err == nil {
+			walk(v)
+		}
+	}
+	return names
+}
@@ -0,0 +1,470 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// Action is the kind of a deployment step.
+type Action string
+
+const (
+	ActionDeploy Action = "deploy"
+	ActionSkip   Action = "skip"
+	ActionCall   Action = "call"
+)
+
+// Step is an action taken, or planned in the dry run, for a contract of the manifest.
+type Step struct {
+	Action Action
+	// Name is the deployment name, the called one for calls.
+	Name string
+	// Contract is the fully qualified name of the source contract.
+	Contract string
+	// Address is the deployed or called address, predicted from the nonce in the dry run.
+	Address common.Address
+	// Method is set for calls, the skipped calls included.
+	Method string
+	Args   []interface{}
+	// Tx is nil for the skipped steps and in the dry run.
+	Tx *types.Transaction
+}
+
+func (s *Step) String() string {
+	args := make([]string, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = formatArg(arg)
+	}
+	switch {
+	case len(s.Method) > 0 && s.Action == ActionSkip:
+		return fmt.Sprintf("skip %s.%s(%s), already called", s.Name, s.Method, strings.Join(args, ", "))
+	case len(s.Method) > 0:
+		return fmt.Sprintf("call %s.%s(%s) at %s", s.Name, s.Method, strings.Join(args, ", "), s.Address.Hex())
+	case s.Action == ActionSkip:
+		return fmt.Sprintf("skip %s (%s), deployed at %s", s.Name, s.Contract, s.Address.Hex())
+	default:
+		return fmt.Sprintf("deploy %s (%s) at %s(%s)", s.Name, s.Contract, s.Address.Hex(), strings.Join(args, ", "))
+	}
+}
+
+func formatArg(arg interface{}) string {
+	switch arg := arg.(type) {
+	case common.Address:
+		return arg.Hex()
+	case []byte:
+		return hexutil.Encode(arg)
+	case string:
+		return fmt.Sprintf("%q", arg)
+	default:
+		return fmt.Sprint(arg)
+	}
+}
+
+// Backend is the node the contracts are deployed to, *ethclient.Client
+// or ethfw.FailoverClient among others.
+type Backend interface {
+	ethfw.Backend
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// Deployer executes the manifests. The contracts already deployed with the same bytecode
+// and constructor arguments, and with the runtime code still at their addresses, are
+// skipped, as well as the calls already made to the same addresses with the same input.
+// Every contract is deployed after the ones it references, the calls are made after all
+// the contracts are deployed, in the manifest order. Each transaction is waited for
+// to be mined before the next one is sent.
+type Deployer struct {
+	Client Backend
+	Opts   *bind.TransactOpts
+	// Sources are the contracts to deploy, keyed like the results of sol.CompileProject.
+	Sources map[string]*sol.Contract
+	// ChainID keys the deployments, the chain id of the client by default.
+	ChainID string
+	// Deployments are updated with the results, unless in the dry run.
+	// Loaded from the DeploymentsFile if nil.
+	Deployments Deployments
+	// DeploymentsFile is the file the deployments are saved to after every step, if set.
+	DeploymentsFile string
+	// DryRun plans the steps without sending any transactions.
+	DryRun bool
+	// Log receives the steps as they are taken, if set.
+	Log io.Writer
+}
+
+// Run executes the manifest and returns the steps taken.
+func (d *Deployer) Run(ctx context.Context, m *Manifest) ([]*Step, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if d.Client == nil || d.Opts == nil {
+		err := errors.New("deployer needs a client and transact opts")
+		return nil, err
+	}
+	if len(d.ChainID) == 0 {
+		id, err := d.Client.ChainID(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to get chain id: %v", err)
+			return nil, err
+		}
+		d.ChainID = id.String()
+	}
+	if d.Deployments == nil && len(d.DeploymentsFile) > 0 {
+		// the file would be overwritten by the first save otherwise
+		deployments, err := LoadDeployments(d.DeploymentsFile)
+		if err != nil {
+			return nil, err
+		}
+		d.Deployments = deployments
+	} else if d.Deployments == nil {
+		d.Deployments = make(Deployments)
+	}
+	r := &run{
+		Deployer:  d,
+		ctx:       ctx,
+		chain:     d.Deployments.Chain(d.ChainID),
+		sources:   make(map[string]*sol.Contract),
+		addresses: make(map[string]common.Address),
+	}
+	r.opts = *d.Opts
+	r.opts.Context = ctx
+	if d.DryRun {
+		// the plan must not be recorded
+		r.chain = copyChain(r.chain)
+		if d.Opts.Nonce != nil {
+			r.nonce = d.Opts.Nonce.Uint64()
+		} else {
+			nonce, err := d.Client.PendingNonceAt(ctx, d.Opts.From)
+			if err != nil {
+				err = fmt.Errorf("failed to get nonce: %v", err)
+				return nil, err
+			}
+			r.nonce = nonce
+		}
+	}
+	for _, spec := range m.Contracts {
+		src, err := d.source(spec.source())
+		if err != nil {
+			err = fmt.Errorf("%s: %v", spec.Name, err)
+			return nil, err
+		}
+		r.sources[spec.Name] = src
+	}
+	ordered, err := m.order(r.libraries)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range ordered {
+		if err := r.deploy(spec); err != nil {
+			err = fmt.Errorf("%s: %v", spec.Name, err)
+			return r.steps, err
+		}
+	}
+	for _, spec := range m.Contracts {
+		for _, call := range spec.Calls {
+			if err := r.call(spec, call); err != nil {
+				err = fmt.Errorf("%s: %s: %v", spec.Name, call.Method, err)
+				return r.steps, err
+			}
+		}
+	}
+	return r.steps, nil
+}
+
+// source finds the contract by the key, by the fully qualified name or by the name.
+func (d *Deployer) source(name string) (*sol.Contract, error) {
+	if src, ok := d.Sources[name]; ok {
+		return src, nil
+	}
+	var found []string
+	for key, src := range d.Sources {
+		if qualifiedName(src) == name || src.Name == name {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		err := fmt.Errorf("contract %s not found", name)
+		return nil, err
+	case 1:
+		return d.Sources[found[0]], nil
+	default:
+		sort.Strings(found)
+		err := fmt.Errorf("contract name %s is ambiguous: %s", name, strings.Join(found, ", "))
+		return nil, err
+	}
+}
+
+func qualifiedName(src *sol.Contract) string {
+	if len(src.SourcePath) == 0 {
+		return src.Name
+	}
+	return src.SourcePath + ":" + src.Name
+}
+
+func copyChain(chain map[string]*Deployment) map[string]*Deployment {
+	copied := make(map[string]*Deployment, len(chain))
+	for name, dep := range chain {
+		copied[name] = dep
+	}
+	return copied
+}
+
+// run is the state of a Deployer run.
+type run struct {
+	*Deployer
+	ctx   context.Context
+	opts  bind.TransactOpts
+	chain map[string]*Deployment
+	// nonce is the next nonce in the dry run.
+	nonce     uint64
+	sources   map[string]*sol.Contract
+	addresses map[string]common.Address
+	steps     []*Step
+}
+
+// libraries returns the names of the deployments that provide the libraries of the contract.
+func (r *run) libraries(spec *ContractSpec) []string {
+	var names []string
+	for _, lib := range r.sources[spec.Name].Libraries() {
+		if name, ok := r.library(lib); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// library returns the name of the deployment of the library, given by the fully qualified name.
+func (r *run) library(lib string) (string, bool) {
+	var found []string
+	for name, src := range r.sources {
+		if qualifiedName(src) == lib {
+			return name, true
+		} else if !strings.Contains(lib, ":") && src.Name == lib {
+			found = append(found, name)
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+	return found[0], true
+}
+
+func (r *run) resolve(name string) (common.Address, error) {
+	addr, ok := r.addresses[name]
+	if !ok {
+		err := fmt.Errorf("contract %s is not deployed yet", name)
+		return common.Address{}, err
+	}
+	return addr, nil
+}
+
+func (r *run) step(step *Step) {
+	r.steps = append(r.steps, step)
+	if r.Log != nil {
+		fmt.Fprintln(r.Log, step)
+	}
+}
+
+func (r *run) save() error {
+	if r.DryRun || len(r.DeploymentsFile) == 0 {
+		return nil
+	}
+	if err := r.Deployments.Save(r.DeploymentsFile); err != nil {
+		err = fmt.Errorf("failed to save deployments: %v", err)
+		return err
+	}
+	return nil
+}
+
+// sent advances the nonce if it's set explicitly.
+func (r *run) sent(tx *types.Transaction) {
+	if r.opts.Nonce != nil {
+		r.opts.Nonce = new(big.Int).SetUint64(tx.Nonce() + 1)
+	}
+}
+
+// wait waits for the transaction to be mined successfully.
+func (r *run) wait(tx *types.Transaction) (*types.Receipt, error) {
+	receipt, err := bind.WaitMined(r.ctx, r.Client, tx)
+	if err != nil {
+		return nil, err
+	} else if receipt.Status == types.ReceiptStatusFailed {
+		err = fmt.Errorf("transaction %s failed", tx.Hash().Hex())
+		return nil, err
+	}
+	return receipt, nil
+}
+
+func (r *run) deploy(spec *ContractSpec) error {
+	src := r.sources[spec.Name]
+	if len(src.Bin) == 0 {
+		return errors.New("contract has no bytecode")
+	}
+	if src.NeedsLinking() {
+		libs := make(map[string]common.Address)
+		for _, lib := range src.Libraries() {
+			if name, ok := r.library(lib); ok {
+				libs[lib] = r.addresses[name]
+			}
+		}
+		linked, err := src.Link(libs)
+		if err != nil {
+			return err
+		}
+		src = linked
+	}
+	bound, err := ethfw.BindContract(r.Client, src)
+	if err != nil {
+		return err
+	}
+	args, err := convertArgs(bound.ABI().Constructor.Inputs, spec.Args, r.resolve)
+	if err != nil {
+		return err
+	}
+	initCode, err := ethfw.ContractDeployBin(src, args...)
+	if err != nil {
+		return err
+	}
+	initCodeHash := crypto.Keccak256Hash(initCode)
+	step := &Step{
+		Action:   ActionDeploy,
+		Name:     spec.Name,
+		Contract: qualifiedName(src),
+		Args:     args,
+	}
+	if dep, ok := r.chain[spec.Name]; ok && dep.InitCodeHash == initCodeHash {
+		code, err := r.Client.CodeAt(r.ctx, dep.Address, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to get code: %v", err)
+			return err
+		}
+		if len(code) > 0 && crypto.Keccak256Hash(code) == dep.CodeHash {
+			r.addresses[spec.Name] = dep.Address
+			step.Action = ActionSkip
+			step.Address = dep.Address
+			r.step(step)
+			return nil
+		}
+	}
+	if r.DryRun {
+		step.Address = crypto.CreateAddress(r.opts.From, r.nonce)
+		r.nonce++
+		r.addresses[spec.Name] = step.Address
+		// the calls of the redeployed contract are made again
+		r.chain[spec.Name] = &Deployment{
+			Address: step.Address,
+		}
+		r.step(step)
+		return nil
+	}
+	addr, tx, err := bound.DeployContract(&r.opts, args...)
+	if err != nil {
+		return err
+	}
+	r.sent(tx)
+	if _, err := r.wait(tx); err != nil {
+		return err
+	}
+	code, err := r.Client.CodeAt(r.ctx, addr, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to get code: %v", err)
+		return err
+	} else if len(code) == 0 {
+		return bind.ErrNoCodeAfterDeploy
+	}
+	r.addresses[spec.Name] = addr
+	r.chain[spec.Name] = &Deployment{
+		Contract:     qualifiedName(src),
+		Address:      addr,
+		InitCodeHash: initCodeHash,
+		CodeHash:     crypto.Keccak256Hash(code),
+		Transaction:  tx.Hash(),
+	}
+	step.Address = addr
+	step.Tx = tx
+	r.step(step)
+	return r.save()
+}
+
+func (r *run) call(spec *ContractSpec, call *CallSpec) error {
+	target := call.Target
+	if len(target) == 0 {
+		target = spec.Name
+	}
+	bound, err := ethfw.BindContract(r.Client, r.sources[target])
+	if err != nil {
+		return err
+	}
+	addr := r.addresses[target]
+	bound.SetAddress(addr)
+	method, ok := bound.ABI().Methods[call.Method]
+	if !ok {
+		err := fmt.Errorf("method not found in ABI of %s", target)
+		return err
+	}
+	args, err := convertArgs(method.Inputs, call.Args, r.resolve)
+	if err != nil {
+		return err
+	}
+	input, err := bound.ABI().Pack(call.Method, args...)
+	if err != nil {
+		return err
+	}
+	inputHash := crypto.Keccak256Hash(input)
+	step := &Step{
+		Action:  ActionCall,
+		Name:    target,
+		Address: addr,
+		Method:  call.Method,
+		Args:    args,
+	}
+	dep := r.chain[spec.Name]
+	for _, made := range dep.Calls {
+		if made.To == addr && made.Method == call.Method && made.InputHash == inputHash {
+			step.Action = ActionSkip
+			r.step(step)
+			return nil
+		}
+	}
+	if r.DryRun {
+		r.nonce++
+		r.step(step)
+		return nil
+	}
+	tx, err := bound.Transact(&r.opts, call.Method, args...)
+	if err != nil {
+		return err
+	}
+	r.sent(tx)
+	if _, err := r.wait(tx); err != nil {
+		return err
+	}
+	dep.Calls = append(dep.Calls, &DeploymentCall{
+		Target:      target,
+		To:          addr,
+		Method:      call.Method,
+		InputHash:   inputHash,
+		Transaction: tx.Hash(),
+	})
+	step.Tx = tx
+	r.step(step)
+	return r.save()
+}
@@ -0,0 +1,333 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+func testSources() map[string]*sol.Contract {
+	return map[string]*sol.Contract{
+		"contracts/Registry.sol:Registry": {
+			Name:       "Registry",
+			SourcePath: "contracts/Registry.sol",
+			ABI:        []byte(`[{"type":"function","name":"register","inputs":[{"name":"addr","type":"address"}],"outputs":[]}]`),
+			Bin:        "60806040",
+		},
+		"contracts/Token.sol:Token": {
+			Name:       "Token",
+			SourcePath: "contracts/Token.sol",
+			ABI: []byte(`[
+				{"type":"constructor","inputs":[{"name":"name","type":"string"},{"name":"decimals","type":"uint8"},{"name":"registry","type":"address"}]},
+				{"type":"function","name":"setMinter","inputs":[{"name":"minter","type":"address"}],"outputs":[]}
+			]`),
+			Bin: "60806041",
+		},
+		"contracts/Math.sol:Math": {
+			Name:       "Math",
+			SourcePath: "contracts/Math.sol",
+			ABI:        []byte(`[]`),
+			Bin:        "60806042",
+		},
+		"contracts/Counter.sol:Counter": {
+			Name:       "Counter",
+			SourcePath: "contracts/Counter.sol",
+			ABI:        []byte(`[]`),
+			Bin:        "6080" + sol.LibraryPlaceholder("contracts/Math.sol:Math") + "6043",
+			LinkReferences: map[string][]sol.LinkReference{
+				"contracts/Math.sol:Math": {{Start: 2, Length: 20}},
+			},
+		},
+	}
+}
+
+const testManifest = `{
+	"contracts": [
+		{"name": "Token", "args": ["Token", 18, "$Registry"], "calls": [
+			{"target": "Registry", "method": "register", "args": ["$Token"]},
+			{"method": "setMinter", "args": ["0x00000000000000000000000000000000000000aa"]}
+		]},
+		{"name": "Registry", "contract": "contracts/Registry.sol:Registry"},
+		{"name": "Counter"},
+		{"name": "Math"}
+	]
+}`
+
+func actions(steps []*Step) []string {
+	var actions []string
+	for _, step := range steps {
+		if len(step.Method) > 0 {
+			actions = append(actions, string(step.Action)+" "+step.Name+"."+step.Method)
+		} else {
+			actions = append(actions, string(step.Action)+" "+step.Name)
+		}
+	}
+	return actions
+}
+
+// sentTo returns the data of the transactions sent to the address.
+func sentTo(node *rpctest.MockNode, to common.Address) [][]byte {
+	var calls [][]byte
+	for _, tx := range node.Transactions() {
+		if tx.To() != nil && *tx.To() == to {
+			calls = append(calls, tx.Data())
+		}
+	}
+	return calls
+}
+
+func TestDeployer(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "deploy")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	deploymentsFile := filepath.Join(dir, "deployments.json")
+
+	node := rpctest.NewMockNode()
+	defer node.Close()
+	node.SetAutoMine(true)
+	// differs from the chain id, the deployments are keyed by the latter
+	node.SetResult("net_version", "1")
+	client, err := ethclient.Dial(node.URL())
+	require.NoError(err)
+	defer client.Close()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	ctx := context.Background()
+
+	m, err := ParseManifest([]byte(testManifest))
+	require.NoError(err)
+	newDeployer := func(dryRun bool) *Deployer {
+		deployments, err := LoadDeployments(deploymentsFile)
+		require.NoError(err)
+		return &Deployer{
+			Client:          client,
+			Opts:            bind.NewKeyedTransactor(key),
+			Sources:         testSources(),
+			Deployments:     deployments,
+			DeploymentsFile: deploymentsFile,
+			DryRun:          dryRun,
+		}
+	}
+
+	// the first run deploys everything
+	d := newDeployer(false)
+	log := new(bytes.Buffer)
+	d.Log = log
+	steps, err := d.Run(ctx, m)
+	require.NoError(err)
+	require.Equal([]string{
+		"deploy Registry",
+		"deploy Token",
+		"deploy Math",
+		"deploy Counter",
+		"call Registry.register",
+		"call Token.setMinter",
+	}, actions(steps))
+	require.Equal("1337", d.ChainID)
+	require.Len(node.Transactions(), 6)
+	require.Len(strings.Split(strings.TrimSpace(log.String()), "\n"), 6)
+	require.Contains(log.String(), `deploy Token (contracts/Token.sol:Token) at `+steps[1].Address.Hex()+`("Token", 18, `+steps[0].Address.Hex()+`)`)
+
+	deployments, err := LoadDeployments(deploymentsFile)
+	require.NoError(err)
+	token := deployments["1337"]["Token"]
+	require.Equal(steps[1].Address, token.Address)
+	require.Equal("contracts/Token.sol:Token", token.Contract)
+	require.Equal(steps[1].Tx.Hash(), token.Transaction)
+	require.Len(token.Calls, 2)
+	require.Equal(steps[0].Address, token.Calls[0].To)
+
+	// the token was deployed with the registry address, the registry got the token address
+	tokenABI, err := abi.JSON(bytes.NewReader(testSources()["contracts/Token.sol:Token"].ABI))
+	require.NoError(err)
+	args, err := tokenABI.Constructor.Inputs.Pack("Token", uint8(18), steps[0].Address)
+	require.NoError(err)
+	require.Equal(crypto.Keccak256Hash(common.FromHex("60806041"), args), token.InitCodeHash)
+	registryCalls := sentTo(node, steps[0].Address)
+	require.Len(registryCalls, 1)
+	require.Equal(common.LeftPadBytes(steps[1].Address.Bytes(), 32), registryCalls[0][4:])
+	// the counter is linked with the math library
+	counter := deployments["1337"]["Counter"]
+	require.Equal(
+		crypto.Keccak256Hash(common.FromHex("6080"+strings.ToLower(steps[2].Address.Hex()[2:])+"6043")),
+		counter.InitCodeHash,
+	)
+
+	// the rerun skips everything, the deployments are loaded from the file
+	d = newDeployer(false)
+	d.Deployments = nil
+	steps, err = d.Run(ctx, m)
+	require.NoError(err)
+	require.Equal([]string{
+		"skip Registry",
+		"skip Token",
+		"skip Math",
+		"skip Counter",
+		"skip Registry.register",
+		"skip Token.setMinter",
+	}, actions(steps))
+	require.Len(node.Transactions(), 6)
+
+	// the changed token is redeployed and its calls are made again
+	m.Contracts[0].Args[1] = json.RawMessage(`"6"`)
+	steps, err = newDeployer(false).Run(ctx, m)
+	require.NoError(err)
+	require.Equal([]string{
+		"skip Registry",
+		"deploy Token",
+		"skip Math",
+		"skip Counter",
+		"call Registry.register",
+		"call Token.setMinter",
+	}, actions(steps))
+	require.Len(node.Transactions(), 9)
+	require.Len(sentTo(node, deployments["1337"]["Registry"].Address), 2)
+
+	// the dry run plans the deployment of the lost registry and of the token referencing it
+	node.SetCode(deployments["1337"]["Registry"].Address, nil)
+	d = newDeployer(true)
+	steps, err = d.Run(ctx, m)
+	require.NoError(err)
+	require.Equal([]string{
+		"deploy Registry",
+		"deploy Token",
+		"skip Math",
+		"skip Counter",
+		"call Registry.register",
+		"call Token.setMinter",
+	}, actions(steps))
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	require.Equal(crypto.CreateAddress(from, 9), steps[0].Address)
+	require.Equal(crypto.CreateAddress(from, 10), steps[1].Address)
+	require.Len(node.Transactions(), 9)
+	saved, err := LoadDeployments(deploymentsFile)
+	require.NoError(err)
+	require.Equal(deployments["1337"]["Registry"], saved["1337"]["Registry"])
+	require.Equal(d.Deployments, saved)
+}
+
+func TestManifestValidate(t *testing.T) {
+	require := require.New(t)
+	for manifest, msg := range map[string]string{
+		`{"contracts": []}`:                                                            "manifest has no contracts",
+		`{"contracts": [{"name": "A"}, {"name": "A"}]}`:                                "duplicate contract name A",
+		`{"contracts": [{"name": "A", "args": ["$B"]}]}`:                               "A references unknown contract B",
+		`{"contracts": [{"name": "A", "calls": [{"method": "f", "target": "B"}]}]}`:    "A calls unknown contract B",
+		`{"contracts": [{"name": "A", "calls": [{"args": []}]}]}`:                      "A has a call without a method",
+		`{"contracts": [{"name": "A", "calls": [{"method": "f", "args": [["$C"]]}]}]}`: "A.f references unknown contract C",
+		`{"contracts": [{"name": "$A"}]}`:                                              `invalid contract name "$A"`,
+	} {
+		_, err := ParseManifest([]byte(manifest))
+		require.EqualError(err, msg, manifest)
+	}
+	// the escaped strings aren't references
+	_, err := ParseManifest([]byte(`{"contracts": [{"name": "A", "args": ["$$USD", ["$$A"]]}]}`))
+	require.NoError(err)
+
+	m, err := ParseManifest([]byte(`{"contracts": [
+		{"name": "A", "args": ["$B"]},
+		{"name": "B", "args": [["$C"]]},
+		{"name": "C", "args": [{"a": "$A"}]}
+	]}`))
+	require.NoError(err)
+	_, err = m.order(func(*ContractSpec) []string { return nil })
+	require.EqualError(err, "dependency cycle: A -> B -> C -> A")
+}
+
+func TestConvertArgs(t *testing.T) {
+	require := require.New(t)
+	parsed, err := abi.JSON(strings.NewReader(`[{"type":"function","name":"f","inputs":[
+		{"name":"a","type":"uint8"},
+		{"name":"b","type":"int256"},
+		{"name":"c","type":"address[]"},
+		{"name":"d","type":"bytes32"},
+		{"name":"e","type":"bytes"},
+		{"name":"f","type":"bool[2]"},
+		{"name":"g","type":"tuple","components":[{"name":"id","type":"uint64"},{"name":"label","type":"string"}]}
+	],"outputs":[]}]`))
+	require.NoError(err)
+	registry := common.HexToAddress("0x1234")
+	resolve := func(name string) (common.Address, error) {
+		require.Equal("Registry", name)
+		return registry, nil
+	}
+	raw := func(values ...string) []json.RawMessage {
+		msgs := make([]json.RawMessage, len(values))
+		for i, v := range values {
+			msgs[i] = json.RawMessage(v)
+		}
+		return msgs
+	}
+	args, err := convertArgs(parsed.Methods["f"].Inputs, raw(
+		`255`,
+		`"-0x10"`,
+		`["$Registry", "0x00000000000000000000000000000000000000aa"]`,
+		`"0x`+strings.Repeat("01", 32)+`"`,
+		`"0xdead"`,
+		`[true, false]`,
+		`{"id": 7, "label": "seven"}`,
+	), resolve)
+	require.NoError(err)
+	require.Equal(uint8(255), args[0])
+	require.Equal(big.NewInt(-16), args[1])
+	require.Equal([]common.Address{registry, common.HexToAddress("0xaa")}, args[2])
+	require.Equal([2]bool{true, false}, args[5])
+	_, err = parsed.Pack("f", args...)
+	require.NoError(err)
+
+	// the leading zeros don't make octal numbers, the escaped strings are unescaped
+	stringT, err := abi.NewType("string", "", nil)
+	require.NoError(err)
+	uintT, err := abi.NewType("uint256", "", nil)
+	require.NoError(err)
+	args, err = convertArgs(abi.Arguments{{Type: uintT}, {Type: stringT}}, raw(`"010"`, `"$$USD"`), resolve)
+	require.NoError(err)
+	require.Equal(big.NewInt(10), args[0])
+	require.Equal("$USD", args[1])
+
+	for _, tc := range []struct {
+		typ, value, msg string
+	}{
+		{"uint8", `256`, "argument x: value 256 overflows uint8"},
+		{"int8", `-129`, "argument x: value -129 overflows int8"},
+		{"int8", `-128`, ""},
+		{"uint256", `-1`, "argument x: negative value -1 for uint256"},
+		{"address", `"0x12"`, "argument x: invalid address 0x12"},
+		{"bytes4", `"0x1234"`, "argument x: expected 4 bytes for bytes4, got 2"},
+		{"bool[2]", `[true]`, "argument x: expected 2 items for bool[2], got 1"},
+		{"string", `1`, "argument x: expected string, got 1"},
+		{"uint256", `"0b1"`, "argument x: invalid number 0b1"},
+		{"uint256", `"1_000"`, "argument x: invalid number 1_000"},
+		{"int256", `"--1"`, "argument x: invalid number --1"},
+	} {
+		typ, err := abi.NewType(tc.typ, "", nil)
+		require.NoError(err)
+		_, err = convertArgs(abi.Arguments{{Name: "x", Type: typ}}, raw(tc.value), resolve)
+		if len(tc.msg) == 0 {
+			require.NoError(err)
+		} else {
+			require.EqualError(err, tc.msg)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Deployments are the deployed contracts, keyed by chain id and by name.
+type Deployments map[string]map[string]*Deployment
+
+// Deployment is a deployed contract of the manifest.
+type Deployment struct {
+	// Contract is the fully qualified name of the source contract (path:Name).
+	Contract string         `json:"contract"`
+	Address  common.Address `json:"address"`
+	// InitCodeHash is the hash of the linked bytecode with the constructor arguments.
+	InitCodeHash common.Hash `json:"initCodeHash"`
+	// CodeHash is the hash of the runtime code at the address.
+	CodeHash    common.Hash       `json:"codeHash"`
+	Transaction common.Hash       `json:"transactionHash"`
+	Calls       []*DeploymentCall `json:"calls,omitempty"`
+}
+
+// DeploymentCall is a post-deploy call made to a contract.
+type DeploymentCall struct {
+	Target string         `json:"target"`
+	To     common.Address `json:"to"`
+	Method string         `json:"method"`
+	// InputHash is the hash of the calldata.
+	InputHash   common.Hash `json:"inputHash"`
+	Transaction common.Hash `json:"transactionHash"`
+}
+
+// LoadDeployments reads the deployments file, a missing file has no deployments.
+func LoadDeployments(path string) (Deployments, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(Deployments), nil
+	} else if err != nil {
+		return nil, err
+	}
+	d := make(Deployments)
+	if err := json.Unmarshal(data, &d); err != nil {
+		err = fmt.Errorf("failed to parse deployments %s: %v", path, err)
+		return nil, err
+	}
+	return d, nil
+}
+
+// Save writes the deployments file, replacing it atomically.
+func (d Deployments) Save(path string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".deployments-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Chain returns the deployments on the chain, creating them if missing.
+func (d Deployments) Chain(chainID string) map[string]*Deployment {
+	chain, ok := d[chainID]
+	if !ok {
+		chain = make(map[string]*Deployment)
+		d[chainID] = chain
+	}
+	return chain
+}
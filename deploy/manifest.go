@@ -0,0 +1,169 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package deploy deploys systems of contracts described by manifests, recording the
+// deployments per chain, so that the reruns deploy only what has changed.
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Manifest describes the contracts to deploy and the calls to make after the deployment.
+//
+//	{
+//	  "contracts": [
+//	    {"name": "Registry", "contract": "contracts/Registry.sol:Registry"},
+//	    {"name": "Token", "args": ["Token", 18, "$Registry"], "calls": [
+//	      {"target": "Registry", "method": "register", "args": ["$Token"]}
+//	    ]}
+//	  ]
+//	}
+//
+// The address arguments may reference other deployments of the manifest as "$Name",
+// the other strings starting with "$" must be escaped as "$$", like "$$USD" for "$USD".
+type Manifest struct {
+	Contracts []*ContractSpec `json:"contracts"`
+}
+
+// ContractSpec is a contract deployment of the manifest.
+type ContractSpec struct {
+	// Name identifies the deployment in the manifest and in the deployments file.
+	Name string `json:"name"`
+	// Contract is the source contract, by fully qualified name (path:Name) or by name.
+	// Defaults to Name.
+	Contract string `json:"contract,omitempty"`
+	// Args are the constructor arguments.
+	Args []json.RawMessage `json:"args,omitempty"`
+	// Calls are made once the contracts are deployed.
+	Calls []*CallSpec `json:"calls,omitempty"`
+}
+
+// CallSpec is a transaction sent to a deployed contract.
+type CallSpec struct {
+	// Target is the name of the called deployment, the contract itself by default.
+	Target string            `json:"target,omitempty"`
+	Method string            `json:"method"`
+	Args   []json.RawMessage `json:"args,omitempty"`
+}
+
+// LoadManifest reads and validates the JSON manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(data)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", path, err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParseManifest parses and validates the JSON manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		err = fmt.Errorf("failed to parse manifest: %v", err)
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks that the deployment names are unique and the references are known.
+func (m *Manifest) Validate() error {
+	if len(m.Contracts) == 0 {
+		return errors.New("manifest has no contracts")
+	}
+	names := make(map[string]bool, len(m.Contracts))
+	for _, spec := range m.Contracts {
+		if len(spec.Name) == 0 || strings.HasPrefix(spec.Name, "$") {
+			err := fmt.Errorf("invalid contract name %q", spec.Name)
+			return err
+		} else if names[spec.Name] {
+			err := fmt.Errorf("duplicate contract name %s", spec.Name)
+			return err
+		}
+		names[spec.Name] = true
+	}
+	for _, spec := range m.Contracts {
+		for _, ref := range references(spec.Args) {
+			if !names[ref] {
+				err := fmt.Errorf("%s references unknown contract %s", spec.Name, ref)
+				return err
+			}
+		}
+		for _, call := range spec.Calls {
+			if len(call.Method) == 0 {
+				err := fmt.Errorf("%s has a call without a method", spec.Name)
+				return err
+			} else if len(call.Target) > 0 && !names[call.Target] {
+				err := fmt.Errorf("%s calls unknown contract %s", spec.Name, call.Target)
+				return err
+			}
+			for _, ref := range references(call.Args) {
+				if !names[ref] {
+					err := fmt.Errorf("%s.%s references unknown contract %s", spec.Name, call.Method, ref)
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// source returns the name of the source contract.
+func (s *ContractSpec) source() string {
+	if len(s.Contract) == 0 {
+		return s.Name
+	}
+	return s.Contract
+}
+
+// order returns the contracts in the deployment order: every contract goes after the ones
+// it references, otherwise the manifest order is kept. The libraries are references
+// of the contracts that use them.
+func (m *Manifest) order(libraries func(spec *ContractSpec) []string) ([]*ContractSpec, error) {
+	byName := make(map[string]*ContractSpec, len(m.Contracts))
+	for _, spec := range m.Contracts {
+		byName[spec.Name] = spec
+	}
+	var ordered []*ContractSpec
+	done := make(map[string]bool)
+	var visit func(spec *ContractSpec, path []string) error
+	visit = func(spec *ContractSpec, path []string) error {
+		if done[spec.Name] {
+			return nil
+		}
+		for _, p := range path {
+			if p == spec.Name {
+				err := fmt.Errorf("dependency cycle: %s", strings.Join(append(path, spec.Name), " -> "))
+				return err
+			}
+		}
+		deps := append(references(spec.Args), libraries(spec)...)
+		for _, dep := range deps {
+			if err := visit(byName[dep], append(path, spec.Name)); err != nil {
+				return err
+			}
+		}
+		done[spec.Name] = true
+		ordered = append(ordered, spec)
+		return nil
+	}
+	for _, spec := range m.Contracts {
+		if err := visit(spec, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
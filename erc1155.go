@@ -0,0 +1,233 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// ERC1155ABI is the ABI of the ERC-1155 multi token contracts, with the metadata URI extension.
+const ERC1155ABI = `[
+	{"type":"function","name":"supportsInterface","inputs":[{"name":"interfaceId","type":"bytes4"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"view"},
+	{"type":"function","name":"uri","inputs":[{"name":"id","type":"uint256"}],"outputs":[{"name":"","type":"string"}],"stateMutability":"view"},
+	{"type":"function","name":"balanceOf","inputs":[{"name":"account","type":"address"},{"name":"id","type":"uint256"}],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+	{"type":"function","name":"balanceOfBatch","inputs":[{"name":"accounts","type":"address[]"},{"name":"ids","type":"uint256[]"}],"outputs":[{"name":"","type":"uint256[]"}],"stateMutability":"view"},
+	{"type":"function","name":"isApprovedForAll","inputs":[{"name":"account","type":"address"},{"name":"operator","type":"address"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"view"},
+	{"type":"function","name":"setApprovalForAll","inputs":[{"name":"operator","type":"address"},{"name":"approved","type":"bool"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"id","type":"uint256"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"function","name":"safeBatchTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"ids","type":"uint256[]"},{"name":"values","type":"uint256[]"},{"name":"data","type":"bytes"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"event","name":"TransferSingle","inputs":[{"name":"operator","type":"address","indexed":true},{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"id","type":"uint256","indexed":false},{"name":"value","type":"uint256","indexed":false}],"anonymous":false},
+	{"type":"event","name":"TransferBatch","inputs":[{"name":"operator","type":"address","indexed":true},{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"ids","type":"uint256[]","indexed":false},{"name":"values","type":"uint256[]","indexed":false}],"anonymous":false},
+	{"type":"event","name":"ApprovalForAll","inputs":[{"name":"account","type":"address","indexed":true},{"name":"operator","type":"address","indexed":true},{"name":"approved","type":"bool","indexed":false}],"anonymous":false},
+	{"type":"event","name":"URI","inputs":[{"name":"value","type":"string","indexed":false},{"name":"id","type":"uint256","indexed":true}],"anonymous":false}
+]`
+
+// ERC1155 is the client of an ERC-1155 multi token contract. The metadata is fetched with
+// HTTPMetadataFetcher unless another fetcher is set.
+type ERC1155 struct {
+	*BoundContract
+
+	fetcher MetadataFetcher
+}
+
+// NewERC1155 binds the token contract at the address.
+func NewERC1155(client Backend, address common.Address) (*ERC1155, error) {
+	bound, err := BindContract(client, &sol.Contract{
+		Name:    "ERC1155",
+		ABI:     []byte(ERC1155ABI),
+		Address: address,
+	})
+	if err != nil {
+		return nil, err
+	}
+	token := &ERC1155{
+		BoundContract: bound,
+		fetcher:       new(HTTPMetadataFetcher),
+	}
+	return token, nil
+}
+
+// SetMetadataFetcher replaces the fetcher of the token metadata, HTTPMetadataFetcher by default.
+func (t *ERC1155) SetMetadataFetcher(fetcher MetadataFetcher) {
+	t.fetcher = fetcher
+}
+
+// SupportsInterface detects the ERC-165 interface, e.g. InterfaceERC1155MetadataURI.
+func (t *ERC1155) SupportsInterface(ctx context.Context, id [4]byte) (bool, error) {
+	return supportsInterface(ctx, t.BoundContract, id)
+}
+
+// BalanceOf returns the amount of the token owned by the account.
+func (t *ERC1155) BalanceOf(ctx context.Context, account common.Address, id *big.Int) (*big.Int, error) {
+	var balance *big.Int
+	if err := t.CallInto(ctx, "balanceOf", &balance, account, id); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// BalanceOfBatch returns the balances of the accounts, each of the token with the same index.
+func (t *ERC1155) BalanceOfBatch(ctx context.Context, accounts []common.Address, ids []*big.Int) ([]*big.Int, error) {
+	if len(accounts) != len(ids) {
+		err := fmt.Errorf("%d accounts for %d token ids", len(accounts), len(ids))
+		return nil, err
+	}
+	var balances []*big.Int
+	if err := t.CallInto(ctx, "balanceOfBatch", &balances, accounts, ids); err != nil {
+		return nil, err
+	}
+	if len(balances) != len(ids) {
+		err := fmt.Errorf("%d balances returned for %d token ids", len(balances), len(ids))
+		return nil, err
+	}
+	return balances, nil
+}
+
+// IsApprovedForAll reports whether the operator may transfer all the account's tokens.
+func (t *ERC1155) IsApprovedForAll(ctx context.Context, account, operator common.Address) (bool, error) {
+	var approved bool
+	err := t.CallInto(ctx, "isApprovedForAll", &approved, account, operator)
+	return approved, err
+}
+
+// URI returns the metadata URI of the token, with the {id} placeholder substituted.
+func (t *ERC1155) URI(ctx context.Context, id *big.Int) (string, error) {
+	var uri string
+	if err := t.CallInto(ctx, "uri", &uri, id); err != nil {
+		return "", err
+	}
+	return strings.Replace(uri, "{id}", fmt.Sprintf("%064x", id), -1), nil
+}
+
+// Metadata fetches the metadata JSON at the token URI.
+func (t *ERC1155) Metadata(ctx context.Context, id *big.Int) (*TokenMetadata, error) {
+	uri, err := t.URI(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return fetchMetadata(ctx, t.fetcher, uri)
+}
+
+// SetApprovalForAll approves or revokes the operator to transfer all the sender's tokens.
+func (t *ERC1155) SetApprovalForAll(opts *bind.TransactOpts, operator common.Address, approved bool) (*types.Transaction, error) {
+	return t.Transact(opts, "setApprovalForAll", operator, approved)
+}
+
+// SafeTransferFrom transfers the amount of the token, the contract recipients must accept
+// it in onERC1155Received, which gets the data.
+func (t *ERC1155) SafeTransferFrom(opts *bind.TransactOpts,
+	from, to common.Address, id, amount *big.Int, data []byte) (*types.Transaction, error) {
+
+	if data == nil {
+		data = []byte{}
+	}
+	return t.Transact(opts, "safeTransferFrom", from, to, id, amount, data)
+}
+
+// SafeBatchTransferFrom transfers the amounts of the tokens, each of the token with the same
+// index, the contract recipients must accept them in onERC1155BatchReceived.
+func (t *ERC1155) SafeBatchTransferFrom(opts *bind.TransactOpts,
+	from, to common.Address, ids, amounts []*big.Int, data []byte) (*types.Transaction, error) {
+
+	if len(ids) != len(amounts) {
+		err := fmt.Errorf("%d amounts for %d token ids", len(amounts), len(ids))
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return t.Transact(opts, "safeBatchTransferFrom", from, to, ids, amounts, data)
+}
+
+// erc1155TransferSingle is the TransferSingle event of ERC-1155.
+type erc1155TransferSingle struct {
+	Operator common.Address
+	From     common.Address
+	To       common.Address
+	Id       *big.Int
+	Value    *big.Int
+}
+
+// erc1155TransferBatch is the TransferBatch event of ERC-1155.
+type erc1155TransferBatch struct {
+	Operator common.Address
+	From     common.Address
+	To       common.Address
+	Ids      []*big.Int
+	Values   []*big.Int
+}
+
+// TransferHistory returns the single and batch transfers of the token in the blocks of
+// the query, in order. The token id isn't indexed, so all the transfers of the contract
+// are queried. Holders of the transfers since the mint are the current holders.
+func (t *ERC1155) TransferHistory(ctx context.Context, id *big.Int, q EventQuery) ([]*TokenTransfer, error) {
+	singles, err := t.FilterEvents(ctx, "TransferSingle", q)
+	if err != nil {
+		return nil, err
+	}
+	batches, err := t.FilterEvents(ctx, "TransferBatch", q)
+	if err != nil {
+		return nil, err
+	}
+	logs := append(singles, batches...)
+	sortLogs(logs)
+	var transfers []*TokenTransfer
+	for _, log := range logs {
+		if log.Removed {
+			continue
+		}
+		transfer := &TokenTransfer{
+			TokenID:     id,
+			BlockNumber: log.BlockNumber,
+			TxHash:      log.TxHash,
+			LogIndex:    log.Index,
+		}
+		if log.Topics[0] == t.abi.Events["TransferSingle"].ID {
+			var ev erc1155TransferSingle
+			if err := t.DecodeEvent("TransferSingle", log, &ev); err != nil {
+				err = fmt.Errorf("failed to decode transfer in %s: %v", log.TxHash.Hex(), err)
+				return nil, err
+			}
+			if ev.Id.Cmp(id) != 0 {
+				continue
+			}
+			transfer.Operator, transfer.From, transfer.To = ev.Operator, ev.From, ev.To
+			transfer.Amount = ev.Value
+			transfers = append(transfers, transfer)
+			continue
+		}
+		var ev erc1155TransferBatch
+		if err := t.DecodeEvent("TransferBatch", log, &ev); err != nil {
+			err = fmt.Errorf("failed to decode batch transfer in %s: %v", log.TxHash.Hex(), err)
+			return nil, err
+		}
+		if len(ev.Ids) != len(ev.Values) {
+			err = fmt.Errorf("batch transfer in %s has %d values for %d ids", log.TxHash.Hex(), len(ev.Values), len(ev.Ids))
+			return nil, err
+		}
+		amount := new(big.Int)
+		for i, batchID := range ev.Ids {
+			if batchID.Cmp(id) == 0 {
+				amount.Add(amount, ev.Values[i])
+			}
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+		transfer.Operator, transfer.From, transfer.To = ev.Operator, ev.From, ev.To
+		transfer.Amount = amount
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+func TestERC1155(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	node.SetCode(nftTestAddress, []byte{0})
+	handleNFTCalls(t, node, ERC1155ABI, func(name string, args []interface{}) ([]interface{}, error) {
+		switch name {
+		case "supportsInterface":
+			id := args[0].([4]byte)
+			return []interface{}{id == InterfaceERC165 || id == InterfaceERC1155 || id == InterfaceERC1155MetadataURI}, nil
+		case "balanceOf":
+			return []interface{}{new(big.Int).Mul(args[1].(*big.Int), big.NewInt(10))}, nil
+		case "balanceOfBatch":
+			var balances []*big.Int
+			for _, id := range args[1].([]*big.Int) {
+				balances = append(balances, new(big.Int).Mul(id, big.NewInt(10)))
+			}
+			return []interface{}{balances}, nil
+		case "isApprovedForAll":
+			return []interface{}{args[1].(common.Address) == nftTestBob}, nil
+		case "uri":
+			return []interface{}{`data:application/json,{"name":"{id}"}`}, nil
+		}
+		return nil, errors.New("execution reverted")
+	})
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	token, err := NewERC1155(client, nftTestAddress)
+	require.NoError(err)
+
+	for id, want := range map[[4]byte]bool{
+		InterfaceERC1155:            true,
+		InterfaceERC1155MetadataURI: true,
+		InterfaceERC721:             false,
+	} {
+		supported, err := token.SupportsInterface(ctx, id)
+		require.NoError(err)
+		require.Equal(want, supported, "interface %x", id)
+	}
+	balance, err := token.BalanceOf(ctx, nftTestAlice, big.NewInt(3))
+	require.NoError(err)
+	require.Equal(int64(30), balance.Int64())
+	balances, err := token.BalanceOfBatch(ctx,
+		[]common.Address{nftTestAlice, nftTestBob}, []*big.Int{big.NewInt(1), big.NewInt(2)})
+	require.NoError(err)
+	require.Equal([]*big.Int{big.NewInt(10), big.NewInt(20)}, balances)
+	_, err = token.BalanceOfBatch(ctx, []common.Address{nftTestAlice}, []*big.Int{big.NewInt(1), big.NewInt(2)})
+	require.EqualError(err, "1 accounts for 2 token ids")
+	approved, err := token.IsApprovedForAll(ctx, nftTestAlice, nftTestBob)
+	require.NoError(err)
+	require.True(approved)
+
+	uri, err := token.URI(ctx, big.NewInt(0x4cce))
+	require.NoError(err)
+	require.Equal(`data:application/json,{"name":"0000000000000000000000000000000000000000000000000000000000004cce"}`, uri)
+	meta, err := token.Metadata(ctx, big.NewInt(1))
+	require.NoError(err)
+	require.Equal(strings.Repeat("0", 63)+"1", meta.Name)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	opts := &bind.TransactOpts{From: from, Signer: keys.SignerFn(from, "")}
+	_, err = token.SafeTransferFrom(opts, from, nftTestBob, big.NewInt(1), big.NewInt(5), nil)
+	require.NoError(err)
+	_, err = token.SafeBatchTransferFrom(opts, from, nftTestBob,
+		[]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(5), big.NewInt(6)}, nil)
+	require.NoError(err)
+	_, err = token.SafeBatchTransferFrom(opts, from, nftTestBob, []*big.Int{big.NewInt(1)}, nil, nil)
+	require.EqualError(err, "0 amounts for 1 token ids")
+	sent := node.Transactions()
+	require.Len(sent, 2)
+	parsed, err := abi.JSON(strings.NewReader(ERC1155ABI))
+	require.NoError(err)
+	args, err := parsed.Methods["safeBatchTransferFrom"].Inputs.Unpack(sent[1].Data()[4:])
+	require.NoError(err)
+	require.Equal([]interface{}{
+		from, nftTestBob,
+		[]*big.Int{big.NewInt(1), big.NewInt(2)},
+		[]*big.Int{big.NewInt(5), big.NewInt(6)},
+		[]byte{},
+	}, args)
+}
+
+func TestERC1155TransferHistory(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	carol := common.HexToAddress("0x4000000000000000000000000000000000000003")
+	zero := common.Address{}
+	id := big.NewInt(7)
+	handleNFTLogs(node, []types.Log{
+		nftLog(t, ERC1155ABI, "TransferBatch", 11, 1, nftTestAlice, nftTestAlice, carol,
+			[]*big.Int{big.NewInt(7), big.NewInt(8), big.NewInt(7)},
+			[]*big.Int{big.NewInt(3), big.NewInt(100), big.NewInt(1)}),
+		nftLog(t, ERC1155ABI, "TransferSingle", 10, 0, nftTestAlice, zero, nftTestAlice, id, big.NewInt(10)),
+		nftLog(t, ERC1155ABI, "TransferSingle", 10, 1, nftTestAlice, zero, nftTestBob, big.NewInt(8), big.NewInt(10)),
+		nftLog(t, ERC1155ABI, "TransferSingle", 11, 4, nftTestBob, nftTestAlice, nftTestBob, id, big.NewInt(2)),
+		nftLog(t, ERC1155ABI, "TransferBatch", 12, 0, nftTestBob, nftTestBob, zero,
+			[]*big.Int{big.NewInt(8)}, []*big.Int{big.NewInt(1)}),
+		nftLog(t, ERC1155ABI, "TransferSingle", 12, 2, carol, carol, zero, id, big.NewInt(4)),
+	})
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	token, err := NewERC1155(client, nftTestAddress)
+	require.NoError(err)
+
+	to := uint64(20)
+	transfers, err := token.TransferHistory(context.Background(), id, EventQuery{ToBlock: &to})
+	require.NoError(err)
+	require.Len(transfers, 4)
+	for i, want := range []struct {
+		operator, from, to common.Address
+		amount             int64
+	}{
+		{nftTestAlice, zero, nftTestAlice, 10},
+		{nftTestAlice, nftTestAlice, carol, 4},
+		{nftTestBob, nftTestAlice, nftTestBob, 2},
+		{carol, carol, zero, 4},
+	} {
+		require.Equal(want.operator, transfers[i].Operator)
+		require.Equal(want.from, transfers[i].From)
+		require.Equal(want.to, transfers[i].To)
+		require.Equal(want.amount, transfers[i].Amount.Int64())
+		require.Equal(int64(7), transfers[i].TokenID.Int64())
+	}
+	require.Equal(uint(1), transfers[1].LogIndex)
+	require.Equal(map[common.Address]*big.Int{
+		nftTestAlice: big.NewInt(4),
+		nftTestBob:   big.NewInt(2),
+	}, Holders(transfers))
+}
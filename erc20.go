@@ -0,0 +1,361 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// ERC20ABI is the ABI of the ERC-20 tokens, with the EIP-2612 permit extension.
+const ERC20ABI = `[
+	{"type":"function","name":"name","inputs":[],"outputs":[{"name":"","type":"string"}],"stateMutability":"view"},
+	{"type":"function","name":"symbol","inputs":[],"outputs":[{"name":"","type":"string"}],"stateMutability":"view"},
+	{"type":"function","name":"decimals","inputs":[],"outputs":[{"name":"","type":"uint8"}],"stateMutability":"view"},
+	{"type":"function","name":"totalSupply","inputs":[],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+	{"type":"function","name":"balanceOf","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+	{"type":"function","name":"allowance","inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable"},
+	{"type":"function","name":"transferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable"},
+	{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable"},
+	{"type":"function","name":"nonces","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+	{"type":"function","name":"DOMAIN_SEPARATOR","inputs":[],"outputs":[{"name":"","type":"bytes32"}],"stateMutability":"view"},
+	{"type":"function","name":"permit","inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"},{"name":"value","type":"uint256"},{"name":"deadline","type":"uint256"},{"name":"v","type":"uint8"},{"name":"r","type":"bytes32"},{"name":"s","type":"bytes32"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}],"anonymous":false},
+	{"type":"event","name":"Approval","inputs":[{"name":"owner","type":"address","indexed":true},{"name":"spender","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}],"anonymous":false}
+]`
+
+// ErrTokenReturnedFalse is returned when the token reports the failure of a transfer
+// or an approval by returning false instead of reverting.
+var ErrTokenReturnedFalse = errors.New("token call returned false")
+
+// permitTypeHash is the EIP-712 type hash of the EIP-2612 Permit.
+var permitTypeHash = crypto.Keccak256Hash([]byte(
+	"Permit(address owner,address spender,uint256 value,uint256 nonce,uint256 deadline)"))
+
+// ERC20 is the client of an ERC-20 token. The amounts are in the token base units, ToWei and
+// FromWei convert them according to the token decimals. The transfers and approvals are
+// checked with a call first, so the tokens returning false fail before sending, and the
+// tokens returning nothing at all are supported.
+type ERC20 struct {
+	*BoundContract
+
+	mux      sync.Mutex
+	decimals *uint8
+}
+
+// NewERC20 binds the token at the address.
+func NewERC20(client Backend, address common.Address) (*ERC20, error) {
+	bound, err := BindContract(client, &sol.Contract{
+		Name:    "ERC20",
+		ABI:     []byte(ERC20ABI),
+		Address: address,
+	})
+	if err != nil {
+		return nil, err
+	}
+	token := &ERC20{
+		BoundContract: bound,
+	}
+	return token, nil
+}
+
+// Name returns the token name.
+func (t *ERC20) Name(ctx context.Context) (string, error) {
+	var name string
+	err := t.CallInto(ctx, "name", &name)
+	return name, err
+}
+
+// Symbol returns the token symbol.
+func (t *ERC20) Symbol(ctx context.Context) (string, error) {
+	var symbol string
+	err := t.CallInto(ctx, "symbol", &symbol)
+	return symbol, err
+}
+
+// Decimals returns the token decimals, which are requested once.
+func (t *ERC20) Decimals(ctx context.Context) (uint8, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.decimals != nil {
+		return *t.decimals, nil
+	}
+	var decimals uint8
+	if err := t.CallInto(ctx, "decimals", &decimals); err != nil {
+		return 0, err
+	}
+	t.decimals = &decimals
+	return decimals, nil
+}
+
+// TotalSupply returns the amount of tokens in existence.
+func (t *ERC20) TotalSupply(ctx context.Context) (*Wei, error) {
+	return t.callAmount(ctx, "totalSupply")
+}
+
+// BalanceOf returns the amount of tokens owned by the owner.
+func (t *ERC20) BalanceOf(ctx context.Context, owner common.Address) (*Wei, error) {
+	return t.callAmount(ctx, "balanceOf", owner)
+}
+
+// Allowance returns the amount of the owner's tokens the spender may transfer.
+func (t *ERC20) Allowance(ctx context.Context, owner, spender common.Address) (*Wei, error) {
+	return t.callAmount(ctx, "allowance", owner, spender)
+}
+
+func (t *ERC20) callAmount(ctx context.Context, method string, params ...interface{}) (*Wei, error) {
+	var amount *big.Int
+	if err := t.CallInto(ctx, method, &amount, params...); err != nil {
+		return nil, err
+	}
+	return BigWei(amount), nil
+}
+
+// ToWei converts the amount of tokens into the base units, e.g. 1.5 tokens with 6 decimals
+// into 1500000. The amounts more precise than the decimals are rejected.
+func (t *ERC20) ToWei(ctx context.Context, tokens decimal.Decimal) (*Wei, error) {
+	decimals, err := t.Decimals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	units := tokens.Shift(int32(decimals))
+	if !units.Equal(units.Truncate(0)) {
+		err := fmt.Errorf("amount %s has more than %d decimals", tokens, decimals)
+		return nil, err
+	}
+	return DecimalWei(units), nil
+}
+
+// FromWei converts the base units into the amount of tokens.
+func (t *ERC20) FromWei(ctx context.Context, amount *Wei) (decimal.Decimal, error) {
+	decimals, err := t.Decimals(ctx)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return (*decimal.Decimal)(amount).Shift(-int32(decimals)), nil
+}
+
+// Transfer transfers the amount to the recipient. It shadows BoundContract.Transfer,
+// which sends ether to the contract, use t.BoundContract.Transfer for that.
+func (t *ERC20) Transfer(opts *bind.TransactOpts, to common.Address, amount *Wei) (*types.Transaction, error) {
+	return t.send(opts, "transfer", to, amount.ToInt())
+}
+
+// TransferFrom transfers the amount from the owner, within the allowance of the sender.
+func (t *ERC20) TransferFrom(opts *bind.TransactOpts, from, to common.Address, amount *Wei) (*types.Transaction, error) {
+	return t.send(opts, "transferFrom", from, to, amount.ToInt())
+}
+
+// Approve sets the amount of the sender's tokens the spender may transfer.
+func (t *ERC20) Approve(opts *bind.TransactOpts, spender common.Address, amount *Wei) (*types.Transaction, error) {
+	return t.send(opts, "approve", spender, amount.ToInt())
+}
+
+// EnsureAllowance approves the amount to the spender unless the allowance is enough already,
+// returning the transactions sent. The tokens like USDT refuse to change a non-zero allowance,
+// so if the approval reverts while the zero one doesn't, the allowance is reset to zero first,
+// waiting for that transaction to be mined. The approval returning false counts as refused too.
+// The other failures of the approval are returned.
+func (t *ERC20) EnsureAllowance(opts *bind.TransactOpts,
+	spender common.Address, amount *Wei) ([]*types.Transaction, error) {
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	allowance, err := t.Allowance(ctx, opts.From, spender)
+	if err != nil {
+		return nil, err
+	}
+	if allowance.ToInt().Cmp(amount.ToInt()) >= 0 {
+		return nil, nil
+	}
+	approveOpts := *opts
+	var txs []*types.Transaction
+	if allowance.ToInt().Sign() > 0 {
+		if err := t.check(&approveOpts, "approve", spender, amount.ToInt()); err != nil {
+			if _, ok := err.(*RevertError); !ok && err != ErrTokenReturnedFalse {
+				return nil, err
+			} else if t.check(&approveOpts, "approve", spender, new(big.Int)) != nil {
+				return nil, err
+			}
+			tx, err := t.send(&approveOpts, "approve", spender, new(big.Int))
+			if err != nil {
+				err = fmt.Errorf("failed to reset allowance: %v", err)
+				return nil, err
+			}
+			txs = append(txs, tx)
+			receipt, err := bind.WaitMined(ctx, t.client, tx)
+			if err != nil {
+				return txs, err
+			} else if receipt.Status != types.ReceiptStatusSuccessful {
+				err = fmt.Errorf("allowance reset %s failed", tx.Hash().Hex())
+				return txs, err
+			}
+			if approveOpts.Nonce != nil {
+				approveOpts.Nonce = new(big.Int).SetUint64(tx.Nonce() + 1)
+			}
+		}
+	}
+	tx, err := t.send(&approveOpts, "approve", spender, amount.ToInt())
+	if err != nil {
+		return txs, err
+	}
+	return append(txs, tx), nil
+}
+
+// send checks the call of the method, then sends it in a transaction.
+func (t *ERC20) send(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	if err := t.check(opts, method, params...); err != nil {
+		return nil, err
+	}
+	return t.Transact(opts, method, params...)
+}
+
+// check calls the method at the latest block, returning *RevertError if reverted and
+// ErrTokenReturnedFalse if the token returns false.
+func (t *ERC20) check(opts *bind.TransactOpts, method string, params ...interface{}) error {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	input, err := t.abi.Pack(method, params...)
+	if err != nil {
+		err = fmt.Errorf("failed to pack %s params: %v", method, err)
+		return err
+	}
+	address := t.address
+	msg := ethereum.CallMsg{
+		From:  opts.From,
+		To:    &address,
+		Value: opts.Value,
+		Data:  input,
+	}
+	output, err := t.client.CallContract(ctx, msg, nil)
+	if err != nil {
+		if revert, ok := revertFromError(err); ok {
+			return revert
+		}
+		return err
+	}
+	return checkBoolOutput(output)
+}
+
+// checkBoolOutput checks the output of the method returning a bool, if any: the tokens
+// not following ERC-20 return nothing, or revert in the output on some nodes.
+func checkBoolOutput(output []byte) error {
+	switch {
+	case len(output) == 0:
+		return nil
+	case len(output)%32 == 4 && (bytes.HasPrefix(output, revertSelector) || bytes.HasPrefix(output, panicSelector)):
+		revert, _ := DecodeRevert(output)
+		return revert
+	case len(output) < 32:
+		err := fmt.Errorf("unexpected token call output %#x", output)
+		return err
+	case new(big.Int).SetBytes(output[:32]).Sign() == 0:
+		return ErrTokenReturnedFalse
+	}
+	return nil
+}
+
+// Permit is the EIP-2612 approval signed by the owner, which anyone may submit.
+type Permit struct {
+	Owner    common.Address
+	Spender  common.Address
+	Value    *big.Int
+	Nonce    *big.Int
+	Deadline *big.Int
+	V        uint8
+	R        [32]byte
+	S        [32]byte
+}
+
+// Nonces returns the nonce of the owner's next permit.
+func (t *ERC20) Nonces(ctx context.Context, owner common.Address) (*big.Int, error) {
+	var nonce *big.Int
+	if err := t.CallInto(ctx, "nonces", &nonce, owner); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// DomainSeparator returns the EIP-712 domain separator of the token.
+func (t *ERC20) DomainSeparator(ctx context.Context) (common.Hash, error) {
+	var separator [32]byte
+	if err := t.CallInto(ctx, "DOMAIN_SEPARATOR", &separator); err != nil {
+		return common.Hash{}, err
+	}
+	return separator, nil
+}
+
+// SignPermit signs the permit of the owner to spend the amount until the deadline,
+// with the owner's key of keys. The domain separator and the nonce are the token's.
+func (t *ERC20) SignPermit(ctx context.Context, keys KeyCache, password string,
+	owner, spender common.Address, amount *Wei, deadline time.Time) (*Permit, error) {
+
+	separator, err := t.DomainSeparator(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get domain separator: %v", err)
+		return nil, err
+	}
+	nonce, err := t.Nonces(ctx, owner)
+	if err != nil {
+		err = fmt.Errorf("failed to get permit nonce: %v", err)
+		return nil, err
+	}
+	key, err := keys.PrivateKeyContext(ctx, owner, password)
+	if err != nil {
+		return nil, err
+	}
+	p := &Permit{
+		Owner:    owner,
+		Spender:  spender,
+		Value:    amount.ToInt(),
+		Nonce:    nonce,
+		Deadline: big.NewInt(deadline.Unix()),
+	}
+	sig, err := crypto.Sign(p.Digest(separator).Bytes(), key)
+	if err != nil {
+		return nil, err
+	}
+	copy(p.R[:], sig[:32])
+	copy(p.S[:], sig[32:64])
+	p.V = sig[64] + 27
+	return p, nil
+}
+
+// Digest returns the EIP-712 hash of the permit signed by the owner.
+func (p *Permit) Digest(domainSeparator common.Hash) common.Hash {
+	structHash := crypto.Keccak256Hash(
+		permitTypeHash[:],
+		common.LeftPadBytes(p.Owner[:], 32),
+		common.LeftPadBytes(p.Spender[:], 32),
+		common.LeftPadBytes(p.Value.Bytes(), 32),
+		common.LeftPadBytes(p.Nonce.Bytes(), 32),
+		common.LeftPadBytes(p.Deadline.Bytes(), 32),
+	)
+	return crypto.Keccak256Hash([]byte{0x19, 0x01}, domainSeparator[:], structHash[:])
+}
+
+// Permit submits the signed permit, approving the amount to the spender.
+func (t *ERC20) Permit(opts *bind.TransactOpts, p *Permit) (*types.Transaction, error) {
+	return t.send(opts, "permit", p.Owner, p.Spender, p.Value, p.Deadline, p.V, p.R, p.S)
+}
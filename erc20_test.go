@@ -0,0 +1,388 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+// testToken is an ERC-20 token with 6 decimals and EIP-2612 permits, answering the calls
+// and applying the transactions sent to the node.
+type testToken struct {
+	abi     abi.ABI
+	address common.Address
+
+	mux        sync.Mutex
+	balances   map[common.Address]*big.Int
+	allowances map[[2]common.Address]*big.Int
+	nonces     map[common.Address]uint64
+	// returnFalse makes the failed transfers and approvals return false instead of reverting.
+	returnFalse bool
+	// noBool makes the transfers and approvals return nothing.
+	noBool bool
+	// zeroFirst refuses to change a non-zero allowance, like USDT.
+	zeroFirst bool
+	// paused reverts the approvals.
+	paused bool
+}
+
+func newTestToken(t *testing.T, node *rpctest.MockNode) *testToken {
+	parsed, err := abi.JSON(strings.NewReader(ERC20ABI))
+	require.NoError(t, err)
+	token := &testToken{
+		abi:        parsed,
+		address:    common.HexToAddress("0x2000000000000000000000000000000000000020"),
+		balances:   make(map[common.Address]*big.Int),
+		allowances: make(map[[2]common.Address]*big.Int),
+		nonces:     make(map[common.Address]uint64),
+	}
+	node.SetCode(token.address, []byte{0})
+	node.Handle("eth_call", func(req rpctest.Request) (interface{}, error) {
+		var msg struct {
+			From common.Address `json:"from"`
+			Data hexutil.Bytes  `json:"input"`
+		}
+		if err := json.Unmarshal(req.Params[0], &msg); err != nil {
+			return nil, err
+		}
+		output, err := token.call(msg.From, msg.Data, false)
+		return hexutil.Bytes(output), err
+	})
+	node.OnTransaction(func(from common.Address, tx *types.Transaction) error {
+		_, err := token.call(from, tx.Data(), true)
+		return err
+	})
+	return token
+}
+
+func (tt *testToken) setBalance(account common.Address, amount int64) {
+	tt.mux.Lock()
+	tt.balances[account] = big.NewInt(amount)
+	tt.mux.Unlock()
+}
+
+func (tt *testToken) amount(m map[common.Address]*big.Int, account common.Address) *big.Int {
+	if v, ok := m[account]; ok {
+		return v
+	}
+	return new(big.Int)
+}
+
+func (tt *testToken) allowance(owner, spender common.Address) *big.Int {
+	if v, ok := tt.allowances[[2]common.Address{owner, spender}]; ok {
+		return v
+	}
+	return new(big.Int)
+}
+
+// typedData returns the EIP-712 permit of the token.
+func (tt *testToken) typedData(owner, spender common.Address, value *big.Int, nonce uint64, deadline *big.Int) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"Permit": {
+				{Name: "owner", Type: "address"},
+				{Name: "spender", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "nonce", Type: "uint256"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: "Permit",
+		Domain: apitypes.TypedDataDomain{
+			Name:              "Token",
+			Version:           "1",
+			ChainId:           math.NewHexOrDecimal256(1337),
+			VerifyingContract: tt.address.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"owner":    owner.Hex(),
+			"spender":  spender.Hex(),
+			"value":    value.String(),
+			"nonce":    new(big.Int).SetUint64(nonce).String(),
+			"deadline": deadline.String(),
+		},
+	}
+}
+
+func (tt *testToken) call(from common.Address, data []byte, apply bool) ([]byte, error) {
+	tt.mux.Lock()
+	defer tt.mux.Unlock()
+	if len(data) < 4 {
+		return nil, nil
+	}
+	m, err := tt.abi.MethodById(data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := m.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	ok := func() ([]byte, error) {
+		if tt.noBool {
+			return nil, nil
+		}
+		return m.Outputs.Pack(true)
+	}
+	switch m.Name {
+	case "decimals":
+		return m.Outputs.Pack(uint8(6))
+	case "balanceOf":
+		return m.Outputs.Pack(tt.amount(tt.balances, args[0].(common.Address)))
+	case "allowance":
+		return m.Outputs.Pack(tt.allowance(args[0].(common.Address), args[1].(common.Address)))
+	case "nonces":
+		return m.Outputs.Pack(new(big.Int).SetUint64(tt.nonces[args[0].(common.Address)]))
+	case "DOMAIN_SEPARATOR":
+		typedData := tt.typedData(common.Address{}, common.Address{}, new(big.Int), 0, new(big.Int))
+		separator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+		if err != nil {
+			return nil, err
+		}
+		return m.Outputs.Pack(common.BytesToHash(separator))
+	case "transfer":
+		to, value := args[0].(common.Address), args[1].(*big.Int)
+		balance := tt.amount(tt.balances, from)
+		if balance.Cmp(value) < 0 {
+			if tt.returnFalse {
+				return m.Outputs.Pack(false)
+			}
+			return nil, errors.New("execution reverted: insufficient balance")
+		}
+		if apply {
+			tt.balances[from] = new(big.Int).Sub(balance, value)
+			tt.balances[to] = new(big.Int).Add(tt.amount(tt.balances, to), value)
+		}
+		return ok()
+	case "approve":
+		spender, value := args[0].(common.Address), args[1].(*big.Int)
+		if tt.paused {
+			return nil, errors.New("execution reverted: paused")
+		}
+		if tt.zeroFirst && value.Sign() > 0 && tt.allowance(from, spender).Sign() > 0 {
+			if tt.returnFalse {
+				return m.Outputs.Pack(false)
+			}
+			return nil, errors.New("execution reverted")
+		}
+		if apply {
+			tt.allowances[[2]common.Address{from, spender}] = value
+		}
+		return ok()
+	case "permit":
+		owner, spender, value := args[0].(common.Address), args[1].(common.Address), args[2].(*big.Int)
+		deadline, v, r, s := args[3].(*big.Int), args[4].(uint8), args[5].([32]byte), args[6].([32]byte)
+		hash, _, err := apitypes.TypedDataAndHash(tt.typedData(owner, spender, value, tt.nonces[owner], deadline))
+		if err != nil {
+			return nil, err
+		}
+		sig := append(append(append([]byte{}, r[:]...), s[:]...), v-27)
+		pub, err := crypto.SigToPub(hash, sig)
+		if err != nil || crypto.PubkeyToAddress(*pub) != owner {
+			return nil, errors.New("execution reverted: invalid signature")
+		}
+		if apply {
+			tt.allowances[[2]common.Address{owner, spender}] = value
+			tt.nonces[owner]++
+		}
+		return nil, nil
+	}
+	return nil, errors.New("execution reverted")
+}
+
+func TestERC20(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	tt := newTestToken(t, node)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	opts := &bind.TransactOpts{From: from, Signer: keys.SignerFn(from, "")}
+	tt.setBalance(from, 2500000)
+
+	token, err := NewERC20(client, tt.address)
+	require.NoError(err)
+	amount, err := token.ToWei(ctx, decimal.RequireFromString("1.5"))
+	require.NoError(err)
+	require.Equal("1500000", amount.String())
+	_, err = token.ToWei(ctx, decimal.RequireFromString("0.0000001"))
+	require.EqualError(err, "amount 0.0000001 has more than 6 decimals")
+	tokens, err := token.FromWei(ctx, BigWei(big.NewInt(2500000)))
+	require.NoError(err)
+	require.Equal("2.5", tokens.String())
+	decimalsCalls := 0
+	for _, call := range node.Requests("eth_call") {
+		if bytes.Contains(call.Params[0], []byte(hexutil.Encode(tt.abi.Methods["decimals"].ID))) {
+			decimalsCalls++
+		}
+	}
+	require.Equal(1, decimalsCalls)
+
+	_, err = token.Transfer(opts, to, amount)
+	require.NoError(err)
+	balance, err := token.BalanceOf(ctx, to)
+	require.NoError(err)
+	require.Equal("1500000", balance.String())
+
+	// the failed transfers are not sent
+	_, err = token.Transfer(opts, to, amount)
+	revert, ok := err.(*RevertError)
+	require.True(ok, "unexpected error: %v", err)
+	require.Equal("insufficient balance", revert.Reason)
+	tt.returnFalse = true
+	_, err = token.Transfer(opts, to, amount)
+	require.Equal(ErrTokenReturnedFalse, err)
+	require.Len(node.Transactions(), 1)
+
+	// the tokens returning nothing
+	tt.noBool = true
+	_, err = token.Transfer(opts, to, BigWei(big.NewInt(1000000)))
+	require.NoError(err)
+	balance, err = token.BalanceOf(ctx, from)
+	require.NoError(err)
+	require.Equal("0", balance.String())
+}
+
+func TestERC20EnsureAllowance(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	tt := newTestToken(t, node)
+	tt.zeroFirst = true
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	spender := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	opts := &bind.TransactOpts{From: from, Signer: keys.SignerFn(from, "")}
+	token, err := NewERC20(client, tt.address)
+	require.NoError(err)
+
+	sent, err := token.EnsureAllowance(opts, spender, BigWei(big.NewInt(100)))
+	require.NoError(err)
+	require.Len(sent, 1)
+	sent, err = token.EnsureAllowance(opts, spender, BigWei(big.NewInt(50)))
+	require.NoError(err)
+	require.Empty(sent)
+
+	// the allowance is reset to zero first
+	_, err = token.Approve(opts, spender, BigWei(big.NewInt(200)))
+	require.Error(err)
+	sent, err = token.EnsureAllowance(opts, spender, BigWei(big.NewInt(200)))
+	require.NoError(err)
+	require.Len(sent, 2)
+	unpacked, err := tt.abi.Methods["approve"].Inputs.Unpack(sent[0].Data()[4:])
+	require.NoError(err)
+	require.Zero(unpacked[1].(*big.Int).Sign())
+	require.Equal(sent[0].Nonce()+1, sent[1].Nonce())
+	allowance, err := token.Allowance(ctx, from, spender)
+	require.NoError(err)
+	require.Equal("200", allowance.String())
+	require.Len(node.Transactions(), 3)
+
+	// the allowance is not reset if the approval would fail anyway
+	tt.paused = true
+	sent, err = token.EnsureAllowance(opts, spender, BigWei(big.NewInt(300)))
+	require.EqualError(err, "execution reverted: paused")
+	require.Empty(sent)
+	tt.paused = false
+
+	// the approval returning false is refused too
+	tt.returnFalse = true
+	sent, err = token.EnsureAllowance(opts, spender, BigWei(big.NewInt(300)))
+	require.NoError(err)
+	require.Len(sent, 2)
+	allowance, err = token.Allowance(ctx, from, spender)
+	require.NoError(err)
+	require.Equal("300", allowance.String())
+	require.Len(node.Transactions(), 5)
+}
+
+func TestERC20Permit(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	tt := newTestToken(t, node)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	ownerKey, err := crypto.GenerateKey()
+	require.NoError(err)
+	owner := crypto.PubkeyToAddress(ownerKey.PublicKey)
+	spenderKey, err := crypto.GenerateKey()
+	require.NoError(err)
+	spender := crypto.PubkeyToAddress(spenderKey.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(owner, ownerKey)
+	keys.SetPrivateKey(spender, spenderKey)
+	token, err := NewERC20(client, tt.address)
+	require.NoError(err)
+	deadline := time.Unix(1900000000, 0)
+
+	p, err := token.SignPermit(ctx, keys, "", owner, spender, BigWei(big.NewInt(300)), deadline)
+	require.NoError(err)
+	require.Equal(uint64(0), p.Nonce.Uint64())
+	separator, err := token.DomainSeparator(ctx)
+	require.NoError(err)
+	hash, _, err := apitypes.TypedDataAndHash(tt.typedData(owner, spender, big.NewInt(300), 0, big.NewInt(1900000000)))
+	require.NoError(err)
+	require.Equal(common.BytesToHash(hash), p.Digest(separator))
+
+	// the spender submits the owner's permit
+	_, err = token.Permit(&bind.TransactOpts{From: spender, Signer: keys.SignerFn(spender, "")}, p)
+	require.NoError(err)
+	allowance, err := token.Allowance(ctx, owner, spender)
+	require.NoError(err)
+	require.Equal("300", allowance.String())
+	nonce, err := token.Nonces(ctx, owner)
+	require.NoError(err)
+	require.Equal(uint64(1), nonce.Uint64())
+
+	// the permit can't be replayed
+	_, err = token.Permit(&bind.TransactOpts{From: spender, Signer: keys.SignerFn(spender, "")}, p)
+	require.EqualError(err, "execution reverted: invalid signature")
+	_, err = token.SignPermit(ctx, keys, "", spender, owner, BigWei(big.NewInt(1)), deadline)
+	require.NoError(err)
+	_, err = token.SignPermit(ctx, NewKeyCache(), "", owner, spender, BigWei(big.NewInt(1)), deadline)
+	require.Equal(ErrNoKeyStore, err)
+}
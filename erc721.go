@@ -0,0 +1,201 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// ERC721ABI is the ABI of the ERC-721 tokens, with the metadata extension. The overloaded
+// safeTransferFrom with the data argument is bound as safeTransferFrom0.
+const ERC721ABI = `[
+	{"type":"function","name":"supportsInterface","inputs":[{"name":"interfaceId","type":"bytes4"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"view"},
+	{"type":"function","name":"name","inputs":[],"outputs":[{"name":"","type":"string"}],"stateMutability":"view"},
+	{"type":"function","name":"symbol","inputs":[],"outputs":[{"name":"","type":"string"}],"stateMutability":"view"},
+	{"type":"function","name":"tokenURI","inputs":[{"name":"tokenId","type":"uint256"}],"outputs":[{"name":"","type":"string"}],"stateMutability":"view"},
+	{"type":"function","name":"balanceOf","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"view"},
+	{"type":"function","name":"ownerOf","inputs":[{"name":"tokenId","type":"uint256"}],"outputs":[{"name":"","type":"address"}],"stateMutability":"view"},
+	{"type":"function","name":"getApproved","inputs":[{"name":"tokenId","type":"uint256"}],"outputs":[{"name":"","type":"address"}],"stateMutability":"view"},
+	{"type":"function","name":"isApprovedForAll","inputs":[{"name":"owner","type":"address"},{"name":"operator","type":"address"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"view"},
+	{"type":"function","name":"approve","inputs":[{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"function","name":"setApprovalForAll","inputs":[{"name":"operator","type":"address"},{"name":"approved","type":"bool"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"function","name":"transferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"},{"name":"data","type":"bytes"}],"outputs":[],"stateMutability":"nonpayable"},
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"tokenId","type":"uint256","indexed":true}],"anonymous":false},
+	{"type":"event","name":"Approval","inputs":[{"name":"owner","type":"address","indexed":true},{"name":"approved","type":"address","indexed":true},{"name":"tokenId","type":"uint256","indexed":true}],"anonymous":false},
+	{"type":"event","name":"ApprovalForAll","inputs":[{"name":"owner","type":"address","indexed":true},{"name":"operator","type":"address","indexed":true},{"name":"approved","type":"bool","indexed":false}],"anonymous":false}
+]`
+
+// ERC721 is the client of an ERC-721 token contract. The metadata is fetched with
+// HTTPMetadataFetcher unless another fetcher is set.
+type ERC721 struct {
+	*BoundContract
+
+	fetcher MetadataFetcher
+}
+
+// NewERC721 binds the token contract at the address.
+func NewERC721(client Backend, address common.Address) (*ERC721, error) {
+	bound, err := BindContract(client, &sol.Contract{
+		Name:    "ERC721",
+		ABI:     []byte(ERC721ABI),
+		Address: address,
+	})
+	if err != nil {
+		return nil, err
+	}
+	token := &ERC721{
+		BoundContract: bound,
+		fetcher:       new(HTTPMetadataFetcher),
+	}
+	return token, nil
+}
+
+// SetMetadataFetcher replaces the fetcher of the token metadata, HTTPMetadataFetcher by default.
+func (t *ERC721) SetMetadataFetcher(fetcher MetadataFetcher) {
+	t.fetcher = fetcher
+}
+
+// SupportsInterface detects the ERC-165 interface, e.g. InterfaceERC721Metadata.
+func (t *ERC721) SupportsInterface(ctx context.Context, id [4]byte) (bool, error) {
+	return supportsInterface(ctx, t.BoundContract, id)
+}
+
+// Name returns the collection name.
+func (t *ERC721) Name(ctx context.Context) (string, error) {
+	var name string
+	err := t.CallInto(ctx, "name", &name)
+	return name, err
+}
+
+// Symbol returns the collection symbol.
+func (t *ERC721) Symbol(ctx context.Context) (string, error) {
+	var symbol string
+	err := t.CallInto(ctx, "symbol", &symbol)
+	return symbol, err
+}
+
+// BalanceOf returns the number of the owner's tokens.
+func (t *ERC721) BalanceOf(ctx context.Context, owner common.Address) (*big.Int, error) {
+	var balance *big.Int
+	if err := t.CallInto(ctx, "balanceOf", &balance, owner); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// OwnerOf returns the owner of the token.
+func (t *ERC721) OwnerOf(ctx context.Context, tokenID *big.Int) (common.Address, error) {
+	var owner common.Address
+	err := t.CallInto(ctx, "ownerOf", &owner, tokenID)
+	return owner, err
+}
+
+// GetApproved returns the account approved to transfer the token, the zero address if none.
+func (t *ERC721) GetApproved(ctx context.Context, tokenID *big.Int) (common.Address, error) {
+	var approved common.Address
+	err := t.CallInto(ctx, "getApproved", &approved, tokenID)
+	return approved, err
+}
+
+// IsApprovedForAll reports whether the operator may transfer all the owner's tokens.
+func (t *ERC721) IsApprovedForAll(ctx context.Context, owner, operator common.Address) (bool, error) {
+	var approved bool
+	err := t.CallInto(ctx, "isApprovedForAll", &approved, owner, operator)
+	return approved, err
+}
+
+// TokenURI returns the metadata URI of the token.
+func (t *ERC721) TokenURI(ctx context.Context, tokenID *big.Int) (string, error) {
+	var uri string
+	err := t.CallInto(ctx, "tokenURI", &uri, tokenID)
+	return uri, err
+}
+
+// Metadata fetches the metadata JSON at the token URI.
+func (t *ERC721) Metadata(ctx context.Context, tokenID *big.Int) (*TokenMetadata, error) {
+	uri, err := t.TokenURI(ctx, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	return fetchMetadata(ctx, t.fetcher, uri)
+}
+
+// Approve approves the account to transfer the token, the zero address clears the approval.
+func (t *ERC721) Approve(opts *bind.TransactOpts, to common.Address, tokenID *big.Int) (*types.Transaction, error) {
+	return t.Transact(opts, "approve", to, tokenID)
+}
+
+// SetApprovalForAll approves or revokes the operator to transfer all the sender's tokens.
+func (t *ERC721) SetApprovalForAll(opts *bind.TransactOpts, operator common.Address, approved bool) (*types.Transaction, error) {
+	return t.Transact(opts, "setApprovalForAll", operator, approved)
+}
+
+// TransferFrom transfers the token without checking the recipient accepts it.
+func (t *ERC721) TransferFrom(opts *bind.TransactOpts, from, to common.Address, tokenID *big.Int) (*types.Transaction, error) {
+	return t.Transact(opts, "transferFrom", from, to, tokenID)
+}
+
+// SafeTransferFrom transfers the token, the contract recipients must accept it in
+// onERC721Received, which gets the data.
+func (t *ERC721) SafeTransferFrom(opts *bind.TransactOpts,
+	from, to common.Address, tokenID *big.Int, data []byte) (*types.Transaction, error) {
+
+	if data == nil {
+		data = []byte{}
+	}
+	return t.Transact(opts, "safeTransferFrom0", from, to, tokenID, data)
+}
+
+// erc721Transfer is the Transfer event of ERC-721.
+type erc721Transfer struct {
+	From    common.Address
+	To      common.Address
+	TokenId *big.Int
+}
+
+// OwnershipHistory returns the transfers of the token in the blocks of the query, in order,
+// the recipient of the last one is the owner. Holders of the transfers since the mint
+// is the owner too.
+func (t *ERC721) OwnershipHistory(ctx context.Context, tokenID *big.Int, q EventQuery) ([]*TokenTransfer, error) {
+	q.Indexed = map[string][]interface{}{
+		"tokenId": {tokenID},
+	}
+	logs, err := t.FilterEvents(ctx, "Transfer", q)
+	if err != nil {
+		return nil, err
+	}
+	sortLogs(logs)
+	transfers := make([]*TokenTransfer, 0, len(logs))
+	for _, log := range logs {
+		if log.Removed {
+			continue
+		}
+		var ev erc721Transfer
+		if err := t.DecodeEvent("Transfer", log, &ev); err != nil {
+			err = fmt.Errorf("failed to decode transfer in %s: %v", log.TxHash.Hex(), err)
+			return nil, err
+		}
+		transfers = append(transfers, &TokenTransfer{
+			From:        ev.From,
+			To:          ev.To,
+			TokenID:     ev.TokenId,
+			Amount:      big.NewInt(1),
+			BlockNumber: log.BlockNumber,
+			TxHash:      log.TxHash,
+			LogIndex:    log.Index,
+		})
+	}
+	return transfers, nil
+}
@@ -0,0 +1,312 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+var (
+	nftTestAddress = common.HexToAddress("0x4000000000000000000000000000000000000040")
+	nftTestAlice   = common.HexToAddress("0x4000000000000000000000000000000000000001")
+	nftTestBob     = common.HexToAddress("0x4000000000000000000000000000000000000002")
+)
+
+// handleNFTCalls answers eth_call with the results of fn for the methods of the ABI,
+// the errors of fn are the reverts.
+func handleNFTCalls(t *testing.T, node *rpctest.MockNode, abiJSON string,
+	fn func(name string, args []interface{}) ([]interface{}, error)) {
+
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	require.NoError(t, err)
+	node.Handle("eth_call", func(req rpctest.Request) (interface{}, error) {
+		var msg struct {
+			Data hexutil.Bytes `json:"input"`
+		}
+		if err := json.Unmarshal(req.Params[0], &msg); err != nil {
+			return nil, err
+		}
+		m, err := parsed.MethodById(msg.Data[:4])
+		if err != nil {
+			return nil, err
+		}
+		args, err := m.Inputs.Unpack(msg.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		results, err := fn(m.Name, args)
+		if err != nil {
+			return nil, err
+		}
+		output, err := m.Outputs.Pack(results...)
+		return hexutil.Bytes(output), err
+	})
+}
+
+// nftLog returns the log of the event in the block, the args are the indexed ones
+// followed by the rest.
+func nftLog(t *testing.T, abiJSON, name string, block uint64, index uint, args ...interface{}) types.Log {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	require.NoError(t, err)
+	event := parsed.Events[name]
+	topics := []common.Hash{event.ID}
+	var data []interface{}
+	for i, input := range event.Inputs {
+		switch arg := args[i].(type) {
+		case common.Address:
+			if input.Indexed {
+				topics = append(topics, common.BytesToHash(arg.Bytes()))
+				continue
+			}
+		case *big.Int:
+			if input.Indexed {
+				topics = append(topics, common.BigToHash(arg))
+				continue
+			}
+		}
+		data = append(data, args[i])
+	}
+	packed, err := event.Inputs.NonIndexed().Pack(data...)
+	require.NoError(t, err)
+	return types.Log{
+		Address:     nftTestAddress,
+		Topics:      topics,
+		Data:        packed,
+		BlockNumber: block,
+		TxHash:      common.BigToHash(new(big.Int).SetUint64(block)),
+		Index:       index,
+	}
+}
+
+// handleNFTLogs answers eth_getLogs with the logs matching the topics of the query.
+func handleNFTLogs(node *rpctest.MockNode, logs []types.Log) {
+	node.Handle("eth_getLogs", func(req rpctest.Request) (interface{}, error) {
+		var q struct {
+			Topics [][]common.Hash `json:"topics"`
+		}
+		if err := json.Unmarshal(req.Params[0], &q); err != nil {
+			return nil, err
+		}
+		matched := []types.Log{}
+	next:
+		for _, log := range logs {
+			for i, topics := range q.Topics {
+				if len(topics) == 0 {
+					continue
+				}
+				if i >= len(log.Topics) {
+					continue next
+				}
+				found := false
+				for _, topic := range topics {
+					found = found || topic == log.Topics[i]
+				}
+				if !found {
+					continue next
+				}
+			}
+			matched = append(matched, log)
+		}
+		return matched, nil
+	})
+}
+
+func TestERC721(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	node.SetCode(nftTestAddress, []byte{0})
+	erc165 := true
+	metadata := `{"name":"Token #7","image":"ipfs://image","attributes":[{"trait_type":"level","value":3}]}`
+	tokenURI := "data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(metadata))
+	handleNFTCalls(t, node, ERC721ABI, func(name string, args []interface{}) ([]interface{}, error) {
+		switch name {
+		case "supportsInterface":
+			if !erc165 {
+				return nil, errors.New("execution reverted")
+			}
+			id := args[0].([4]byte)
+			return []interface{}{id == InterfaceERC165 || id == InterfaceERC721 || id == InterfaceERC721Metadata}, nil
+		case "name":
+			return []interface{}{"Tokens"}, nil
+		case "balanceOf":
+			return []interface{}{big.NewInt(2)}, nil
+		case "ownerOf":
+			if args[0].(*big.Int).Int64() != 7 {
+				return nil, errors.New("execution reverted: invalid token ID")
+			}
+			return []interface{}{nftTestAlice}, nil
+		case "getApproved":
+			return []interface{}{nftTestBob}, nil
+		case "tokenURI":
+			return []interface{}{tokenURI}, nil
+		}
+		return nil, errors.New("execution reverted")
+	})
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	token, err := NewERC721(client, nftTestAddress)
+	require.NoError(err)
+
+	for id, want := range map[[4]byte]bool{
+		InterfaceERC165:           true,
+		InterfaceERC721:           true,
+		InterfaceERC721Metadata:   true,
+		InterfaceERC721Enumerable: false,
+		InterfaceERC1155:          false,
+	} {
+		supported, err := token.SupportsInterface(ctx, id)
+		require.NoError(err)
+		require.Equal(want, supported, "interface %x", id)
+	}
+	name, err := token.Name(ctx)
+	require.NoError(err)
+	require.Equal("Tokens", name)
+	balance, err := token.BalanceOf(ctx, nftTestAlice)
+	require.NoError(err)
+	require.Equal(int64(2), balance.Int64())
+	owner, err := token.OwnerOf(ctx, big.NewInt(7))
+	require.NoError(err)
+	require.Equal(nftTestAlice, owner)
+	_, err = token.OwnerOf(ctx, big.NewInt(8))
+	require.EqualError(err, "execution reverted: invalid token ID")
+	approved, err := token.GetApproved(ctx, big.NewInt(7))
+	require.NoError(err)
+	require.Equal(nftTestBob, approved)
+
+	meta, err := token.Metadata(ctx, big.NewInt(7))
+	require.NoError(err)
+	require.Equal("Token #7", meta.Name)
+	require.Equal("ipfs://image", meta.Image)
+	require.Equal([]MetadataAttribute{{TraitType: "level", Value: float64(3)}}, meta.Attributes)
+	require.JSONEq(metadata, string(meta.Raw))
+
+	// the contracts without ERC-165 support nothing
+	erc165 = false
+	supported, err := token.SupportsInterface(ctx, InterfaceERC721)
+	require.NoError(err)
+	require.False(supported)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	opts := &bind.TransactOpts{From: from, Signer: keys.SignerFn(from, "")}
+	_, err = token.SafeTransferFrom(opts, from, nftTestBob, big.NewInt(7), nil)
+	require.NoError(err)
+	_, err = token.SafeTransferFrom(opts, from, nftTestBob, big.NewInt(7), []byte("hi"))
+	require.NoError(err)
+	sent := node.Transactions()
+	require.Len(sent, 2)
+	parsed, err := abi.JSON(strings.NewReader(ERC721ABI))
+	require.NoError(err)
+	method := parsed.Methods["safeTransferFrom0"]
+	require.Equal(method.ID, sent[0].Data()[:4])
+	args, err := method.Inputs.Unpack(sent[1].Data()[4:])
+	require.NoError(err)
+	require.Equal([]interface{}{from, nftTestBob, big.NewInt(7), []byte("hi")}, args)
+}
+
+func TestERC721Metadata(t *testing.T) {
+	require := require.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ipfs/QmToken/7.json":
+			w.Write([]byte(`{"name":"IPFS #7"}`))
+		case "/7.json":
+			w.Write([]byte(`{"name":"HTTP #7"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	fetcher := &HTTPMetadataFetcher{IPFSGateway: server.URL + "/ipfs/"}
+
+	for uri, name := range map[string]string{
+		"ipfs://QmToken/7.json":                      "IPFS #7",
+		"ipfs://ipfs/QmToken/7.json":                 "IPFS #7",
+		server.URL + "/7.json":                       "HTTP #7",
+		`data:application/json,{"name":"Data%20#7"}`: "Data #7",
+	} {
+		meta, err := fetchMetadata(ctx, fetcher, uri)
+		require.NoError(err, uri)
+		require.Equal(name, meta.Name)
+	}
+	_, err := fetchMetadata(ctx, fetcher, server.URL+"/8.json")
+	require.EqualError(err, "failed to fetch "+server.URL+"/8.json: 404 Not Found")
+	_, err = fetchMetadata(ctx, fetcher, "ar://token")
+	require.EqualError(err, "unsupported metadata URI ar://token")
+
+	// the custom fetchers get the URIs as they are
+	var fetched []string
+	_, err = fetchMetadata(ctx, MetadataFetcherFunc(func(ctx context.Context, uri string) ([]byte, error) {
+		fetched = append(fetched, uri)
+		return []byte(`{"name":"Custom"}`), nil
+	}), "ar://token")
+	require.NoError(err)
+	require.Equal([]string{"ar://token"}, fetched)
+	_, err = fetchMetadata(ctx, MetadataFetcherFunc(func(ctx context.Context, uri string) ([]byte, error) {
+		return []byte("<html>"), nil
+	}), "ar://token")
+	require.Error(err)
+}
+
+func TestERC721OwnershipHistory(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	carol := common.HexToAddress("0x4000000000000000000000000000000000000003")
+	zero := common.Address{}
+	id := big.NewInt(7)
+	removed := nftLog(t, ERC721ABI, "Transfer", 12, 0, nftTestBob, nftTestAlice, id)
+	removed.Removed = true
+	handleNFTLogs(node, []types.Log{
+		nftLog(t, ERC721ABI, "Transfer", 12, 3, nftTestAlice, nftTestBob, id),
+		nftLog(t, ERC721ABI, "Transfer", 10, 0, zero, nftTestAlice, id),
+		nftLog(t, ERC721ABI, "Transfer", 11, 0, zero, nftTestBob, big.NewInt(8)),
+		removed,
+		nftLog(t, ERC721ABI, "Transfer", 12, 5, nftTestBob, carol, id),
+	})
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	token, err := NewERC721(client, nftTestAddress)
+	require.NoError(err)
+
+	to := uint64(20)
+	transfers, err := token.OwnershipHistory(context.Background(), id, EventQuery{ToBlock: &to})
+	require.NoError(err)
+	require.Len(transfers, 3)
+	for i, want := range [][2]common.Address{{zero, nftTestAlice}, {nftTestAlice, nftTestBob}, {nftTestBob, carol}} {
+		require.Equal(want[0], transfers[i].From)
+		require.Equal(want[1], transfers[i].To)
+		require.Equal(int64(7), transfers[i].TokenID.Int64())
+		require.Equal(int64(1), transfers[i].Amount.Int64())
+	}
+	require.Equal(uint64(12), transfers[2].BlockNumber)
+	require.Equal(uint(5), transfers[2].LogIndex)
+	holders := Holders(transfers)
+	require.Len(holders, 1)
+	require.Equal(int64(1), holders[carol].Int64())
+}
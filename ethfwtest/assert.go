@@ -0,0 +1,53 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfwtest
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/AtlantPlatform/ethfw"
+)
+
+// RequireRevert fails the test unless err is a revert with the reason.
+func RequireRevert(t testing.TB, err error, reason string) {
+	t.Helper()
+	revert, ok := err.(*ethfw.RevertError)
+	if !ok {
+		t.Fatalf("expected revert %q, got %v", reason, err)
+	}
+	if revert.Reason != reason {
+		t.Fatalf("expected revert %q, got %q", reason, revert.Reason)
+	}
+}
+
+// Events returns the logs of the named event emitted by the contract in the receipt.
+func Events(contract *ethfw.BoundContract, receipt *types.Receipt, name string) []types.Log {
+	ev, ok := contract.ABI().Events[name]
+	if !ok {
+		return nil
+	}
+	var logs []types.Log
+	for _, log := range receipt.Logs {
+		if log.Address == contract.Address() && len(log.Topics) > 0 && log.Topics[0] == ev.ID {
+			logs = append(logs, *log)
+		}
+	}
+	return logs
+}
+
+// RequireEvent fails the test unless the contract emitted the named event in the receipt,
+// the first one is decoded into out, as by BoundContract.DecodeEvent.
+func RequireEvent(t testing.TB, contract *ethfw.BoundContract, receipt *types.Receipt, name string, out interface{}) {
+	t.Helper()
+	logs := Events(contract, receipt, name)
+	if len(logs) == 0 {
+		t.Fatalf("expected event %s of %s in transaction %s", name, contract.Address().Hex(), receipt.TxHash.Hex())
+	}
+	if err := contract.DecodeEvent(name, logs[0], out); err != nil {
+		t.Fatalf("failed to decode event %s: %v", name, err)
+	}
+}
@@ -0,0 +1,489 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package ethfwtest runs contract integration tests against an in-process simulated chain.
+package ethfwtest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+const (
+	// DefaultAccounts is the number of the pre-funded accounts.
+	DefaultAccounts = 10
+	// DefaultGasLimit is the block gas limit of the chain.
+	DefaultGasLimit = 8000000
+)
+
+// errIndexing is the error of the receipts requested while the chain indexes its transactions.
+const errIndexing = "transaction indexing is in progress"
+
+// indexTimeout limits the wait for the transactions of the mined block to be indexed.
+const indexTimeout = 5 * time.Second
+
+// ChainID is the chain ID of the simulated chain.
+var ChainID = big.NewInt(1337)
+
+// DefaultBalance is the balance of the pre-funded accounts, 1000 ether.
+var DefaultBalance = new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+
+// Account is a pre-funded account of the chain.
+type Account struct {
+	Address common.Address
+	Key     *ecdsa.PrivateKey
+}
+
+// ChainOptions configure the chain created by NewChain.
+type ChainOptions struct {
+	// Accounts is the number of the pre-funded accounts, DefaultAccounts by default.
+	Accounts int
+	// Balance of each account, DefaultBalance by default.
+	Balance *big.Int
+	// GasLimit is the block gas limit, DefaultGasLimit by default.
+	GasLimit uint64
+	// AutoMine mines a block with every transaction sent.
+	AutoMine bool
+}
+
+// Chain is a simulated chain with pre-funded accounts, which keys are in Keys. It implements
+// ethfw.Backend, the contracts bound to it keep working after Revert. Transactions of the accounts
+// should be sent with Transact, which keeps the Nonces.
+type Chain struct {
+	Accounts []*Account
+	Keys     ethfw.KeyCache
+	Nonces   ethfw.NonceCache
+
+	opts  ChainOptions
+	alloc types.GenesisAlloc
+
+	mux     sync.Mutex
+	sim     *backends.SimulatedBackend
+	blocks  []*minedBlock
+	pending *minedBlock
+}
+
+// minedBlock records a block to replay it when the chain is reverted.
+type minedBlock struct {
+	txs    []*types.Transaction
+	offset time.Duration
+}
+
+// NewChain creates a chain with the accounts generated deterministically, so their addresses
+// are the same in every test.
+func NewChain(opts ChainOptions) *Chain {
+	if opts.Accounts <= 0 {
+		opts.Accounts = DefaultAccounts
+	}
+	if opts.Balance == nil {
+		opts.Balance = DefaultBalance
+	}
+	if opts.GasLimit == 0 {
+		opts.GasLimit = DefaultGasLimit
+	}
+	c := &Chain{
+		Keys:    ethfw.NewKeyCache(),
+		Nonces:  ethfw.NewNonceCache(),
+		opts:    opts,
+		alloc:   make(types.GenesisAlloc),
+		pending: new(minedBlock),
+	}
+	for i := 0; i < opts.Accounts; i++ {
+		key, _ := crypto.ToECDSA(crypto.Keccak256([]byte("ethfwtest:" + strconv.Itoa(i))))
+		account := &Account{
+			Address: crypto.PubkeyToAddress(key.PublicKey),
+			Key:     key,
+		}
+		c.Accounts = append(c.Accounts, account)
+		c.Keys.SetPrivateKey(account.Address, key)
+		c.Nonces.Set(account.Address, 0)
+		c.alloc[account.Address] = types.Account{Balance: opts.Balance}
+	}
+	c.sim = backends.NewSimulatedBackend(c.alloc, opts.GasLimit)
+	return c
+}
+
+// Backend returns the simulated backend, which is replaced by Revert.
+func (c *Chain) Backend() *backends.SimulatedBackend {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.sim
+}
+
+// Close stops the simulated chain.
+func (c *Chain) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.sim.Close()
+}
+
+// Transactor returns the transact options of the account, signing with its key from Keys.
+func (c *Chain) Transactor(account common.Address) *bind.TransactOpts {
+	key, ok := c.Keys.PrivateKey(account, "")
+	if !ok {
+		return &bind.TransactOpts{From: account}
+	}
+	opts, _ := bind.NewKeyedTransactorWithChainID(key, ChainID)
+	return opts
+}
+
+// Transact is an ethfw.TransactFunc that takes the nonces from Nonces, the access list of
+// opts.Context makes an access list transaction. The transactions failing the gas estimation
+// because of a revert return *ethfw.RevertError.
+func (c *Chain) Transact(opts *bind.TransactOpts, contract *common.Address, input []byte) (*types.Transaction, error) {
+	if opts.Signer == nil {
+		err := errors.New("no signer to authorize the transaction with")
+		return nil, err
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	value := opts.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	gasPrice := opts.GasPrice
+	if gasPrice == nil {
+		suggested, err := c.SuggestGasPrice(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to suggest gas price: %v", err)
+			return nil, err
+		}
+		gasPrice = suggested
+	}
+	accessList := ethfw.AccessListFromContext(ctx)
+	gasLimit := opts.GasLimit
+	if gasLimit == 0 {
+		msg := ethereum.CallMsg{From: opts.From, To: contract, Value: value, Data: input, AccessList: accessList}
+		estimated, err := c.EstimateGas(ctx, msg)
+		if err != nil {
+			if _, callErr := c.PendingCallContract(ctx, msg); callErr != nil {
+				if revert, ok := revertFromError(callErr); ok {
+					return nil, revert
+				}
+			}
+			err = fmt.Errorf("failed to estimate gas: %v", err)
+			return nil, err
+		}
+		gasLimit = estimated
+	}
+
+	var signed *types.Transaction
+	err := c.Nonces.SerializeContext(ctx, opts.From, func(ctx context.Context) error {
+		var nonce uint64
+		if opts.Nonce != nil {
+			nonce = opts.Nonce.Uint64()
+		} else {
+			nonce = c.Nonces.Incr(opts.From)
+		}
+		var tx *types.Transaction
+		if accessList != nil {
+			tx = types.NewTx(&types.AccessListTx{
+				ChainID:    ChainID,
+				Nonce:      nonce,
+				GasPrice:   gasPrice,
+				Gas:        gasLimit,
+				To:         contract,
+				Value:      value,
+				Data:       input,
+				AccessList: accessList,
+			})
+		} else if contract == nil {
+			tx = types.NewContractCreation(nonce, value, gasLimit, gasPrice, input)
+		} else {
+			tx = types.NewTransaction(nonce, *contract, value, gasLimit, gasPrice, input)
+		}
+		var err error
+		if signed, err = opts.Signer(opts.From, tx); err == nil {
+			err = c.SendTransaction(ctx, signed)
+		}
+		if err != nil && opts.Nonce == nil {
+			c.Nonces.Decr(opts.From)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// Bind binds the contract to the chain, with Transact to send the transactions.
+func (c *Chain) Bind(src *sol.Contract) (*ethfw.BoundContract, error) {
+	bound, err := ethfw.BindContract(c, src)
+	if err != nil {
+		return nil, err
+	}
+	bound.SetTransact(c.Transact)
+	return bound, nil
+}
+
+// revertFromError returns the revert of the failed call, the simulated backend reports
+// it with the revert data.
+func revertFromError(err error) (*ethfw.RevertError, bool) {
+	de, ok := err.(interface{ ErrorData() interface{} })
+	if !ok {
+		return nil, false
+	}
+	hexData, ok := de.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := hexutil.Decode(hexData)
+	if err != nil {
+		return nil, false
+	}
+	return ethfw.DecodeRevert(data)
+}
+
+// Deploy deploys the contract from the account and mines it, the deployment
+// must succeed.
+func (c *Chain) Deploy(from common.Address, src *sol.Contract, params ...interface{}) (*ethfw.BoundContract, error) {
+	bound, err := c.Bind(src)
+	if err != nil {
+		return nil, err
+	}
+	_, tx, err := bound.DeployContract(c.Transactor(from), params...)
+	if err != nil {
+		err = fmt.Errorf("failed to deploy %s: %v", src.Name, err)
+		return nil, err
+	}
+	if _, err := c.Mined(tx); err != nil {
+		err = fmt.Errorf("failed to deploy %s: %v", src.Name, err)
+		return nil, err
+	}
+	return bound, nil
+}
+
+// Mined mines the pending block, if the transaction is pending, and returns
+// its receipt. The failed transactions return an error along with the receipt.
+func (c *Chain) Mined(tx *types.Transaction) (*types.Receipt, error) {
+	ctx := context.Background()
+	receipt, err := c.TransactionReceipt(ctx, tx.Hash())
+	if err == ethereum.NotFound {
+		c.Commit()
+		receipt, err = c.TransactionReceipt(ctx, tx.Hash())
+	}
+	if err == ethereum.NotFound {
+		err = fmt.Errorf("transaction %s was not mined", tx.Hash().Hex())
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	} else if receipt.Status != types.ReceiptStatusSuccessful {
+		err = fmt.Errorf("transaction %s failed", tx.Hash().Hex())
+		return receipt, err
+	}
+	return receipt, nil
+}
+
+// Commit mines the pending transactions into a block.
+func (c *Chain) Commit() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.commit()
+}
+
+func (c *Chain) commit() {
+	next := new(minedBlock)
+	if c.pending.offset != 0 && len(c.pending.txs) == 0 {
+		c.sim.AdjustTime(c.pending.offset)
+	} else {
+		// the time of the blocks with transactions can't be adjusted
+		next.offset, c.pending.offset = c.pending.offset, 0
+		c.sim.Commit()
+		c.waitIndexed(c.pending.txs)
+	}
+	c.blocks = append(c.blocks, c.pending)
+	c.pending = next
+}
+
+// waitIndexed waits for the receipts of the mined transactions, which the simulated
+// backend indexes in the background.
+func (c *Chain) waitIndexed(txs []*types.Transaction) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	for _, tx := range txs {
+		for {
+			if _, err := c.sim.TransactionReceipt(ctx, tx.Hash()); err == nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+}
+
+// Mine mines the pending transactions and the empty blocks after them, n blocks in total.
+func (c *Chain) Mine(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i := 0; i < n; i++ {
+		c.commit()
+	}
+}
+
+// AdvanceTime moves the time of the next mined block forward. If the block has transactions,
+// the time of the empty block after it is moved instead.
+func (c *Chain) AdvanceTime(d time.Duration) {
+	c.mux.Lock()
+	c.pending.offset += d
+	c.mux.Unlock()
+}
+
+// BlockNumber returns the number of the latest mined block.
+func (c *Chain) BlockNumber() uint64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return uint64(len(c.blocks))
+}
+
+// Snapshot returns the id of the current state, which is the number of the mined blocks.
+// The pending transactions are not part of the snapshot.
+func (c *Chain) Snapshot() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.blocks)
+}
+
+// Revert returns the chain to the snapshot, discarding the pending transactions and the
+// blocks mined since. The simulated backend is recreated by replaying the blocks, so the log
+// subscriptions end and the nonces of the accounts are synced from the chain.
+func (c *Chain) Revert(snapshot int) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if snapshot < 0 || snapshot > len(c.blocks) {
+		err := fmt.Errorf("no snapshot %d, the chain has %d blocks", snapshot, len(c.blocks))
+		return err
+	}
+	blocks := c.blocks[:snapshot]
+	c.sim.Close()
+	c.sim = backends.NewSimulatedBackend(c.alloc, c.opts.GasLimit)
+	c.blocks = nil
+	ctx := context.Background()
+	for _, block := range blocks {
+		for _, tx := range block.txs {
+			if err := c.sim.SendTransaction(ctx, tx); err != nil {
+				err = fmt.Errorf("failed to replay transaction %s: %v", tx.Hash().Hex(), err)
+				return err
+			}
+		}
+		c.pending = block
+		c.commit()
+	}
+	c.pending = new(minedBlock)
+	for _, account := range c.Accounts {
+		nonce, err := c.sim.PendingNonceAt(ctx, account.Address)
+		if err != nil {
+			return err
+		}
+		c.Nonces.Set(account.Address, nonce)
+	}
+	return nil
+}
+
+// SendTransaction adds the transaction to the pending block, mining it if AutoMine is set.
+// The invalid transactions are rejected with an error.
+func (c *Chain) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err := c.sim.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	c.pending.txs = append(c.pending.txs, tx)
+	if c.opts.AutoMine {
+		c.commit()
+	}
+	return nil
+}
+
+func (c *Chain) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return c.Backend().CodeAt(ctx, contract, blockNumber)
+}
+
+func (c *Chain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return c.Backend().CallContract(ctx, call, blockNumber)
+}
+
+func (c *Chain) PendingCodeAt(ctx context.Context, contract common.Address) ([]byte, error) {
+	return c.Backend().PendingCodeAt(ctx, contract)
+}
+
+func (c *Chain) PendingCallContract(ctx context.Context, call ethereum.CallMsg) ([]byte, error) {
+	return c.Backend().PendingCallContract(ctx, call)
+}
+
+func (c *Chain) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return c.Backend().PendingNonceAt(ctx, account)
+}
+
+func (c *Chain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return c.Backend().SuggestGasPrice(ctx)
+}
+
+func (c *Chain) ChainID(ctx context.Context) (*big.Int, error) {
+	return c.Backend().ChainID(ctx)
+}
+
+func (c *Chain) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return c.Backend().SuggestGasTipCap(ctx)
+}
+
+func (c *Chain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return c.Backend().HeaderByNumber(ctx, number)
+}
+
+func (c *Chain) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return c.Backend().EstimateGas(ctx, call)
+}
+
+func (c *Chain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	return c.Backend().FilterLogs(ctx, query)
+}
+
+func (c *Chain) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return c.Backend().SubscribeFilterLogs(ctx, query, ch)
+}
+
+// TransactionReceipt returns the receipt of the mined transaction, or ethereum.NotFound.
+func (c *Chain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	receipt, err := c.Backend().TransactionReceipt(ctx, txHash)
+	if err != nil && err.Error() == errIndexing {
+		// the fresh chain reports the pending transactions this way
+		return nil, ethereum.NotFound
+	}
+	return receipt, err
+}
+
+func (c *Chain) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return c.Backend().BalanceAt(ctx, account, blockNumber)
+}
+
+func (c *Chain) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return c.Backend().NonceAt(ctx, account, blockNumber)
+}
+
+func (c *Chain) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	return c.Backend().StorageAt(ctx, account, key, blockNumber)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfwtest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// counterContract increments the counter with every call, emitting Incremented, and
+// reverts with "limit" after 3.
+func counterContract() *sol.Contract {
+	topic := crypto.Keccak256Hash([]byte("Incremented(uint256)"))
+	revert := "08c379a0" +
+		common.Bytes2Hex(common.LeftPadBytes([]byte{0x20}, 32)) +
+		common.Bytes2Hex(common.LeftPadBytes([]byte{5}, 32)) +
+		common.Bytes2Hex(common.RightPadBytes([]byte("limit"), 32))
+	runtime := "600054600101" + // sload(0) + 1
+		"80600411601957" + // jump to 0x19 if < 4
+		"6064604c60003960646000fd" + // revert with the data at 0x4c
+		"5b80600055600052" + // sstore(0, value), mstore(0, value)
+		"7f" + common.Bytes2Hex(topic[:]) + "60206000a1" + // log1(0, 32, topic)
+		"60206000f3" + // return the value
+		revert
+	return &sol.Contract{
+		Name: "Counter",
+		ABI: []byte(`[
+			{"type":"function","name":"increment","inputs":[],"outputs":[{"name":"","type":"uint256"}],"stateMutability":"nonpayable"},
+			{"type":"event","name":"Incremented","inputs":[{"name":"value","type":"uint256","indexed":false}],"anonymous":false}
+		]`),
+		Bin: "60b0600c60003960b06000f3" + runtime,
+	}
+}
+
+func TestChain(t *testing.T) {
+	require := require.New(t)
+	chain := NewChain(ChainOptions{Accounts: 2})
+	defer chain.Close()
+	require.Len(chain.Accounts, 2)
+	other := NewChain(ChainOptions{})
+	defer other.Close()
+	require.Equal(chain.Accounts[0].Address, other.Accounts[0].Address)
+	ctx := context.Background()
+	from := chain.Accounts[0].Address
+	balance, err := chain.BalanceAt(ctx, from, nil)
+	require.NoError(err)
+	require.Equal(DefaultBalance, balance)
+
+	counter, err := chain.Deploy(from, counterContract())
+	require.NoError(err)
+	require.Equal(ethfw.ContractAddress(from, 0), counter.Address())
+	require.Equal(uint64(1), chain.Nonces.Get(from))
+	require.Equal(uint64(1), chain.BlockNumber())
+	snapshot := chain.Snapshot()
+
+	var value *big.Int
+	require.NoError(counter.CallInto(ctx, "increment", &value))
+	require.Equal(big.NewInt(1), value)
+	for i := int64(1); i <= 3; i++ {
+		tx, err := counter.Transact(chain.Transactor(from), "increment")
+		require.NoError(err)
+		receipt, err := chain.Mined(tx)
+		require.NoError(err)
+		var ev struct{ Value *big.Int }
+		RequireEvent(t, counter, receipt, "Incremented", &ev)
+		require.Equal(big.NewInt(i), ev.Value)
+	}
+	require.Equal(uint64(4), chain.Nonces.Get(from))
+	_, err = counter.Transact(chain.Transactor(from), "increment")
+	RequireRevert(t, err, "limit")
+	RequireRevert(t, counter.CallInto(ctx, "increment", &value), "limit")
+	require.Equal(uint64(4), chain.Nonces.Get(from))
+
+	// the reverted chain has the counter just deployed
+	chain.AdvanceTime(time.Hour)
+	chain.Commit()
+	require.NoError(chain.Revert(snapshot))
+	require.Equal(uint64(1), chain.BlockNumber())
+	require.Equal(uint64(1), chain.Nonces.Get(from))
+	require.NoError(counter.CallInto(ctx, "increment", &value))
+	require.Equal(big.NewInt(1), value)
+	require.Error(chain.Revert(2))
+
+	chain.Mine(3)
+	require.Equal(uint64(4), chain.BlockNumber())
+	tx, err := counter.Transact(chain.Transactor(chain.Accounts[1].Address), "increment")
+	require.NoError(err)
+	_, err = chain.Mined(tx)
+	require.NoError(err)
+	logs, err := counter.FilterEvents(ctx, "Incremented", ethfw.EventQuery{FromBlock: 0, ToBlock: new(uint64)})
+	require.NoError(err)
+	require.Empty(logs)
+	last := chain.BlockNumber()
+	logs, err = counter.FilterEvents(ctx, "Incremented", ethfw.EventQuery{ToBlock: &last})
+	require.NoError(err)
+	require.Len(logs, 1)
+}
+
+func TestChainAutoMine(t *testing.T) {
+	require := require.New(t)
+	chain := NewChain(ChainOptions{AutoMine: true})
+	defer chain.Close()
+	from := chain.Accounts[0].Address
+	counter, err := chain.Deploy(from, counterContract())
+	require.NoError(err)
+	tx, err := counter.Transact(chain.Transactor(from), "increment")
+	require.NoError(err)
+	receipt, err := chain.TransactionReceipt(context.Background(), tx.Hash())
+	require.NoError(err)
+	require.NotNil(receipt)
+	require.Len(Events(counter, receipt, "Incremented"), 1)
+	require.Equal(uint64(2), chain.BlockNumber())
+
+	// the nonce taken by the rejected transaction is given back
+	opts := chain.Transactor(from)
+	opts.Value = new(big.Int).Mul(DefaultBalance, big.NewInt(2))
+	opts.GasLimit = 100000
+	_, err = counter.Transact(opts, "increment")
+	require.Error(err)
+	require.Equal(uint64(2), chain.Nonces.Get(from))
+	_, err = counter.Transact(chain.Transactor(from), "increment")
+	require.NoError(err)
+
+	// the access list of the context makes an access list transaction
+	accessList := types.AccessList{{Address: counter.Address(), StorageKeys: []common.Hash{{}}}}
+	opts = chain.Transactor(from)
+	opts.Context = ethfw.WithAccessList(context.Background(), accessList)
+	tx, err = counter.Transact(opts, "increment")
+	require.NoError(err)
+	require.Equal(uint8(types.AccessListTxType), tx.Type())
+	require.Equal(accessList, tx.AccessList())
+}
@@ -0,0 +1,45 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfwtest
+
+import "github.com/AtlantPlatform/ethfw/internal/rpctest"
+
+// MockNode is an in-process fake Ethereum JSON-RPC node over HTTP, for the error path tests.
+// It has no EVM, every method is answered by the scripted responses, queued per method with
+// Enqueue, then by the handlers set with Handle. The built-in handlers keep the block number,
+// the gas price, the nonces, the code and the storage of the accounts, the sent transactions
+// and their receipts.
+type MockNode = rpctest.MockNode
+
+// NewMockNode starts the node, which has the chain id 1337, the block number 0
+// and the gas price of 1 gwei.
+func NewMockNode() *MockNode {
+	return rpctest.NewMockNode()
+}
+
+// RPCError is a JSON-RPC error answered by the MockNode.
+type RPCError = rpctest.RPCError
+
+// The errors geth answers to the rejected transactions.
+var (
+	ErrNonceTooLow            = rpctest.ErrNonceTooLow
+	ErrUnderpriced            = rpctest.ErrUnderpriced
+	ErrReplacementUnderpriced = rpctest.ErrReplacementUnderpriced
+	ErrInsufficientFunds      = rpctest.ErrInsufficientFunds
+	ErrKnownTransaction       = rpctest.ErrKnownTransaction
+)
+
+// Response is a scripted response of the MockNode.
+type Response = rpctest.Response
+
+// Timeout is the response that is never answered, the client times out.
+var Timeout = rpctest.Timeout
+
+// Request is a JSON-RPC request received by the MockNode.
+type Request = rpctest.Request
+
+// HandlerFunc answers the requests of a method, the errors other than *RPCError
+// are answered with the code -32000.
+type HandlerFunc = rpctest.HandlerFunc
@@ -0,0 +1,202 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfwtest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw"
+)
+
+func TestMockNodeTransactions(t *testing.T) {
+	require := require.New(t)
+	node := NewMockNode()
+	defer node.Close()
+	client, err := ethclient.Dial(node.URL())
+	require.NoError(err)
+	defer client.Close()
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	signTx := func(nonce uint64) *types.Transaction {
+		tx := types.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(1e9), nil)
+		signed, err := types.SignTx(tx, types.HomesteadSigner{}, key)
+		require.NoError(err)
+		return signed
+	}
+
+	// the nonce cache syncs from the node
+	node.SetNonce(from, 5)
+	nonces := ethfw.NewNonceCache()
+	nonces.Set(from, 0)
+	nonces.Sync(from, func() (uint64, error) {
+		return client.PendingNonceAt(ctx, from)
+	})
+	require.Equal(uint64(5), nonces.Get(from))
+	err = client.SendTransaction(ctx, signTx(4))
+	require.EqualError(err, "nonce too low")
+	rpcErr, ok := err.(rpc.Error)
+	require.True(ok)
+	require.Equal(-32000, rpcErr.ErrorCode())
+
+	// the scripted failure comes first
+	node.Fail("eth_sendRawTransaction", ErrUnderpriced)
+	tx := signTx(5)
+	require.EqualError(client.SendTransaction(ctx, tx), "transaction underpriced")
+	require.NoError(client.SendTransaction(ctx, tx))
+	require.EqualError(client.SendTransaction(ctx, tx), "already known")
+	require.Len(node.Requests("eth_sendRawTransaction"), 4)
+	sent := node.Transactions()
+	require.Len(sent, 1)
+	require.Equal(tx.Hash(), sent[0].Hash())
+	nonce, err := client.PendingNonceAt(ctx, from)
+	require.NoError(err)
+	require.Equal(uint64(6), nonce)
+
+	// the receipt appears once mined and is gone after the reorg
+	_, err = client.TransactionReceipt(ctx, tx.Hash())
+	require.Equal(ethereum.NotFound, err)
+	node.Mine()
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	require.NoError(err)
+	require.Equal(types.ReceiptStatusSuccessful, receipt.Status)
+	header, err := client.HeaderByNumber(ctx, nil)
+	require.NoError(err)
+	require.Equal(int64(1), header.Number.Int64())
+	node.Reorg(tx.Hash())
+	_, err = client.TransactionReceipt(ctx, tx.Hash())
+	require.Equal(ethereum.NotFound, err)
+	node.Mine()
+	_, err = client.TransactionReceipt(ctx, tx.Hash())
+	require.NoError(err)
+
+	var hash common.Hash
+	require.NoError(node.Requests("eth_getTransactionReceipt")[0].Param(0, &hash))
+	require.Equal(tx.Hash(), hash)
+}
+
+func TestMockNodeResponses(t *testing.T) {
+	require := require.New(t)
+	node := NewMockNode()
+	defer node.Close()
+	client, err := ethclient.Dial(node.URL())
+	require.NoError(err)
+	defer client.Close()
+	ctx := context.Background()
+
+	price, err := client.SuggestGasPrice(ctx)
+	require.NoError(err)
+	require.Equal(big.NewInt(1e9), price)
+	node.SetGasPrice(big.NewInt(2e9))
+	node.Enqueue("eth_gasPrice",
+		Response{Result: "0x1"},
+		Response{HTTPStatus: 503},
+		Response{Error: &RPCError{Code: -32005, Message: "limit exceeded"}},
+		Timeout,
+	)
+	price, err = client.SuggestGasPrice(ctx)
+	require.NoError(err)
+	require.Equal(big.NewInt(1), price)
+	_, err = client.SuggestGasPrice(ctx)
+	require.Error(err)
+	require.Contains(err.Error(), "503")
+	_, err = client.SuggestGasPrice(ctx)
+	require.EqualError(err, "limit exceeded")
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = client.SuggestGasPrice(timeoutCtx)
+	require.Error(err)
+	require.Contains(err.Error(), context.DeadlineExceeded.Error())
+	price, err = client.SuggestGasPrice(ctx)
+	require.NoError(err)
+	require.Equal(big.NewInt(2e9), price)
+	require.Len(node.Requests("eth_gasPrice"), 6)
+
+	node.SetResult("eth_getCode", "0x6001")
+	code, err := client.CodeAt(ctx, common.Address{}, nil)
+	require.NoError(err)
+	require.Equal([]byte{0x60, 0x01}, code)
+	_, err = client.BalanceAt(ctx, common.Address{}, nil)
+	require.EqualError(err, "the method eth_getBalance does not exist/is not available")
+	id, err := client.NetworkID(ctx)
+	require.NoError(err)
+	require.Equal(big.NewInt(1337), id)
+}
+
+func TestMockNodeState(t *testing.T) {
+	require := require.New(t)
+	node := NewMockNode()
+	defer node.Close()
+	client, err := ethclient.Dial(node.URL())
+	require.NoError(err)
+	defer client.Close()
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	signTx := func(nonce uint64, to *common.Address, data []byte) *types.Transaction {
+		tx := types.NewContractCreation(nonce, new(big.Int), 21000, big.NewInt(1e9), data)
+		if to != nil {
+			tx = types.NewTransaction(nonce, *to, new(big.Int), 21000, big.NewInt(1e9), data)
+		}
+		signed, err := types.SignTx(tx, types.HomesteadSigner{}, key)
+		require.NoError(err)
+		return signed
+	}
+
+	// the transactions are mined as they come, the created contracts get the init code
+	node.SetAutoMine(true)
+	create := signTx(0, nil, []byte{0x60, 0x80})
+	require.NoError(client.SendTransaction(ctx, create))
+	receipt, err := client.TransactionReceipt(ctx, create.Hash())
+	require.NoError(err)
+	require.Equal(crypto.CreateAddress(from, 0), receipt.ContractAddress)
+	code, err := client.CodeAt(ctx, receipt.ContractAddress, nil)
+	require.NoError(err)
+	require.Equal([]byte{0x60, 0x80}, code)
+	tx, isPending, err := client.TransactionByHash(ctx, create.Hash())
+	require.NoError(err)
+	require.True(isPending)
+	require.Equal(create.Hash(), tx.Hash())
+	gas, err := client.EstimateGas(ctx, ethereum.CallMsg{From: from})
+	require.NoError(err)
+	require.Equal(uint64(1000000), gas)
+
+	// the hook stands for the EVM
+	node.OnTransaction(func(sender common.Address, tx *types.Transaction) error {
+		require.Equal(from, sender)
+		if len(tx.Data()) > 0 {
+			return &RPCError{Code: 3, Message: "execution reverted"}
+		}
+		node.SetStorage(*tx.To(), common.Hash{}, common.BigToHash(big.NewInt(1)))
+		return nil
+	})
+	to := common.HexToAddress("0x01")
+	require.EqualError(client.SendTransaction(ctx, signTx(1, &to, []byte{1})), "execution reverted")
+	require.NoError(client.SendTransaction(ctx, signTx(1, &to, nil)))
+	value, err := client.StorageAt(ctx, to, common.Hash{}, nil)
+	require.NoError(err)
+	require.Equal(common.BigToHash(big.NewInt(1)).Bytes(), value)
+	require.Len(node.Transactions(), 2)
+
+	// the lost responses are served first
+	node.Enqueue("eth_sendRawTransaction", Response{HTTPStatus: 502, Lost: true})
+	lost := signTx(2, &to, nil)
+	require.Error(client.SendTransaction(ctx, lost))
+	require.Len(node.Transactions(), 3)
+	require.EqualError(client.SendTransaction(ctx, lost), "already known")
+}
@@ -0,0 +1,383 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// DefaultLogChunkSize is the number of blocks queried by a single eth_getLogs request.
+	DefaultLogChunkSize = 5000
+	// DefaultPollInterval is the interval of polling for new events, when the node
+	// doesn't support subscriptions.
+	DefaultPollInterval = 15 * time.Second
+	// DefaultMaxPollFailures is the number of consecutive failed polls that ends
+	// the polling subscription.
+	DefaultMaxPollFailures = 10
+)
+
+// EventQuery selects the events of a contract.
+type EventQuery struct {
+	// FromBlock is the first block to query, WatchEvents starts from the next block
+	// if it's zero.
+	FromBlock uint64
+	// ToBlock is the last block to query, nil means the latest block.
+	// It's ignored by WatchEvents.
+	ToBlock *uint64
+	// Indexed filters the events by the values of the indexed arguments, keyed by their names,
+	// the values of an argument are alternatives. Values must have the Go types of the arguments,
+	// strings and bytes are hashed, other dynamic types must be given as common.Hash.
+	Indexed map[string][]interface{}
+	// ChunkSize is the maximum number of blocks per request, zero means DefaultLogChunkSize.
+	// Chunks are halved further if the node rejects a request for returning too many logs.
+	ChunkSize uint64
+	// PollInterval is used by WatchEvents if the node doesn't support subscriptions,
+	// zero means DefaultPollInterval.
+	PollInterval time.Duration
+	// MaxPollFailures is the number of consecutive failed polls after which WatchEvents
+	// gives up, zero means DefaultMaxPollFailures.
+	MaxPollFailures int
+}
+
+// eventTopics returns the topics filter of the event, the first topic is the event id.
+func (c *BoundContract) eventTopics(name string, indexed map[string][]interface{}) ([][]common.Hash, error) {
+	ev, ok := c.abi.Events[name]
+	if !ok {
+		err := fmt.Errorf("event %s not found in ABI of %s", name, c.contractName())
+		return nil, err
+	}
+	for argName := range indexed {
+		if !isIndexedArg(ev, argName) {
+			err := fmt.Errorf("event %s has no indexed argument %s", name, argName)
+			return nil, err
+		}
+	}
+	topics := [][]common.Hash{{ev.ID}}
+	for _, arg := range ev.Inputs {
+		if !arg.Indexed {
+			continue
+		}
+		var rule []common.Hash
+		for _, value := range indexed[arg.Name] {
+			topic, err := makeTopic(arg, value)
+			if err != nil {
+				err = fmt.Errorf("event %s: %v", name, err)
+				return nil, err
+			}
+			rule = append(rule, topic)
+		}
+		topics = append(topics, rule)
+	}
+	// trailing wildcards are not needed
+	for len(topics) > 1 && len(topics[len(topics)-1]) == 0 {
+		topics = topics[:len(topics)-1]
+	}
+	return topics, nil
+}
+
+func isIndexedArg(ev abi.Event, name string) bool {
+	for _, arg := range ev.Inputs {
+		if arg.Indexed && arg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// makeTopic encodes the value of the indexed argument, checking its type.
+func makeTopic(arg abi.Argument, value interface{}) (common.Hash, error) {
+	if hash, ok := value.(common.Hash); ok {
+		return hash, nil
+	}
+	switch arg.Type.T {
+	case abi.StringTy:
+		s, ok := value.(string)
+		if !ok {
+			err := fmt.Errorf("argument %s: expected string, got %T", arg.Name, value)
+			return common.Hash{}, err
+		}
+		return crypto.Keccak256Hash([]byte(s)), nil
+	case abi.BytesTy:
+		b, ok := value.([]byte)
+		if !ok {
+			err := fmt.Errorf("argument %s: expected []byte, got %T", arg.Name, value)
+			return common.Hash{}, err
+		}
+		return crypto.Keccak256Hash(b), nil
+	case abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		err := fmt.Errorf("argument %s: expected common.Hash of %s, got %T", arg.Name, arg.Type, value)
+		return common.Hash{}, err
+	}
+	packed, err := abi.Arguments{{Type: arg.Type}}.Pack(value)
+	if err != nil {
+		err = fmt.Errorf("argument %s: %v", arg.Name, err)
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(packed), nil
+}
+
+// FilterEvents returns the logs of the named event emitted by the contract, matching the query.
+// Long block ranges are queried in chunks.
+func (c *BoundContract) FilterEvents(ctx context.Context, name string, q EventQuery) ([]types.Log, error) {
+	topics, err := c.eventTopics(name, q.Indexed)
+	if err != nil {
+		return nil, err
+	}
+	to := q.ToBlock
+	if to == nil {
+		head, err := c.client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to get the latest block: %v", err)
+			return nil, err
+		}
+		latest := head.Number.Uint64()
+		to = &latest
+	}
+	return c.filterLogs(ctx, topics, q.FromBlock, *to, q.ChunkSize)
+}
+
+func (c *BoundContract) filterLogs(ctx context.Context, topics [][]common.Hash,
+	from, to, chunkSize uint64) ([]types.Log, error) {
+
+	if chunkSize == 0 {
+		chunkSize = DefaultLogChunkSize
+	}
+	var logs []types.Log
+	for from <= to {
+		end := from + chunkSize - 1
+		if end > to || end < from {
+			end = to
+		}
+		chunk, err := c.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: []common.Address{c.address},
+			Topics:    topics,
+		})
+		if err != nil {
+			if end > from && isLogLimitError(err) {
+				chunkSize = (end - from + 1) / 2
+				continue
+			}
+			err = fmt.Errorf("failed to get logs of blocks %d-%d: %v", from, end, err)
+			return nil, err
+		}
+		logs = append(logs, chunk...)
+		if end == to {
+			break
+		}
+		from = end + 1
+	}
+	return logs, nil
+}
+
+// isLogLimitError reports whether the node rejected eth_getLogs because of the size
+// of the response or of the block range, the messages differ between nodes.
+func isLogLimitError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"query returned more than",
+		"limit exceeded",
+		"too many",
+		"block range",
+		"response size",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeEvent decodes the log of the named event into out, which is either a pointer
+// to a struct with the fields named after the arguments, or a map. Indexed arguments
+// of dynamic types are decoded as their hashes.
+func (c *BoundContract) DecodeEvent(name string, log types.Log, out interface{}) error {
+	if m, ok := out.(map[string]interface{}); ok {
+		return c.decodeEventMap(name, log, m)
+	}
+	if rv := reflect.ValueOf(out); rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Map {
+		m, ok := rv.Elem().Interface().(map[string]interface{})
+		if !ok {
+			err := fmt.Errorf("cannot decode event into %T", out)
+			return err
+		}
+		if m == nil {
+			m = make(map[string]interface{})
+			rv.Elem().Set(reflect.ValueOf(m))
+		}
+		return c.decodeEventMap(name, log, m)
+	}
+	return c.BoundContract.UnpackLog(out, name, log)
+}
+
+func (c *BoundContract) decodeEventMap(name string, log types.Log, out map[string]interface{}) error {
+	ev, ok := c.abi.Events[name]
+	if !ok {
+		err := fmt.Errorf("event %s not found in ABI of %s", name, c.contractName())
+		return err
+	}
+	if !ev.Anonymous && (len(log.Topics) == 0 || log.Topics[0] != ev.ID) {
+		err := fmt.Errorf("log is not of event %s", name)
+		return err
+	}
+	values, err := ev.Inputs.UnpackValues(log.Data)
+	if err != nil {
+		err = fmt.Errorf("failed to unpack event %s: %v", name, err)
+		return err
+	}
+	topics := log.Topics
+	if !ev.Anonymous {
+		topics = topics[1:]
+	}
+	for i, arg := range ev.Inputs {
+		argName := arg.Name
+		if len(argName) == 0 {
+			argName = fmt.Sprintf("arg%d", i)
+		}
+		if !arg.Indexed {
+			out[argName], values = values[0], values[1:]
+			continue
+		}
+		if len(topics) == 0 {
+			err := fmt.Errorf("log of event %s has too few topics", name)
+			return err
+		}
+		value, err := parseTopic(arg, topics[0])
+		if err != nil {
+			err = fmt.Errorf("failed to unpack event %s: %v", name, err)
+			return err
+		}
+		out[argName], topics = value, topics[1:]
+	}
+	return nil
+}
+
+func parseTopic(arg abi.Argument, topic common.Hash) (interface{}, error) {
+	switch arg.Type.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return topic, nil
+	}
+	values, err := abi.Arguments{{Type: arg.Type}}.UnpackValues(topic[:])
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// WatchEvents streams the logs of the named event emitted by the contract into the sink,
+// starting after the latest block, or from the FromBlock of the query if it's set. The node
+// subscription is used if supported, otherwise the node is polled for new logs until
+// the context is done or the subscription is unsubscribed. A failed poll is retried
+// from the same block, the interval doubles with each consecutive failure, up to
+// 32 intervals. The subscription ends with the last error after MaxPollFailures of them.
+func (c *BoundContract) WatchEvents(ctx context.Context, name string, q EventQuery,
+	sink chan<- types.Log) (event.Subscription, error) {
+
+	topics, err := c.eventTopics(name, q.Indexed)
+	if err != nil {
+		return nil, err
+	}
+	if q.FromBlock == 0 {
+		sub, err := c.client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{
+			Addresses: []common.Address{c.address},
+			Topics:    topics,
+		}, sink)
+		if err == nil {
+			return sub, nil
+		} else if err != rpc.ErrNotificationsUnsupported {
+			return nil, err
+		}
+	}
+	next := q.FromBlock
+	if next == 0 {
+		head, err := c.client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to get the latest block: %v", err)
+			return nil, err
+		}
+		next = head.Number.Uint64() + 1
+	}
+	interval := q.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	maxFailures := q.MaxPollFailures
+	if maxFailures <= 0 {
+		maxFailures = DefaultMaxPollFailures
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		var failures int
+		for {
+			logs, latest, err := c.pollLogs(ctx, topics, next, q.ChunkSize)
+			wait := interval
+			if err != nil {
+				failures++
+				if failures >= maxFailures {
+					return err
+				}
+				wait = interval << uint(min(failures, 5))
+			} else {
+				failures = 0
+				for _, log := range logs {
+					select {
+					case sink <- log:
+					case <-quit:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				if latest >= next {
+					next = latest + 1
+				}
+			}
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-quit:
+				t.Stop()
+				return nil
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+	}), nil
+}
+
+// pollLogs returns the logs from the next block up to the latest one, and the latest block number.
+func (c *BoundContract) pollLogs(ctx context.Context, topics [][]common.Hash,
+	next, chunkSize uint64) ([]types.Log, uint64, error) {
+
+	head, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to get the latest block: %v", err)
+		return nil, 0, err
+	}
+	latest := head.Number.Uint64()
+	if latest < next {
+		return nil, latest, nil
+	}
+	logs, err := c.filterLogs(ctx, topics, next, latest, chunkSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, latest, nil
+}
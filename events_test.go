@@ -0,0 +1,269 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+const eventsTestABI = `[
+	{"type":"event","name":"Transfer","anonymous":false,"inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]},
+	{"type":"event","name":"Named","anonymous":false,"inputs":[{"name":"label","type":"string","indexed":true},{"name":"id","type":"uint64","indexed":false}]}
+]`
+
+var (
+	eventsTestAddress = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	eventsTestFrom    = common.HexToAddress("0x2000000000000000000000000000000000000002")
+	eventsTestTo      = common.HexToAddress("0x3000000000000000000000000000000000000003")
+)
+
+func newEventsTestContract(t *testing.T, node *rpctest.MockNode) (*BoundContract, func()) {
+	client, closeFn := dial(t, node)
+	contract, err := BindContract(client, &sol.Contract{
+		Name:    "Token",
+		ABI:     []byte(eventsTestABI),
+		Address: eventsTestAddress,
+	})
+	require.NoError(t, err)
+	return contract, closeFn
+}
+
+// transferLog returns the log of Transfer(eventsTestFrom, eventsTestTo, value) in the block.
+func transferLog(t *testing.T, block uint64, value int64) types.Log {
+	parsed, err := abi.JSON(bytes.NewReader([]byte(eventsTestABI)))
+	require.NoError(t, err)
+	data, err := parsed.Events["Transfer"].Inputs.NonIndexed().Pack(big.NewInt(value))
+	require.NoError(t, err)
+	return types.Log{
+		Address: eventsTestAddress,
+		Topics: []common.Hash{
+			parsed.Events["Transfer"].ID,
+			common.BytesToHash(eventsTestFrom.Bytes()),
+			common.BytesToHash(eventsTestTo.Bytes()),
+		},
+		Data:        data,
+		BlockNumber: block,
+	}
+}
+
+type filterRange struct {
+	From, To uint64
+}
+
+// handleLogs answers eth_getLogs with a Transfer log in the first block of each range,
+// failing the ranges longer than maxRange.
+func handleLogs(t *testing.T, node *rpctest.MockNode, maxRange uint64) *[]filterRange {
+	var ranges []filterRange
+	node.Handle("eth_getLogs", func(req rpctest.Request) (interface{}, error) {
+		var q struct {
+			FromBlock hexutil.Uint64 `json:"fromBlock"`
+			ToBlock   hexutil.Uint64 `json:"toBlock"`
+		}
+		if err := json.Unmarshal(req.Params[0], &q); err != nil {
+			return nil, err
+		}
+		if maxRange > 0 && uint64(q.ToBlock-q.FromBlock)+1 > maxRange {
+			return nil, errors.New("query returned more than 10000 results")
+		}
+		ranges = append(ranges, filterRange{uint64(q.FromBlock), uint64(q.ToBlock)})
+		return []types.Log{transferLog(t, uint64(q.FromBlock), int64(q.FromBlock))}, nil
+	})
+	return &ranges
+}
+
+func TestFilterEvents(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetBlockNumber(25)
+	ranges := handleLogs(t, node, 0)
+	contract, closeFn := newEventsTestContract(t, node)
+	defer closeFn()
+
+	logs, err := contract.FilterEvents(context.Background(), "Transfer", EventQuery{
+		Indexed: map[string][]interface{}{
+			"to": {eventsTestTo},
+		},
+		ChunkSize: 10,
+	})
+	require.NoError(err)
+	require.Len(logs, 3)
+	require.Equal([]filterRange{{0, 9}, {10, 19}, {20, 25}}, *ranges)
+
+	var q struct {
+		Address []common.Address `json:"address"`
+		Topics  [][]common.Hash  `json:"topics"`
+	}
+	require.NoError(json.Unmarshal(node.Requests("eth_getLogs")[0].Params[0], &q))
+	require.Equal([]common.Address{eventsTestAddress}, q.Address)
+	require.Equal([][]common.Hash{
+		{crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))},
+		nil,
+		{common.BytesToHash(eventsTestTo.Bytes())},
+	}, q.Topics)
+}
+
+func TestFilterEventsSplitsChunks(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	ranges := handleLogs(t, node, 4)
+	contract, closeFn := newEventsTestContract(t, node)
+	defer closeFn()
+
+	to := uint64(9)
+	logs, err := contract.FilterEvents(context.Background(), "Transfer", EventQuery{
+		FromBlock: 0,
+		ToBlock:   &to,
+		ChunkSize: 10,
+	})
+	require.NoError(err)
+	require.Len(logs, 5)
+	require.Equal([]filterRange{{0, 1}, {2, 3}, {4, 5}, {6, 7}, {8, 9}}, *ranges)
+}
+
+func TestFilterEventsTypes(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	contract, closeFn := newEventsTestContract(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	to := uint64(1)
+
+	_, err := contract.FilterEvents(ctx, "Transfer", EventQuery{
+		ToBlock: &to,
+		Indexed: map[string][]interface{}{"from": {"0x2000000000000000000000000000000000000002"}},
+	})
+	require.Error(err)
+	_, err = contract.FilterEvents(ctx, "Transfer", EventQuery{
+		ToBlock: &to,
+		Indexed: map[string][]interface{}{"value": {big.NewInt(1)}},
+	})
+	require.EqualError(err, "event Transfer has no indexed argument value")
+	_, err = contract.FilterEvents(ctx, "Approval", EventQuery{ToBlock: &to})
+	require.Error(err)
+
+	topics, err := contract.eventTopics("Named", map[string][]interface{}{
+		"label": {"a", "b"},
+	})
+	require.NoError(err)
+	require.Equal([]common.Hash{crypto.Keccak256Hash([]byte("a")), crypto.Keccak256Hash([]byte("b"))}, topics[1])
+}
+
+func TestDecodeEvent(t *testing.T) {
+	require := require.New(t)
+	contract, closeFn := newEventsTestContract(t, rpctest.NewMockNode())
+	defer closeFn()
+	log := transferLog(t, 1, 42)
+
+	values := make(map[string]interface{})
+	require.NoError(contract.DecodeEvent("Transfer", log, values))
+	require.Equal(map[string]interface{}{
+		"from":  eventsTestFrom,
+		"to":    eventsTestTo,
+		"value": big.NewInt(42),
+	}, values)
+
+	var transfer struct {
+		From  common.Address
+		To    common.Address
+		Value *big.Int
+	}
+	require.NoError(contract.DecodeEvent("Transfer", log, &transfer))
+	require.Equal(eventsTestTo, transfer.To)
+	require.Equal(int64(42), transfer.Value.Int64())
+
+	require.Error(contract.DecodeEvent("Named", log, values))
+}
+
+func TestWatchEventsPolling(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetBlockNumber(10)
+	ranges := handleLogs(t, node, 0)
+	contract, closeFn := newEventsTestContract(t, node)
+	defer closeFn()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := make(chan types.Log)
+	sub, err := contract.WatchEvents(ctx, "Transfer", EventQuery{
+		PollInterval: 10 * time.Millisecond,
+	}, sink)
+	require.NoError(err)
+	defer sub.Unsubscribe()
+
+	node.SetBlockNumber(12)
+	select {
+	case log := <-sink:
+		require.Equal(uint64(11), log.BlockNumber)
+	case err := <-sub.Err():
+		require.FailNow("subscription failed", "%v", err)
+	case <-time.After(5 * time.Second):
+		require.FailNow("no events")
+	}
+	sub.Unsubscribe()
+	require.Equal(filterRange{11, 12}, (*ranges)[0])
+	// the node doesn't support subscriptions over HTTP
+	require.Empty(node.Requests("eth_subscribe"))
+}
+
+func TestWatchEventsPollingRetry(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetBlockNumber(10)
+	handleLogs(t, node, 0)
+	contract, closeFn := newEventsTestContract(t, node)
+	defer closeFn()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := make(chan types.Log)
+	sub, err := contract.WatchEvents(ctx, "Transfer", EventQuery{
+		PollInterval:    10 * time.Millisecond,
+		MaxPollFailures: 3,
+	}, sink)
+	require.NoError(err)
+	defer sub.Unsubscribe()
+
+	// a failed poll is retried from the same block
+	busy := &rpctest.RPCError{Code: -32000, Message: "busy"}
+	node.Fail("eth_getLogs", busy)
+	node.SetBlockNumber(12)
+	select {
+	case log := <-sink:
+		require.Equal(uint64(11), log.BlockNumber)
+	case err := <-sub.Err():
+		require.FailNow("subscription failed", "%v", err)
+	case <-time.After(5 * time.Second):
+		require.FailNow("no events")
+	}
+
+	// the subscription ends after the consecutive failures
+	node.Fail("eth_getBlockByNumber", busy, busy, busy)
+	select {
+	case <-sink:
+		require.FailNow("unexpected event")
+	case err := <-sub.Err():
+		require.Error(err)
+		require.Contains(err.Error(), "busy")
+	case <-time.After(5 * time.Second):
+		require.FailNow("subscription didn't end")
+	}
+}
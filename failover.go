@@ -0,0 +1,636 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures opening the circuit
+	// breaker of an endpoint.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerTimeout is the time an endpoint is avoided for once its breaker opens.
+	DefaultBreakerTimeout = 30 * time.Second
+	// DefaultMaxLag is the number of blocks an endpoint may be behind the highest one
+	// and still be healthy.
+	DefaultMaxLag = 5
+)
+
+// DefaultRetryPolicy is the retry policy of the methods without their own one.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+}
+
+// ErrNoEndpoints is returned when all the endpoints have their circuit breakers open.
+var ErrNoEndpoints = errors.New("no available RPC endpoints")
+
+// RetryPolicy is the retry policy of a JSON-RPC method.
+type RetryPolicy struct {
+	// Attempts is the number of attempts across the endpoints, 1 disables retries.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled before every next one.
+	Backoff time.Duration
+	// MaxBackoff limits the delay between the retries.
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// FailoverOptions configure the FailoverClient.
+type FailoverOptions struct {
+	// Retry is the retry policy of the methods missing from Retries, DefaultRetryPolicy
+	// by default.
+	Retry RetryPolicy
+	// Retries are the retry policies by JSON-RPC method, e.g. eth_call.
+	Retries map[string]RetryPolicy
+	// BreakerThreshold is the number of consecutive failures opening the circuit breaker
+	// of an endpoint, DefaultBreakerThreshold by default.
+	BreakerThreshold int
+	// BreakerTimeout is the time an endpoint is avoided for once its breaker opens, then
+	// a single request probes it. DefaultBreakerTimeout by default.
+	BreakerTimeout time.Duration
+	// RateLimit is the number of requests per second sent to each endpoint, unlimited if zero.
+	RateLimit float64
+	// Burst is the number of requests that may be sent at once within the rate limit, 1 by default.
+	Burst int
+	// HealthInterval is the interval of the health checks, which are only made
+	// by CheckHealth if it's zero.
+	HealthInterval time.Duration
+	// MaxLag is the number of blocks an endpoint may be behind the highest one,
+	// DefaultMaxLag by default.
+	MaxLag uint64
+}
+
+// FailoverClient is a Backend over several JSON-RPC endpoints. The requests go to the first
+// healthy endpoint, the failed ones are retried with backoff on the next endpoints. Only the
+// failures of the transport are retried, along with the rate limit errors, the nodes rejecting
+// the requests are not. The endpoints failing repeatedly are avoided by their circuit breakers,
+// the ones lagging behind are avoided until the next health check. Sent transactions are looked
+// up after a failure, so that a transaction is never reported failed once the node has it.
+type FailoverClient struct {
+	opts      FailoverOptions
+	endpoints []*failoverEndpoint
+
+	closeOnce sync.Once
+	closeC    chan struct{}
+}
+
+type failoverEndpoint struct {
+	url     string
+	rpc     *rpc.Client
+	client  *ethclient.Client
+	limiter *tokenBucket
+
+	mux       sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	height    uint64
+	lagging   bool
+}
+
+// EndpointStatus is the state of an endpoint of the FailoverClient.
+type EndpointStatus struct {
+	URL string
+	// Height is the block number seen by the last health check.
+	Height uint64
+	// Healthy is false if the endpoint failed the last health check or lags behind.
+	Healthy bool
+	// Failures is the number of consecutive failures.
+	Failures int
+	// BreakerOpen is true if the endpoint is avoided after the failures.
+	BreakerOpen bool
+}
+
+// DialFailover connects to the endpoints, in the order of preference.
+func DialFailover(ctx context.Context, urls []string, opts FailoverOptions) (*FailoverClient, error) {
+	if len(urls) == 0 {
+		err := errors.New("no RPC endpoints to dial")
+		return nil, err
+	}
+	if opts.Retry.Attempts <= 0 {
+		opts.Retry = DefaultRetryPolicy
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if opts.BreakerTimeout <= 0 {
+		opts.BreakerTimeout = DefaultBreakerTimeout
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+	if opts.MaxLag == 0 {
+		opts.MaxLag = DefaultMaxLag
+	}
+	c := &FailoverClient{
+		opts:   opts,
+		closeC: make(chan struct{}),
+	}
+	for _, url := range urls {
+		rpcClient, err := rpc.DialContext(ctx, url)
+		if err != nil {
+			c.Close()
+			err = fmt.Errorf("failed to dial %s: %v", url, err)
+			return nil, err
+		}
+		e := &failoverEndpoint{
+			url:    url,
+			rpc:    rpcClient,
+			client: ethclient.NewClient(rpcClient),
+		}
+		if opts.RateLimit > 0 {
+			e.limiter = newTokenBucket(opts.RateLimit, opts.Burst)
+		}
+		c.endpoints = append(c.endpoints, e)
+	}
+	if opts.HealthInterval > 0 {
+		go c.checkHealthLoop()
+	}
+	return c, nil
+}
+
+// Close stops the health checks and closes the connections.
+func (c *FailoverClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeC)
+		for _, e := range c.endpoints {
+			e.rpc.Close()
+		}
+	})
+}
+
+func (c *FailoverClient) checkHealthLoop() {
+	t := time.NewTicker(c.opts.HealthInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.closeC:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.opts.HealthInterval)
+			c.CheckHealth(ctx)
+			cancel()
+		}
+	}
+}
+
+// CheckHealth queries the block numbers of the endpoints. The endpoints failing to answer
+// or lagging more than MaxLag blocks behind the highest one are avoided until the next check,
+// unless no other endpoint is available.
+func (c *FailoverClient) CheckHealth(ctx context.Context) []EndpointStatus {
+	var wg sync.WaitGroup
+	heights := make([]*uint64, len(c.endpoints))
+	for i, e := range c.endpoints {
+		wg.Add(1)
+		go func(i int, e *failoverEndpoint) {
+			defer wg.Done()
+			var height hexutil.Uint64
+			if err := e.rpc.CallContext(ctx, &height, "eth_blockNumber"); err == nil {
+				h := uint64(height)
+				heights[i] = &h
+			}
+		}(i, e)
+	}
+	wg.Wait()
+	var best uint64
+	for _, h := range heights {
+		if h != nil && *h > best {
+			best = *h
+		}
+	}
+	for i, e := range c.endpoints {
+		e.mux.Lock()
+		if h := heights[i]; h != nil {
+			e.height = *h
+			e.lagging = *h+c.opts.MaxLag < best
+		} else {
+			e.lagging = true
+		}
+		e.mux.Unlock()
+	}
+	return c.Status()
+}
+
+// Status returns the state of the endpoints.
+func (c *FailoverClient) Status() []EndpointStatus {
+	now := time.Now()
+	status := make([]EndpointStatus, len(c.endpoints))
+	for i, e := range c.endpoints {
+		e.mux.Lock()
+		status[i] = EndpointStatus{
+			URL:         e.url,
+			Height:      e.height,
+			Healthy:     !e.lagging,
+			Failures:    e.failures,
+			BreakerOpen: e.failures >= c.opts.BreakerThreshold && now.Before(e.openUntil),
+		}
+		e.mux.Unlock()
+	}
+	return status
+}
+
+// acquire reports whether the endpoint may be used, letting a single probe through
+// once the breaker timeout passes.
+func (e *failoverEndpoint) acquire(now time.Time, threshold int, healthyOnly bool) bool {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if healthyOnly && e.lagging {
+		return false
+	}
+	if e.failures < threshold {
+		return true
+	}
+	if now.Before(e.openUntil) || e.probing {
+		return false
+	}
+	e.probing = true
+	return true
+}
+
+// release ends the probe without a result.
+func (e *failoverEndpoint) release() {
+	e.mux.Lock()
+	e.probing = false
+	e.mux.Unlock()
+}
+
+func (e *failoverEndpoint) report(failed bool, threshold int, timeout time.Duration) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.probing = false
+	if !failed {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if e.failures >= threshold {
+		e.openUntil = time.Now().Add(timeout)
+	}
+}
+
+// pick returns the first available endpoint not tried yet, preferring the healthy ones.
+func (c *FailoverClient) pick(tried map[*failoverEndpoint]bool) *failoverEndpoint {
+	now := time.Now()
+	for _, healthyOnly := range []bool{true, false} {
+		for _, e := range c.endpoints {
+			if !tried[e] && e.acquire(now, c.opts.BreakerThreshold, healthyOnly) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (c *FailoverClient) policy(method string) RetryPolicy {
+	if p, ok := c.opts.Retries[method]; ok && p.Attempts > 0 {
+		return p
+	}
+	return c.opts.Retry
+}
+
+// call makes the request with fn, retrying it on the next endpoints according to
+// the policy of the method.
+func (c *FailoverClient) call(ctx context.Context, method string,
+	fn func(ctx context.Context, client *ethclient.Client) error) error {
+
+	policy := c.policy(method)
+	tried := make(map[*failoverEndpoint]bool, len(c.endpoints))
+	var lastErr error
+	for attempt := 0; attempt < policy.Attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(policy.backoff(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		e := c.pick(tried)
+		if e == nil && len(tried) > 0 {
+			// every endpoint has been tried, start over
+			tried = make(map[*failoverEndpoint]bool, len(c.endpoints))
+			e = c.pick(tried)
+		}
+		if e == nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return ErrNoEndpoints
+		}
+		tried[e] = true
+		if e.limiter != nil {
+			if err := e.limiter.wait(ctx); err != nil {
+				e.release()
+				return err
+			}
+		}
+		err := fn(ctx, e.client)
+		retry := err != nil && isRetriable(ctx, err)
+		e.report(retry, c.opts.BreakerThreshold, c.opts.BreakerTimeout)
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// isRetriable reports whether the request may succeed if it's repeated: the connection
+// failed or timed out, the node limited the rate or answered with a server error.
+// Other errors, like the ones returned by the node or the rejected HTTP requests, are final.
+func isRetriable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch err {
+	case ethereum.NotFound, rpc.ErrNotificationsUnsupported, context.Canceled, context.DeadlineExceeded:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+	}
+	if rpcErr, ok := err.(rpc.Error); ok {
+		// limit exceeded
+		return rpcErr.ErrorCode() == -32005
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+var knownTransactionErrors = []string{
+	"already known",
+	"known transaction",
+	"already imported",
+	"already exists",
+}
+
+func isKnownTransaction(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range knownTransactionErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait takes a token, waiting for it if the bucket is empty.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mux.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mux.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mux.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// SendTransaction sends the transaction, retrying only if the endpoint doesn't have it
+// after the failure. The endpoints reporting the transaction as known accept it.
+func (c *FailoverClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return c.call(ctx, "eth_sendRawTransaction", func(ctx context.Context, client *ethclient.Client) error {
+		err := client.SendTransaction(ctx, tx)
+		if err == nil || isKnownTransaction(err) {
+			return nil
+		}
+		// the transaction may have reached the node, or an endpoint tried before
+		if _, _, lookupErr := client.TransactionByHash(ctx, tx.Hash()); lookupErr == nil {
+			return nil
+		}
+		return err
+	})
+}
+
+func (c *FailoverClient) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	var code []byte
+	err := c.call(ctx, "eth_getCode", func(ctx context.Context, client *ethclient.Client) (err error) {
+		code, err = client.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+func (c *FailoverClient) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	var code []byte
+	err := c.call(ctx, "eth_getCode", func(ctx context.Context, client *ethclient.Client) (err error) {
+		code, err = client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return code, err
+}
+
+func (c *FailoverClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var output []byte
+	err := c.call(ctx, "eth_call", func(ctx context.Context, client *ethclient.Client) (err error) {
+		output, err = client.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return output, err
+}
+
+func (c *FailoverClient) PendingCallContract(ctx context.Context, msg ethereum.CallMsg) ([]byte, error) {
+	var output []byte
+	err := c.call(ctx, "eth_call", func(ctx context.Context, client *ethclient.Client) (err error) {
+		output, err = client.PendingCallContract(ctx, msg)
+		return err
+	})
+	return output, err
+}
+
+func (c *FailoverClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	var nonce uint64
+	err := c.call(ctx, "eth_getTransactionCount", func(ctx context.Context, client *ethclient.Client) (err error) {
+		nonce, err = client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+func (c *FailoverClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	var nonce uint64
+	err := c.call(ctx, "eth_getTransactionCount", func(ctx context.Context, client *ethclient.Client) (err error) {
+		nonce, err = client.NonceAt(ctx, account, blockNumber)
+		return err
+	})
+	return nonce, err
+}
+
+func (c *FailoverClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	var balance *big.Int
+	err := c.call(ctx, "eth_getBalance", func(ctx context.Context, client *ethclient.Client) (err error) {
+		balance, err = client.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return balance, err
+}
+
+func (c *FailoverClient) StorageAt(ctx context.Context, account common.Address,
+	key common.Hash, blockNumber *big.Int) ([]byte, error) {
+
+	var value []byte
+	err := c.call(ctx, "eth_getStorageAt", func(ctx context.Context, client *ethclient.Client) (err error) {
+		value, err = client.StorageAt(ctx, account, key, blockNumber)
+		return err
+	})
+	return value, err
+}
+
+func (c *FailoverClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var price *big.Int
+	err := c.call(ctx, "eth_gasPrice", func(ctx context.Context, client *ethclient.Client) (err error) {
+		price, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+func (c *FailoverClient) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	var tip *big.Int
+	err := c.call(ctx, "eth_maxPriorityFeePerGas", func(ctx context.Context, client *ethclient.Client) (err error) {
+		tip, err = client.SuggestGasTipCap(ctx)
+		return err
+	})
+	return tip, err
+}
+
+func (c *FailoverClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	var gas uint64
+	err := c.call(ctx, "eth_estimateGas", func(ctx context.Context, client *ethclient.Client) (err error) {
+		gas, err = client.EstimateGas(ctx, msg)
+		return err
+	})
+	return gas, err
+}
+
+func (c *FailoverClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	err := c.call(ctx, "eth_getLogs", func(ctx context.Context, client *ethclient.Client) (err error) {
+		logs, err = client.FilterLogs(ctx, q)
+		return err
+	})
+	return logs, err
+}
+
+// SubscribeFilterLogs subscribes on the first available endpoint, the subscription
+// ends if the endpoint fails.
+func (c *FailoverClient) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery,
+	ch chan<- types.Log) (ethereum.Subscription, error) {
+
+	var sub ethereum.Subscription
+	err := c.call(ctx, "eth_subscribe", func(ctx context.Context, client *ethclient.Client) (err error) {
+		sub, err = client.SubscribeFilterLogs(ctx, q, ch)
+		return err
+	})
+	return sub, err
+}
+
+func (c *FailoverClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	var receipt *types.Receipt
+	err := c.call(ctx, "eth_getTransactionReceipt", func(ctx context.Context, client *ethclient.Client) (err error) {
+		receipt, err = client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
+
+func (c *FailoverClient) TransactionByHash(ctx context.Context,
+	hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+
+	err = c.call(ctx, "eth_getTransactionByHash", func(ctx context.Context, client *ethclient.Client) (err error) {
+		tx, isPending, err = client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+func (c *FailoverClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := c.call(ctx, "eth_getBlockByNumber", func(ctx context.Context, client *ethclient.Client) (err error) {
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (c *FailoverClient) ChainID(ctx context.Context) (*big.Int, error) {
+	var id *big.Int
+	err := c.call(ctx, "eth_chainId", func(ctx context.Context, client *ethclient.Client) (err error) {
+		id, err = client.ChainID(ctx)
+		return err
+	})
+	return id, err
+}
+
+func (c *FailoverClient) NetworkID(ctx context.Context) (*big.Int, error) {
+	var id *big.Int
+	err := c.call(ctx, "net_version", func(ctx context.Context, client *ethclient.Client) (err error) {
+		id, err = client.NetworkID(ctx)
+		return err
+	})
+	return id, err
+}
@@ -0,0 +1,214 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+func dialFailover(t *testing.T, opts FailoverOptions, nodes ...*rpctest.MockNode) (*FailoverClient, func()) {
+	var urls []string
+	for _, node := range nodes {
+		urls = append(urls, node.URL())
+	}
+	client, err := DialFailover(context.Background(), urls, opts)
+	require.NoError(t, err)
+	return client, func() {
+		client.Close()
+		for _, node := range nodes {
+			node.Close()
+		}
+	}
+}
+
+// failHTTP makes the node fail the next n requests of the method with the HTTP status,
+// after serving them if lost.
+func failHTTP(node *rpctest.MockNode, method string, status int, lost bool, n int) {
+	for i := 0; i < n; i++ {
+		node.Enqueue(method, rpctest.Response{HTTPStatus: status, Lost: lost})
+	}
+}
+
+func TestFailoverClient(t *testing.T) {
+	require := require.New(t)
+	a, b := rpctest.NewMockNode(), rpctest.NewMockNode()
+	failHTTP(a, "eth_getCode", http.StatusServiceUnavailable, false, 2)
+	b.SetCode(common.Address{}, common.FromHex("0x6001"))
+	b.Handle("eth_estimateGas", func(req rpctest.Request) (interface{}, error) {
+		return nil, errors.New("execution reverted")
+	})
+	client, closeFn := dialFailover(t, FailoverOptions{
+		Retry:            RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+		BreakerThreshold: 2,
+		BreakerTimeout:   time.Hour,
+	}, a, b)
+	defer closeFn()
+	ctx := context.Background()
+	var _ Backend = client
+
+	// the failed requests go to the next endpoint, until the breaker of the first one opens
+	for i := 1; i <= 3; i++ {
+		code, err := client.CodeAt(ctx, common.Address{}, nil)
+		require.NoError(err)
+		require.Equal(common.FromHex("0x6001"), code)
+		if i <= 2 {
+			require.Len(a.Requests(""), i)
+		}
+	}
+	require.Len(a.Requests(""), 2)
+	status := client.Status()
+	require.True(status[0].BreakerOpen)
+	require.Equal(2, status[0].Failures)
+	require.False(status[1].BreakerOpen)
+
+	// the errors of the node are not retried
+	_, err := client.EstimateGas(ctx, ethereum.CallMsg{})
+	require.EqualError(err, "execution reverted")
+	require.Len(b.Requests("eth_estimateGas"), 1)
+
+	// the last endpoint is retried, then its breaker opens too
+	failHTTP(b, "eth_getCode", http.StatusBadGateway, false, 3)
+	_, err = client.CodeAt(ctx, common.Address{}, nil)
+	require.Error(err)
+	require.Contains(err.Error(), "502 Bad Gateway")
+	_, err = client.CodeAt(ctx, common.Address{}, nil)
+	require.Equal(ErrNoEndpoints, err)
+	require.Len(a.Requests(""), 2)
+}
+
+func TestFailoverHTTPErrors(t *testing.T) {
+	require := require.New(t)
+	a, b := rpctest.NewMockNode(), rpctest.NewMockNode()
+	client, closeFn := dialFailover(t, FailoverOptions{
+		Retry:            RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+		BreakerThreshold: 1,
+		BreakerTimeout:   time.Hour,
+	}, a, b)
+	defer closeFn()
+	ctx := context.Background()
+
+	// the rejected request fails fast, without opening the breaker
+	failHTTP(a, "eth_getCode", http.StatusUnauthorized, false, 1)
+	_, err := client.CodeAt(ctx, common.Address{}, nil)
+	require.Error(err)
+	require.Contains(err.Error(), "401 Unauthorized")
+	require.Empty(b.Requests("eth_getCode"))
+	require.False(client.Status()[0].BreakerOpen)
+
+	// the rate limited one goes to the next endpoint
+	failHTTP(a, "eth_getCode", http.StatusTooManyRequests, false, 1)
+	_, err = client.CodeAt(ctx, common.Address{}, nil)
+	require.NoError(err)
+	require.Len(b.Requests("eth_getCode"), 1)
+	require.True(client.Status()[0].BreakerOpen)
+}
+
+func TestFailoverSendTransaction(t *testing.T) {
+	require := require.New(t)
+	a, b := rpctest.NewMockNode(), rpctest.NewMockNode()
+	client, closeFn := dialFailover(t, FailoverOptions{
+		Retry: RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+	}, a, b)
+	defer closeFn()
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	b.SetNonce(crypto.PubkeyToAddress(key.PublicKey), 6)
+	signTx := func(nonce uint64) *types.Transaction {
+		tx := types.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(1), nil)
+		signed, err := types.SignTx(tx, types.HomesteadSigner{}, key)
+		require.NoError(err)
+		return signed
+	}
+
+	// the response is lost, but the node has the transaction, so it's not sent again
+	failHTTP(a, "eth_sendRawTransaction", http.StatusBadGateway, true, 1)
+	require.NoError(client.SendTransaction(ctx, signTx(0)))
+	require.Len(a.Transactions(), 1)
+	require.Empty(b.Requests("eth_sendRawTransaction"))
+
+	// the request is lost, so the transaction goes to the next endpoint, which rejects it
+	failHTTP(a, "eth_sendRawTransaction", http.StatusBadGateway, false, 1)
+	err = client.SendTransaction(ctx, signTx(5))
+	require.EqualError(err, "nonce too low")
+	require.Len(a.Transactions(), 1)
+	require.Len(b.Requests("eth_sendRawTransaction"), 1)
+	require.Len(a.Requests("eth_getTransactionByHash"), 2)
+
+	// the transaction the endpoint already has is not sent anywhere else
+	require.NoError(client.SendTransaction(ctx, signTx(0)))
+	require.Empty(b.Transactions())
+}
+
+func TestFailoverHealth(t *testing.T) {
+	require := require.New(t)
+	a, b := rpctest.NewMockNode(), rpctest.NewMockNode()
+	a.SetBlockNumber(10)
+	b.SetBlockNumber(100)
+	a.SetCode(common.Address{}, []byte{0x0a})
+	b.SetCode(common.Address{}, []byte{0x0b})
+	client, closeFn := dialFailover(t, FailoverOptions{
+		Retry:  RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+		MaxLag: 50,
+	}, a, b)
+	defer closeFn()
+	ctx := context.Background()
+
+	status := client.CheckHealth(ctx)
+	require.False(status[0].Healthy)
+	require.Equal(uint64(10), status[0].Height)
+	require.True(status[1].Healthy)
+	code, err := client.CodeAt(ctx, common.Address{}, nil)
+	require.NoError(err)
+	require.Equal([]byte{0x0b}, code)
+
+	// the lagging endpoint is still used when the healthy one fails
+	failHTTP(b, "eth_getCode", http.StatusServiceUnavailable, false, 2)
+	failHTTP(b, "eth_blockNumber", http.StatusServiceUnavailable, false, 1)
+	code, err = client.CodeAt(ctx, common.Address{}, nil)
+	require.NoError(err)
+	require.Equal([]byte{0x0a}, code)
+	status = client.CheckHealth(ctx)
+	require.True(status[0].Healthy)
+	require.False(status[1].Healthy)
+}
+
+func TestFailoverRateLimit(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetCode(common.Address{}, []byte{0x01})
+	client, closeFn := dialFailover(t, FailoverOptions{
+		RateLimit: 100,
+		Burst:     2,
+	}, node)
+	defer closeFn()
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		_, err := client.CodeAt(ctx, common.Address{}, nil)
+		require.NoError(err)
+	}
+	// 2 requests at once, then 5 more at 10ms intervals
+	require.True(time.Since(start) >= 45*time.Millisecond, "too fast: %v", time.Since(start))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := client.CodeAt(ctx, common.Address{}, nil)
+	require.Equal(context.Canceled, err)
+}
@@ -0,0 +1,485 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DefaultReorgWindow is the number of recent blocks the indexer keeps to roll back reorgs.
+const DefaultReorgWindow = 128
+
+// ErrReorgTooDeep is returned by the indexer when the chain has been reorganized below
+// the oldest block it keeps.
+var ErrReorgTooDeep = errors.New("indexer: reorg is deeper than the reorg window")
+
+// Checkpoint is the state of the indexer: the last processed block and the recent blocks,
+// which are rolled back on reorgs.
+type Checkpoint struct {
+	Number uint64
+	Hash   common.Hash
+	// Recent are the last processed blocks within the reorg window, oldest first.
+	Recent []IndexedBlock
+}
+
+// IndexedBlock is a processed block with the logs delivered from it.
+type IndexedBlock struct {
+	Number uint64
+	Hash   common.Hash
+	Logs   []types.Log
+}
+
+// CheckpointStore persists the checkpoint of the indexer.
+type CheckpointStore interface {
+	// LoadCheckpoint returns nil if there is no checkpoint yet.
+	LoadCheckpoint() (*Checkpoint, error)
+	SaveCheckpoint(cp *Checkpoint) error
+}
+
+// NewMemoryCheckpointStore returns a store that keeps the checkpoint in memory.
+func NewMemoryCheckpointStore() CheckpointStore {
+	return &memoryCheckpointStore{
+		mux: new(sync.Mutex),
+	}
+}
+
+type memoryCheckpointStore struct {
+	mux *sync.Mutex
+	cp  []byte
+}
+
+func (s *memoryCheckpointStore) LoadCheckpoint() (*Checkpoint, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.cp == nil {
+		return nil, nil
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(s.cp, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (s *memoryCheckpointStore) SaveCheckpoint(cp *Checkpoint) error {
+	// keep a copy, like the file store does
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.cp = data
+	s.mux.Unlock()
+	return nil
+}
+
+// NewFileCheckpointStore returns a store that keeps the checkpoint in a JSON file,
+// which is replaced atomically.
+func NewFileCheckpointStore(path string) CheckpointStore {
+	return &fileCheckpointStore{
+		path: path,
+	}
+}
+
+type fileCheckpointStore struct {
+	path string
+}
+
+func (s *fileCheckpointStore) LoadCheckpoint() (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		err = fmt.Errorf("indexer: failed to read checkpoint: %v", err)
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		err = fmt.Errorf("indexer: failed to parse checkpoint: %v", err)
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (s *fileCheckpointStore) SaveCheckpoint(cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".checkpoint-")
+	if err != nil {
+		err = fmt.Errorf("indexer: failed to save checkpoint: %v", err)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		err = fmt.Errorf("indexer: failed to save checkpoint: %v", err)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		err = fmt.Errorf("indexer: failed to save checkpoint: %v", err)
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		os.Remove(f.Name())
+		err = fmt.Errorf("indexer: failed to save checkpoint: %v", err)
+		return err
+	}
+	return nil
+}
+
+// IndexedEvent is an event delivered by the indexer. Log.Removed is set for the events
+// of the blocks rolled back by a reorg, these are delivered in the reverse order.
+type IndexedEvent struct {
+	Name string
+	Log  types.Log
+}
+
+// IndexerHandler processes the events of a block, or of a range of blocks far from
+// the head. The checkpoint is saved after the handler succeeds, so the events may be
+// delivered again after a restart. After an error the events are delivered again by the
+// next poll.
+type IndexerHandler func(ctx context.Context, events []IndexedEvent) error
+
+// IndexerOptions configure the indexer, zero values mean the defaults.
+type IndexerOptions struct {
+	// Events are the names of the indexed events, all events of the ABI by default.
+	Events []string
+	// FromBlock is the first block to index if there is no checkpoint.
+	FromBlock uint64
+	// Confirmations is the number of blocks on top of a block before it's indexed.
+	Confirmations uint64
+	// ReorgWindow is the number of recent blocks kept to roll back reorgs,
+	// DefaultReorgWindow by default.
+	ReorgWindow uint64
+	// ChunkSize limits the blocks queried at once, DefaultLogChunkSize by default.
+	ChunkSize uint64
+	// PollInterval is the interval of polling for new blocks, DefaultPollInterval by default.
+	PollInterval time.Duration
+	// OnError is called with the errors of the polls Run retries, e.g. to log them.
+	OnError func(err error)
+}
+
+// Indexer delivers the events of a contract from the confirmed blocks, detecting reorgs
+// by the parent hashes and delivering the events of rolled back blocks as removed.
+type Indexer struct {
+	contract *BoundContract
+	store    CheckpointStore
+	opts     IndexerOptions
+	topics   [][]common.Hash
+	names    map[common.Hash]string
+
+	cp *Checkpoint
+}
+
+// NewIndexer creates an indexer of the contract events, resuming from the stored checkpoint.
+func NewIndexer(contract *BoundContract, store CheckpointStore, opts IndexerOptions) (*Indexer, error) {
+	if opts.ReorgWindow == 0 {
+		opts.ReorgWindow = DefaultReorgWindow
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultLogChunkSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	events := opts.Events
+	if len(events) == 0 {
+		for name := range contract.abi.Events {
+			events = append(events, name)
+		}
+	}
+	ix := &Indexer{
+		contract: contract,
+		store:    store,
+		opts:     opts,
+		topics:   [][]common.Hash{{}},
+		names:    make(map[common.Hash]string),
+	}
+	for _, name := range events {
+		ev, ok := contract.abi.Events[name]
+		if !ok {
+			err := fmt.Errorf("indexer: event %s not found in ABI of %s", name, contract.contractName())
+			return nil, err
+		}
+		ix.topics[0] = append(ix.topics[0], ev.ID)
+		ix.names[ev.ID] = name
+	}
+	cp, err := store.LoadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	ix.cp = cp
+	return ix, nil
+}
+
+// Checkpoint returns the number and hash of the last processed block, ok is false
+// if no blocks have been processed yet.
+func (ix *Indexer) Checkpoint() (number uint64, hash common.Hash, ok bool) {
+	if ix.cp == nil {
+		return 0, common.Hash{}, false
+	}
+	return ix.cp.Number, ix.cp.Hash, true
+}
+
+// Run polls the node for new blocks until the context is done. The failed polls are
+// reported to OnError and retried at the next interval, only ErrReorgTooDeep, which
+// can't be recovered from, stops the indexer.
+func (ix *Indexer) Run(ctx context.Context, handler IndexerHandler) error {
+	t := time.NewTicker(ix.opts.PollInterval)
+	defer t.Stop()
+	for {
+		if err := ix.Poll(ctx, handler); err == ErrReorgTooDeep {
+			return err
+		} else if err != nil && ctx.Err() == nil && ix.opts.OnError != nil {
+			ix.opts.OnError(err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Poll processes the blocks confirmed since the last poll, rolling back a reorg first.
+func (ix *Indexer) Poll(ctx context.Context, handler IndexerHandler) error {
+	head, err := ix.contract.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		err = fmt.Errorf("indexer: failed to get the latest block: %v", err)
+		return err
+	}
+	if head.Number.Uint64() < ix.opts.Confirmations {
+		return nil
+	}
+	target := head.Number.Uint64() - ix.opts.Confirmations
+	if ix.cp != nil {
+		canonical, err := ix.canonical(ctx, ix.cp.Number, ix.cp.Hash)
+		if err != nil {
+			return err
+		}
+		if !canonical {
+			if _, err := ix.rollback(ctx, handler); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		from := ix.opts.FromBlock
+		if ix.cp != nil {
+			from = ix.cp.Number + 1
+		}
+		if from > target {
+			return nil
+		}
+		var reorged bool
+		if target-from >= ix.opts.ReorgWindow {
+			// far from the head the blocks are processed in chunks, without keeping them
+			end := from + ix.opts.ChunkSize - 1
+			if end > target-ix.opts.ReorgWindow {
+				end = target - ix.opts.ReorgWindow
+			}
+			reorged, err = ix.processRange(ctx, handler, from, end)
+		} else {
+			reorged, err = ix.processBlock(ctx, handler, from)
+		}
+		if err != nil {
+			return err
+		}
+		if reorged {
+			rolledBack, err := ix.rollback(ctx, handler)
+			if err != nil {
+				return err
+			} else if !rolledBack {
+				// the node hasn't caught up with the reorg yet
+				return nil
+			}
+		}
+	}
+}
+
+func (ix *Indexer) header(ctx context.Context, number uint64) (*types.Header, error) {
+	header, err := ix.contract.client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		err = fmt.Errorf("indexer: failed to get block %d: %v", number, err)
+		return nil, err
+	}
+	return header, nil
+}
+
+// canonical reports whether the block is still in the chain, the missing blocks have
+// been removed by a reorg to a shorter chain.
+func (ix *Indexer) canonical(ctx context.Context, number uint64, hash common.Hash) (bool, error) {
+	header, err := ix.contract.client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err == ethereum.NotFound {
+		return false, nil
+	} else if err != nil {
+		err = fmt.Errorf("indexer: failed to get block %d: %v", number, err)
+		return false, err
+	}
+	return header.Hash() == hash, nil
+}
+
+func (ix *Indexer) logs(ctx context.Context, from, to uint64) ([]types.Log, error) {
+	logs, err := ix.contract.client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: []common.Address{ix.contract.address},
+		Topics:    ix.topics,
+	})
+	if err != nil {
+		err = fmt.Errorf("indexer: failed to get logs of blocks %d-%d: %v", from, to, err)
+		return nil, err
+	}
+	return logs, nil
+}
+
+// checkParent reports whether the block follows the checkpoint.
+func (ix *Indexer) checkParent(header *types.Header) bool {
+	return ix.cp == nil || header.ParentHash == ix.cp.Hash
+}
+
+func (ix *Indexer) processRange(ctx context.Context, handler IndexerHandler, from, to uint64) (bool, error) {
+	first, err := ix.header(ctx, from)
+	if err != nil {
+		return false, err
+	}
+	if !ix.checkParent(first) {
+		return true, nil
+	}
+	last, err := ix.header(ctx, to)
+	if err != nil {
+		return false, err
+	}
+	logs, err := ix.logs(ctx, from, to)
+	if err != nil {
+		return false, err
+	}
+	if err := ix.deliver(ctx, handler, logs); err != nil {
+		return false, err
+	}
+	return false, ix.save(&Checkpoint{
+		Number: to,
+		Hash:   last.Hash(),
+	})
+}
+
+func (ix *Indexer) processBlock(ctx context.Context, handler IndexerHandler, number uint64) (bool, error) {
+	header, err := ix.header(ctx, number)
+	if err != nil {
+		return false, err
+	}
+	if !ix.checkParent(header) {
+		return true, nil
+	}
+	logs, err := ix.logs(ctx, number, number)
+	if err != nil {
+		return false, err
+	}
+	for _, log := range logs {
+		if log.BlockHash != header.Hash() {
+			// the block has been replaced after the header was fetched
+			return true, nil
+		}
+	}
+	if err := ix.deliver(ctx, handler, logs); err != nil {
+		return false, err
+	}
+	cp := &Checkpoint{
+		Number: number,
+		Hash:   header.Hash(),
+	}
+	if ix.cp != nil {
+		cp.Recent = ix.cp.Recent
+	}
+	cp.Recent = append(cp.Recent, IndexedBlock{
+		Number: number,
+		Hash:   header.Hash(),
+		Logs:   logs,
+	})
+	if over := uint64(len(cp.Recent)); over > ix.opts.ReorgWindow {
+		cp.Recent = cp.Recent[over-ix.opts.ReorgWindow:]
+	}
+	return false, ix.save(cp)
+}
+
+// rollback removes the recent blocks that are no longer canonical, delivering their
+// events as removed, until the common ancestor is found. Reports whether any blocks
+// have been removed.
+func (ix *Indexer) rollback(ctx context.Context, handler IndexerHandler) (bool, error) {
+	recent := ix.cp.Recent
+	var removed []types.Log
+	for len(recent) > 0 {
+		block := recent[len(recent)-1]
+		canonical, err := ix.canonical(ctx, block.Number, block.Hash)
+		if err != nil {
+			return false, err
+		}
+		if canonical {
+			break
+		}
+		for i := len(block.Logs) - 1; i >= 0; i-- {
+			log := block.Logs[i]
+			log.Removed = true
+			removed = append(removed, log)
+		}
+		recent = recent[:len(recent)-1]
+	}
+	if len(recent) == 0 {
+		return false, ErrReorgTooDeep
+	}
+	if len(recent) == len(ix.cp.Recent) {
+		return false, nil
+	}
+	if err := ix.deliver(ctx, handler, removed); err != nil {
+		return false, err
+	}
+	ancestor := recent[len(recent)-1]
+	return true, ix.save(&Checkpoint{
+		Number: ancestor.Number,
+		Hash:   ancestor.Hash,
+		Recent: recent,
+	})
+}
+
+func (ix *Indexer) deliver(ctx context.Context, handler IndexerHandler, logs []types.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	events := make([]IndexedEvent, 0, len(logs))
+	for _, log := range logs {
+		if len(log.Topics) == 0 {
+			continue
+		}
+		events = append(events, IndexedEvent{
+			Name: ix.names[log.Topics[0]],
+			Log:  log,
+		})
+	}
+	return handler(ctx, events)
+}
+
+func (ix *Indexer) save(cp *Checkpoint) error {
+	if err := ix.store.SaveCheckpoint(cp); err != nil {
+		return err
+	}
+	ix.cp = cp
+	return nil
+}
@@ -0,0 +1,339 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+// simChain is a simulated chain of headers with Transfer logs of the test contract,
+// served by the node, which can be reorganized.
+type simChain struct {
+	t       *testing.T
+	node    *rpctest.MockNode
+	mux     sync.Mutex
+	headers []*types.Header
+	logs    map[common.Hash][]types.Log
+}
+
+func newSimChain(t *testing.T, node *rpctest.MockNode, blocks int) *simChain {
+	c := &simChain{
+		t:    t,
+		node: node,
+		logs: make(map[common.Hash][]types.Log),
+	}
+	c.headers = append(c.headers, &types.Header{
+		Number:     big.NewInt(0),
+		Difficulty: big.NewInt(1),
+	})
+	c.mine(blocks, 0)
+	node.Handle("eth_getBlockByNumber", c.getBlockByNumber)
+	node.Handle("eth_getLogs", c.getLogs)
+	return c
+}
+
+// mine adds the blocks, each with a Transfer log of the value equal to the block number
+// plus the fork, which makes the forks have different hashes and logs.
+func (c *simChain) mine(blocks int, fork int64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i := 0; i < blocks; i++ {
+		parent := c.headers[len(c.headers)-1]
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number, big.NewInt(1)),
+			Difficulty: big.NewInt(1),
+			Extra:      []byte{byte(fork)},
+		}
+		log := transferLog(c.t, header.Number.Uint64(), header.Number.Int64()+fork)
+		log.BlockHash = header.Hash()
+		c.headers = append(c.headers, header)
+		c.logs[header.Hash()] = []types.Log{log}
+	}
+}
+
+// reorg replaces the last depth blocks with the blocks of the fork.
+func (c *simChain) reorg(depth, blocks int, fork int64) {
+	c.mux.Lock()
+	c.headers = c.headers[:len(c.headers)-depth]
+	c.mux.Unlock()
+	c.mine(blocks, fork)
+}
+
+func (c *simChain) head() *types.Header {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.headers[len(c.headers)-1]
+}
+
+func (c *simChain) getBlockByNumber(req rpctest.Request) (interface{}, error) {
+	var number string
+	if err := json.Unmarshal(req.Params[0], &number); err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if number == "latest" {
+		return c.headers[len(c.headers)-1], nil
+	}
+	n, err := hexutil.DecodeUint64(number)
+	if err != nil {
+		return nil, err
+	}
+	if n >= uint64(len(c.headers)) {
+		return nil, nil
+	}
+	return c.headers[n], nil
+}
+
+func (c *simChain) getLogs(req rpctest.Request) (interface{}, error) {
+	var q struct {
+		FromBlock hexutil.Uint64 `json:"fromBlock"`
+		ToBlock   hexutil.Uint64 `json:"toBlock"`
+	}
+	if err := json.Unmarshal(req.Params[0], &q); err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	logs := []types.Log{}
+	for n := uint64(q.FromBlock); n <= uint64(q.ToBlock) && n < uint64(len(c.headers)); n++ {
+		logs = append(logs, c.logs[c.headers[n].Hash()]...)
+	}
+	return logs, nil
+}
+
+// eventValues returns the values of the Transfer events, negative for the removed ones.
+func eventValues(t *testing.T, contract *BoundContract, events []IndexedEvent) []int64 {
+	var values []int64
+	for _, ev := range events {
+		require.Equal(t, "Transfer", ev.Name)
+		var transfer struct {
+			From, To common.Address
+			Value    *big.Int
+		}
+		require.NoError(t, contract.DecodeEvent(ev.Name, ev.Log, &transfer))
+		if ev.Log.Removed {
+			values = append(values, -transfer.Value.Int64())
+		} else {
+			values = append(values, transfer.Value.Int64())
+		}
+	}
+	return values
+}
+
+func newTestIndexer(t *testing.T, blocks int, store CheckpointStore,
+	opts IndexerOptions) (*Indexer, *simChain, *[]int64, func()) {
+
+	node := rpctest.NewMockNode()
+	chain := newSimChain(t, node, blocks)
+	contract, closeFn := newEventsTestContract(t, node)
+	ix, err := NewIndexer(contract, store, opts)
+	require.NoError(t, err)
+	values := new([]int64)
+	return ix, chain, values, closeFn
+}
+
+func collectValues(t *testing.T, ix *Indexer, values *[]int64) IndexerHandler {
+	return func(ctx context.Context, events []IndexedEvent) error {
+		*values = append(*values, eventValues(t, ix.contract, events)...)
+		return nil
+	}
+}
+
+func TestIndexerConfirmations(t *testing.T) {
+	require := require.New(t)
+	ix, chain, values, closeFn := newTestIndexer(t, 6, NewMemoryCheckpointStore(), IndexerOptions{
+		FromBlock:     2,
+		Confirmations: 2,
+	})
+	defer closeFn()
+	ctx := context.Background()
+
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{2, 3, 4}, *values)
+	number, _, ok := ix.Checkpoint()
+	require.True(ok)
+	require.Equal(uint64(4), number)
+
+	chain.mine(1, 0)
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{2, 3, 4, 5}, *values)
+}
+
+func TestIndexerReorg(t *testing.T) {
+	require := require.New(t)
+	ix, chain, values, closeFn := newTestIndexer(t, 10, NewMemoryCheckpointStore(), IndexerOptions{
+		FromBlock: 1,
+	})
+	defer closeFn()
+	ctx := context.Background()
+
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Len(*values, 10)
+	*values = nil
+
+	// blocks 9 and 10 are replaced with 3 blocks of the fork
+	chain.reorg(2, 3, 100)
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{-10, -9, 109, 110, 111}, *values)
+	number, hash, _ := ix.Checkpoint()
+	require.Equal(uint64(11), number)
+	require.Equal(chain.head().Hash(), hash)
+
+	// no new blocks, but the head is replaced
+	*values = nil
+	chain.reorg(1, 1, 200)
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{-111, 211}, *values)
+}
+
+func TestIndexerRestart(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "indexer")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	store := NewFileCheckpointStore(filepath.Join(dir, "checkpoint.json"))
+
+	ix, chain, values, closeFn := newTestIndexer(t, 5, store, IndexerOptions{})
+	defer closeFn()
+	ctx := context.Background()
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{1, 2, 3, 4, 5}, *values)
+
+	chain.reorg(2, 2, 100)
+	restarted, err := NewIndexer(ix.contract, store, IndexerOptions{})
+	require.NoError(err)
+	number, _, ok := restarted.Checkpoint()
+	require.True(ok)
+	require.Equal(uint64(5), number)
+
+	*values = nil
+	require.NoError(restarted.Poll(ctx, collectValues(t, restarted, values)))
+	require.Equal([]int64{-5, -4, 104, 105}, *values)
+}
+
+func TestIndexerReorgTooDeep(t *testing.T) {
+	require := require.New(t)
+	ix, chain, values, closeFn := newTestIndexer(t, 8, NewMemoryCheckpointStore(), IndexerOptions{
+		ReorgWindow: 2,
+	})
+	defer closeFn()
+	ctx := context.Background()
+
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	chain.reorg(3, 3, 100)
+	require.Equal(ErrReorgTooDeep, ix.Poll(ctx, collectValues(t, ix, values)))
+}
+
+func TestIndexerChunks(t *testing.T) {
+	require := require.New(t)
+	ix, chain, values, closeFn := newTestIndexer(t, 20, NewMemoryCheckpointStore(), IndexerOptions{
+		ReorgWindow: 3,
+		ChunkSize:   4,
+	})
+	defer closeFn()
+	ctx := context.Background()
+
+	var batches int
+	require.NoError(ix.Poll(ctx, func(ctx context.Context, events []IndexedEvent) error {
+		batches++
+		return collectValues(t, ix, values)(ctx, events)
+	}))
+	expected := make([]int64, 0, 20)
+	for i := int64(1); i <= 20; i++ {
+		expected = append(expected, i)
+	}
+	require.Equal(expected, *values)
+	// blocks 1-16 in chunks of 4 blocks, then 4 blocks one by one
+	require.Equal(8, batches)
+	require.Len(ix.cp.Recent, 3)
+
+	// the reorg within the window is rolled back
+	*values = nil
+	chain.reorg(2, 2, 100)
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{-20, -19, 119, 120}, *values)
+}
+
+func TestIndexerReorgShorterChain(t *testing.T) {
+	require := require.New(t)
+	ix, chain, values, closeFn := newTestIndexer(t, 10, NewMemoryCheckpointStore(), IndexerOptions{
+		FromBlock: 1,
+	})
+	defer closeFn()
+	ctx := context.Background()
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	*values = nil
+
+	// blocks 8 to 10 are replaced with a single block, the checkpoint block is gone
+	chain.reorg(3, 1, 100)
+	require.NoError(ix.Poll(ctx, collectValues(t, ix, values)))
+	require.Equal([]int64{-10, -9, -8, 108}, *values)
+	number, hash, _ := ix.Checkpoint()
+	require.Equal(uint64(8), number)
+	require.Equal(chain.head().Hash(), hash)
+}
+
+func TestIndexerRun(t *testing.T) {
+	require := require.New(t)
+	var mux sync.Mutex
+	var errs []string
+	ix, chain, values, closeFn := newTestIndexer(t, 3, NewMemoryCheckpointStore(), IndexerOptions{
+		PollInterval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			mux.Lock()
+			errs = append(errs, err.Error())
+			mux.Unlock()
+		},
+	})
+	defer closeFn()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a node failure and a handler failure don't stop the indexer
+	chain.node.Fail("eth_getBlockByNumber", &rpctest.RPCError{Code: -32000, Message: "service unavailable"})
+	handlerFailed := false
+	collect := collectValues(t, ix, values)
+	done := make(chan error, 1)
+	go func() {
+		done <- ix.Run(ctx, func(ctx context.Context, events []IndexedEvent) error {
+			mux.Lock()
+			defer mux.Unlock()
+			if !handlerFailed {
+				handlerFailed = true
+				return errors.New("database is down")
+			}
+			return collect(ctx, events)
+		})
+	}()
+	require.Eventually(func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(*values) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.Equal(context.Canceled, <-done)
+	require.Equal([]int64{1, 2, 3}, *values)
+	require.Len(errs, 2)
+	require.Contains(errs[0], "service unavailable")
+	require.Equal("database is down", errs[1])
+}
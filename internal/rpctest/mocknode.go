@@ -0,0 +1,567 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package rpctest is the mock JSON-RPC node of ethfwtest, apart so that the tests of ethfw
+// can use it too.
+package rpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// RPCError is a JSON-RPC error answered by the MockNode.
+type RPCError struct {
+	Code    int
+	Message string
+	Data    interface{}
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// The errors geth answers to the rejected transactions.
+var (
+	ErrNonceTooLow            = &RPCError{Code: -32000, Message: "nonce too low"}
+	ErrUnderpriced            = &RPCError{Code: -32000, Message: "transaction underpriced"}
+	ErrReplacementUnderpriced = &RPCError{Code: -32000, Message: "replacement transaction underpriced"}
+	ErrInsufficientFunds      = &RPCError{Code: -32000, Message: "insufficient funds for gas * price + value"}
+	ErrKnownTransaction       = &RPCError{Code: -32000, Message: "already known"}
+)
+
+// Response is a scripted response of the MockNode.
+type Response struct {
+	// Result is encoded to JSON, nil is answered as null.
+	Result interface{}
+	// Error is answered instead of the result if set.
+	Error *RPCError
+	// Delay is the time to wait before answering.
+	Delay time.Duration
+	// Hang leaves the request unanswered until the client gives up or the node is closed.
+	Hang bool
+	// HTTPStatus fails the whole HTTP request with the status if set.
+	HTTPStatus int
+	// Lost has the request served by the handler before failing with HTTPStatus,
+	// like the responses lost on the way back.
+	Lost bool
+}
+
+// Timeout is the response that is never answered, the client times out.
+var Timeout = Response{Hang: true}
+
+// Request is a JSON-RPC request received by the MockNode.
+type Request struct {
+	Method string
+	Params []json.RawMessage
+}
+
+// Param decodes the i-th parameter into v.
+func (r Request) Param(i int, v interface{}) error {
+	if i >= len(r.Params) {
+		err := fmt.Errorf("%s has no param %d", r.Method, i)
+		return err
+	}
+	return json.Unmarshal(r.Params[i], v)
+}
+
+// HandlerFunc answers the requests of a method, the errors other than *RPCError
+// are answered with the code -32000.
+type HandlerFunc func(req Request) (interface{}, error)
+
+// MockNode is an in-process fake Ethereum JSON-RPC node over HTTP, for the error path tests.
+// It has no EVM, every method is answered by the scripted responses, queued per method with
+// Enqueue, then by the handlers set with Handle. The built-in handlers keep the block number,
+// the gas price, the nonces, the code and the storage of the accounts, the sent transactions
+// and their receipts.
+type MockNode struct {
+	server *httptest.Server
+	closeC chan struct{}
+
+	mux         sync.Mutex
+	handlers    map[string]HandlerFunc
+	queued      map[string][]Response
+	requests    []Request
+	chainID     *big.Int
+	blockNumber uint64
+	gasPrice    *big.Int
+	nonces      map[common.Address]uint64
+	code        map[common.Address][]byte
+	storage     map[common.Address]map[common.Hash]common.Hash
+	pending     []*types.Transaction
+	sent        []*types.Transaction
+	receipts    map[common.Hash]*types.Receipt
+	autoMine    bool
+	onTx        func(from common.Address, tx *types.Transaction) error
+}
+
+// NewMockNode starts the node, which has the chain id 1337, the block number 0
+// and the gas price of 1 gwei. It estimates every call to need 1000000 gas.
+func NewMockNode() *MockNode {
+	n := &MockNode{
+		closeC:   make(chan struct{}),
+		handlers: make(map[string]HandlerFunc),
+		queued:   make(map[string][]Response),
+		chainID:  big.NewInt(1337),
+		gasPrice: big.NewInt(1e9),
+		nonces:   make(map[common.Address]uint64),
+		code:     make(map[common.Address][]byte),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+	n.handleBuiltins()
+	n.server = httptest.NewServer(n)
+	return n
+}
+
+// URL is the address to dial the node at.
+func (n *MockNode) URL() string {
+	return n.server.URL
+}
+
+// Close releases the hanging requests and stops the node.
+func (n *MockNode) Close() {
+	close(n.closeC)
+	n.server.Close()
+}
+
+// Handle sets the handler of the method, replacing the built-in one.
+func (n *MockNode) Handle(method string, fn HandlerFunc) {
+	n.mux.Lock()
+	n.handlers[method] = fn
+	n.mux.Unlock()
+}
+
+// SetResult makes the node answer the method with the result.
+func (n *MockNode) SetResult(method string, result interface{}) {
+	n.Handle(method, func(Request) (interface{}, error) {
+		return result, nil
+	})
+}
+
+// Enqueue adds the responses to answer the next requests of the method with, in order,
+// before the handler takes over.
+func (n *MockNode) Enqueue(method string, responses ...Response) {
+	n.mux.Lock()
+	n.queued[method] = append(n.queued[method], responses...)
+	n.mux.Unlock()
+}
+
+// Fail makes the next requests of the method fail with the errors, in order.
+func (n *MockNode) Fail(method string, errs ...*RPCError) {
+	for _, err := range errs {
+		n.Enqueue(method, Response{Error: err})
+	}
+}
+
+// Requests returns the requests of the method received so far, or all of them if
+// the method is empty.
+func (n *MockNode) Requests(method string) []Request {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	var requests []Request
+	for _, req := range n.requests {
+		if len(method) == 0 || req.Method == method {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// SetChainID sets the chain id answered by eth_chainId and net_version.
+func (n *MockNode) SetChainID(id *big.Int) {
+	n.mux.Lock()
+	n.chainID = id
+	n.mux.Unlock()
+}
+
+// SetBlockNumber sets the number of the latest block.
+func (n *MockNode) SetBlockNumber(number uint64) {
+	n.mux.Lock()
+	n.blockNumber = number
+	n.mux.Unlock()
+}
+
+// SetGasPrice sets the gas price answered by eth_gasPrice.
+func (n *MockNode) SetGasPrice(price *big.Int) {
+	n.mux.Lock()
+	n.gasPrice = price
+	n.mux.Unlock()
+}
+
+// SetNonce sets the nonce of the account.
+func (n *MockNode) SetNonce(account common.Address, nonce uint64) {
+	n.mux.Lock()
+	n.nonces[account] = nonce
+	n.mux.Unlock()
+}
+
+// SetCode sets the code of the account, nil removes it.
+func (n *MockNode) SetCode(account common.Address, code []byte) {
+	n.mux.Lock()
+	n.code[account] = code
+	n.mux.Unlock()
+}
+
+// SetStorage sets the storage slot of the account.
+func (n *MockNode) SetStorage(account common.Address, key, value common.Hash) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.storage[account] == nil {
+		n.storage[account] = make(map[common.Hash]common.Hash)
+	}
+	n.storage[account][key] = value
+}
+
+// SetAutoMine makes the node mine every transaction it accepts in a block of its own.
+func (n *MockNode) SetAutoMine(on bool) {
+	n.mux.Lock()
+	n.autoMine = on
+	n.mux.Unlock()
+}
+
+// OnTransaction sets fn to be called with the transactions sent before they are accepted,
+// its errors reject them. It stands for the EVM, applying the calls the tests care about.
+func (n *MockNode) OnTransaction(fn func(from common.Address, tx *types.Transaction) error) {
+	n.mux.Lock()
+	n.onTx = fn
+	n.mux.Unlock()
+}
+
+// Transactions returns the transactions accepted by eth_sendRawTransaction.
+func (n *MockNode) Transactions() []*types.Transaction {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return append([]*types.Transaction{}, n.sent...)
+}
+
+// Mine includes the pending transactions into a new block, they all succeed.
+// The contracts created get their init code as the code.
+func (n *MockNode) Mine() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.mine()
+}
+
+func (n *MockNode) mine() {
+	n.blockNumber++
+	var cumulativeGas uint64
+	for _, tx := range n.pending {
+		cumulativeGas += 21000
+		receipt := &types.Receipt{
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: cumulativeGas,
+			TxHash:            tx.Hash(),
+			GasUsed:           21000,
+			Logs:              []*types.Log{},
+		}
+		if tx.To() == nil {
+			from, _ := types.Sender(types.LatestSignerForChainID(n.chainID), tx)
+			receipt.ContractAddress = crypto.CreateAddress(from, tx.Nonce())
+			n.code[receipt.ContractAddress] = tx.Data()
+		}
+		n.receipts[tx.Hash()] = receipt
+	}
+	n.pending = nil
+}
+
+// SetReceipt sets the receipt of the transaction, which the receipt refers to.
+func (n *MockNode) SetReceipt(receipt *types.Receipt) {
+	n.mux.Lock()
+	n.receipts[receipt.TxHash] = receipt
+	n.mux.Unlock()
+}
+
+// Reorg drops the receipts of the transactions, as if a reorg took them out of the chain.
+// The transactions are pending again, until the next Mine.
+func (n *MockNode) Reorg(hashes ...common.Hash) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	for _, hash := range hashes {
+		delete(n.receipts, hash)
+		for _, tx := range n.sent {
+			if tx.Hash() == hash {
+				n.pending = append(n.pending, tx)
+			}
+		}
+	}
+}
+
+func (n *MockNode) handleBuiltins() {
+	n.handlers["eth_chainId"] = func(Request) (interface{}, error) {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return (*hexutil.Big)(n.chainID), nil
+	}
+	n.handlers["net_version"] = func(Request) (interface{}, error) {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return n.chainID.String(), nil
+	}
+	n.handlers["eth_blockNumber"] = func(Request) (interface{}, error) {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return hexutil.Uint64(n.blockNumber), nil
+	}
+	n.handlers["eth_getBlockByNumber"] = func(req Request) (interface{}, error) {
+		var tag string
+		if err := req.Param(0, &tag); err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		number := n.blockNumber
+		n.mux.Unlock()
+		if tag != "latest" && tag != "pending" {
+			requested, err := hexutil.DecodeUint64(tag)
+			if err != nil {
+				return nil, err
+			} else if requested > number {
+				return nil, nil
+			}
+			number = requested
+		}
+		return &types.Header{
+			Number:     new(big.Int).SetUint64(number),
+			Difficulty: big.NewInt(1),
+			Time:       number,
+		}, nil
+	}
+	n.handlers["eth_getCode"] = func(req Request) (interface{}, error) {
+		var account common.Address
+		if err := req.Param(0, &account); err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return hexutil.Bytes(n.code[account]), nil
+	}
+	n.handlers["eth_getStorageAt"] = func(req Request) (interface{}, error) {
+		var account common.Address
+		var key common.Hash
+		if err := req.Param(0, &account); err != nil {
+			return nil, err
+		} else if err := req.Param(1, &key); err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return n.storage[account][key], nil
+	}
+	n.handlers["eth_gasPrice"] = func(Request) (interface{}, error) {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return (*hexutil.Big)(n.gasPrice), nil
+	}
+	n.handlers["eth_estimateGas"] = func(Request) (interface{}, error) {
+		return hexutil.Uint64(1000000), nil
+	}
+	n.handlers["eth_getTransactionCount"] = func(req Request) (interface{}, error) {
+		var account common.Address
+		if err := req.Param(0, &account); err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return hexutil.Uint64(n.nonces[account]), nil
+	}
+	n.handlers["eth_sendRawTransaction"] = func(req Request) (interface{}, error) {
+		var raw hexutil.Bytes
+		if err := req.Param(0, &raw); err != nil {
+			return nil, err
+		}
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return nil, &RPCError{Code: -32000, Message: "rlp: " + err.Error()}
+		}
+		from, err := n.checkTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		onTx := n.onTx
+		n.mux.Unlock()
+		// the hook may use the node
+		if onTx != nil {
+			if err := onTx(from, tx); err != nil {
+				return nil, err
+			}
+		}
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		n.nonces[from] = tx.Nonce() + 1
+		n.sent = append(n.sent, tx)
+		n.pending = append(n.pending, tx)
+		if n.autoMine {
+			n.mine()
+		}
+		return tx.Hash(), nil
+	}
+	n.handlers["eth_getTransactionByHash"] = func(req Request) (interface{}, error) {
+		var hash common.Hash
+		if err := req.Param(0, &hash); err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		for _, tx := range n.sent {
+			if tx.Hash() == hash {
+				return tx, nil
+			}
+		}
+		return nil, nil
+	}
+	n.handlers["eth_getTransactionReceipt"] = func(req Request) (interface{}, error) {
+		var hash common.Hash
+		if err := req.Param(0, &hash); err != nil {
+			return nil, err
+		}
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		return n.receipts[hash], nil
+	}
+}
+
+// checkTransaction returns the sender of the transaction, unless it is known
+// or its nonce is too low.
+func (n *MockNode) checkTransaction(tx *types.Transaction) (common.Address, error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	from, err := types.Sender(types.LatestSignerForChainID(n.chainID), tx)
+	if err != nil {
+		return common.Address{}, &RPCError{Code: -32000, Message: "invalid sender"}
+	}
+	for _, sent := range n.sent {
+		if sent.Hash() == tx.Hash() {
+			return common.Address{}, ErrKnownTransaction
+		}
+	}
+	if tx.Nonce() < n.nonces[from] {
+		return common.Address{}, ErrNonceTooLow
+	}
+	return from, nil
+}
+
+type mockRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// ServeHTTP answers single requests and batches. The scripted HTTP failures of the requests
+// in a batch fail the whole batch.
+func (n *MockNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	batch := len(body) > 0 && body[0] == '['
+	var reqs []mockRequest
+	if batch {
+		err = json.Unmarshal(body, &reqs)
+	} else {
+		reqs = make([]mockRequest, 1)
+		err = json.Unmarshal(body, &reqs[0])
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resps := make([]map[string]interface{}, len(reqs))
+	for i, req := range reqs {
+		resp := n.respond(req)
+		if resp.Hang {
+			select {
+			case <-r.Context().Done():
+			case <-n.closeC:
+			}
+			return
+		}
+		if resp.Delay > 0 {
+			select {
+			case <-time.After(resp.Delay):
+			case <-r.Context().Done():
+				return
+			case <-n.closeC:
+				return
+			}
+		}
+		if resp.HTTPStatus != 0 {
+			http.Error(w, http.StatusText(resp.HTTPStatus), resp.HTTPStatus)
+			return
+		}
+		resps[i] = map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+		}
+		if resp.Error != nil {
+			rpcErr := map[string]interface{}{
+				"code":    resp.Error.Code,
+				"message": resp.Error.Message,
+			}
+			if resp.Error.Data != nil {
+				rpcErr["data"] = resp.Error.Data
+			}
+			resps[i]["error"] = rpcErr
+		} else {
+			resps[i]["result"] = resp.Result
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(resps)
+	} else {
+		json.NewEncoder(w).Encode(resps[0])
+	}
+}
+
+// respond records the request and returns its queued response, or the one of the handler.
+func (n *MockNode) respond(req mockRequest) Response {
+	request := Request{
+		Method: req.Method,
+		Params: req.Params,
+	}
+	n.mux.Lock()
+	n.requests = append(n.requests, request)
+	fn, ok := n.handlers[req.Method]
+	if queued := n.queued[req.Method]; len(queued) > 0 {
+		n.queued[req.Method] = queued[1:]
+		n.mux.Unlock()
+		if queued[0].Lost && ok {
+			fn(request)
+		}
+		return queued[0]
+	}
+	n.mux.Unlock()
+	if !ok {
+		return Response{
+			Error: &RPCError{
+				Code:    -32601,
+				Message: "the method " + req.Method + " does not exist/is not available",
+			},
+		}
+	}
+	result, err := fn(request)
+	if err != nil {
+		rpcErr, ok := err.(*RPCError)
+		if !ok {
+			rpcErr = &RPCError{Code: -32000, Message: err.Error()}
+		}
+		return Response{Error: rpcErr}
+	}
+	return Response{Result: result}
+}
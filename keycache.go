@@ -5,10 +5,12 @@
 package ethfw
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/sha1"
 	"errors"
 	"io/ioutil"
+	"math/big"
 	"strings"
 	"sync"
 
@@ -23,9 +25,11 @@ type KeyCache interface {
 	SetPath(account common.Address, path string) bool
 	UnsetPath(account common.Address, path string)
 	PrivateKey(account common.Address, password string) (key *ecdsa.PrivateKey, ok bool)
+	PrivateKeyContext(ctx context.Context, account common.Address, password string) (*ecdsa.PrivateKey, error)
 	SetPrivateKey(account common.Address, pk *ecdsa.PrivateKey)
 	UnsetKey(account common.Address, password string)
 	SignerFn(account common.Address, password string) bind.SignerFn
+	SignerFnForChain(account common.Address, password string, chainID *big.Int) bind.SignerFn
 }
 
 func NewKeyCache() KeyCache {
@@ -34,7 +38,7 @@ func NewKeyCache() KeyCache {
 		pathsMux: new(sync.RWMutex),
 		keys:     make(map[string]*ecdsa.PrivateKey),
 		keysMux:  new(sync.RWMutex),
-		guard:    NewUniquify(),
+		guard:    NewSharedUniquify(),
 	}
 }
 
@@ -82,58 +86,88 @@ func (k *keyCache) SetPrivateKey(account common.Address, pk *ecdsa.PrivateKey) {
 }
 
 func (k *keyCache) PrivateKey(account common.Address, password string) (key *ecdsa.PrivateKey, ok bool) {
+	key, err := k.PrivateKeyContext(context.Background(), account, password)
+	if err != nil {
+		return nil, false
+	}
+	return key, true
+}
+
+// PrivateKeyContext returns the private key for account, decrypting its keystore file
+// if the key is not cached yet. Concurrent lookups of the same key share a single
+// decryption, waiting for it is aborted once ctx is done.
+func (k *keyCache) PrivateKeyContext(ctx context.Context,
+	account common.Address, password string) (*ecdsa.PrivateKey, error) {
+
 	h := hashAccountPass(account, password)
-	if err := k.guard.Call(string(h), func() error {
+	key, err := k.guard.Do(ctx, string(h), func(ctx context.Context) (interface{}, error) {
 		k.keysMux.RLock()
-		key, ok = k.keys[string(h)]
+		key, ok := k.keys[string(h)]
 		k.keysMux.RUnlock()
 		if ok {
-			return nil
+			return key, nil
 		}
 		k.pathsMux.RLock()
 		path, pathOk := k.paths[account]
 		k.pathsMux.RUnlock()
 		if !pathOk {
-			return ErrNoKeyStore
+			return nil, ErrNoKeyStore
 		}
 		if strings.HasPrefix(path, "keystore://") {
 			path = strings.TrimPrefix(path, "keystore://")
 		}
 		keyJSON, err := ioutil.ReadFile(path)
 		if err != nil {
-			return ErrNoKeyStore
+			return nil, ErrNoKeyStore
 		}
 		pk, err := keystore.DecryptKey(keyJSON, password)
 		if err != nil {
-			return ErrKeyDecrypt
+			return nil, ErrKeyDecrypt
 		}
 		k.keysMux.Lock()
 		k.keys[string(h)] = pk.PrivateKey
 		k.keysMux.Unlock()
-		key = pk.PrivateKey
-		ok = true
-		return nil
-	}); err != nil {
-		return nil, false
+		return pk.PrivateKey, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return key, ok
+	return key.(*ecdsa.PrivateKey), nil
 }
 
+// SignerFn returns the signer of the account's transactions. The legacy transactions
+// are signed without replay protection, the typed ones for their own chain ID.
 func (k *keyCache) SignerFn(account common.Address, password string) bind.SignerFn {
+	return k.signerFn(account, password, func(tx *types.Transaction) types.Signer {
+		if tx.Type() != types.LegacyTxType {
+			return types.LatestSignerForChainID(tx.ChainId())
+		}
+		return types.HomesteadSigner{}
+	})
+}
+
+// SignerFnForChain returns the signer of the account's transactions on the chain,
+// the legacy transactions are signed with EIP-155 replay protection.
+func (k *keyCache) SignerFnForChain(account common.Address, password string, chainID *big.Int) bind.SignerFn {
+	signer := types.LatestSignerForChainID(chainID)
+	return k.signerFn(account, password, func(*types.Transaction) types.Signer {
+		return signer
+	})
+}
+
+func (k *keyCache) signerFn(account common.Address, password string,
+	signerFor func(tx *types.Transaction) types.Signer) bind.SignerFn {
+
 	key, ok := k.PrivateKey(account, password)
 	if !ok {
 		return nil
 	}
 	keyAddr := crypto.PubkeyToAddress(key.PublicKey)
-	return func(signer types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+	return func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
 		if address != keyAddr {
 			return nil, errors.New("not authorized to sign this account")
 		}
-		signature, err := crypto.Sign(signer.Hash(tx).Bytes(), key)
-		if err != nil {
-			return nil, err
-		}
-		return tx.WithSignature(signer, signature)
+		return types.SignTx(tx, signerFor(tx), key)
 	}
 }
 
@@ -0,0 +1,41 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCacheSignerFnForChain(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	tx := types.NewTransaction(0, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(1), nil)
+
+	// the legacy transactions get the replay protection
+	signed, err := keys.SignerFnForChain(from, "", big.NewInt(1337))(from, tx)
+	require.NoError(err)
+	require.True(signed.Protected())
+	require.Equal(big.NewInt(1337), signed.ChainId())
+	sender, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1337)), signed)
+	require.NoError(err)
+	require.Equal(from, sender)
+
+	signed, err = keys.SignerFn(from, "")(from, tx)
+	require.NoError(err)
+	require.False(signed.Protected())
+
+	_, err = keys.SignerFnForChain(from, "", big.NewInt(1337))(common.HexToAddress("0x02"), tx)
+	require.EqualError(err, "not authorized to sign this account")
+}
@@ -0,0 +1,152 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+// DeployLinked deploys the contract, linked with the libraries it depends on. Libraries
+// missing from deployed get deployed first, in dependency order, from their sources
+// in libraries. Both maps are keyed by fully qualified library names (path:Name) or by names,
+// addresses of the newly deployed libraries are added into deployed, if it's not nil.
+// Each library deployment is waited for to be mined before the next one is sent. The linked
+// contract is deployed and returned as a new binding, c is left unlinked.
+func (c *BoundContract) DeployLinked(opts *bind.TransactOpts, libraries map[string]*sol.Contract,
+	deployed map[string]common.Address, params ...interface{}) (common.Address, *types.Transaction, *BoundContract, error) {
+
+	if deployed == nil {
+		deployed = make(map[string]common.Address)
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// libraries have no payable constructors, the value goes to the contract only
+	libOpts := *opts
+	libOpts.Value = nil
+	var deploy func(lib string, path []string) error
+	deploy = func(lib string, path []string) error {
+		if _, ok := libraryAddress(lib, deployed); ok {
+			return nil
+		}
+		for _, p := range path {
+			if p == lib {
+				err := fmt.Errorf("library dependency cycle: %s", strings.Join(append(path, lib), " -> "))
+				return err
+			}
+		}
+		name, src, ok := librarySource(lib, libraries)
+		if !ok {
+			err := fmt.Errorf("no source to deploy library %s", lib)
+			return err
+		}
+		for _, dep := range src.Libraries() {
+			if err := deploy(dep, append(path, lib)); err != nil {
+				return err
+			}
+		}
+		linked, err := src.Link(deployed)
+		if err != nil {
+			return err
+		}
+		bound, err := BindContract(c.client, linked)
+		if err != nil {
+			return err
+		}
+		bound.SetTransact(c.transactFn)
+		addr, tx, err := bound.DeployContract(&libOpts)
+		if err != nil {
+			err = fmt.Errorf("failed to deploy library %s: %v", lib, err)
+			return err
+		}
+		if _, err := bind.WaitDeployed(ctx, c.client, tx); err != nil {
+			err = fmt.Errorf("failed to deploy library %s: %v", lib, err)
+			return err
+		}
+		deployed[name] = addr
+		if libOpts.Nonce != nil {
+			libOpts.Nonce = new(big.Int).SetUint64(tx.Nonce() + 1)
+		}
+		return nil
+	}
+	for _, lib := range c.src.Libraries() {
+		if err := deploy(lib, nil); err != nil {
+			return common.Address{}, nil, nil, err
+		}
+	}
+	linked, err := c.src.Link(deployed)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	bound, err := BindContract(c.client, linked)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	bound.transactFn = c.transactFn
+	bound.accessLists = c.accessLists
+	deployOpts := libOpts
+	deployOpts.Value = opts.Value
+	addr, tx, err := bound.DeployContract(&deployOpts, params...)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return addr, tx, bound, nil
+}
+
+// libraryName strips the path from a fully qualified library name.
+func libraryName(lib string) string {
+	return lib[strings.LastIndex(lib, ":")+1:]
+}
+
+// libraryMatches reports whether the library name reported by Contract.Libraries
+// is the one of the key, as the hashed or truncated name of a placeholder.
+func libraryMatches(lib, key string) bool {
+	if lib == strings.Trim(sol.LibraryPlaceholder(key), "_") {
+		return true
+	}
+	return len(key) > len(lib) && len(lib) == 36 && key[:36] == lib
+}
+
+func libraryAddress(lib string, deployed map[string]common.Address) (common.Address, bool) {
+	if addr, ok := deployed[lib]; ok {
+		return addr, true
+	}
+	if addr, ok := deployed[libraryName(lib)]; ok {
+		return addr, true
+	}
+	for key, addr := range deployed {
+		if libraryMatches(lib, key) {
+			return addr, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// librarySource returns the source of the library and the name to record its address by,
+// the key of the source if lib is only the hashed or truncated name from a placeholder.
+func librarySource(lib string, libraries map[string]*sol.Contract) (string, *sol.Contract, bool) {
+	if src, ok := libraries[lib]; ok {
+		return lib, src, true
+	}
+	if src, ok := libraries[libraryName(lib)]; ok {
+		return lib, src, true
+	}
+	for key, src := range libraries {
+		if libraryMatches(lib, key) {
+			return key, src, true
+		}
+	}
+	return "", nil, false
+}
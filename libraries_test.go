@@ -0,0 +1,99 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+func TestDeployLinked(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	opts, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(err)
+	sim := backends.NewSimulatedBackend(types.GenesisAlloc{
+		opts.From: {Balance: big.NewInt(1e18)},
+	}, 8000000)
+	defer sim.Close()
+	// the deployments are waited for, so the blocks are mined in the background
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				sim.Commit()
+			}
+		}
+	}()
+
+	// both return a single byte of code, the contract pushes the library address first
+	returnCode := "600160005360016000f3"
+	mathLib := "lib/Math.sol:Math"
+	calc := &sol.Contract{
+		Name: "Calc",
+		ABI:  []byte(`[]`),
+		Bin:  "73" + sol.LibraryPlaceholder(mathLib) + "50" + returnCode,
+		LinkReferences: map[string][]sol.LinkReference{
+			mathLib: {{Start: 1, Length: 20}},
+		},
+	}
+	libraries := map[string]*sol.Contract{
+		"Math": {Name: "Math", ABI: []byte(`[]`), Bin: returnCode},
+	}
+	contract, err := BindContract(sim, calc)
+	require.NoError(err)
+	deployed := make(map[string]common.Address)
+	addr, tx, linked, err := contract.DeployLinked(opts, libraries, deployed)
+	require.NoError(err)
+	libAddr, ok := deployed["lib/Math.sol:Math"]
+	require.True(ok)
+	require.True(bytes.Contains(tx.Data(), libAddr.Bytes()))
+	require.Equal(addr, linked.Address())
+	require.False(linked.Source().NeedsLinking())
+
+	// the original binding is left unlinked
+	require.True(contract.Source().NeedsLinking())
+	require.Equal(common.Address{}, contract.Address())
+	_, err = bind.WaitDeployed(context.Background(), sim, tx)
+	require.NoError(err)
+
+	// without link references the libraries are matched by the hashed placeholders
+	calc.LinkReferences = nil
+	libraries = map[string]*sol.Contract{
+		mathLib: {Name: "Math", ABI: []byte(`[]`), Bin: returnCode},
+	}
+	contract, err = BindContract(sim, calc)
+	require.NoError(err)
+	deployed = make(map[string]common.Address)
+	_, tx, linked, err = contract.DeployLinked(opts, libraries, deployed)
+	require.NoError(err)
+	libAddr, ok = deployed[mathLib]
+	require.True(ok)
+	require.True(bytes.Contains(tx.Data(), libAddr.Bytes()))
+	require.False(linked.Source().NeedsLinking())
+	_, err = bind.WaitDeployed(context.Background(), sim, tx)
+	require.NoError(err)
+}
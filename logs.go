@@ -0,0 +1,36 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// CollectLogs reads all the logs produced by a finite subscription, like the one
+// returned by bind.BoundContract.FilterLogs, and unsubscribes.
+func CollectLogs(logs <-chan types.Log, sub event.Subscription) ([]types.Log, error) {
+	defer sub.Unsubscribe()
+	var collected []types.Log
+	for {
+		select {
+		case log := <-logs:
+			collected = append(collected, log)
+		case err := <-sub.Err():
+			if err != nil {
+				return nil, err
+			}
+			// the producer has finished, but some logs may still be buffered
+			for {
+				select {
+				case log := <-logs:
+					collected = append(collected, log)
+				default:
+					return collected, nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Multicall3Address is the address of Multicall3, deployed on most chains.
+var Multicall3Address = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+const multicall3ABI = `[{"type":"function","name":"aggregate3","stateMutability":"payable","inputs":[{"name":"calls","type":"tuple[]","components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],"outputs":[{"name":"returnData","type":"tuple[]","components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}]`
+
+// errNoMulticall is returned by aggregate if Multicall3 has no code at the block of the calls.
+var errNoMulticall = errors.New("Multicall3 has no code at the block")
+
+const (
+	// DefaultMulticallGas is the gas limit of the calls in one aggregate3 call.
+	DefaultMulticallGas = 30000000
+	// DefaultMulticallSize is the size limit of the calldata of one aggregate3 call.
+	DefaultMulticallSize = 128 * 1024
+	// DefaultBatchSize is the number of requests in one JSON-RPC batch.
+	DefaultBatchSize = 100
+	// DefaultCallGas is the gas assumed for a call that doesn't set its own.
+	DefaultCallGas = 100000
+)
+
+// ContractCall is a read of the contract method, executed in a batch by Multicall.
+type ContractCall struct {
+	Contract *BoundContract
+	Method   string
+	Params   []interface{}
+	// Out receives the outputs, like in CallInto. Nil out discards the outputs.
+	Out interface{}
+	// AllowFailure makes the batch succeed if this call fails.
+	AllowFailure bool
+	// Gas is the expected gas of the call, used to split the batches.
+	Gas uint64
+
+	// Err is set to the error of the call after the batch, *RevertError if reverted.
+	Err error
+}
+
+// MulticallOptions are the options of Multicall, the zero values select the defaults.
+type MulticallOptions struct {
+	// Address is the address of Multicall3, Multicall3Address by default.
+	Address common.Address
+	// RPCClient is used to send JSON-RPC batches if Multicall3 isn't deployed.
+	// Without it, the calls are sent one by one.
+	RPCClient *rpc.Client
+	// DisableMulticall forces JSON-RPC batches even if Multicall3 is deployed.
+	DisableMulticall bool
+	// MaxGas is the gas limit of the calls aggregated into one call.
+	MaxGas uint64
+	// MaxSize is the calldata size limit of one aggregate call.
+	MaxSize int
+	// BatchSize is the number of requests in one JSON-RPC batch.
+	BatchSize int
+}
+
+// Multicall executes many contract reads in a few requests, by aggregating them into
+// Multicall3 aggregate3 calls, or by sending JSON-RPC batches of eth_call.
+type Multicall struct {
+	client Backend
+	opts   MulticallOptions
+	abi    abi.ABI
+
+	mux      sync.Mutex
+	deployed *bool
+}
+
+// NewMulticall returns a Multicall that uses the client to call Multicall3.
+func NewMulticall(client Backend, opts MulticallOptions) (*Multicall, error) {
+	parsed, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Address == (common.Address{}) {
+		opts.Address = Multicall3Address
+	}
+	if opts.MaxGas == 0 {
+		opts.MaxGas = DefaultMulticallGas
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMulticallSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	m := &Multicall{
+		client: client,
+		opts:   opts,
+		abi:    parsed,
+	}
+	if opts.DisableMulticall {
+		m.deployed = new(bool)
+	}
+	return m, nil
+}
+
+// Call executes the calls at the block of opts and sets their outputs and errors.
+// Returns the error of the first failed call that doesn't allow failure, or the error
+// of a request. Note that the calls aggregated by Multicall3 have it as the sender.
+func (m *Multicall) Call(opts *bind.CallOpts, calls ...*ContractCall) error {
+	if opts == nil {
+		opts = new(bind.CallOpts)
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	inputs := make([][]byte, len(calls))
+	for i, call := range calls {
+		call.Err = nil
+		if _, ok := call.Contract.abi.Methods[call.Method]; !ok {
+			err := fmt.Errorf("method %s not found in ABI of %s", call.Method, call.Contract.contractName())
+			return err
+		}
+		input, err := call.Contract.abi.Pack(call.Method, call.Params...)
+		if err != nil {
+			err = fmt.Errorf("failed to pack %s params: %v", call.Method, err)
+			return err
+		}
+		inputs[i] = input
+	}
+	deployed, err := m.isDeployed(ctx)
+	if err != nil {
+		return err
+	}
+	if deployed {
+		err = m.aggregate(ctx, opts, calls, inputs)
+		if err == errNoMulticall {
+			// the block is before the deployment of Multicall3
+			deployed = false
+		}
+	}
+	if !deployed {
+		err = m.callEach(ctx, opts, calls, inputs)
+	}
+	if err != nil {
+		return err
+	}
+	for _, call := range calls {
+		if call.Err != nil && !call.AllowFailure {
+			return call.Err
+		}
+	}
+	return nil
+}
+
+// callEach sends the calls in JSON-RPC batches, or one by one without the RPC client.
+func (m *Multicall) callEach(ctx context.Context, opts *bind.CallOpts, calls []*ContractCall, inputs [][]byte) error {
+	if m.opts.RPCClient != nil {
+		return m.batch(ctx, opts, calls, inputs)
+	}
+	for _, call := range calls {
+		call.Err = call.Contract.CallIntoOpts(opts, call.Method, call.Out, call.Params...)
+	}
+	return nil
+}
+
+// isDeployed checks once whether Multicall3 has code at the latest block. The calls at
+// the blocks before its deployment get the empty output from aggregate3 and are sent
+// by callEach instead.
+func (m *Multicall) isDeployed(ctx context.Context) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.deployed != nil {
+		return *m.deployed, nil
+	}
+	code, err := m.client.CodeAt(ctx, m.opts.Address, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to get code of Multicall3: %v", err)
+		return false, err
+	}
+	deployed := len(code) > 0
+	m.deployed = &deployed
+	return deployed, nil
+}
+
+type multicallCall3 struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// aggregate sends the calls in aggregate3 calls, split by the gas and size limits.
+// The calls are always sent with allowFailure, so that a failed call doesn't discard
+// the results of the others.
+func (m *Multicall) aggregate(ctx context.Context, opts *bind.CallOpts, calls []*ContractCall, inputs [][]byte) error {
+	for start := 0; start < len(calls); {
+		end := start
+		var gas uint64
+		size := 4 + 64
+		for end < len(calls) {
+			callGas := calls[end].Gas
+			if callGas == 0 {
+				callGas = DefaultCallGas
+			}
+			// the offset, target, allowFailure, data offset and length words and the padded data
+			callSize := 5*32 + (len(inputs[end])+31)/32*32
+			if end > start && (gas+callGas > m.opts.MaxGas || size+callSize > m.opts.MaxSize) {
+				break
+			}
+			gas += callGas
+			size += callSize
+			end++
+		}
+		if err := m.aggregateChunk(ctx, opts, calls[start:end], inputs[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+func (m *Multicall) aggregateChunk(ctx context.Context, opts *bind.CallOpts, calls []*ContractCall, inputs [][]byte) error {
+	args := make([]multicallCall3, len(calls))
+	for i, call := range calls {
+		args[i] = multicallCall3{
+			Target:       call.Contract.address,
+			AllowFailure: true,
+			CallData:     inputs[i],
+		}
+	}
+	input, err := m.abi.Pack("aggregate3", args)
+	if err != nil {
+		err = fmt.Errorf("failed to pack aggregate3 params: %v", err)
+		return err
+	}
+	msg := ethereum.CallMsg{
+		From: opts.From,
+		To:   &m.opts.Address,
+		Data: input,
+	}
+	output, err := callContract(ctx, m.client, msg, opts)
+	if err != nil {
+		if revert, ok := revertFromError(err); ok {
+			return revert
+		}
+		return err
+	} else if len(output) == 0 {
+		return errNoMulticall
+	}
+	var results []multicallResult
+	if err := m.abi.UnpackIntoInterface(&results, "aggregate3", output); err != nil {
+		err = fmt.Errorf("failed to unpack aggregate3 outputs: %v", err)
+		return err
+	} else if len(results) != len(calls) {
+		err = fmt.Errorf("aggregate3 returned %d results for %d calls", len(results), len(calls))
+		return err
+	}
+	for i, call := range calls {
+		call.Err = m.result(call, results[i].Success, results[i].ReturnData)
+	}
+	return nil
+}
+
+// result decodes the return data of the aggregated call.
+func (m *Multicall) result(call *ContractCall, success bool, data []byte) error {
+	if !success {
+		if revert, ok := DecodeRevert(data); ok {
+			return revert
+		}
+		return &RevertError{}
+	}
+	if len(data) == 0 {
+		if len(call.Contract.abi.Methods[call.Method].Outputs) == 0 {
+			return nil
+		}
+		// Multicall3 calls succeed on the addresses without code
+		return bind.ErrNoCode
+	}
+	return call.Contract.unpackCall(call.Method, data, call.Out)
+}
+
+// batch sends the calls as eth_call requests in JSON-RPC batches.
+func (m *Multicall) batch(ctx context.Context, opts *bind.CallOpts, calls []*ContractCall, inputs [][]byte) error {
+	block := "latest"
+	if opts.Pending {
+		block = "pending"
+	} else if opts.BlockNumber != nil {
+		block = hexutil.EncodeBig(opts.BlockNumber)
+	}
+	for start := 0; start < len(calls); start += m.opts.BatchSize {
+		end := start + m.opts.BatchSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+		elems := make([]rpc.BatchElem, end-start)
+		outputs := make([]hexutil.Bytes, end-start)
+		for i := range elems {
+			arg := map[string]interface{}{
+				"to":   calls[start+i].Contract.address,
+				"data": hexutil.Bytes(inputs[start+i]),
+			}
+			if opts.From != (common.Address{}) {
+				arg["from"] = opts.From
+			}
+			elems[i] = rpc.BatchElem{
+				Method: "eth_call",
+				Args:   []interface{}{arg, block},
+				Result: &outputs[i],
+			}
+		}
+		if err := m.opts.RPCClient.BatchCallContext(ctx, elems); err != nil {
+			err = fmt.Errorf("failed to send batch of eth_call: %v", err)
+			return err
+		}
+		for i, elem := range elems {
+			call := calls[start+i]
+			switch {
+			case elem.Error != nil:
+				if revert, ok := revertFromError(elem.Error); ok {
+					call.Err = revert
+				} else {
+					call.Err = elem.Error
+				}
+			case len(outputs[i]) == 0:
+				// resolve the empty output like CallIntoOpts does
+				call.Err = call.Contract.CallIntoOpts(opts, call.Method, call.Out, call.Params...)
+			default:
+				call.Err = call.Contract.unpackCall(call.Method, outputs[i], call.Out)
+			}
+		}
+	}
+	return nil
+}
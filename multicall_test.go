@@ -0,0 +1,249 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+var multicallTestToken = common.HexToAddress("0x1000000000000000000000000000000000000001")
+
+// newMulticallTestNode returns a node with the test contract and, if deployed is set,
+// Multicall3 executing aggregate3 against it.
+func newMulticallTestNode(t *testing.T, deployed bool) *rpctest.MockNode {
+	parsed, outputs := callTestOutputs(t)
+	mcABI, err := abi.JSON(strings.NewReader(multicall3ABI))
+	require.NoError(t, err)
+	// call returns the output of the test contract method and whether it has succeeded
+	call := func(to common.Address, data []byte) ([]byte, bool) {
+		if to != multicallTestToken {
+			return nil, true
+		}
+		for name, m := range parsed.Methods {
+			if bytes.HasPrefix(data, m.ID) {
+				return outputs[name], name != "fail" && name != "overflow"
+			}
+		}
+		return nil, false
+	}
+
+	node := rpctest.NewMockNode()
+	node.SetCode(multicallTestToken, []byte{0x60})
+	if deployed {
+		node.SetCode(Multicall3Address, []byte{0x60})
+	}
+	node.Handle("eth_call", func(req rpctest.Request) (interface{}, error) {
+		var msg struct {
+			To    common.Address `json:"to"`
+			Data  hexutil.Bytes  `json:"input"`
+			Data2 hexutil.Bytes  `json:"data"`
+		}
+		if err := json.Unmarshal(req.Params[0], &msg); err != nil {
+			return nil, err
+		}
+		if len(msg.Data) == 0 {
+			// the batches send the calldata as data
+			msg.Data = msg.Data2
+		}
+		if msg.To != Multicall3Address || !deployed {
+			output, _ := call(msg.To, msg.Data)
+			return hexutil.Bytes(output), nil
+		}
+		var calls []multicallCall3
+		inputs := mcABI.Methods["aggregate3"].Inputs
+		values, err := inputs.Unpack(msg.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		if err := inputs.Copy(&calls, values); err != nil {
+			return nil, err
+		}
+		results := make([]multicallResult, len(calls))
+		for i, c := range calls {
+			output, ok := call(c.Target, c.CallData)
+			results[i] = multicallResult{ok, output}
+		}
+		output, err := mcABI.Methods["aggregate3"].Outputs.Pack(results)
+		if err != nil {
+			return nil, err
+		}
+		return hexutil.Bytes(output), nil
+	})
+	return node
+}
+
+func newMulticallTestContract(t *testing.T, client *ethclient.Client, address common.Address) *BoundContract {
+	contract, err := BindContract(client, &sol.Contract{
+		Name:    "Token",
+		ABI:     []byte(callTestABI),
+		Address: address,
+	})
+	require.NoError(t, err)
+	return contract
+}
+
+type multicallTestCalls struct {
+	balance *big.Int
+	info    struct {
+		Name     string
+		Decimals uint8
+	}
+	calls []*ContractCall
+}
+
+func newMulticallTestCalls(token, missing *BoundContract) *multicallTestCalls {
+	c := &multicallTestCalls{
+		balance: new(big.Int),
+	}
+	c.calls = []*ContractCall{
+		{Contract: token, Method: "balanceOf", Params: []interface{}{common.HexToAddress("0x2")}, Out: &c.balance},
+		{Contract: token, Method: "info", Out: &c.info},
+		{Contract: token, Method: "fail", AllowFailure: true},
+		{Contract: token, Method: "overflow", AllowFailure: true},
+		{Contract: missing, Method: "balanceOf", Params: []interface{}{common.HexToAddress("0x2")}, AllowFailure: true},
+	}
+	return c
+}
+
+func (c *multicallTestCalls) check(t *testing.T) {
+	require := require.New(t)
+	require.NoError(c.calls[0].Err)
+	require.Equal(int64(1000), c.balance.Int64())
+	require.NoError(c.calls[1].Err)
+	require.Equal("Token", c.info.Name)
+	require.Equal(uint8(18), c.info.Decimals)
+	require.EqualError(c.calls[2].Err, "execution reverted: not allowed")
+	revert, ok := c.calls[3].Err.(*RevertError)
+	require.True(ok, "unexpected error: %v", c.calls[3].Err)
+	require.Equal(int64(0x11), revert.PanicCode.Int64())
+	require.Equal(bind.ErrNoCode, c.calls[4].Err)
+}
+
+func TestMulticallAggregate(t *testing.T) {
+	require := require.New(t)
+	node := newMulticallTestNode(t, true)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	token := newMulticallTestContract(t, client, multicallTestToken)
+	missing := newMulticallTestContract(t, client, common.HexToAddress("0x3"))
+
+	mc, err := NewMulticall(client, MulticallOptions{})
+	require.NoError(err)
+	calls := newMulticallTestCalls(token, missing)
+	require.NoError(mc.Call(nil, calls.calls...))
+	calls.check(t)
+	require.Len(node.Requests("eth_call"), 1)
+	require.Len(node.Requests("eth_getCode"), 1)
+
+	// the call that doesn't allow failure fails the batch
+	calls.calls[2].AllowFailure = false
+	err = mc.Call(nil, calls.calls...)
+	require.EqualError(err, "execution reverted: not allowed")
+	require.Equal(int64(1000), calls.balance.Int64())
+}
+
+func TestMulticallSplit(t *testing.T) {
+	require := require.New(t)
+	node := newMulticallTestNode(t, true)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	token := newMulticallTestContract(t, client, multicallTestToken)
+	missing := newMulticallTestContract(t, client, common.HexToAddress("0x3"))
+
+	mc, err := NewMulticall(client, MulticallOptions{
+		MaxGas: 2 * DefaultCallGas,
+	})
+	require.NoError(err)
+	calls := newMulticallTestCalls(token, missing)
+	require.NoError(mc.Call(&bind.CallOpts{Context: context.Background(), BlockNumber: big.NewInt(16)}, calls.calls...))
+	calls.check(t)
+	ethCalls := node.Requests("eth_call")
+	require.Len(ethCalls, 3)
+	var block string
+	require.NoError(json.Unmarshal(ethCalls[0].Params[1], &block))
+	require.Equal("0x10", block)
+
+	mc, err = NewMulticall(client, MulticallOptions{
+		MaxSize: 4 + 64 + 2*(5*32+64),
+	})
+	require.NoError(err)
+	require.NoError(mc.Call(nil, calls.calls...))
+	calls.check(t)
+	require.Len(node.Requests("eth_call"), 3+3)
+}
+
+func TestMulticallBeforeDeployment(t *testing.T) {
+	require := require.New(t)
+	// Multicall3 has code at the latest block only, aggregate3 returns nothing before it
+	node := newMulticallTestNode(t, false)
+	node.SetCode(Multicall3Address, []byte{0x60})
+	rpcClient, closeFn := dialRPC(t, node)
+	defer closeFn()
+	client := ethclient.NewClient(rpcClient)
+	token := newMulticallTestContract(t, client, multicallTestToken)
+	missing := newMulticallTestContract(t, client, common.HexToAddress("0x3"))
+
+	mc, err := NewMulticall(client, MulticallOptions{
+		RPCClient: rpcClient,
+	})
+	require.NoError(err)
+	calls := newMulticallTestCalls(token, missing)
+	require.NoError(mc.Call(&bind.CallOpts{BlockNumber: big.NewInt(16)}, calls.calls...))
+	calls.check(t)
+	// the aggregate3 call and then the batch, all at the block of the calls
+	ethCalls := node.Requests("eth_call")
+	require.True(len(ethCalls) > len(calls.calls))
+	for _, call := range ethCalls {
+		var block string
+		require.NoError(json.Unmarshal(call.Params[1], &block))
+		require.Equal("0x10", block)
+	}
+}
+
+func TestMulticallBatch(t *testing.T) {
+	require := require.New(t)
+	node := newMulticallTestNode(t, false)
+	rpcClient, closeFn := dialRPC(t, node)
+	defer closeFn()
+	client := ethclient.NewClient(rpcClient)
+	token := newMulticallTestContract(t, client, multicallTestToken)
+	missing := newMulticallTestContract(t, client, common.HexToAddress("0x3"))
+
+	mc, err := NewMulticall(client, MulticallOptions{
+		RPCClient: rpcClient,
+		BatchSize: 2,
+	})
+	require.NoError(err)
+	calls := newMulticallTestCalls(token, missing)
+	require.NoError(mc.Call(&bind.CallOpts{Pending: true}, calls.calls...))
+	calls.check(t)
+	for _, call := range node.Requests("eth_call") {
+		var block string
+		require.NoError(json.Unmarshal(call.Params[1], &block))
+		require.Equal("pending", block)
+	}
+
+	// without the RPC client the calls are sent one by one
+	mc, err = NewMulticall(client, MulticallOptions{})
+	require.NoError(err)
+	calls = newMulticallTestCalls(token, missing)
+	require.NoError(mc.Call(nil, calls.calls...))
+	calls.check(t)
+}
@@ -0,0 +1,231 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// The ERC-165 identifiers of the token standard interfaces.
+var (
+	InterfaceERC165             = [4]byte{0x01, 0xff, 0xc9, 0xa7}
+	InterfaceERC721             = [4]byte{0x80, 0xac, 0x58, 0xcd}
+	InterfaceERC721Metadata     = [4]byte{0x5b, 0x5e, 0x13, 0x9f}
+	InterfaceERC721Enumerable   = [4]byte{0x78, 0x0e, 0x9d, 0x63}
+	InterfaceERC1155            = [4]byte{0xd9, 0xb6, 0x7a, 0x26}
+	InterfaceERC1155MetadataURI = [4]byte{0x0e, 0x89, 0x34, 0x1c}
+)
+
+// DefaultIPFSGateway is the gateway HTTPMetadataFetcher fetches the ipfs:// URIs through.
+const DefaultIPFSGateway = "https://ipfs.io/ipfs/"
+
+// supportsInterface detects the interface as ERC-165 prescribes: the contract must support
+// ERC-165 itself and must not claim to support the invalid 0xffffffff interface. Contracts
+// reverting or returning nothing don't support ERC-165.
+func supportsInterface(ctx context.Context, c *BoundContract, id [4]byte) (bool, error) {
+	for _, check := range []struct {
+		id   [4]byte
+		want bool
+	}{
+		{InterfaceERC165, true},
+		{[4]byte{0xff, 0xff, 0xff, 0xff}, false},
+	} {
+		var supported bool
+		if err := c.CallInto(ctx, "supportsInterface", &supported, check.id); err != nil {
+			if _, ok := err.(*RevertError); ok {
+				return false, nil
+			}
+			return false, err
+		} else if supported != check.want {
+			return false, nil
+		}
+	}
+	if id == InterfaceERC165 {
+		return true, nil
+	}
+	var supported bool
+	if err := c.CallInto(ctx, "supportsInterface", &supported, id); err != nil {
+		if _, ok := err.(*RevertError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return supported, nil
+}
+
+// MetadataFetcher fetches the metadata JSON of the tokens by their URIs.
+type MetadataFetcher interface {
+	Fetch(ctx context.Context, uri string) ([]byte, error)
+}
+
+// MetadataFetcherFunc is a func fetching the metadata.
+type MetadataFetcherFunc func(ctx context.Context, uri string) ([]byte, error)
+
+// Fetch calls fn.
+func (fn MetadataFetcherFunc) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	return fn(ctx, uri)
+}
+
+// HTTPMetadataFetcher fetches the http and https URIs, the ipfs ones through the IPFS gateway,
+// and decodes the data URIs.
+type HTTPMetadataFetcher struct {
+	// Client is http.DefaultClient by default.
+	Client *http.Client
+	// IPFSGateway is DefaultIPFSGateway by default.
+	IPFSGateway string
+}
+
+// Fetch returns the metadata at the URI.
+func (f *HTTPMetadataFetcher) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(uri, "data:"):
+		return decodeDataURI(uri)
+	case strings.HasPrefix(uri, "ipfs://"):
+		gateway := f.IPFSGateway
+		if len(gateway) == 0 {
+			gateway = DefaultIPFSGateway
+		}
+		path := strings.TrimPrefix(strings.TrimPrefix(uri, "ipfs://"), "ipfs/")
+		uri = strings.TrimSuffix(gateway, "/") + "/" + path
+	case strings.HasPrefix(uri, "http://"), strings.HasPrefix(uri, "https://"):
+	default:
+		err := fmt.Errorf("unsupported metadata URI %s", uri)
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to fetch %s: %s", uri, resp.Status)
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// decodeDataURI returns the data of the RFC 2397 URI, like data:application/json;base64,...
+func decodeDataURI(uri string) ([]byte, error) {
+	sep := strings.IndexByte(uri, ',')
+	if sep < 0 {
+		err := fmt.Errorf("invalid data URI %.32s", uri)
+		return nil, err
+	}
+	header, data := uri[len("data:"):sep], uri[sep+1:]
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	unescaped, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(unescaped), nil
+}
+
+// TokenMetadata is the metadata JSON of ERC-721 and ERC-1155 tokens.
+type TokenMetadata struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Image       string              `json:"image"`
+	ExternalURL string              `json:"external_url,omitempty"`
+	Attributes  []MetadataAttribute `json:"attributes,omitempty"`
+	// Raw is the whole JSON, for the non-standard fields.
+	Raw json.RawMessage `json:"-"`
+}
+
+// MetadataAttribute is a trait of the token, the value is a string or a number.
+type MetadataAttribute struct {
+	TraitType string      `json:"trait_type"`
+	Value     interface{} `json:"value"`
+}
+
+// fetchMetadata fetches and parses the metadata at the URI.
+func fetchMetadata(ctx context.Context, fetcher MetadataFetcher, uri string) (*TokenMetadata, error) {
+	if fetcher == nil {
+		fetcher = new(HTTPMetadataFetcher)
+	}
+	data, err := fetcher.Fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	var meta TokenMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		err = fmt.Errorf("failed to parse metadata of %s: %v", uri, err)
+		return nil, err
+	}
+	meta.Raw = data
+	return &meta, nil
+}
+
+// sortLogs orders the logs as they were emitted.
+func sortLogs(logs []types.Log) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+}
+
+// TokenTransfer is a transfer of a token, the mints are from and the burns are to the
+// zero address. Amount is 1 for ERC-721 tokens, which transfers have no Operator.
+type TokenTransfer struct {
+	Operator    common.Address
+	From        common.Address
+	To          common.Address
+	TokenID     *big.Int
+	Amount      *big.Int
+	BlockNumber uint64
+	TxHash      common.Hash
+	LogIndex    uint
+}
+
+// Holders returns the balances of the token holders after the transfers, which must start
+// with the mint. The accounts left without tokens are omitted.
+func Holders(transfers []*TokenTransfer) map[common.Address]*big.Int {
+	balances := make(map[common.Address]*big.Int)
+	for _, t := range transfers {
+		if t.From != (common.Address{}) {
+			balance, ok := balances[t.From]
+			if !ok {
+				balance = new(big.Int)
+			}
+			balances[t.From] = new(big.Int).Sub(balance, t.Amount)
+		}
+		if t.To != (common.Address{}) {
+			balance, ok := balances[t.To]
+			if !ok {
+				balance = new(big.Int)
+			}
+			balances[t.To] = new(big.Int).Add(balance, t.Amount)
+		}
+	}
+	for account, balance := range balances {
+		if balance.Sign() == 0 {
+			delete(balances, account)
+		}
+	}
+	return balances
+}
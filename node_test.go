@@ -0,0 +1,31 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+// dial returns a client connected to the node, with a func to stop both.
+func dial(t *testing.T, node *rpctest.MockNode) (*ethclient.Client, func()) {
+	client, closeFn := dialRPC(t, node)
+	return ethclient.NewClient(client), closeFn
+}
+
+// dialRPC is like dial, but returns the RPC client.
+func dialRPC(t *testing.T, node *rpctest.MockNode) (*rpc.Client, func()) {
+	client, err := rpc.Dial(node.URL())
+	require.NoError(t, err)
+	return client, func() {
+		client.Close()
+		node.Close()
+	}
+}
@@ -5,6 +5,7 @@
 package ethfw
 
 import (
+	"context"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -12,6 +13,10 @@ import (
 
 type NonceCache interface {
 	Serialize(account common.Address, fn func() error) error
+	SerializeContext(ctx context.Context, account common.Address, fn func(ctx context.Context) error) error
+	// Guard returns the lock used by Serialize, ids are hex-encoded accounts.
+	// Use its Stats and SetHooks to observe contention on hot accounts.
+	Guard() Uniquify
 	Sync(account common.Address, syncFn func() (uint64, error))
 
 	Set(account common.Address, nonce uint64)
@@ -43,6 +48,16 @@ func (n nonceCache) Serialize(account common.Address, fn func() error) error {
 	return n.guard.Call(account.Hex(), fn)
 }
 
+// SerializeContext is like Serialize, but gives up waiting for the exclusive access
+// once ctx is done, returning ctx.Err().
+func (n nonceCache) SerializeContext(ctx context.Context, account common.Address, fn func(ctx context.Context) error) error {
+	return n.guard.CallContext(ctx, account.Hex(), fn)
+}
+
+func (n nonceCache) Guard() Uniquify {
+	return n.guard
+}
+
 func (n nonceCache) Get(account common.Address) uint64 {
 	n.mux.RLock()
 	lock, ok := n.locks[account]
@@ -0,0 +1,93 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package offline
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Validate checks that every transaction of the bundle is signed by its account and is the
+// transaction prepared, so the signed one can't be swapped without the bundle noticing.
+func Validate(bundle *Bundle) error {
+	chainID := bundle.ChainID.ToInt()
+	signer := types.LatestSignerForChainID(chainID)
+	for i, tx := range bundle.Transactions {
+		if len(tx.Signed) == 0 {
+			err := fmt.Errorf("transaction %d is not signed", i)
+			return err
+		}
+		signed := new(types.Transaction)
+		if err := signed.UnmarshalBinary(tx.Signed); err != nil {
+			err = fmt.Errorf("transaction %d: failed to decode: %v", i, err)
+			return err
+		}
+		if signed.ChainId().Cmp(chainID) != 0 {
+			err := fmt.Errorf("transaction %d is signed for chain %s", i, signed.ChainId())
+			return err
+		}
+		unsigned, err := tx.Unsigned(chainID)
+		if err != nil {
+			err = fmt.Errorf("transaction %d: %v", i, err)
+			return err
+		}
+		if signer.Hash(signed) != signer.Hash(unsigned) {
+			err := fmt.Errorf("transaction %d differs from the prepared one", i)
+			return err
+		}
+		from, err := types.Sender(signer, signed)
+		if err != nil {
+			err = fmt.Errorf("transaction %d: %v", i, err)
+			return err
+		} else if from != tx.From {
+			err := fmt.Errorf("transaction %d is signed by %s, not %s", i, from.Hex(), tx.From.Hex())
+			return err
+		}
+		if tx.Hash != nil && *tx.Hash != signed.Hash() {
+			err := fmt.Errorf("transaction %d hash is %s, not %s", i, signed.Hash().Hex(), tx.Hash.Hex())
+			return err
+		}
+	}
+	return nil
+}
+
+// Broadcaster is the node the signed bundle is submitted to.
+type Broadcaster interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// Broadcast validates the signed bundle and sends its transactions in order, returning
+// the hashes of the transactions sent, which are all of them unless there's an error.
+func Broadcast(ctx context.Context, client Broadcaster, bundle *Bundle) ([]common.Hash, error) {
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get chain ID: %v", err)
+		return nil, err
+	} else if chainID.Cmp(bundle.ChainID.ToInt()) != 0 {
+		err = fmt.Errorf("bundle is for chain %s, the node is on chain %s", bundle.ChainID.ToInt(), chainID)
+		return nil, err
+	}
+	if err := Validate(bundle); err != nil {
+		return nil, err
+	}
+	hashes := make([]common.Hash, 0, len(bundle.Transactions))
+	for i, tx := range bundle.Transactions {
+		signed := new(types.Transaction)
+		if err := signed.UnmarshalBinary(tx.Signed); err != nil {
+			return hashes, err
+		}
+		if err := client.SendTransaction(ctx, signed); err != nil {
+			err = fmt.Errorf("failed to send transaction %d: %v", i, err)
+			return hashes, err
+		}
+		hashes = append(hashes, signed.Hash())
+	}
+	return hashes, nil
+}
@@ -0,0 +1,285 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+// Package offline splits sending transactions between an online machine, which prepares
+// and broadcasts them, and an air-gapped one holding the keys, which signs them. The
+// machines exchange the transactions as a bundle, a JSON file.
+package offline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/AtlantPlatform/ethfw"
+)
+
+// BundleVersion is the version of the bundle format.
+const BundleVersion = 1
+
+// Bundle is the batch of transactions of a chain, unsigned until the signer signs them.
+type Bundle struct {
+	Version      int          `json:"version"`
+	ChainID      *hexutil.Big `json:"chainId"`
+	Transactions []*Tx        `json:"transactions"`
+}
+
+// Tx is a legacy, access list or dynamic fee transaction of the bundle. Signed is the raw
+// signed transaction, ready for eth_sendRawTransaction.
+type Tx struct {
+	Type                 hexutil.Uint64   `json:"type"`
+	From                 common.Address   `json:"from"`
+	To                   *common.Address  `json:"to"`
+	Nonce                hexutil.Uint64   `json:"nonce"`
+	Value                *hexutil.Big     `json:"value"`
+	Gas                  hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big     `json:"gasPrice,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big     `json:"maxPriorityFeePerGas,omitempty"`
+	MaxFeePerGas         *hexutil.Big     `json:"maxFeePerGas,omitempty"`
+	AccessList           types.AccessList `json:"accessList,omitempty"`
+	Data                 hexutil.Bytes    `json:"data"`
+	Method               *Method          `json:"method,omitempty"`
+	Signed               hexutil.Bytes    `json:"signed,omitempty"`
+	Hash                 *common.Hash     `json:"hash,omitempty"`
+}
+
+// Method describes the contract method called by the transaction to the person signing it.
+type Method struct {
+	Contract  string `json:"contract,omitempty"`
+	Signature string `json:"signature"`
+	Args      []Arg  `json:"args"`
+}
+
+// Arg is an argument of the called method, with its value formatted.
+type Arg struct {
+	Name  string `json:"name,omitempty"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// NewBundle returns the empty bundle of the chain.
+func NewBundle(chainID *big.Int) *Bundle {
+	return &Bundle{
+		Version: BundleVersion,
+		ChainID: (*hexutil.Big)(chainID),
+	}
+}
+
+// ReadBundle reads the bundle from the JSON file.
+func ReadBundle(path string) (*Bundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBundle(data)
+}
+
+// ParseBundle parses the JSON bundle.
+func ParseBundle(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		err = fmt.Errorf("failed to parse bundle: %v", err)
+		return nil, err
+	}
+	if b.Version != BundleVersion {
+		err := fmt.Errorf("unsupported bundle version %d", b.Version)
+		return nil, err
+	} else if b.ChainID == nil {
+		err := errors.New("bundle has no chain ID")
+		return nil, err
+	}
+	for i, tx := range b.Transactions {
+		if tx == nil {
+			err := fmt.Errorf("transaction %d is empty", i)
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+// Write saves the bundle to the JSON file.
+func (b *Bundle) Write(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Unsigned returns the transaction as prepared, before signing.
+func (tx *Tx) Unsigned(chainID *big.Int) (*types.Transaction, error) {
+	b, err := tx.builder(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return b.Build()
+}
+
+func (tx *Tx) builder(chainID *big.Int) (*ethfw.TxBuilder, error) {
+	b := ethfw.NewTxBuilder(tx.From).
+		ChainID(chainID).
+		Nonce(uint64(tx.Nonce)).
+		Value(tx.Value.ToInt()).
+		Gas(uint64(tx.Gas)).
+		Data(tx.Data)
+	switch uint8(tx.Type) {
+	case types.LegacyTxType, types.AccessListTxType:
+		b.GasPrice(tx.GasPrice.ToInt())
+	case types.DynamicFeeTxType:
+		b.FeeCaps(tx.MaxPriorityFeePerGas.ToInt(), tx.MaxFeePerGas.ToInt())
+	default:
+		err := fmt.Errorf("unsupported transaction type %d", tx.Type)
+		return nil, err
+	}
+	b.Type(uint8(tx.Type))
+	if tx.To != nil {
+		b.To(*tx.To)
+	}
+	if tx.AccessList != nil {
+		b.AccessList(tx.AccessList)
+	}
+	return b, nil
+}
+
+// String describes the transaction in a line, e.g.
+// "#3 0x.. -> Token.transfer(0x.., 100) value 0 gas 52000".
+func (tx *Tx) String() string {
+	to := "new contract"
+	if tx.To != nil {
+		to = tx.To.Hex()
+	}
+	if m := tx.Method; m != nil {
+		args := make([]string, len(m.Args))
+		for i, arg := range m.Args {
+			args[i] = arg.Value
+		}
+		name := m.Signature
+		if i := strings.Index(name, "("); i >= 0 {
+			name = name[:i]
+		}
+		if len(m.Contract) > 0 {
+			name = m.Contract + "." + name
+		}
+		to = fmt.Sprintf("%s %s(%s)", to, name, strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("#%d %s -> %s value %s gas %d", tx.Nonce, tx.From.Hex(), to, tx.Value.ToInt(), tx.Gas)
+}
+
+// DescribeMethod describes the method of the contract called with the data.
+func DescribeMethod(contractABI abi.ABI, data []byte) (*Method, error) {
+	if len(data) < 4 {
+		err := errors.New("call data is too short")
+		return nil, err
+	}
+	m, err := contractABI.MethodById(data[:4])
+	if err != nil {
+		return nil, err
+	}
+	values, err := m.Inputs.Unpack(data[4:])
+	if err != nil {
+		err = fmt.Errorf("failed to unpack %s params: %v", m.Name, err)
+		return nil, err
+	}
+	method := &Method{
+		Signature: m.Sig,
+		Args:      make([]Arg, len(values)),
+	}
+	for i, v := range values {
+		method.Args[i] = Arg{
+			Name:  m.Inputs[i].Name,
+			Type:  m.Inputs[i].Type.String(),
+			Value: FormatValue(v),
+		}
+	}
+	return method, nil
+}
+
+// Verify checks that the description matches the call data: the method selector is the one
+// of the signature, the arguments decode to the values shown. The tuple arguments can't be
+// verified without the ABI.
+func (m *Method) Verify(data []byte) error {
+	selector := crypto.Keccak256([]byte(m.Signature))[:4]
+	if len(data) < 4 || !bytes.Equal(data[:4], selector) {
+		err := fmt.Errorf("call data does not call %s", m.Signature)
+		return err
+	}
+	types := make([]string, len(m.Args))
+	for i, arg := range m.Args {
+		types[i] = arg.Type
+	}
+	if name := strings.SplitN(m.Signature, "(", 2)[0]; m.Signature != name+"("+strings.Join(types, ",")+")" {
+		err := fmt.Errorf("arguments do not match %s", m.Signature)
+		return err
+	}
+	args := make(abi.Arguments, len(m.Args))
+	for i, arg := range m.Args {
+		t, err := abi.NewType(arg.Type, "", nil)
+		if err != nil {
+			err = fmt.Errorf("argument %d of %s: %v", i, m.Signature, err)
+			return err
+		}
+		args[i] = abi.Argument{Name: arg.Name, Type: t}
+	}
+	values, err := args.Unpack(data[4:])
+	if err != nil {
+		err = fmt.Errorf("failed to unpack %s params: %v", m.Signature, err)
+		return err
+	}
+	for i, v := range values {
+		if value := FormatValue(v); value != m.Args[i].Value {
+			err := fmt.Errorf("argument %d of %s is %s, not %s", i, m.Signature, value, m.Args[i].Value)
+			return err
+		}
+	}
+	return nil
+}
+
+// FormatValue formats the decoded ABI value: the addresses as checksummed hex,
+// the byte arrays as hex, the numbers in decimal.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case common.Address:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	case *big.Int:
+		return v.String()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(data), rv)
+			return hexutil.Encode(data)
+		}
+		fallthrough
+	case reflect.Slice:
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = FormatValue(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Struct:
+		fields := make([]string, rv.NumField())
+		for i := range fields {
+			fields[i] = FormatValue(rv.Field(i).Interface())
+		}
+		return "(" + strings.Join(fields, ", ") + ")"
+	}
+	return fmt.Sprint(v)
+}
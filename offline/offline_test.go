@@ -0,0 +1,130 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package offline
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/ethfwtest"
+	"github.com/AtlantPlatform/ethfw/gasmeter"
+)
+
+const tokenABI = `[
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable"}
+]`
+
+type fixedGasStation struct {
+	price *ethfw.Wei
+}
+
+func (g fixedGasStation) Estimate(priority gasmeter.GasPriority) (*ethfw.Wei, time.Duration) {
+	return g.price, time.Minute
+}
+
+func TestOffline(t *testing.T) {
+	require := require.New(t)
+	chain := ethfwtest.NewChain(ethfwtest.ChainOptions{Accounts: 2})
+	defer chain.Close()
+	ctx := context.Background()
+	from := chain.Accounts[0].Address
+	to := chain.Accounts[1].Address
+	parsed, err := abi.JSON(strings.NewReader(tokenABI))
+	require.NoError(err)
+
+	// the online machine has no keys
+	p := NewPreparer(chain, nil, nil)
+	bundle, err := p.Prepare(ctx, &Request{
+		From:  from,
+		To:    &to,
+		Value: big.NewInt(1e9),
+	}, &Request{
+		From:     from,
+		To:       &to,
+		ABI:      &parsed,
+		Contract: "Token",
+		Method:   "transfer",
+		Params:   []interface{}{to, big.NewInt(100)},
+		Gas:      50000,
+	})
+	require.NoError(err)
+	require.Len(bundle.Transactions, 2)
+	require.Equal(uint64(0), uint64(bundle.Transactions[0].Nonce))
+	require.Equal(uint64(1), uint64(bundle.Transactions[1].Nonce))
+	require.Equal(uint64(2), p.Nonces.Get(from))
+	require.NotNil(bundle.Transactions[0].MaxFeePerGas)
+	require.Equal("#1 "+from.Hex()+" -> "+to.Hex()+" Token.transfer("+to.Hex()+", 100) value 0 gas 50000",
+		bundle.Transactions[1].String())
+
+	// the gas station price makes a legacy transaction
+	p.GasStation = fixedGasStation{price: ethfw.Gwei(10)}
+	require.NoError(p.Append(ctx, bundle, &Request{From: from, To: &to}))
+	require.Len(bundle.Transactions, 3)
+	require.Equal(uint64(2), uint64(bundle.Transactions[2].Nonce))
+	require.Equal(big.NewInt(10e9), bundle.Transactions[2].GasPrice.ToInt())
+	require.Nil(bundle.Transactions[2].MaxFeePerGas)
+
+	_, err = Broadcast(ctx, chain, bundle)
+	require.EqualError(err, "transaction 0 is not signed")
+
+	// the bundle is carried to the offline machine as JSON
+	data, err := json.Marshal(bundle)
+	require.NoError(err)
+	offline, err := ParseBundle(data)
+	require.NoError(err)
+	reencoded, err := json.Marshal(offline)
+	require.NoError(err)
+	require.JSONEq(string(data), string(reencoded))
+	require.Equal([]common.Address{from}, offline.Signers())
+
+	keys := ethfw.NewKeyCache()
+	require.Error(Sign(ctx, offline, keys, ""))
+	keys.SetPrivateKey(from, chain.Accounts[0].Key)
+
+	forged := *offline.Transactions[1].Method
+	forged.Args = []Arg{forged.Args[0], {Name: "value", Type: "uint256", Value: "1"}}
+	offline.Transactions[1].Method = &forged
+	require.EqualError(Sign(ctx, offline, keys, ""), "transaction 1: argument 1 of transfer(address,uint256) is 100, not 1")
+	offline.Transactions[1].Method = bundle.Transactions[1].Method
+	require.NoError(Sign(ctx, offline, keys, ""))
+	require.NoError(Validate(offline))
+
+	data, err = json.Marshal(offline)
+	require.NoError(err)
+	signed, err := ParseBundle(data)
+	require.NoError(err)
+
+	// the tampered fields don't match the signed transaction
+	signed.Transactions[0].Value = (*hexutil.Big)(big.NewInt(2e9))
+	require.EqualError(Validate(signed), "transaction 0 differs from the prepared one")
+	signed.Transactions[0].Value = offline.Transactions[0].Value
+	signed.Transactions[0].From = to
+	require.EqualError(Validate(signed), "transaction 0 is signed by "+from.Hex()+", not "+to.Hex())
+	signed.Transactions[0].From = from
+
+	hashes, err := Broadcast(ctx, chain, signed)
+	require.NoError(err)
+	require.Len(hashes, 3)
+	chain.Commit()
+	for i, hash := range hashes {
+		require.Equal(*signed.Transactions[i].Hash, hash)
+		receipt, err := chain.TransactionReceipt(ctx, hash)
+		require.NoError(err)
+		require.Equal(uint64(1), receipt.Status)
+	}
+	nonce, err := chain.NonceAt(ctx, from, nil)
+	require.NoError(err)
+	require.Equal(uint64(3), nonce)
+}
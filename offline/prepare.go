@@ -0,0 +1,193 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package offline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/AtlantPlatform/ethfw"
+	"github.com/AtlantPlatform/ethfw/gasmeter"
+)
+
+// Request is a transaction to prepare. The call data is either Data, or packed from Method
+// and Params of the ABI, which also describes the call in the bundle.
+type Request struct {
+	From  common.Address
+	To    *common.Address
+	Value *big.Int
+	Data  []byte
+
+	ABI      *abi.ABI
+	Contract string
+	Method   string
+	Params   []interface{}
+
+	// Gas is estimated if not set.
+	Gas        uint64
+	AccessList types.AccessList
+}
+
+// Preparer prepares the bundles on the online machine. The nonces are taken from Nonces,
+// so the transactions sent meanwhile by the same process don't collide with the bundle.
+// The gas price is estimated by GasStation if set, otherwise suggested by the node.
+type Preparer struct {
+	Client      ethfw.TxBackend
+	Nonces      ethfw.NonceCache
+	GasStation  gasmeter.GasStation
+	GasPriority gasmeter.GasPriority
+}
+
+// NewPreparer returns the preparer of the client's transactions.
+func NewPreparer(client ethfw.TxBackend, nonces ethfw.NonceCache, gasStation gasmeter.GasStation) *Preparer {
+	if nonces == nil {
+		nonces = ethfw.NewNonceCache()
+	}
+	return &Preparer{
+		Client:      client,
+		Nonces:      nonces,
+		GasStation:  gasStation,
+		GasPriority: gasmeter.GasPriorityFast,
+	}
+}
+
+// Prepare returns the new bundle of the requested transactions.
+func (p *Preparer) Prepare(ctx context.Context, reqs ...*Request) (*Bundle, error) {
+	chainID, err := p.Client.ChainID(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get chain ID: %v", err)
+		return nil, err
+	}
+	bundle := NewBundle(chainID)
+	if err := p.Append(ctx, bundle, reqs...); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// Append prepares the requested transactions and adds them to the bundle, the nonces
+// continue the ones of the same account in the bundle.
+func (p *Preparer) Append(ctx context.Context, bundle *Bundle, reqs ...*Request) error {
+	chainID, err := p.Client.ChainID(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get chain ID: %v", err)
+		return err
+	} else if chainID.Cmp(bundle.ChainID.ToInt()) != 0 {
+		err = fmt.Errorf("bundle is for chain %s, the node is on chain %s", bundle.ChainID.ToInt(), chainID)
+		return err
+	}
+	for i, req := range reqs {
+		tx, err := p.prepare(ctx, bundle, req)
+		if err != nil {
+			err = fmt.Errorf("failed to prepare transaction %d: %v", i, err)
+			return err
+		}
+		bundle.Transactions = append(bundle.Transactions, tx)
+	}
+	return nil
+}
+
+func (p *Preparer) prepare(ctx context.Context, bundle *Bundle, req *Request) (*Tx, error) {
+	data := req.Data
+	var method *Method
+	if req.ABI != nil {
+		if len(req.Method) > 0 {
+			packed, err := req.ABI.Pack(req.Method, req.Params...)
+			if err != nil {
+				err = fmt.Errorf("failed to pack %s params: %v", req.Method, err)
+				return nil, err
+			}
+			data = packed
+		}
+		if len(data) > 0 {
+			m, err := DescribeMethod(*req.ABI, data)
+			if err != nil {
+				return nil, err
+			}
+			m.Contract = req.Contract
+			method = m
+		}
+	} else if len(req.Method) > 0 {
+		err := errors.New("method call requires the ABI")
+		return nil, err
+	}
+	value := req.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	b := ethfw.NewTxBuilder(req.From).
+		ChainID(bundle.ChainID.ToInt()).
+		Value(value).
+		Data(data).
+		Gas(req.Gas)
+	if req.To != nil {
+		b.To(*req.To)
+	}
+	if req.AccessList != nil {
+		b.AccessList(req.AccessList)
+	}
+	if p.GasStation != nil {
+		priority := p.GasPriority
+		if len(priority) == 0 {
+			priority = gasmeter.GasPriorityFast
+		}
+		if price, _ := p.GasStation.Estimate(priority); price.ToInt().Sign() > 0 {
+			b.GasPrice(price.ToInt())
+		}
+	}
+
+	var unsigned *types.Transaction
+	err := p.Nonces.SerializeContext(ctx, req.From, func(ctx context.Context) error {
+		nonce, err := p.Client.PendingNonceAt(ctx, req.From)
+		if err != nil {
+			err = fmt.Errorf("failed to retrieve account nonce: %v", err)
+			return err
+		}
+		if cached := p.Nonces.Get(req.From); cached > nonce {
+			nonce = cached
+		}
+		for _, tx := range bundle.Transactions {
+			if tx.From == req.From && uint64(tx.Nonce) >= nonce {
+				nonce = uint64(tx.Nonce) + 1
+			}
+		}
+		if err := b.Nonce(nonce).Fill(ctx, p.Client); err != nil {
+			return err
+		}
+		if unsigned, err = b.Build(); err != nil {
+			return err
+		}
+		p.Nonces.Set(req.From, nonce+1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	tx := &Tx{
+		Type:       hexutil.Uint64(unsigned.Type()),
+		From:       req.From,
+		To:         unsigned.To(),
+		Nonce:      hexutil.Uint64(unsigned.Nonce()),
+		Value:      (*hexutil.Big)(unsigned.Value()),
+		Gas:        hexutil.Uint64(unsigned.Gas()),
+		AccessList: req.AccessList,
+		Data:       unsigned.Data(),
+		Method:     method,
+	}
+	if unsigned.Type() == types.DynamicFeeTxType {
+		tx.MaxPriorityFeePerGas = (*hexutil.Big)(unsigned.GasTipCap())
+		tx.MaxFeePerGas = (*hexutil.Big)(unsigned.GasFeeCap())
+	} else {
+		tx.GasPrice = (*hexutil.Big)(unsigned.GasPrice())
+	}
+	return tx, nil
+}
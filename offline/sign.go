@@ -0,0 +1,63 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package offline
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/AtlantPlatform/ethfw"
+)
+
+// Sign signs the transactions of the bundle on the offline machine, with the keys of their
+// accounts. The method descriptions are checked against the call data first, so the person
+// signing sees what is signed.
+func Sign(ctx context.Context, bundle *Bundle, keys ethfw.KeyCache, password string) error {
+	for i, tx := range bundle.Transactions {
+		if tx.Method != nil {
+			if err := tx.Method.Verify(tx.Data); err != nil {
+				err = fmt.Errorf("transaction %d: %v", i, err)
+				return err
+			}
+		}
+		if _, err := keys.PrivateKeyContext(ctx, tx.From, password); err != nil {
+			err = fmt.Errorf("transaction %d: key of %s: %v", i, tx.From.Hex(), err)
+			return err
+		}
+		b, err := tx.builder(bundle.ChainID.ToInt())
+		if err != nil {
+			err = fmt.Errorf("transaction %d: %v", i, err)
+			return err
+		}
+		signed, err := b.Sign(keys, password)
+		if err != nil {
+			err = fmt.Errorf("transaction %d: %v", i, err)
+			return err
+		}
+		raw, err := ethfw.RawTx(signed)
+		if err != nil {
+			return err
+		}
+		hash := signed.Hash()
+		tx.Signed = raw
+		tx.Hash = &hash
+	}
+	return nil
+}
+
+// Signers returns the accounts signing the bundle.
+func (b *Bundle) Signers() []common.Address {
+	var accounts []common.Address
+	seen := make(map[common.Address]bool)
+	for _, tx := range b.Transactions {
+		if !seen[tx.From] {
+			seen[tx.From] = true
+			accounts = append(accounts, tx.From)
+		}
+	}
+	return accounts
+}
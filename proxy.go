@@ -0,0 +1,230 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+var (
+	// ImplementationSlot is the ERC-1967 storage slot of the proxy implementation address.
+	ImplementationSlot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	// AdminSlot is the ERC-1967 storage slot of the proxy admin address.
+	AdminSlot = common.HexToHash("0xb53127684a568b3173ae13b9f8a6016e243e63b6e8ee1178d6a717850b5d6103")
+)
+
+const proxyUpgradeABI = `[
+	{"type":"function","name":"upgradeToAndCall","stateMutability":"payable","outputs":[],
+	 "inputs":[{"name":"newImplementation","type":"address"},{"name":"data","type":"bytes"}]},
+	{"type":"function","name":"upgradeAndCall","stateMutability":"payable","outputs":[],
+	 "inputs":[{"name":"proxy","type":"address"},{"name":"implementation","type":"address"},{"name":"data","type":"bytes"}]}
+]`
+
+var proxyUpgradeABIParsed abi.ABI
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(proxyUpgradeABI))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse proxy upgrade ABI: %v", err))
+	}
+	proxyUpgradeABIParsed = parsed
+}
+
+// ProxyImplementation reads the implementation address of the ERC-1967 proxy.
+func ProxyImplementation(ctx context.Context, client ethereum.ChainStateReader, proxy common.Address) (common.Address, error) {
+	return readAddressSlot(ctx, client, proxy, ImplementationSlot)
+}
+
+// ProxyAdmin reads the admin address of the ERC-1967 proxy, which is zero for UUPS proxies.
+func ProxyAdmin(ctx context.Context, client ethereum.ChainStateReader, proxy common.Address) (common.Address, error) {
+	return readAddressSlot(ctx, client, proxy, AdminSlot)
+}
+
+func readAddressSlot(ctx context.Context, client ethereum.ChainStateReader,
+	contract common.Address, slot common.Hash) (common.Address, error) {
+
+	value, err := client.StorageAt(ctx, contract, slot, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to read slot %s of %s: %v", slot.Hex(), contract.Hex(), err)
+		return common.Address{}, err
+	}
+	return common.BytesToAddress(value), nil
+}
+
+// BindProxy binds the implementation contract to the proxy address.
+func BindProxy(client Backend, proxy common.Address, impl *sol.Contract) (*BoundContract, error) {
+	src := *impl
+	src.Address = proxy
+	return BindContract(client, &src)
+}
+
+// ProxyOptions describe the proxy deployed by DeployProxy.
+type ProxyOptions struct {
+	// Proxy is the proxy contract, ERC1967Proxy(logic, data) for UUPS proxies or
+	// TransparentUpgradeableProxy(logic, admin, data), told apart by the constructor.
+	Proxy *sol.Contract
+	// Admin is the admin of the transparent proxy.
+	Admin common.Address
+	// Initializer is the implementation method called by the proxy constructor, if set.
+	Initializer string
+	// Params are the initializer arguments.
+	Params []interface{}
+}
+
+// DeployProxy deploys the contract as an implementation, waits for it to be mined, then
+// deploys the proxy to it, calling the initializer. The contract is bound to the proxy
+// address, the proxy deployment is not waited for.
+func (c *BoundContract) DeployProxy(opts *bind.TransactOpts,
+	popts ProxyOptions) (proxy, impl common.Address, tx *types.Transaction, err error) {
+
+	if popts.Proxy == nil {
+		err = fmt.Errorf("no proxy contract to deploy %s", c.src.Name)
+		return
+	}
+	var data []byte
+	if len(popts.Initializer) > 0 {
+		if data, err = c.abi.Pack(popts.Initializer, popts.Params...); err != nil {
+			err = fmt.Errorf("failed to pack initializer %s: %v", popts.Initializer, err)
+			return
+		}
+	}
+	proxyContract, err := BindContract(c.client, popts.Proxy)
+	if err != nil {
+		return
+	}
+	proxyContract.SetTransact(c.transactFn)
+	var params []interface{}
+	switch len(proxyContract.abi.Constructor.Inputs) {
+	case 2:
+	case 3:
+		params = []interface{}{popts.Admin}
+	default:
+		err = fmt.Errorf("unsupported proxy %s constructor", popts.Proxy.Name)
+		return
+	}
+
+	proxyOpts := *opts
+	implContract, err := BindContract(c.client, c.src)
+	if err != nil {
+		return
+	}
+	implContract.SetTransact(c.transactFn)
+	if impl, err = deployAndWait(implContract, &proxyOpts); err != nil {
+		err = fmt.Errorf("failed to deploy implementation %s: %v", c.src.Name, err)
+		return
+	}
+	params = append([]interface{}{impl}, append(params, data)...)
+	if proxy, tx, err = proxyContract.DeployContract(&proxyOpts, params...); err != nil {
+		err = fmt.Errorf("failed to deploy proxy %s: %v", popts.Proxy.Name, err)
+		return
+	}
+	c.SetAddress(proxy)
+	return proxy, impl, tx, nil
+}
+
+// UpgradeOptions describe the upgrade made by UpgradeProxy.
+type UpgradeOptions struct {
+	// ProxyAdmin is the ProxyAdmin contract of the transparent proxy, the upgrade is sent
+	// to the proxy itself if it's not set.
+	ProxyAdmin common.Address
+	// Call is the new implementation method called after the upgrade, if set.
+	Call string
+	// Params are the call arguments.
+	Params []interface{}
+	// SkipLayoutCheck disables the storage layout check, which is made when both
+	// implementations have their storage layouts.
+	SkipLayoutCheck bool
+}
+
+// UpgradeProxy checks that the new implementation keeps the storage layout, deploys it,
+// waits for it to be mined and sends the upgrade to the proxy the contract is bound to,
+// with upgradeToAndCall, or to the ProxyAdmin, with upgradeAndCall. The contract gets
+// rebound with the new implementation ABI, the upgrade is not waited for.
+func (c *BoundContract) UpgradeProxy(opts *bind.TransactOpts, impl *sol.Contract,
+	uopts UpgradeOptions) (common.Address, *types.Transaction, error) {
+
+	if !uopts.SkipLayoutCheck && len(c.src.StorageLayout) > 0 && len(impl.StorageLayout) > 0 {
+		if err := sol.CheckStorageLayout(c.src, impl); err != nil {
+			return common.Address{}, nil, err
+		}
+	}
+	proxy := c.address
+	next, err := BindProxy(c.client, proxy, impl)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	var data []byte
+	if len(uopts.Call) > 0 {
+		if data, err = next.abi.Pack(uopts.Call, uopts.Params...); err != nil {
+			err = fmt.Errorf("failed to pack call %s: %v", uopts.Call, err)
+			return common.Address{}, nil, err
+		}
+	}
+
+	upgradeOpts := *opts
+	implContract, err := BindContract(c.client, impl)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	implContract.SetTransact(c.transactFn)
+	implAddr, err := deployAndWait(implContract, &upgradeOpts)
+	if err != nil {
+		err = fmt.Errorf("failed to deploy implementation %s: %v", impl.Name, err)
+		return common.Address{}, nil, err
+	}
+	upgrader := &BoundContract{
+		transactFn:  c.transactFn,
+		accessLists: c.accessLists,
+		client:      c.client,
+		abi:         proxyUpgradeABIParsed,
+	}
+	var tx *types.Transaction
+	if uopts.ProxyAdmin == (common.Address{}) {
+		upgrader.SetAddress(proxy)
+		tx, err = upgrader.Transact(&upgradeOpts, "upgradeToAndCall", implAddr, data)
+	} else {
+		upgrader.SetAddress(uopts.ProxyAdmin)
+		tx, err = upgrader.Transact(&upgradeOpts, "upgradeAndCall", proxy, implAddr, data)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to upgrade proxy %s: %v", proxy.Hex(), err)
+		return common.Address{}, nil, err
+	}
+	c.src = next.src
+	c.abi = next.abi
+	c.SetAddress(proxy)
+	return implAddr, tx, nil
+}
+
+// deployAndWait deploys the contract and waits for it to be mined, advancing
+// the nonce of opts if it's set.
+func deployAndWait(contract *BoundContract, opts *bind.TransactOpts) (common.Address, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	addr, tx, err := contract.DeployContract(opts)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if _, err := bind.WaitDeployed(ctx, contract.client, tx); err != nil {
+		return common.Address{}, err
+	}
+	if opts.Nonce != nil {
+		opts.Nonce = new(big.Int).SetUint64(tx.Nonce() + 1)
+	}
+	return addr, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+	"github.com/AtlantPlatform/ethfw/sol"
+)
+
+const boxLayout = `{"storage":[
+	{"contract":"Box.sol:Box","label":"value","offset":0,"slot":"0","type":"%s"}%s
+],"types":{
+	"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+	"t_int256":{"encoding":"inplace","label":"int256","numberOfBytes":"32"}
+}}`
+
+func boxContract(name, bin, valueType, storage string) *sol.Contract {
+	return &sol.Contract{
+		Name: name,
+		ABI: []byte(`[
+			{"type":"function","name":"initialize","inputs":[{"name":"value","type":"uint256"}],"outputs":[]},
+			{"type":"function","name":"migrate","inputs":[],"outputs":[]}
+		]`),
+		Bin:           bin,
+		StorageLayout: []byte(fmt.Sprintf(boxLayout, valueType, storage)),
+	}
+}
+
+func TestDeployProxy(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	opts := bind.NewKeyedTransactor(key)
+	ctx := context.Background()
+
+	box, err := BindContract(client, boxContract("Box", "6001", "t_uint256", ""))
+	require.NoError(err)
+	erc1967 := &sol.Contract{
+		Name: "ERC1967Proxy",
+		ABI:  []byte(`[{"type":"constructor","inputs":[{"name":"logic","type":"address"},{"name":"data","type":"bytes"}]}]`),
+		Bin:  "6002",
+	}
+	proxy, impl, tx, err := box.DeployProxy(opts, ProxyOptions{
+		Proxy:       erc1967,
+		Initializer: "initialize",
+		Params:      []interface{}{big.NewInt(42)},
+	})
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	require.Equal(crypto.CreateAddress(from, 0), impl)
+	require.Equal(crypto.CreateAddress(from, 1), proxy)
+	require.Equal(proxy, box.Address())
+	initData, err := box.ABI().Pack("initialize", big.NewInt(42))
+	require.NoError(err)
+	proxyABI, err := BindContract(client, erc1967)
+	require.NoError(err)
+	args, err := proxyABI.ABI().Pack("", impl, initData)
+	require.NoError(err)
+	require.Equal(append(common.FromHex("6002"), args...), tx.Data())
+
+	node.SetStorage(proxy, ImplementationSlot, common.BytesToHash(impl.Bytes()))
+	addr, err := ProxyImplementation(ctx, client, proxy)
+	require.NoError(err)
+	require.Equal(impl, addr)
+	addr, err = ProxyAdmin(ctx, client, proxy)
+	require.NoError(err)
+	require.Equal(common.Address{}, addr)
+
+	// the value changing its type is caught before anything is sent
+	_, _, err = box.UpgradeProxy(opts, boxContract("BoxV2", "6003", "t_int256", ""), UpgradeOptions{})
+	require.EqualError(err, "sol: storage layout of BoxV2 is incompatible: value changed type from uint256 to int256")
+	require.Len(node.Transactions(), 2)
+
+	v2 := boxContract("BoxV2", "6003", "t_uint256", `,
+		{"contract":"Box.sol:Box","label":"limit","offset":0,"slot":"1","type":"t_uint256"}`)
+	newImpl, tx, err := box.UpgradeProxy(opts, v2, UpgradeOptions{Call: "migrate"})
+	require.NoError(err)
+	require.Equal(crypto.CreateAddress(from, 2), newImpl)
+	require.Equal(proxy, *tx.To())
+	migrate, err := box.ABI().Pack("migrate")
+	require.NoError(err)
+	upgrade, err := proxyUpgradeABIParsed.Pack("upgradeToAndCall", newImpl, migrate)
+	require.NoError(err)
+	require.Equal(upgrade, tx.Data())
+	require.Equal("BoxV2", box.Source().Name)
+	require.Equal(proxy, box.Address())
+	require.Len(node.Transactions(), 4)
+
+	bound, err := BindProxy(client, proxy, v2)
+	require.NoError(err)
+	require.Equal(proxy, bound.Address())
+	require.Equal(common.Address{}, v2.Address)
+}
+
+func TestDeployTransparentProxy(t *testing.T) {
+	require := require.New(t)
+	node := rpctest.NewMockNode()
+	node.SetAutoMine(true)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	opts := bind.NewKeyedTransactor(key)
+
+	box, err := BindContract(client, boxContract("Box", "6001", "t_uint256", ""))
+	require.NoError(err)
+	admin := common.HexToAddress("0xad")
+	node.SetCode(admin, []byte{0x60})
+	transparent := &sol.Contract{
+		Name: "TransparentUpgradeableProxy",
+		ABI: []byte(`[{"type":"constructor","inputs":[
+			{"name":"logic","type":"address"},{"name":"admin","type":"address"},{"name":"data","type":"bytes"}
+		]}]`),
+		Bin: "6002",
+	}
+	proxy, impl, tx, err := box.DeployProxy(opts, ProxyOptions{
+		Proxy: transparent,
+		Admin: admin,
+	})
+	require.NoError(err)
+	proxyABI, err := BindContract(client, transparent)
+	require.NoError(err)
+	args, err := proxyABI.ABI().Pack("", impl, admin, []byte{})
+	require.NoError(err)
+	require.Equal(append(common.FromHex("6002"), args...), tx.Data())
+
+	newImpl, tx, err := box.UpgradeProxy(opts, boxContract("BoxV2", "6003", "t_uint256", ""), UpgradeOptions{
+		ProxyAdmin: admin,
+	})
+	require.NoError(err)
+	require.Equal(admin, *tx.To())
+	upgrade, err := proxyUpgradeABIParsed.Pack("upgradeAndCall", proxy, newImpl, []byte{})
+	require.NoError(err)
+	require.Equal(upgrade, tx.Data())
+}
@@ -0,0 +1,528 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ArtifactFormat is the format of the artifacts written by WriteArtifact.
+const ArtifactFormat = "ethfw-artifact-1"
+
+// artifactJSON is a superset of the Hardhat artifact, which is also the format of
+// the artifacts written by this package.
+type artifactJSON struct {
+	Format                 string                                `json:"_format"`
+	ContractName           string                                `json:"contractName"`
+	SourceName             string                                `json:"sourceName"`
+	CompilerVersion        string                                `json:"compilerVersion,omitempty"`
+	ABI                    json.RawMessage                       `json:"abi"`
+	Bytecode               string                                `json:"bytecode"`
+	DeployedBytecode       string                                `json:"deployedBytecode"`
+	LinkReferences         map[string]map[string][]LinkReference `json:"linkReferences"`
+	DeployedLinkReferences map[string]map[string][]LinkReference `json:"deployedLinkReferences"`
+	MethodIdentifiers      map[string]string                     `json:"methodIdentifiers,omitempty"`
+	SourceMap              string                                `json:"sourceMap,omitempty"`
+	DeployedSourceMap      string                                `json:"deployedSourceMap,omitempty"`
+	Metadata               string                                `json:"metadata,omitempty"`
+	StorageLayout          json.RawMessage                       `json:"storageLayout,omitempty"`
+	DevDoc                 json.RawMessage                       `json:"devdoc,omitempty"`
+	UserDoc                json.RawMessage                       `json:"userdoc,omitempty"`
+	Address                *common.Address                       `json:"address,omitempty"`
+	Networks               map[string]artifactNetwork            `json:"networks,omitempty"`
+}
+
+type artifactNetwork struct {
+	Address common.Address `json:"address"`
+}
+
+type truffleArtifact struct {
+	ContractName      string          `json:"contractName"`
+	ABI               json.RawMessage `json:"abi"`
+	Metadata          string          `json:"metadata"`
+	Bytecode          string          `json:"bytecode"`
+	DeployedBytecode  string          `json:"deployedBytecode"`
+	SourceMap         string          `json:"sourceMap"`
+	DeployedSourceMap string          `json:"deployedSourceMap"`
+	SourcePath        string          `json:"sourcePath"`
+	Compiler          struct {
+		Version string `json:"version"`
+	} `json:"compiler"`
+	Networks map[string]struct {
+		Address string `json:"address"`
+	} `json:"networks"`
+	DevDoc  json.RawMessage `json:"devdoc"`
+	UserDoc json.RawMessage `json:"userdoc"`
+}
+
+type foundryArtifact struct {
+	ABI               json.RawMessage   `json:"abi"`
+	Bytecode          standardBytecode  `json:"bytecode"`
+	DeployedBytecode  standardBytecode  `json:"deployedBytecode"`
+	MethodIdentifiers map[string]string `json:"methodIdentifiers"`
+	RawMetadata       string            `json:"rawMetadata"`
+	Metadata          struct {
+		Compiler struct {
+			Version string `json:"version"`
+		} `json:"compiler"`
+		Settings struct {
+			CompilationTarget map[string]string `json:"compilationTarget"`
+		} `json:"settings"`
+	} `json:"metadata"`
+	StorageLayout json.RawMessage `json:"storageLayout"`
+	DevDoc        json.RawMessage `json:"devdoc"`
+	UserDoc       json.RawMessage `json:"userdoc"`
+	AST           struct {
+		AbsolutePath string `json:"absolutePath"`
+	} `json:"ast"`
+}
+
+// LoadArtifact reads a contract artifact, detecting its format: Hardhat, Truffle,
+// Foundry or the one written by WriteArtifact.
+func LoadArtifact(path string) (*Contract, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("sol: failed to read artifact: %v", err)
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		err = fmt.Errorf("sol: failed to parse artifact %s: %v", path, err)
+		return nil, err
+	}
+	switch {
+	case fields["_format"] != nil:
+		return parseArtifact(path, data)
+	case bytes.HasPrefix(bytes.TrimSpace(fields["bytecode"]), []byte("{")):
+		return parseFoundryArtifact(path, data)
+	case fields["contractName"] != nil:
+		return parseTruffleArtifact(path, data)
+	}
+	err = fmt.Errorf("sol: unknown format of artifact %s", path)
+	return nil, err
+}
+
+// LoadHardhatArtifact reads a Hardhat artifact, like artifacts/contracts/Token.sol/Token.json,
+// or an artifact written by WriteArtifact.
+func LoadHardhatArtifact(path string) (*Contract, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("sol: failed to read artifact: %v", err)
+		return nil, err
+	}
+	return parseArtifact(path, data)
+}
+
+func parseArtifact(path string, data []byte) (*Contract, error) {
+	var a artifactJSON
+	if err := json.Unmarshal(data, &a); err != nil {
+		err = fmt.Errorf("sol: failed to parse artifact %s: %v", path, err)
+		return nil, err
+	}
+	if a.Format != ArtifactFormat && !strings.HasPrefix(a.Format, "hh-sol-artifact") {
+		err := fmt.Errorf("sol: unsupported format %q of artifact %s", a.Format, path)
+		return nil, err
+	}
+	c := &Contract{
+		Name:            a.ContractName,
+		SourcePath:      a.SourceName,
+		CompilerVersion: a.CompilerVersion,
+
+		ABI: []byte(a.ABI),
+		Bin: trimHex(a.Bytecode),
+
+		RuntimeBin:            trimHex(a.DeployedBytecode),
+		Metadata:              a.Metadata,
+		LinkReferences:        flattenLinkReferences(a.LinkReferences),
+		RuntimeLinkReferences: flattenLinkReferences(a.DeployedLinkReferences),
+		MethodIdentifiers:     a.MethodIdentifiers,
+		SourceMap:             a.SourceMap,
+		RuntimeSourceMap:      a.DeployedSourceMap,
+		StorageLayout:         a.StorageLayout,
+		DevDoc:                a.DevDoc,
+		UserDoc:               a.UserDoc,
+	}
+	if a.Address != nil {
+		c.Address = *a.Address
+	}
+	for network, n := range a.Networks {
+		setAddress(c, network, n.Address)
+	}
+	return c, nil
+}
+
+// LoadTruffleArtifact reads a Truffle artifact, like build/contracts/Token.json,
+// including the addresses of the deployments to the networks.
+func LoadTruffleArtifact(path string) (*Contract, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("sol: failed to read artifact: %v", err)
+		return nil, err
+	}
+	return parseTruffleArtifact(path, data)
+}
+
+func parseTruffleArtifact(path string, data []byte) (*Contract, error) {
+	var a truffleArtifact
+	if err := json.Unmarshal(data, &a); err != nil {
+		err = fmt.Errorf("sol: failed to parse artifact %s: %v", path, err)
+		return nil, err
+	}
+	if len(a.ContractName) == 0 {
+		err := fmt.Errorf("sol: artifact %s has no contract name", path)
+		return nil, err
+	}
+	c := &Contract{
+		Name:            a.ContractName,
+		SourcePath:      a.SourcePath,
+		CompilerVersion: a.Compiler.Version,
+
+		ABI: []byte(a.ABI),
+		Bin: trimHex(a.Bytecode),
+
+		RuntimeBin:       trimHex(a.DeployedBytecode),
+		Metadata:         a.Metadata,
+		SourceMap:        a.SourceMap,
+		RuntimeSourceMap: a.DeployedSourceMap,
+		DevDoc:           a.DevDoc,
+		UserDoc:          a.UserDoc,
+	}
+	for network, n := range a.Networks {
+		if !common.IsHexAddress(n.Address) {
+			continue
+		}
+		setAddress(c, network, common.HexToAddress(n.Address))
+	}
+	return c, nil
+}
+
+// LoadFoundryArtifact reads a Foundry artifact, like out/Token.sol/Token.json. The contract
+// name is taken from the file name.
+func LoadFoundryArtifact(path string) (*Contract, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("sol: failed to read artifact: %v", err)
+		return nil, err
+	}
+	return parseFoundryArtifact(path, data)
+}
+
+func parseFoundryArtifact(path string, data []byte) (*Contract, error) {
+	var a foundryArtifact
+	if err := json.Unmarshal(data, &a); err != nil {
+		err = fmt.Errorf("sol: failed to parse artifact %s: %v", path, err)
+		return nil, err
+	}
+	base := filepath.Base(path)
+	c := &Contract{
+		Name:            strings.TrimSuffix(base, filepath.Ext(base)),
+		SourcePath:      a.AST.AbsolutePath,
+		CompilerVersion: a.Metadata.Compiler.Version,
+
+		ABI: []byte(a.ABI),
+		Bin: trimHex(a.Bytecode.Object),
+
+		RuntimeBin:            trimHex(a.DeployedBytecode.Object),
+		Metadata:              a.RawMetadata,
+		LinkReferences:        a.Bytecode.linkReferences(),
+		RuntimeLinkReferences: a.DeployedBytecode.linkReferences(),
+		MethodIdentifiers:     a.MethodIdentifiers,
+		SourceMap:             a.Bytecode.SourceMap,
+		RuntimeSourceMap:      a.DeployedBytecode.SourceMap,
+		StorageLayout:         a.StorageLayout,
+		DevDoc:                a.DevDoc,
+		UserDoc:               a.UserDoc,
+	}
+	for source, name := range a.Metadata.Settings.CompilationTarget {
+		c.SourcePath, c.Name = source, name
+	}
+	if len(c.SourcePath) == 0 {
+		// the output dir is named after the source file
+		c.SourcePath = filepath.Base(filepath.Dir(path))
+	}
+	return c, nil
+}
+
+// LoadHardhatArtifacts reads all the contract artifacts found in the Hardhat artifacts dir,
+// keyed by fully qualified names (path:Name). Debug files and build info are skipped.
+func LoadHardhatArtifacts(dir string) (map[string]*Contract, error) {
+	return loadArtifacts(dir, true, func(path string) (*Contract, bool, error) {
+		if strings.HasSuffix(path, ".dbg.json") {
+			return nil, false, nil
+		}
+		c, err := LoadHardhatArtifact(path)
+		return c, true, err
+	})
+}
+
+// LoadTruffleArtifacts reads all the contract artifacts found in the Truffle build dir,
+// like build/contracts, keyed by fully qualified names (path:Name).
+func LoadTruffleArtifacts(dir string) (map[string]*Contract, error) {
+	return loadArtifacts(dir, false, func(path string) (*Contract, bool, error) {
+		c, err := LoadTruffleArtifact(path)
+		return c, true, err
+	})
+}
+
+// LoadFoundryArtifacts reads all the contract artifacts found in the Foundry out dir,
+// keyed by fully qualified names (path:Name). Build info is skipped.
+func LoadFoundryArtifacts(dir string) (map[string]*Contract, error) {
+	return loadArtifacts(dir, true, func(path string) (*Contract, bool, error) {
+		c, err := LoadFoundryArtifact(path)
+		return c, true, err
+	})
+}
+
+func loadArtifacts(dir string, recursive bool,
+	load func(path string) (*Contract, bool, error)) (map[string]*Contract, error) {
+
+	contracts := make(map[string]*Contract)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && (!recursive || info.Name() == "build-info") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".json" {
+			return nil
+		}
+		c, ok, err := load(path)
+		if err != nil {
+			return err
+		} else if !ok {
+			return nil
+		}
+		contracts[c.SourcePath+":"+c.Name] = c
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("sol: failed to load artifacts: %v", err)
+		return nil, err
+	}
+	return contracts, nil
+}
+
+// LoadHardhatDeployments sets the addresses of the contracts deployed with hardhat-deploy,
+// found in the deployments dir. Addresses are keyed by the chain id of the network,
+// or by the network name if the id is unknown. Deployments are matched with the contracts
+// by names, skipping the names that are ambiguous or not found.
+func LoadHardhatDeployments(dir string, contracts map[string]*Contract) error {
+	networks, err := ioutil.ReadDir(dir)
+	if err != nil {
+		err = fmt.Errorf("sol: failed to read deployments: %v", err)
+		return err
+	}
+	for _, network := range networks {
+		if !network.IsDir() {
+			continue
+		}
+		networkDir := filepath.Join(dir, network.Name())
+		id := network.Name()
+		if chainID, err := ioutil.ReadFile(filepath.Join(networkDir, ".chainId")); err == nil {
+			id = strings.TrimSpace(string(chainID))
+		}
+		files, err := filepath.Glob(filepath.Join(networkDir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				err = fmt.Errorf("sol: failed to read deployment: %v", err)
+				return err
+			}
+			var deployment struct {
+				Address common.Address `json:"address"`
+			}
+			if err := json.Unmarshal(data, &deployment); err != nil {
+				err = fmt.Errorf("sol: failed to parse deployment %s: %v", file, err)
+				return err
+			}
+			base := filepath.Base(file)
+			if c := findContract(contracts, strings.TrimSuffix(base, ".json")); c != nil {
+				setAddress(c, id, deployment.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFoundryBroadcast sets the addresses of the contracts created by a Foundry script,
+// found in its broadcast log, like broadcast/Deploy.s.sol/1/run-latest.json. Addresses
+// are keyed by the chain id. Transactions are matched with the contracts by names, skipping
+// the names that are ambiguous or not found.
+func LoadFoundryBroadcast(path string, contracts map[string]*Contract) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("sol: failed to read broadcast: %v", err)
+		return err
+	}
+	var broadcast struct {
+		Chain        uint64 `json:"chain"`
+		Transactions []struct {
+			TransactionType string `json:"transactionType"`
+			ContractName    string `json:"contractName"`
+			ContractAddress string `json:"contractAddress"`
+		} `json:"transactions"`
+	}
+	if err := json.Unmarshal(data, &broadcast); err != nil {
+		err = fmt.Errorf("sol: failed to parse broadcast %s: %v", path, err)
+		return err
+	}
+	id := strconv.FormatUint(broadcast.Chain, 10)
+	for _, tx := range broadcast.Transactions {
+		if tx.TransactionType != "CREATE" && tx.TransactionType != "CREATE2" {
+			continue
+		}
+		if !common.IsHexAddress(tx.ContractAddress) {
+			continue
+		}
+		if c := findContract(contracts, tx.ContractName); c != nil {
+			setAddress(c, id, common.HexToAddress(tx.ContractAddress))
+		}
+	}
+	return nil
+}
+
+// findContract returns the contract by its fully qualified name, or by the name alone
+// if it's unambiguous.
+func findContract(contracts map[string]*Contract, name string) *Contract {
+	if c, ok := contracts[name]; ok {
+		return c
+	}
+	var found *Contract
+	for _, c := range contracts {
+		if c.Name != name {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = c
+	}
+	return found
+}
+
+func setAddress(c *Contract, network string, address common.Address) {
+	if c.Addresses == nil {
+		c.Addresses = make(map[string]common.Address)
+	}
+	c.Addresses[network] = address
+}
+
+// MarshalArtifact encodes the contract as an artifact, which is compatible with
+// the Hardhat one. The output is stable: the same contract is always encoded the same way.
+// Compiler warnings are not included.
+func MarshalArtifact(c *Contract) ([]byte, error) {
+	a := artifactJSON{
+		Format:                 ArtifactFormat,
+		ContractName:           c.Name,
+		SourceName:             filepath.ToSlash(c.SourcePath),
+		CompilerVersion:        c.CompilerVersion,
+		ABI:                    json.RawMessage(c.ABI),
+		Bytecode:               "0x" + trimHex(c.Bin),
+		DeployedBytecode:       "0x" + trimHex(c.RuntimeBin),
+		LinkReferences:         nestLinkReferences(c.LinkReferences),
+		DeployedLinkReferences: nestLinkReferences(c.RuntimeLinkReferences),
+		MethodIdentifiers:      c.MethodIdentifiers,
+		SourceMap:              c.SourceMap,
+		DeployedSourceMap:      c.RuntimeSourceMap,
+		Metadata:               c.Metadata,
+		StorageLayout:          c.StorageLayout,
+		DevDoc:                 c.DevDoc,
+		UserDoc:                c.UserDoc,
+	}
+	if len(a.ABI) == 0 {
+		a.ABI = json.RawMessage("[]")
+	}
+	if c.Address != (common.Address{}) {
+		address := c.Address
+		a.Address = &address
+	}
+	if len(c.Addresses) > 0 {
+		a.Networks = make(map[string]artifactNetwork, len(c.Addresses))
+		for network, address := range c.Addresses {
+			a.Networks[network] = artifactNetwork{Address: address}
+		}
+	}
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("sol: failed to marshal artifact: %v", err)
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// WriteArtifact saves the contract as an artifact, replacing the file atomically.
+// The artifact can be read back with LoadArtifact or LoadHardhatArtifact.
+func WriteArtifact(path string, c *Contract) error {
+	data, err := MarshalArtifact(c)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		err = fmt.Errorf("sol: failed to write artifact: %v", err)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		err = fmt.Errorf("sol: failed to write artifact: %v", err)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		err = fmt.Errorf("sol: failed to write artifact: %v", err)
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		err = fmt.Errorf("sol: failed to write artifact: %v", err)
+		return err
+	}
+	return nil
+}
+
+func flattenLinkReferences(refs map[string]map[string][]LinkReference) map[string][]LinkReference {
+	return standardBytecode{LinkReferences: refs}.linkReferences()
+}
+
+// nestLinkReferences groups the references by source files, as in the compiler output.
+func nestLinkReferences(refs map[string][]LinkReference) map[string]map[string][]LinkReference {
+	nested := make(map[string]map[string][]LinkReference)
+	for lib, locations := range refs {
+		file, name := "", lib
+		if sep := strings.LastIndex(lib, ":"); sep >= 0 {
+			file, name = lib[:sep], lib[sep+1:]
+		}
+		if nested[file] == nil {
+			nested[file] = make(map[string][]LinkReference)
+		}
+		nested[file][name] = locations
+	}
+	return nested
+}
+
+func trimHex(bin string) string {
+	bin = strings.TrimSpace(bin)
+	if strings.HasPrefix(bin, "0x") || strings.HasPrefix(bin, "0X") {
+		return bin[2:]
+	}
+	return bin
+}
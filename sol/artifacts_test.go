@@ -0,0 +1,127 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadHardhatArtifacts(t *testing.T) {
+	require := require.New(t)
+
+	contracts, err := LoadHardhatArtifacts("testdata/artifacts/hardhat/artifacts")
+	require.NoError(err)
+	require.Len(contracts, 1)
+	c := contracts["contracts/Counter.sol:Counter"]
+	require.NotNil(c)
+	require.Equal("Counter", c.Name)
+	require.Equal("6080604052__$5e2b8a2c4f4bd1d6e8a2c1b38d9e4e6f0f$__6000", c.Bin)
+	require.Equal([]string{"contracts/Math.sol:Math"}, c.Libraries())
+	require.Equal([]LinkReference{{Start: 2, Length: 20}}, c.RuntimeLinkReferences["contracts/Math.sol:Math"])
+
+	linked, err := c.Link(map[string]common.Address{
+		"Math": common.HexToAddress("0x1111111111111111111111111111111111111111"),
+	})
+	require.NoError(err)
+	require.Equal("60806040521111111111111111111111111111111111111111"+"6000", linked.Bin)
+
+	require.NoError(LoadHardhatDeployments("testdata/artifacts/hardhat/deployments", contracts))
+	require.Equal(map[string]common.Address{
+		"11155111": common.HexToAddress("0x5FbDB2315678afecb367f032d93F642f64180aa3"),
+	}, c.Addresses)
+}
+
+func TestLoadTruffleArtifacts(t *testing.T) {
+	require := require.New(t)
+
+	contracts, err := LoadTruffleArtifacts("testdata/artifacts/truffle/build/contracts")
+	require.NoError(err)
+	c := contracts["/home/dev/counter/contracts/Counter.sol:Counter"]
+	require.NotNil(c)
+	require.Equal("0.5.16+commit.9c3226ce.Emscripten.clang", c.CompilerVersion)
+	require.Equal("608060405234801561001057600080fd5b50", c.Bin)
+	require.Equal("6080604052600080fd", c.RuntimeBin)
+	require.Equal(map[string]common.Address{
+		"1":    common.HexToAddress("0xe78A0F7E598Cc8b0Bb87894B0F60dD2a88d6a8Ab"),
+		"5777": common.HexToAddress("0xCfEB869F69431e42cdB54A4F4f105C19C080A601"),
+	}, c.Addresses)
+}
+
+func TestLoadFoundryArtifacts(t *testing.T) {
+	require := require.New(t)
+
+	contracts, err := LoadFoundryArtifacts("testdata/artifacts/foundry/out")
+	require.NoError(err)
+	c := contracts["src/Counter.sol:Counter"]
+	require.NotNil(c)
+	require.Equal("0.8.19+commit.7dd6d404", c.CompilerVersion)
+	require.Equal("6080604052348015600f57600080fd5b50", c.Bin)
+	require.Equal("d09de08a", c.MethodIdentifiers["increment()"])
+	require.False(c.NeedsLinking())
+
+	err = LoadFoundryBroadcast("testdata/artifacts/foundry/broadcast/Deploy.s.sol/31337/run-latest.json", contracts)
+	require.NoError(err)
+	require.Equal(map[string]common.Address{
+		"31337": common.HexToAddress("0x5FbDB2315678afecb367f032d93F642f64180aa3"),
+	}, c.Addresses)
+}
+
+func TestLoadArtifactDetect(t *testing.T) {
+	require := require.New(t)
+
+	for path, source := range map[string]string{
+		"testdata/artifacts/hardhat/artifacts/contracts/Counter.sol/Counter.json": "contracts/Counter.sol",
+		"testdata/artifacts/truffle/build/contracts/Counter.json":                 "/home/dev/counter/contracts/Counter.sol",
+		"testdata/artifacts/foundry/out/Counter.sol/Counter.json":                 "src/Counter.sol",
+	} {
+		c, err := LoadArtifact(path)
+		require.NoError(err, path)
+		require.Equal("Counter", c.Name)
+		require.Equal(source, c.SourcePath)
+	}
+	_, err := LoadArtifact("testdata/artifacts/hardhat/artifacts/build-info/0d1c4b5e.json")
+	require.Error(err)
+}
+
+func TestWriteArtifact(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "artifacts")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{
+		"testdata/artifacts/hardhat/artifacts/contracts/Counter.sol/Counter.json",
+		"testdata/artifacts/truffle/build/contracts/Counter.json",
+		"testdata/artifacts/foundry/out/Counter.sol/Counter.json",
+	} {
+		c, err := LoadArtifact(path)
+		require.NoError(err)
+		c.Address = common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+		out := filepath.Join(dir, "Counter.json")
+		require.NoError(WriteArtifact(out, c))
+		loaded, err := LoadArtifact(out)
+		require.NoError(err)
+		// the ABI is reindented
+		require.JSONEq(string(c.ABI), string(loaded.ABI))
+		loaded.ABI = c.ABI
+		require.Equal(c, loaded, path)
+
+		// the output is stable
+		first, err := ioutil.ReadFile(out)
+		require.NoError(err)
+		require.NoError(WriteArtifact(out, loaded))
+		second, err := ioutil.ReadFile(out)
+		require.NoError(err)
+		require.Equal(string(first), string(second))
+	}
+}
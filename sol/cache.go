@@ -0,0 +1,282 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachingCompiler is a Compiler that stores compilation results on disk.
+type CachingCompiler interface {
+	Compiler
+
+	// Invalidate removes all cached results of the source file, for any of its contents
+	// and compiler settings.
+	Invalidate(prefix, path string) error
+	// Purge removes all cached results.
+	Purge() error
+}
+
+// NewCachingCompiler wraps the compiler with a cache stored in dir, which is created if missing.
+// Results are keyed by the contents of the source file and of all the files it imports,
+// by the compiler version, optimizer runs, allow-paths and remappings. Once the total size
+// of the cache exceeds maxSize bytes, the least recently used results are evicted, zero
+// maxSize means no limit. Compilers from other packages are keyed by their type,
+// since their version is unknown.
+func NewCachingCompiler(c Compiler, dir string, maxSize int64) (CachingCompiler, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		err = fmt.Errorf("solc cache: failed to create dir: %v", err)
+		return nil, err
+	}
+	cc := &cachingCompiler{
+		c:       c,
+		dir:     dir,
+		maxSize: maxSize,
+		mux:     new(sync.Mutex),
+	}
+	return cc, nil
+}
+
+type cachingCompiler struct {
+	c          Compiler
+	dir        string
+	maxSize    int64
+	allowPaths []string
+	remappings []Remapping
+
+	// mux serializes writes and evictions within the process
+	mux *sync.Mutex
+}
+
+// versionReporter is implemented by the compilers of this package, the version
+// may depend on the source file being compiled.
+type versionReporter interface {
+	compilerVersion(prefix, path string) (string, error)
+}
+
+func compilerVersion(c Compiler, prefix, path string) (string, error) {
+	if v, ok := c.(versionReporter); ok {
+		return v.compilerVersion(prefix, path)
+	}
+	return fmt.Sprintf("%T", c), nil
+}
+
+func (cc *cachingCompiler) SetAllowPaths(paths []string) Compiler {
+	cc.allowPaths = paths
+	cc.c.SetAllowPaths(paths)
+	return cc
+}
+
+func (cc *cachingCompiler) SetRemappings(remappings []Remapping) Compiler {
+	cc.remappings = remappings
+	setRemappings(cc.c, remappings)
+	return cc
+}
+
+func (cc *cachingCompiler) compilerVersion(prefix, path string) (string, error) {
+	return compilerVersion(cc.c, prefix, path)
+}
+
+func (cc *cachingCompiler) Compile(prefix, path string, optimize int) (map[string]*Contract, error) {
+	contracts, err := cc.compileAll(prefix, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+	return contractsByName(path, contracts), nil
+}
+
+func (cc *cachingCompiler) compileAll(prefix, path string, optimize int) ([]*Contract, error) {
+	name, err := cc.entryName(prefix, path, optimize)
+	if err != nil {
+		// let the compiler report the problem
+		return compileAll(cc.c, prefix, path, optimize)
+	}
+	if contracts, ok := cc.load(name); ok {
+		return contracts, nil
+	}
+	contracts, err := compileAll(cc.c, prefix, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+	// the cache is an optimization, failing to store the result doesn't fail the compilation
+	cc.store(name, contracts)
+	return contracts, nil
+}
+
+// entryName returns the file name of the cached result: a hash of the source path
+// allowing to invalidate all its results, followed by a hash of all the inputs.
+func (cc *cachingCompiler) entryName(prefix, path string, optimize int) (string, error) {
+	version, err := compilerVersion(cc.c, prefix, path)
+	if err != nil {
+		return "", err
+	}
+	files, err := importClosure(prefix, path, cc.remappings)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "version=%s\noptimize=%d\n", version, optimize)
+	fmt.Fprintf(h, "allow-paths=%s\n", strings.Join(cc.allowPaths, ","))
+	fmt.Fprintf(h, "remappings=%s\n", strings.Join(remappingStrings(cc.remappings), ","))
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(prefix, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+		fh := sha256.New()
+		_, err = io.Copy(fh, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s=%x\n", file, fh.Sum(nil))
+	}
+	return cc.pathHash(prefix, path) + "-" + hex.EncodeToString(h.Sum(nil)) + ".json", nil
+}
+
+func (cc *cachingCompiler) pathHash(prefix, path string) string {
+	source, err := filepath.Abs(filepath.Join(prefix, path))
+	if err != nil {
+		source = filepath.Join(prefix, path)
+	}
+	h := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(h[:8])
+}
+
+func (cc *cachingCompiler) load(name string) ([]*Contract, bool) {
+	path := filepath.Join(cc.dir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var contracts []*Contract
+	if err := json.Unmarshal(data, &contracts); err != nil {
+		return nil, false
+	}
+	for _, c := range contracts {
+		c.StorageLayout = nullAsNil(c.StorageLayout)
+		c.DevDoc = nullAsNil(c.DevDoc)
+		c.UserDoc = nullAsNil(c.UserDoc)
+	}
+	// mark as recently used, for the eviction
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return contracts, true
+}
+
+// nullAsNil restores the nil value of a raw message that has been marshaled as null.
+func nullAsNil(raw json.RawMessage) json.RawMessage {
+	if string(raw) == "null" {
+		return nil
+	}
+	return raw
+}
+
+// store writes the entry atomically, by renaming a complete temporary file.
+func (cc *cachingCompiler) store(name string, contracts []*Contract) error {
+	data, err := json.Marshal(contracts)
+	if err != nil {
+		return err
+	}
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	f, err := ioutil.TempFile(cc.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(cc.dir, name)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return cc.evict()
+}
+
+// evict removes the least recently used entries until the cache fits into maxSize.
+func (cc *cachingCompiler) evict() error {
+	if cc.maxSize <= 0 {
+		return nil
+	}
+	entries, err := cc.entries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, e := range entries {
+		if total <= cc.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(cc.dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= e.Size()
+	}
+	return nil
+}
+
+func (cc *cachingCompiler) entries() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(cc.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := infos[:0]
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			entries = append(entries, info)
+		}
+	}
+	return entries, nil
+}
+
+func (cc *cachingCompiler) Invalidate(prefix, path string) error {
+	return cc.remove(cc.pathHash(prefix, path) + "-")
+}
+
+func (cc *cachingCompiler) Purge() error {
+	return cc.remove("")
+}
+
+func (cc *cachingCompiler) remove(namePrefix string) error {
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+	entries, err := cc.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), namePrefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cc.dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
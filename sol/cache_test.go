@@ -0,0 +1,112 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingCompiler(t *testing.T) {
+	require := require.New(t)
+	inputPath, cleanup := fakeSolc("greeter_output.json")
+	defer cleanup()
+
+	prefix, err := ioutil.TempDir("", "sources")
+	require.NoError(err)
+	defer os.RemoveAll(prefix)
+	writeSource(prefix, "greeter.sol", `import "./lib/mortal.sol"; contract Greeter is Mortal {}`)
+	writeSource(prefix, "lib/mortal.sol", `contract Mortal {}`)
+	writeSource(prefix, "other.sol", `contract Other {}`)
+
+	cacheDir, err := ioutil.TempDir("", "cache")
+	require.NoError(err)
+	defer os.RemoveAll(cacheDir)
+	solc, err := NewStandardJSONCompiler(fakeSolcPath())
+	require.NoError(err)
+	c, err := NewCachingCompiler(solc, cacheDir, 0)
+	require.NoError(err)
+
+	compiled := func(path string, optimize int) bool {
+		os.Remove(inputPath)
+		_, err := c.Compile(prefix, path, optimize)
+		require.NoError(err)
+		_, err = os.Stat(inputPath)
+		return err == nil
+	}
+	require.True(compiled("greeter.sol", 0))
+	expected, err := c.Compile(prefix, "greeter.sol", 0)
+	require.NoError(err)
+	require.False(compiled("greeter.sol", 0))
+	cached, err := c.Compile(prefix, "greeter.sol", 0)
+	require.NoError(err)
+	require.Equal(expected, cached)
+
+	require.True(compiled("greeter.sol", 200), "optimizer runs must be a part of the key")
+	writeSource(prefix, "lib/mortal.sol", `contract Mortal { uint x; }`)
+	require.True(compiled("greeter.sol", 0), "imports must be a part of the key")
+	require.False(compiled("greeter.sol", 0))
+	c.SetAllowPaths([]string{"/tmp"})
+	require.True(compiled("greeter.sol", 0), "allow-paths must be a part of the key")
+
+	require.True(compiled("other.sol", 0))
+	require.NoError(c.Invalidate(prefix, "greeter.sol"))
+	require.True(compiled("greeter.sol", 0))
+	require.False(compiled("other.sol", 0))
+	require.NoError(c.Purge())
+	require.True(compiled("other.sol", 0))
+}
+
+func TestCachingCompilerEviction(t *testing.T) {
+	require := require.New(t)
+	inputPath, cleanup := fakeSolc("greeter_output.json")
+	defer cleanup()
+
+	cacheDir, err := ioutil.TempDir("", "cache")
+	require.NoError(err)
+	defer os.RemoveAll(cacheDir)
+	solc, err := NewStandardJSONCompiler(fakeSolcPath())
+	require.NoError(err)
+	c, err := NewCachingCompiler(solc, cacheDir, 0)
+	require.NoError(err)
+	_, err = c.Compile("testdata", "greeter.sol", 0)
+	require.NoError(err)
+	infos, err := ioutil.ReadDir(cacheDir)
+	require.NoError(err)
+	require.Len(infos, 1)
+	entrySize := infos[0].Size()
+
+	// fits two entries only
+	c, err = NewCachingCompiler(solc, cacheDir, entrySize*5/2)
+	require.NoError(err)
+	for _, optimize := range []int{0, 1, 2} {
+		_, err = c.Compile("testdata", "greeter.sol", optimize)
+		require.NoError(err)
+	}
+	infos, err = ioutil.ReadDir(cacheDir)
+	require.NoError(err)
+	require.Len(infos, 2)
+
+	// the entry with optimize=0 is the least recently used
+	os.Remove(inputPath)
+	_, err = c.Compile("testdata", "greeter.sol", 2)
+	require.NoError(err)
+	_, err = os.Stat(inputPath)
+	require.True(os.IsNotExist(err))
+	_, err = c.Compile("testdata", "greeter.sol", 0)
+	require.NoError(err)
+	_, err = os.Stat(inputPath)
+	require.NoError(err)
+}
+
+func writeSource(prefix, path, src string) {
+	path = filepath.Join(prefix, path)
+	orPanic(os.MkdirAll(filepath.Dir(path), 0755))
+	orPanic(ioutil.WriteFile(path, []byte(src), 0644))
+}
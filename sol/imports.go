@@ -0,0 +1,181 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// stripComments removes line and block comments from the Solidity source,
+// keeping string literals and line breaks intact.
+func stripComments(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(src) && src[j] != c && src[j] != '\n'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			out = append(out, src[i:j+1]...)
+			i = j
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			if i < len(src) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			i += 2
+			for i < len(src) && !(src[i] == '*' && i+1 < len(src) && src[i+1] == '/') {
+				if src[i] == '\n' {
+					out = append(out, '\n')
+				}
+				i++
+			}
+			i++
+			out = append(out, ' ')
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+var importRx = regexp.MustCompile(`(?s)\bimport\s+(?:[^;]*?\s+from\s+)?["']([^"']+)["']`)
+
+// scanImports returns the import paths of the Solidity source, as written.
+func scanImports(src []byte) []string {
+	var imports []string
+	for _, m := range importRx.FindAllSubmatch(stripComments(src), -1) {
+		imports = append(imports, string(m[1]))
+	}
+	return imports
+}
+
+// Remapping replaces the Prefix of import paths with the Target, e.g. the remapping
+// "@openzeppelin/=node_modules/@openzeppelin/". If the Context is set, the remapping
+// applies only to the files with paths starting with it.
+type Remapping struct {
+	Context string
+	Prefix  string
+	Target  string
+}
+
+// ParseRemapping parses a remapping in the solc format: [context:]prefix=target.
+func ParseRemapping(s string) (Remapping, error) {
+	eq := strings.Index(s, "=")
+	if eq <= 0 {
+		err := fmt.Errorf("invalid remapping %q: expected [context:]prefix=target", s)
+		return Remapping{}, err
+	}
+	r := Remapping{
+		Prefix: s[:eq],
+		Target: s[eq+1:],
+	}
+	if colon := strings.Index(r.Prefix, ":"); colon >= 0 {
+		r.Context, r.Prefix = r.Prefix[:colon], r.Prefix[colon+1:]
+	}
+	if len(r.Prefix) == 0 {
+		err := fmt.Errorf("invalid remapping %q: empty prefix", s)
+		return Remapping{}, err
+	}
+	return r, nil
+}
+
+// ParseRemappings parses remappings, one per line or separated by spaces, like
+// the contents of a remappings.txt file.
+func ParseRemappings(s string) ([]Remapping, error) {
+	var remappings []Remapping
+	for _, field := range strings.Fields(s) {
+		r, err := ParseRemapping(field)
+		if err != nil {
+			return nil, err
+		}
+		remappings = append(remappings, r)
+	}
+	return remappings, nil
+}
+
+func (r Remapping) String() string {
+	if len(r.Context) > 0 {
+		return r.Context + ":" + r.Prefix + "=" + r.Target
+	}
+	return r.Prefix + "=" + r.Target
+}
+
+// Remap applies the best matching remapping to the import path from the file: the one
+// with the longest context and then the longest prefix, like solc does.
+func Remap(remappings []Remapping, from, imp string) string {
+	best := -1
+	for i, r := range remappings {
+		if !strings.HasPrefix(from, r.Context) || !strings.HasPrefix(imp, r.Prefix) {
+			continue
+		}
+		if best < 0 || len(r.Context) > len(remappings[best].Context) ||
+			(len(r.Context) == len(remappings[best].Context) && len(r.Prefix) > len(remappings[best].Prefix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return imp
+	}
+	return remappings[best].Target + strings.TrimPrefix(imp, remappings[best].Prefix)
+}
+
+// resolveImport resolves the import path against the importing file, both paths
+// are slash-separated and relative to the compilation prefix.
+func resolveImport(remappings []Remapping, from, imp string) string {
+	if strings.HasPrefix(imp, "./") || strings.HasPrefix(imp, "../") {
+		imp = path.Join(path.Dir(from), imp)
+	}
+	return path.Clean(Remap(remappings, from, imp))
+}
+
+// importClosure returns the source file and all files it imports directly or
+// indirectly, sorted. Imports that cannot be read are skipped, leaving them for the
+// compiler to report.
+func importClosure(prefix, file string, remappings []Remapping) ([]string, error) {
+	file = filepath.ToSlash(filepath.Clean(file))
+	if _, err := os.Stat(filepath.Join(prefix, filepath.FromSlash(file))); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	queue := []string{file}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(prefix, filepath.FromSlash(current)))
+		if err != nil {
+			continue
+		}
+		seen[current] = true
+		for _, imp := range scanImports(src) {
+			queue = append(queue, resolveImport(remappings, current, imp))
+		}
+	}
+	files := make([]string, 0, len(seen))
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
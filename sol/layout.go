@@ -0,0 +1,214 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// StorageLayout is the storage layout output of solc.
+type StorageLayout struct {
+	Storage []StorageVariable       `json:"storage"`
+	Types   map[string]*StorageType `json:"types"`
+}
+
+// StorageVariable is a state variable or a struct member in the storage layout.
+type StorageVariable struct {
+	Contract string `json:"contract"`
+	Label    string `json:"label"`
+	Offset   int    `json:"offset"`
+	Slot     string `json:"slot"`
+	Type     string `json:"type"`
+}
+
+// StorageType describes a type of the storage layout.
+type StorageType struct {
+	Encoding      string            `json:"encoding"`
+	Label         string            `json:"label"`
+	NumberOfBytes string            `json:"numberOfBytes"`
+	Base          string            `json:"base,omitempty"`
+	Key           string            `json:"key,omitempty"`
+	Value         string            `json:"value,omitempty"`
+	Members       []StorageVariable `json:"members,omitempty"`
+}
+
+// ParseStorageLayout parses the storage layout of the contract, which is set by
+// the standard JSON compiler and by the artifact loaders.
+func (c *Contract) ParseStorageLayout() (*StorageLayout, error) {
+	if len(c.StorageLayout) == 0 {
+		err := fmt.Errorf("sol: contract %s has no storage layout", c.Name)
+		return nil, err
+	}
+	layout := new(StorageLayout)
+	if err := json.Unmarshal(c.StorageLayout, layout); err != nil {
+		err = fmt.Errorf("sol: failed to parse storage layout of %s: %v", c.Name, err)
+		return nil, err
+	}
+	return layout, nil
+}
+
+// StorageLayoutError lists the incompatibilities of the storage layouts.
+type StorageLayoutError struct {
+	Contract string
+	Problems []string
+}
+
+func (e *StorageLayoutError) Error() string {
+	return fmt.Sprintf("sol: storage layout of %s is incompatible: %s",
+		e.Contract, strings.Join(e.Problems, "; "))
+}
+
+// CheckStorageLayout checks that the next implementation of an upgradeable contract keeps
+// the storage of the previous one: the variables may be renamed and appended, but not removed,
+// moved or changed to incompatible types. Gaps named __gap may be shrunk to make room for
+// new variables, as long as they end at the same slot. Returns *StorageLayoutError.
+func CheckStorageLayout(prev, next *Contract) error {
+	oldLayout, err := prev.ParseStorageLayout()
+	if err != nil {
+		return err
+	}
+	newLayout, err := next.ParseStorageLayout()
+	if err != nil {
+		return err
+	}
+	c := &layoutChecker{
+		old: oldLayout,
+		new: newLayout,
+	}
+	c.checkVariables("", oldLayout.Storage, newLayout.Storage, false)
+	if len(c.problems) > 0 {
+		return &StorageLayoutError{
+			Contract: next.Name,
+			Problems: c.problems,
+		}
+	}
+	return nil
+}
+
+type layoutChecker struct {
+	old, new *StorageLayout
+	problems []string
+}
+
+func (c *layoutChecker) problem(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// checkVariables compares the variables by position. If appendOnly is set, the new
+// variables may only be appended, gaps included.
+func (c *layoutChecker) checkVariables(prefix string, prev, next []StorageVariable, appendOnly bool) {
+	j := 0
+	for i := 0; i < len(prev); i++ {
+		o := prev[i]
+		if isStorageGap(o) && !appendOnly {
+			end := c.endSlot(c.old, o)
+			for j < len(next) && !isStorageGap(next[j]) && slotNumber(next[j].Slot).Cmp(end) < 0 {
+				j++
+			}
+			if j < len(next) && isStorageGap(next[j]) && c.endSlot(c.new, next[j]).Cmp(end) == 0 {
+				j++
+				continue
+			}
+			c.problem("%s%s must end at slot %s", prefix, o.Label, end)
+			return
+		}
+		if j >= len(next) {
+			c.problem("%s%s was removed", prefix, o.Label)
+			return
+		}
+		n := next[j]
+		j++
+		name := prefix + o.Label
+		if o.Label != n.Label {
+			name += " (renamed to " + n.Label + ")"
+		}
+		if o.Slot != n.Slot || o.Offset != n.Offset {
+			c.problem("%s moved from slot %s offset %d to slot %s offset %d",
+				name, o.Slot, o.Offset, n.Slot, n.Offset)
+			return
+		}
+		if !c.compatible(prefix+o.Label+".", o.Type, n.Type, false) {
+			c.problem("%s changed type from %s to %s", name, c.label(c.old, o.Type), c.label(c.new, n.Type))
+		}
+	}
+}
+
+// compatible reports whether the new type keeps the storage of the old one. The struct
+// members found incompatible are reported on their own.
+func (c *layoutChecker) compatible(prefix, oldID, newID string, grow bool) bool {
+	o, n := c.old.Types[oldID], c.new.Types[newID]
+	if o == nil || n == nil {
+		return oldID == newID
+	}
+	if o.Encoding != n.Encoding {
+		return false
+	}
+	switch o.Encoding {
+	case "mapping":
+		return c.compatible(prefix, o.Key, n.Key, false) && c.compatible(prefix, o.Value, n.Value, true)
+	case "dynamic_array":
+		return c.compatible(prefix, o.Base, n.Base, false)
+	case "bytes":
+		return o.Label == n.Label
+	}
+	// inplace
+	sizeOK := o.NumberOfBytes == n.NumberOfBytes
+	if grow {
+		sizeOK = slotNumber(o.NumberOfBytes).Cmp(slotNumber(n.NumberOfBytes)) <= 0
+	}
+	switch {
+	case len(o.Members) > 0 || len(n.Members) > 0:
+		if !sizeOK || len(n.Members) < len(o.Members) || (!grow && len(n.Members) != len(o.Members)) {
+			return false
+		}
+		c.checkVariables(prefix, o.Members, n.Members, true)
+		return true
+	case len(o.Base) > 0:
+		return sizeOK && c.compatible(prefix, o.Base, n.Base, false)
+	case strings.HasPrefix(o.Label, "enum "):
+		return strings.HasPrefix(n.Label, "enum ") && sizeOK
+	case isAddressLabel(o.Label):
+		return isAddressLabel(n.Label)
+	default:
+		return o.Label == n.Label && o.NumberOfBytes == n.NumberOfBytes
+	}
+}
+
+func (c *layoutChecker) label(layout *StorageLayout, id string) string {
+	if t := layout.Types[id]; t != nil {
+		return t.Label
+	}
+	return id
+}
+
+// endSlot returns the slot after the variable.
+func (c *layoutChecker) endSlot(layout *StorageLayout, v StorageVariable) *big.Int {
+	size := big.NewInt(32)
+	if t := layout.Types[v.Type]; t != nil {
+		size = slotNumber(t.NumberOfBytes)
+	}
+	slots := new(big.Int).Add(size, big.NewInt(31))
+	slots.Div(slots, big.NewInt(32))
+	return slots.Add(slots, slotNumber(v.Slot))
+}
+
+func isStorageGap(v StorageVariable) bool {
+	return v.Label == "__gap" || strings.HasPrefix(v.Label, "__gap_")
+}
+
+func isAddressLabel(label string) bool {
+	return label == "address" || label == "address payable" || strings.HasPrefix(label, "contract ")
+}
+
+func slotNumber(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func layoutContract(t *testing.T, layout *StorageLayout) *Contract {
+	data, err := json.Marshal(layout)
+	require.NoError(t, err)
+	return &Contract{
+		Name:          "Box",
+		StorageLayout: data,
+	}
+}
+
+func layoutTypes() map[string]*StorageType {
+	return map[string]*StorageType{
+		"t_uint8":             {Encoding: "inplace", Label: "uint8", NumberOfBytes: "1"},
+		"t_bool":              {Encoding: "inplace", Label: "bool", NumberOfBytes: "1"},
+		"t_address":           {Encoding: "inplace", Label: "address", NumberOfBytes: "20"},
+		"t_contract(IOwner)5": {Encoding: "inplace", Label: "contract IOwner", NumberOfBytes: "20"},
+		"t_uint64":            {Encoding: "inplace", Label: "uint64", NumberOfBytes: "8"},
+		"t_uint128":           {Encoding: "inplace", Label: "uint128", NumberOfBytes: "16"},
+		"t_uint256":           {Encoding: "inplace", Label: "uint256", NumberOfBytes: "32"},
+		"t_int256":            {Encoding: "inplace", Label: "int256", NumberOfBytes: "32"},
+		"t_mapping(t_address,t_struct(Account)12_storage)": {
+			Encoding: "mapping", Label: "mapping(address => struct Box.Account)", NumberOfBytes: "32",
+			Key: "t_address", Value: "t_struct(Account)12_storage",
+		},
+		"t_struct(Account)12_storage": {
+			Encoding: "inplace", Label: "struct Box.Account", NumberOfBytes: "64",
+			Members: []StorageVariable{
+				{Label: "amount", Slot: "0", Type: "t_uint256"},
+				{Label: "locked", Slot: "1", Type: "t_bool"},
+			},
+		},
+		"t_array(t_uint256)48_storage": {Encoding: "inplace", Label: "uint256[48]", NumberOfBytes: "1536", Base: "t_uint256"},
+		"t_array(t_uint256)47_storage": {Encoding: "inplace", Label: "uint256[47]", NumberOfBytes: "1504", Base: "t_uint256"},
+	}
+}
+
+// layoutV1 is the layout of the first implementation, with a gap up to the slot 51.
+func layoutV1() *StorageLayout {
+	return &StorageLayout{
+		Storage: []StorageVariable{
+			{Contract: "Box.sol:Box", Label: "_initialized", Slot: "0", Offset: 0, Type: "t_uint8"},
+			{Contract: "Box.sol:Box", Label: "_initializing", Slot: "0", Offset: 1, Type: "t_bool"},
+			{Contract: "Box.sol:Box", Label: "owner", Slot: "0", Offset: 2, Type: "t_address"},
+			{Contract: "Box.sol:Box", Label: "value", Slot: "1", Type: "t_uint256"},
+			{Contract: "Box.sol:Box", Label: "accounts", Slot: "2", Type: "t_mapping(t_address,t_struct(Account)12_storage)"},
+			{Contract: "Box.sol:Box", Label: "__gap", Slot: "3", Type: "t_array(t_uint256)48_storage"},
+		},
+		Types: layoutTypes(),
+	}
+}
+
+func TestCheckStorageLayout(t *testing.T) {
+	require := require.New(t)
+	v1 := layoutContract(t, layoutV1())
+	require.NoError(CheckStorageLayout(v1, v1))
+
+	// a variable takes a slot of the gap, the owner becomes a contract type,
+	// the value is renamed and the account in the mapping gets a new member
+	v2 := layoutV1()
+	v2.Storage[2].Type = "t_contract(IOwner)5"
+	v2.Storage[3].Label = "total"
+	v2.Storage[5] = StorageVariable{Label: "limit", Slot: "3", Type: "t_uint256"}
+	v2.Storage = append(v2.Storage, StorageVariable{Label: "__gap", Slot: "4", Type: "t_array(t_uint256)47_storage"})
+	account := v2.Types["t_struct(Account)12_storage"]
+	account.Members = append(account.Members, StorageVariable{Label: "since", Slot: "1", Offset: 1, Type: "t_uint64"})
+	require.NoError(CheckStorageLayout(v1, layoutContract(t, v2)))
+
+	v3 := layoutV1()
+	v3.Storage[3].Type = "t_int256"
+	v3.Storage[5] = StorageVariable{Label: "limit", Slot: "3", Type: "t_uint256"}
+	v3.Storage = append(v3.Storage, StorageVariable{Label: "__gap", Slot: "4", Type: "t_array(t_uint256)48_storage"})
+	v3.Types["t_struct(Account)12_storage"].Members[0].Type = "t_uint128"
+	err := CheckStorageLayout(v1, layoutContract(t, v3))
+	layoutErr, ok := err.(*StorageLayoutError)
+	require.True(ok, "unexpected error: %v", err)
+	require.Equal([]string{
+		"value changed type from uint256 to int256",
+		"accounts.amount changed type from uint256 to uint128",
+		"__gap must end at slot 51",
+	}, layoutErr.Problems)
+
+	v4 := layoutV1()
+	v4.Storage = append(v4.Storage[:1], v4.Storage[2:]...)
+	err = CheckStorageLayout(v1, layoutContract(t, v4))
+	require.EqualError(err, "sol: storage layout of Box is incompatible: "+
+		"_initializing (renamed to owner) moved from slot 0 offset 1 to slot 0 offset 2")
+
+	v5 := layoutV1()
+	v5.Storage = v5.Storage[:3]
+	err = CheckStorageLayout(v1, layoutContract(t, v5))
+	require.EqualError(err, "sol: storage layout of Box is incompatible: value was removed")
+
+	_, err = (&Contract{Name: "Box"}).ParseStorageLayout()
+	require.EqualError(err, "sol: contract Box has no storage layout")
+}
@@ -0,0 +1,196 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// LinkReference is a location of a library address placeholder in the bytecode,
+// Start and Length are in bytes.
+type LinkReference struct {
+	Start  int `json:"start"`
+	Length int `json:"length"`
+}
+
+// UnlinkedError is returned when the bytecode has placeholders of libraries
+// that have no address.
+type UnlinkedError struct {
+	Contract  string
+	Libraries []string
+}
+
+func (e *UnlinkedError) Error() string {
+	return fmt.Sprintf("sol: contract %s must be linked with libraries: %s",
+		e.Contract, strings.Join(e.Libraries, ", "))
+}
+
+// placeholderRx matches both the hashed and the legacy placeholders, which are always
+// 40 characters long, as hex bytecode has no other underscores.
+var placeholderRx = regexp.MustCompile(`__.{38}`)
+
+// LibraryPlaceholder returns the placeholder the compiler puts into the bytecode
+// in place of the library address, given its fully qualified name (path:Name).
+func LibraryPlaceholder(library string) string {
+	hash := hex.EncodeToString(crypto.Keccak256([]byte(library)))
+	return "__$" + hash[:34] + "$__"
+}
+
+// legacyPlaceholder returns the placeholder used by solc before 0.5.0.
+func legacyPlaceholder(library string) string {
+	if len(library) > 36 {
+		library = library[:36]
+	}
+	return "__" + library + strings.Repeat("_", 38-len(library))
+}
+
+// NeedsLinking reports whether the bytecode still has library placeholders.
+func (c *Contract) NeedsLinking() bool {
+	return len(c.unresolved(c.Bin)) > 0 || len(c.unresolved(c.RuntimeBin)) > 0
+}
+
+// Libraries returns the fully qualified names (path:Name) of the libraries the
+// contract must be linked with. Contracts compiled without link references report
+// the names from the placeholders, which may be truncated or hashed.
+func (c *Contract) Libraries() []string {
+	seen := make(map[string]bool)
+	for lib := range c.LinkReferences {
+		seen[lib] = true
+	}
+	for lib := range c.RuntimeLinkReferences {
+		seen[lib] = true
+	}
+	if len(seen) == 0 {
+		for _, p := range c.unresolved(c.Bin) {
+			seen[strings.Trim(p, "_")] = true
+		}
+	}
+	libs := make([]string, 0, len(seen))
+	for lib := range seen {
+		libs = append(libs, lib)
+	}
+	sort.Strings(libs)
+	return libs
+}
+
+func (c *Contract) unresolved(bin string) []string {
+	return placeholderRx.FindAllString(bin, -1)
+}
+
+// Link returns a copy of the contract with the library placeholders replaced by
+// the addresses. Libraries are keyed by their fully qualified names (path:Name) or just
+// by names, if these are unambiguous. Returns *UnlinkedError if any of the
+// placeholders is left unresolved.
+func (c *Contract) Link(libraries map[string]common.Address) (*Contract, error) {
+	linked := *c
+	var err error
+	if linked.Bin, err = c.link(c.Bin, c.LinkReferences, libraries); err != nil {
+		return nil, err
+	}
+	if linked.RuntimeBin, err = c.link(c.RuntimeBin, c.RuntimeLinkReferences, libraries); err != nil {
+		return nil, err
+	}
+	linked.LinkReferences = nil
+	linked.RuntimeLinkReferences = nil
+	return &linked, nil
+}
+
+func (c *Contract) link(bin string, refs map[string][]LinkReference,
+	libraries map[string]common.Address) (string, error) {
+
+	var missing []string
+	code := []byte(bin)
+	for lib, locations := range refs {
+		addr, ok, err := c.lookupLibrary(lib, libraries)
+		if err != nil {
+			return "", err
+		} else if !ok {
+			missing = append(missing, lib)
+			continue
+		}
+		addrHex := hex.EncodeToString(addr[:])
+		for _, loc := range locations {
+			start, end := loc.Start*2, (loc.Start+loc.Length)*2
+			if loc.Length != common.AddressLength || end > len(code) {
+				err := fmt.Errorf("sol: invalid link reference of %s in %s at %d", lib, c.Name, loc.Start)
+				return "", err
+			}
+			copy(code[start:end], addrHex)
+		}
+	}
+	if len(missing) > 0 {
+		return "", c.unlinkedError(missing)
+	}
+	// resolve placeholders left without link references, by their hashed or legacy form
+	unresolved := make(map[string]bool)
+	for _, p := range placeholderRx.FindAllString(string(code), -1) {
+		if unresolved[p] {
+			continue
+		}
+		addr, ok, err := c.lookupLibrary(strings.Trim(p, "_"), libraries)
+		if err != nil {
+			return "", err
+		}
+		for lib, libAddr := range libraries {
+			if ok {
+				break
+			}
+			if p == LibraryPlaceholder(lib) || p == legacyPlaceholder(lib) {
+				addr, ok = libAddr, true
+			}
+		}
+		if !ok {
+			unresolved[p] = true
+			missing = append(missing, strings.Trim(p, "_"))
+			continue
+		}
+		code = []byte(strings.Replace(string(code), p, hex.EncodeToString(addr[:]), -1))
+	}
+	if len(missing) > 0 {
+		return "", c.unlinkedError(missing)
+	}
+	return string(code), nil
+}
+
+func (c *Contract) unlinkedError(missing []string) error {
+	sort.Strings(missing)
+	return &UnlinkedError{
+		Contract:  c.Name,
+		Libraries: missing,
+	}
+}
+
+// lookupLibrary finds the address by the fully qualified name, or by the name alone if
+// no other library of the contract has the same name.
+func (c *Contract) lookupLibrary(lib string, libraries map[string]common.Address) (common.Address, bool, error) {
+	if addr, ok := libraries[lib]; ok {
+		return addr, true, nil
+	}
+	name := libraryName(lib)
+	addr, ok := libraries[name]
+	if !ok {
+		return common.Address{}, false, nil
+	}
+	for _, other := range c.Libraries() {
+		if other != lib && libraryName(other) == name {
+			err := fmt.Errorf("sol: library name %s is ambiguous in %s, %s and %s share it",
+				name, c.Name, lib, other)
+			return common.Address{}, false, err
+		}
+	}
+	return addr, true, nil
+}
+
+// libraryName strips the path from a fully qualified library name.
+func libraryName(lib string) string {
+	return lib[strings.LastIndex(lib, ":")+1:]
+}
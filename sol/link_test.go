@@ -0,0 +1,96 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLink(t *testing.T) {
+	require := require.New(t)
+	mathLib := "lib/Math.sol:Math"
+	bin := "6080" + LibraryPlaceholder(mathLib) + "6000" + LibraryPlaceholder(mathLib)
+	c := &Contract{
+		Name:       "Calc",
+		Bin:        bin,
+		RuntimeBin: "60" + LibraryPlaceholder(mathLib),
+		LinkReferences: map[string][]LinkReference{
+			mathLib: {{Start: 2, Length: 20}, {Start: 24, Length: 20}},
+		},
+		RuntimeLinkReferences: map[string][]LinkReference{
+			mathLib: {{Start: 1, Length: 20}},
+		},
+	}
+	require.True(c.NeedsLinking())
+	require.Equal([]string{mathLib}, c.Libraries())
+
+	_, err := c.Link(nil)
+	require.Error(err)
+	unlinked, ok := err.(*UnlinkedError)
+	require.True(ok)
+	require.Equal([]string{mathLib}, unlinked.Libraries)
+
+	addr := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	addrHex := strings.TrimPrefix(strings.ToLower(addr.Hex()), "0x")
+	linked, err := c.Link(map[string]common.Address{"Math": addr})
+	require.NoError(err)
+	require.False(linked.NeedsLinking())
+	require.Equal("6080"+addrHex+"6000"+addrHex, linked.Bin)
+	require.Equal("60"+addrHex, linked.RuntimeBin)
+	require.True(c.NeedsLinking(), "the original contract must be kept intact")
+}
+
+func TestLinkPlaceholders(t *testing.T) {
+	require := require.New(t)
+	addr := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	addrHex := strings.TrimPrefix(strings.ToLower(addr.Hex()), "0x")
+
+	// hashed placeholders without link references, e.g. from --combined-json
+	c := &Contract{
+		Name: "Calc",
+		Bin:  "6080" + LibraryPlaceholder("Math.sol:Math"),
+	}
+	require.Equal([]string{strings.Trim(LibraryPlaceholder("Math.sol:Math"), "_")}, c.Libraries())
+	linked, err := c.Link(map[string]common.Address{"Math.sol:Math": addr})
+	require.NoError(err)
+	require.Equal("6080"+addrHex, linked.Bin)
+
+	// legacy placeholders of solc before 0.5.0
+	c = &Contract{
+		Name: "Calc",
+		Bin:  "6080__Math.sol:Math_________________________6000",
+	}
+	require.Equal([]string{"Math.sol:Math"}, c.Libraries())
+	linked, err = c.Link(map[string]common.Address{"Math": addr})
+	require.NoError(err)
+	require.Equal("6080"+addrHex+"6000", linked.Bin)
+}
+
+func TestLinkAmbiguousName(t *testing.T) {
+	require := require.New(t)
+	mathA, mathB := "a/Math.sol:Math", "b/Math.sol:Math"
+	c := &Contract{
+		Name: "Calc",
+		Bin:  "73" + LibraryPlaceholder(mathA) + "73" + LibraryPlaceholder(mathB),
+		LinkReferences: map[string][]LinkReference{
+			mathA: {{Start: 1, Length: 20}},
+			mathB: {{Start: 22, Length: 20}},
+		},
+	}
+	addrA := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	addrB := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	_, err := c.Link(map[string]common.Address{"Math": addrA})
+	require.Error(err)
+	require.Contains(err.Error(), "library name Math is ambiguous in Calc")
+
+	linked, err := c.Link(map[string]common.Address{mathA: addrA, mathB: addrB})
+	require.NoError(err)
+	require.Equal("73"+strings.TrimPrefix(strings.ToLower(addrA.Hex()), "0x")+
+		"73"+strings.TrimPrefix(strings.ToLower(addrB.Hex()), "0x"), linked.Bin)
+}
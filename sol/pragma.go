@@ -0,0 +1,319 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a compiler version, without pre-release and build metadata.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or +1 depending on whether v is less than, equal to or greater than w.
+func (v Version) Compare(w Version) int {
+	switch {
+	case v.Major != w.Major:
+		return compareInt(v.Major, w.Major)
+	case v.Minor != w.Minor:
+		return compareInt(v.Minor, w.Minor)
+	default:
+		return compareInt(v.Patch, w.Patch)
+	}
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+var versionRx = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// ParseVersion finds the first semantic version in the string, so it accepts strings
+// like "0.5.9", "v0.5.9", "0.5.9+commit.e560f70d.Linux.g++" or "solc-v0.5.9".
+func ParseVersion(s string) (Version, error) {
+	m := versionRx.FindStringSubmatch(s)
+	if m == nil {
+		err := fmt.Errorf("no version found in %q", s)
+		return Version{}, err
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	return Version{major, minor, patch}, nil
+}
+
+// Constraint is a set of version ranges, as used in 'pragma solidity'. A version matches
+// the constraint if it matches any of the ranges, a nil constraint matches any version.
+type Constraint struct {
+	expr   string
+	ranges [][]comparator
+}
+
+type comparator struct {
+	op string
+	v  Version
+}
+
+func (c comparator) match(v Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return cmp == 0
+	}
+}
+
+func (c *Constraint) String() string {
+	if c == nil {
+		return "*"
+	}
+	return c.expr
+}
+
+// Match reports whether the version satisfies the constraint.
+func (c *Constraint) Match(v Version) bool {
+	if c == nil {
+		return true
+	}
+	for _, r := range c.ranges {
+		matched := true
+		for _, cmp := range r {
+			if !cmp.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns a constraint matched by versions that satisfy both c and other.
+func (c *Constraint) Intersect(other *Constraint) *Constraint {
+	if c == nil {
+		return other
+	} else if other == nil {
+		return c
+	}
+	result := &Constraint{
+		expr: fmt.Sprintf("(%s) (%s)", c.expr, other.expr),
+	}
+	for _, r1 := range c.ranges {
+		for _, r2 := range other.ranges {
+			r := make([]comparator, 0, len(r1)+len(r2))
+			r = append(r, r1...)
+			r = append(r, r2...)
+			result.ranges = append(result.ranges, r)
+		}
+	}
+	return result
+}
+
+// ParseConstraint parses a version constraint expression in the npm semver syntax
+// supported by Solidity: comparators (<, <=, >, >=, =), caret and tilde ranges,
+// partial versions and wildcards (0.5, 0.5.x, *), hyphen ranges and || unions.
+func ParseConstraint(expr string) (*Constraint, error) {
+	c := &Constraint{
+		expr: strings.TrimSpace(expr),
+	}
+	for _, part := range strings.Split(expr, "||") {
+		r, err := parseRange(part)
+		if err != nil {
+			err = fmt.Errorf("invalid version constraint %q: %v", c.expr, err)
+			return nil, err
+		}
+		c.ranges = append(c.ranges, r)
+	}
+	return c, nil
+}
+
+func parseRange(s string) ([]comparator, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty range")
+	}
+	if len(fields) == 3 && fields[1] == "-" {
+		from, _, err := parsePartial(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		to, toN, err := parsePartial(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		r := []comparator{{">=", from}}
+		if toN == 0 {
+			return r, nil
+		} else if toN < 3 {
+			return append(r, comparator{"<", bump(to, toN)}), nil
+		}
+		return append(r, comparator{"<=", to}), nil
+	}
+	var r []comparator
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		// allow whitespace between the operator and the version, like ">= 0.4.22"
+		if isOperator(field) && i+1 < len(fields) {
+			i++
+			field += fields[i]
+		}
+		cmps, err := parseComparator(field)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, cmps...)
+	}
+	return r, nil
+}
+
+func isOperator(s string) bool {
+	switch s {
+	case "<", "<=", ">", ">=", "=", "^", "~":
+		return true
+	}
+	return false
+}
+
+func parseComparator(s string) ([]comparator, error) {
+	op := ""
+	for _, candidate := range []string{"<=", ">=", "<", ">", "=", "^", "~"} {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			s = s[len(candidate):]
+			break
+		}
+	}
+	v, n, err := parsePartial(s)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		// a wildcard matches any version, except with the strict comparisons
+		switch op {
+		case "<", ">":
+			return []comparator{{"<", Version{}}}, nil
+		default:
+			return nil, nil
+		}
+	}
+	switch op {
+	case "", "=":
+		if n == 3 {
+			return []comparator{{"=", v}}, nil
+		}
+		return []comparator{{">=", v}, {"<", bump(v, n)}}, nil
+	case "<":
+		return []comparator{{"<", v}}, nil
+	case ">=":
+		return []comparator{{">=", v}}, nil
+	case "<=":
+		if n == 3 {
+			return []comparator{{"<=", v}}, nil
+		}
+		return []comparator{{"<", bump(v, n)}}, nil
+	case ">":
+		if n == 3 {
+			return []comparator{{">", v}}, nil
+		}
+		return []comparator{{">=", bump(v, n)}}, nil
+	case "~":
+		if n == 1 {
+			return []comparator{{">=", v}, {"<", bump(v, 1)}}, nil
+		}
+		return []comparator{{">=", v}, {"<", bump(v, 2)}}, nil
+	default: // "^"
+		switch {
+		case v.Major > 0 || n == 1:
+			return []comparator{{">=", v}, {"<", bump(v, 1)}}, nil
+		case v.Minor > 0 || n == 2:
+			return []comparator{{">=", v}, {"<", bump(v, 2)}}, nil
+		default:
+			return []comparator{{">=", v}, {"<", bump(v, 3)}}, nil
+		}
+	}
+}
+
+// parsePartial parses a possibly partial version like "0", "0.5", "0.5.x" or "*",
+// returns the number of components specified before the first wildcard.
+func parsePartial(s string) (Version, int, error) {
+	s = strings.TrimPrefix(s, "v")
+	if len(s) == 0 {
+		return Version{}, 0, fmt.Errorf("missing version")
+	}
+	if i := strings.IndexAny(s, "-+"); i > 0 {
+		// pre-release and build metadata is ignored
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return Version{}, 0, fmt.Errorf("malformed version %q", s)
+	}
+	var nums [3]int
+	n := 0
+	for _, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+		num, err := strconv.Atoi(p)
+		if err != nil || num < 0 {
+			return Version{}, 0, fmt.Errorf("malformed version %q", s)
+		}
+		nums[n] = num
+		n++
+	}
+	return Version{nums[0], nums[1], nums[2]}, n, nil
+}
+
+// bump increments the n-th component of the version (1-based) and resets the rest.
+func bump(v Version, n int) Version {
+	switch n {
+	case 1:
+		return Version{v.Major + 1, 0, 0}
+	case 2:
+		return Version{v.Major, v.Minor + 1, 0}
+	default:
+		return Version{v.Major, v.Minor, v.Patch + 1}
+	}
+}
+
+var pragmaRx = regexp.MustCompile(`pragma\s+solidity\s+([^;]+);`)
+
+// PragmaConstraint parses all 'pragma solidity' directives of the source, returns
+// their intersection or nil if there are none.
+func PragmaConstraint(src []byte) (*Constraint, error) {
+	var constraint *Constraint
+	for _, m := range pragmaRx.FindAllSubmatch(stripComments(src), -1) {
+		c, err := ParseConstraint(string(m[1]))
+		if err != nil {
+			return nil, err
+		}
+		constraint = constraint.Intersect(c)
+	}
+	return constraint, nil
+}
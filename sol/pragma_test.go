@@ -0,0 +1,75 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConstraint(t *testing.T) {
+	require := require.New(t)
+	tests := []struct {
+		expr  string
+		match []string
+		miss  []string
+	}{
+		{"0.5.9", []string{"0.5.9"}, []string{"0.5.8", "0.5.10"}},
+		{"=0.5.9", []string{"0.5.9"}, []string{"0.5.10"}},
+		{"^0.5.9", []string{"0.5.9", "0.5.17"}, []string{"0.5.8", "0.6.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0"}},
+		{"~0.5.9", []string{"0.5.9", "0.5.17"}, []string{"0.6.0"}},
+		{">=0.4.22 <0.6.0", []string{"0.4.22", "0.5.17"}, []string{"0.4.21", "0.6.0"}},
+		{">= 0.4.22 < 0.6.0", []string{"0.4.22"}, []string{"0.6.0"}},
+		{">0.4", []string{"0.5.0"}, []string{"0.4.26"}},
+		{"<=0.5", []string{"0.5.17"}, []string{"0.6.0"}},
+		{"0.5.x", []string{"0.5.0", "0.5.17"}, []string{"0.6.0"}},
+		{"0.5", []string{"0.5.3"}, []string{"0.4.26"}},
+		{"*", []string{"0.4.0", "0.8.19"}, nil},
+		{"0.4.0 - 0.5", []string{"0.4.0", "0.5.17"}, []string{"0.6.0"}},
+		{"^0.4.24 || ^0.8.0", []string{"0.4.26", "0.8.19"}, []string{"0.5.0"}},
+	}
+	for _, test := range tests {
+		c, err := ParseConstraint(test.expr)
+		require.NoError(err, test.expr)
+		for _, s := range test.match {
+			v, err := ParseVersion(s)
+			require.NoError(err)
+			require.True(c.Match(v), "%s must match %s", test.expr, s)
+		}
+		for _, s := range test.miss {
+			v, err := ParseVersion(s)
+			require.NoError(err)
+			require.False(c.Match(v), "%s must not match %s", test.expr, s)
+		}
+	}
+
+	_, err := ParseConstraint(">=0.4.a")
+	require.Error(err)
+	_, err = ParseConstraint("")
+	require.Error(err)
+}
+
+func TestPragmaConstraint(t *testing.T) {
+	require := require.New(t)
+	c, err := PragmaConstraint([]byte(`
+		// pragma solidity ^0.4.0;
+		/* pragma solidity 0.4.0; */
+		pragma solidity >=0.5.0;
+		pragma experimental ABIEncoderV2;
+		pragma solidity <0.7.0;
+		contract A {}`))
+	require.NoError(err)
+	require.True(c.Match(Version{0, 6, 12}))
+	require.False(c.Match(Version{0, 4, 26}))
+	require.False(c.Match(Version{0, 7, 0}))
+
+	c, err = PragmaConstraint([]byte(`contract A {}`))
+	require.NoError(err)
+	require.Nil(c)
+	require.True(c.Match(Version{0, 1, 0}))
+}
@@ -0,0 +1,195 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DependencyGraph is the graph of imports between Solidity source files, all paths
+// are slash-separated and relative to the project dir.
+type DependencyGraph struct {
+	// Files are the source files found in the project dir, sorted.
+	Files []string
+	// Imports maps the files to the files they import, these may be outside of Files,
+	// like the remapped dependencies. Imports that cannot be read are not included.
+	Imports map[string][]string
+}
+
+// ScanDependencies finds all the .sol files in dir and builds the graph of their imports.
+// Hidden directories and node_modules are not scanned, but the files imported from
+// there are a part of the graph.
+func ScanDependencies(dir string, remappings []Remapping) (*DependencyGraph, error) {
+	g := &DependencyGraph{
+		Imports: make(map[string][]string),
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".sol" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		g.Files = append(g.Files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("sol: failed to scan sources: %v", err)
+		return nil, err
+	}
+	sort.Strings(g.Files)
+	queue := append([]string{}, g.Files...)
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if _, ok := g.Imports[file]; ok {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			continue
+		}
+		imports := []string{}
+		for _, imp := range scanImports(src) {
+			resolved := resolveImport(remappings, file, imp)
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(resolved))); err != nil {
+				continue
+			}
+			imports = append(imports, resolved)
+			queue = append(queue, resolved)
+		}
+		g.Imports[file] = imports
+	}
+	return g, nil
+}
+
+// Roots returns the files that are not imported by any other file, sorted.
+func (g *DependencyGraph) Roots() []string {
+	imported := make(map[string]bool)
+	for _, imports := range g.Imports {
+		for _, imp := range imports {
+			imported[imp] = true
+		}
+	}
+	var roots []string
+	for _, file := range g.Files {
+		if !imported[file] {
+			roots = append(roots, file)
+		}
+	}
+	return roots
+}
+
+// Closure returns the file and all the files it imports directly or indirectly, sorted.
+func (g *DependencyGraph) Closure(file string) []string {
+	seen := map[string]bool{file: true}
+	queue := []string{file}
+	for len(queue) > 0 {
+		for _, imp := range g.Imports[queue[0]] {
+			if !seen[imp] {
+				seen[imp] = true
+				queue = append(queue, imp)
+			}
+		}
+		queue = queue[1:]
+	}
+	files := make([]string, 0, len(seen))
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Order returns all the files of the graph so that every file goes after the files
+// it imports. Solidity allows circular imports, a cycle is broken at the file
+// that comes first alphabetically.
+func (g *DependencyGraph) Order() []string {
+	var (
+		order   []string
+		visited = make(map[string]bool)
+		files   = make([]string, 0, len(g.Imports))
+	)
+	for file := range g.Imports {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	var visit func(file string)
+	visit = func(file string) {
+		if visited[file] {
+			return
+		}
+		visited[file] = true
+		imports := append([]string{}, g.Imports[file]...)
+		sort.Strings(imports)
+		for _, imp := range imports {
+			visit(imp)
+		}
+		order = append(order, file)
+	}
+	for _, file := range files {
+		visit(file)
+	}
+	return order
+}
+
+// CompileProject sets the remappings on the compiler and compiles all the contracts
+// of the .sol files found in dir, returning them keyed by fully qualified names
+// (path:Name). Each source file is compiled once, either on its own, or as an import
+// of a file compiled before: the files that are not imported by others go first.
+// Compilers from other packages return only the contracts with unique names.
+func CompileProject(c Compiler, dir string, remappings []Remapping, optimize int) (map[string]*Contract, error) {
+	g, err := ScanDependencies(dir, remappings)
+	if err != nil {
+		return nil, err
+	}
+	if !setRemappings(c, remappings) && len(remappings) > 0 {
+		err := fmt.Errorf("sol: compiler %T does not support remappings", c)
+		return nil, err
+	}
+	contracts := make(map[string]*Contract)
+	compiled := make(map[string]bool)
+	roots := g.Roots()
+	for _, file := range append(roots, g.Order()...) {
+		if compiled[file] || !isProjectFile(g, file) {
+			continue
+		}
+		compiled[file] = true
+		result, err := compileAll(c, dir, file, optimize)
+		if err == ErrNoContracts {
+			continue
+		} else if err != nil {
+			err = fmt.Errorf("%s: %v", file, err)
+			return nil, err
+		}
+		for _, contract := range result {
+			source := filepath.ToSlash(filepath.Clean(contract.SourcePath))
+			compiled[source] = true
+			contracts[source+":"+contract.Name] = contract
+		}
+	}
+	return contracts, nil
+}
+
+func isProjectFile(g *DependencyGraph, file string) bool {
+	i := sort.SearchStrings(g.Files, file)
+	return i < len(g.Files) && g.Files[i] == file
+}
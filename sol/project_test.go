@@ -0,0 +1,184 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanImports(t *testing.T) {
+	require := require.New(t)
+	imports := scanImports([]byte(`
+		import "./a.sol";
+		import './b.sol' as B;
+		import * as C from "@oz/c.sol";
+		import {D, E as F} from "../d.sol";
+		// import "commented.sol";
+		/* import "block.sol"; */
+		string constant s = "import \"string.sol\";";
+	`))
+	require.Equal([]string{"./a.sol", "./b.sol", "@oz/c.sol", "../d.sol"}, imports)
+}
+
+func TestRemap(t *testing.T) {
+	require := require.New(t)
+	remappings, err := ParseRemappings(`
+		@oz/=node_modules/@openzeppelin/
+		@oz/token/=vendor/token/
+		legacy/:@oz/=node_modules/@openzeppelin-2/
+	`)
+	require.NoError(err)
+	require.Len(remappings, 3)
+	require.Equal("legacy/", remappings[2].Context)
+	require.Equal("legacy/:@oz/=node_modules/@openzeppelin-2/", remappings[2].String())
+
+	require.Equal("node_modules/@openzeppelin/access/Ownable.sol",
+		Remap(remappings, "contracts/A.sol", "@oz/access/Ownable.sol"))
+	require.Equal("vendor/token/ERC20.sol",
+		Remap(remappings, "contracts/A.sol", "@oz/token/ERC20.sol"))
+	require.Equal("node_modules/@openzeppelin-2/token/ERC20.sol",
+		Remap(remappings, "legacy/A.sol", "@oz/token/ERC20.sol"))
+	require.Equal("other/X.sol", Remap(remappings, "contracts/A.sol", "other/X.sol"))
+
+	_, err = ParseRemapping("no-target")
+	require.Error(err)
+}
+
+func TestCompileProject(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "project")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	writeSource(dir, "contracts/Token.sol", `import "@oz/token/ERC20.sol"; import "./access/Ownable.sol";
+		contract Token is ERC20, Ownable {}`)
+	writeSource(dir, "contracts/Sale.sol", `import "./Token.sol"; contract Sale {}`)
+	writeSource(dir, "contracts/access/Ownable.sol", `contract Ownable {}`)
+	writeSource(dir, "contracts/Types.sol", `struct Point { uint x; uint y; }`)
+	writeSource(dir, "node_modules/@openzeppelin/token/ERC20.sol", `import "../access/Ownable.sol"; contract ERC20 {}`)
+	writeSource(dir, "node_modules/@openzeppelin/access/Ownable.sol", `contract Ownable {}`)
+	remappings, err := ParseRemappings("@oz/=node_modules/@openzeppelin/")
+	require.NoError(err)
+
+	g, err := ScanDependencies(dir, remappings)
+	require.NoError(err)
+	require.Equal([]string{
+		"contracts/Sale.sol",
+		"contracts/Token.sol",
+		"contracts/Types.sol",
+		"contracts/access/Ownable.sol",
+	}, g.Files)
+	require.Equal([]string{"contracts/Sale.sol", "contracts/Types.sol"}, g.Roots())
+	require.Equal([]string{
+		"contracts/Sale.sol",
+		"contracts/Token.sol",
+		"contracts/access/Ownable.sol",
+		"node_modules/@openzeppelin/access/Ownable.sol",
+		"node_modules/@openzeppelin/token/ERC20.sol",
+	}, g.Closure("contracts/Sale.sol"))
+	order := g.Order()
+	require.True(indexOf(order, "contracts/Token.sol") < indexOf(order, "contracts/Sale.sol"))
+	require.True(indexOf(order, "node_modules/@openzeppelin/token/ERC20.sol") < indexOf(order, "contracts/Token.sol"))
+
+	c := &fakeCompiler{}
+	contracts, err := CompileProject(c, dir, remappings, 0)
+	require.NoError(err)
+	require.Equal([]string{"contracts/Sale.sol", "contracts/Types.sol"}, c.compiled)
+	require.Equal(remappings, c.remappings)
+	require.Len(contracts, 5)
+	for _, id := range []string{
+		"contracts/Sale.sol:Sale",
+		"contracts/Token.sol:Token",
+		"contracts/access/Ownable.sol:Ownable",
+		"node_modules/@openzeppelin/access/Ownable.sol:Ownable",
+		"node_modules/@openzeppelin/token/ERC20.sol:ERC20",
+	} {
+		require.Contains(contracts, id)
+	}
+	// the compilers without the remappings support can't compile the remapped imports
+	_, err = CompileProject(struct{ Compiler }{c}, dir, remappings, 0)
+	require.EqualError(err, "sol: compiler struct { sol.Compiler } does not support remappings")
+}
+
+func TestStandardJSONRemappings(t *testing.T) {
+	require := require.New(t)
+	inputPath, cleanup := fakeSolc("greeter_output.json")
+	defer cleanup()
+
+	c, err := NewStandardJSONCompiler(fakeSolcPath())
+	require.NoError(err)
+	remappings, err := ParseRemappings("@oz/=node_modules/@openzeppelin/")
+	require.NoError(err)
+	_, err = c.(Remapper).SetRemappings(remappings).Compile("testdata", "greeter.sol", 0)
+	require.NoError(err)
+	data, err := ioutil.ReadFile(inputPath)
+	require.NoError(err)
+	require.Contains(string(data), `"remappings":["@oz/=node_modules/@openzeppelin/"]`)
+}
+
+// fakeCompiler outputs an empty contract for every contract declaration in the closure
+// of the compiled file, like solc does.
+type fakeCompiler struct {
+	remappings []Remapping
+	compiled   []string
+}
+
+var contractRx = regexp.MustCompile(`contract\s+(\w+)`)
+
+func (f *fakeCompiler) SetAllowPaths(paths []string) Compiler {
+	return f
+}
+
+func (f *fakeCompiler) SetRemappings(remappings []Remapping) Compiler {
+	f.remappings = remappings
+	return f
+}
+
+func (f *fakeCompiler) Compile(prefix, path string, optimize int) (map[string]*Contract, error) {
+	contracts, err := f.compileAll(prefix, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+	return contractsByName(path, contracts), nil
+}
+
+func (f *fakeCompiler) compileAll(prefix, path string, optimize int) ([]*Contract, error) {
+	f.compiled = append(f.compiled, path)
+	files, err := importClosure(prefix, path, f.remappings)
+	if err != nil {
+		return nil, err
+	}
+	var contracts []*Contract
+	for _, file := range files {
+		src, err := ioutil.ReadFile(filepath.Join(prefix, file))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range contractRx.FindAllStringSubmatch(string(src), -1) {
+			contracts = append(contracts, &Contract{
+				Name:       m[1],
+				SourcePath: file,
+			})
+		}
+	}
+	if len(contracts) == 0 {
+		return nil, ErrNoContracts
+	}
+	return contracts, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -26,6 +27,24 @@ type Contract struct {
 
 	ABI []byte
 	Bin string
+
+	// The fields below are set only by the standard JSON compiler and the artifact loaders.
+
+	RuntimeBin string
+	Metadata   string
+	// LinkReferences are keyed by fully qualified library names (path:Name).
+	LinkReferences        map[string][]LinkReference
+	RuntimeLinkReferences map[string][]LinkReference
+	MethodIdentifiers     map[string]string
+	SourceMap             string
+	RuntimeSourceMap      string
+	StorageLayout         json.RawMessage
+	DevDoc                json.RawMessage
+	UserDoc               json.RawMessage
+	Warnings              []Diagnostic
+
+	// Addresses are the known deployments, keyed by network or chain id.
+	Addresses map[string]common.Address
 }
 
 type Compiler interface {
@@ -33,33 +52,54 @@ type Compiler interface {
 	Compile(prefix, path string, optimize int) (map[string]*Contract, error)
 }
 
+// Remapper is implemented by the compilers supporting the import remappings,
+// which all the compilers of this package do.
+type Remapper interface {
+	SetRemappings(remappings []Remapping) Compiler
+}
+
+// setRemappings sets the remappings on the compiler, reporting whether it supports them.
+func setRemappings(c Compiler, remappings []Remapping) bool {
+	r, ok := c.(Remapper)
+	if ok {
+		r.SetRemappings(remappings)
+	}
+	return ok
+}
+
+// multiCompiler is implemented by the compilers of this package, unlike Compile
+// it returns all the contracts, even those with the same names from different sources.
+type multiCompiler interface {
+	compileAll(prefix, path string, optimize int) ([]*Contract, error)
+}
+
 func NewSolCompiler(solcPath string) (Compiler, error) {
-	s := &solCompiler{
-		solcPath: solcPath,
+	// exec.Cmd doesn't search $PATH, resolve the executable once
+	solcPath, err := exec.LookPath(solcPath)
+	if err != nil {
+		err = fmt.Errorf("solc: failed to find executable: %v", err)
+		return nil, err
 	}
-	if err := s.verify(); err != nil {
+	version, err := solcVersion(solcPath)
+	if err != nil {
 		return nil, err
 	}
+	s := &solCompiler{
+		solcPath: solcPath,
+		version:  version,
+	}
 	return s, nil
 }
 
 type solCompiler struct {
 	solcPath   string
+	version    string
 	allowPaths []string
+	remappings []Remapping
 }
 
-func (s *solCompiler) verify() error {
-	out, err := exec.Command(s.solcPath, "--version").CombinedOutput()
-	if err != nil {
-		err = fmt.Errorf("solc verify: failed to exec solc: %v", err)
-		return err
-	}
-	hasPrefix := strings.HasPrefix(string(out), "solc, the solidity compiler")
-	if !hasPrefix {
-		err := fmt.Errorf("solc verify: executable output was unexpected (output: %s)", out)
-		return err
-	}
-	return nil
+func (s *solCompiler) compilerVersion(prefix, path string) (string, error) {
+	return "combined-json " + s.version, nil
 }
 
 func (s *solCompiler) SetAllowPaths(paths []string) Compiler {
@@ -77,12 +117,29 @@ type solcOutput struct {
 	Version   string                  `json:"version"`
 }
 
+func (s *solCompiler) SetRemappings(remappings []Remapping) Compiler {
+	s.remappings = remappings
+	return s
+}
+
 func (s *solCompiler) Compile(prefix, path string, optimize int) (map[string]*Contract, error) {
+	contracts, err := s.compileAll(prefix, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+	return contractsByName(path, contracts), nil
+}
+
+func (s *solCompiler) compileAll(prefix, path string, optimize int) ([]*Contract, error) {
 	args := []string{s.solcPath}
 	if len(s.allowPaths) > 0 {
 		args = append(args, "--allow-paths", strings.Join(s.allowPaths, ","))
 	}
-	args = append(args, "--combined-json", "bin,abi", filepath.Join(prefix, path))
+	for _, r := range s.remappings {
+		args = append(args, r.String())
+	}
+	// the path is relative to the prefix, which is the working dir
+	args = append(args, "--combined-json", "bin,abi", path)
 	if optimize > 0 {
 		args = append(args, "--optimize", fmt.Sprintf("--optimize-runs=%d", optimize))
 	}
@@ -103,33 +160,72 @@ func (s *solCompiler) Compile(prefix, path string, optimize int) (map[string]*Co
 		return nil, err
 	}
 	if len(result.Contracts) == 0 {
-		err := errors.New("solc: no contracts compiled")
-		return nil, err
+		return nil, ErrNoContracts
 	}
-	contracts := make(map[string]*Contract, len(result.Contracts))
+	contracts := make([]*Contract, 0, len(result.Contracts))
 	for id, c := range result.Contracts {
-		idParts := strings.Split(id, ":")
-		if len(idParts) == 1 {
+		sep := strings.LastIndex(id, ":")
+		if sep < 0 {
 			err := fmt.Errorf("solc: found an unnamed contract in output: %s", id)
 			return nil, err
 		}
-		name := idParts[len(idParts)-1]
-		if err != nil {
-			err := fmt.Errorf("solc: failed to remarshal ABI: %v", err)
-			return nil, err
-		}
-		contracts[name] = &Contract{
-			Name:            name,
-			SourcePath:      idParts[0],
+		contracts = append(contracts, &Contract{
+			Name:            id[sep+1:],
+			SourcePath:      id[:sep],
 			CompilerVersion: result.Version,
 
 			ABI: []byte(c.ABI),
 			Bin: c.Bin,
-		}
+		})
 	}
+	sortContracts(contracts)
 	return contracts, nil
 }
 
+// compileAll returns all the contracts compiled by c, compilers from other packages
+// only return the contracts of Compile.
+func compileAll(c Compiler, prefix, path string, optimize int) ([]*Contract, error) {
+	if mc, ok := c.(multiCompiler); ok {
+		return mc.compileAll(prefix, path, optimize)
+	}
+	byName, err := c.Compile(prefix, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+	contracts := make([]*Contract, 0, len(byName))
+	for _, c := range byName {
+		contracts = append(contracts, c)
+	}
+	sortContracts(contracts)
+	return contracts, nil
+}
+
+// ErrNoContracts is returned when the compiled source has no contracts.
+var ErrNoContracts = errors.New("solc: no contracts compiled")
+
+// contractsByName maps the contracts by names, contracts of the source file
+// take precedence over the imported ones with the same name.
+func contractsByName(path string, contracts []*Contract) map[string]*Contract {
+	path = filepath.ToSlash(filepath.Clean(path))
+	byName := make(map[string]*Contract, len(contracts))
+	for _, c := range contracts {
+		if prev, ok := byName[c.Name]; ok && prev.SourcePath == path {
+			continue
+		}
+		byName[c.Name] = c
+	}
+	return byName
+}
+
+func sortContracts(contracts []*Contract) {
+	sort.Slice(contracts, func(i, j int) bool {
+		if contracts[i].SourcePath != contracts[j].SourcePath {
+			return contracts[i].SourcePath < contracts[j].SourcePath
+		}
+		return contracts[i].Name < contracts[j].Name
+	})
+}
+
 func WhichSolc() (string, error) {
 	out, err := exec.Command("which", "solc").Output()
 	if err != nil {
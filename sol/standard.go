@@ -0,0 +1,401 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Diagnostic is a warning or an error reported by the compiler.
+type Diagnostic struct {
+	Severity         string
+	Type             string
+	Component        string
+	Code             string
+	Message          string
+	FormattedMessage string
+	Location         *SourceLocation
+}
+
+func (d Diagnostic) String() string {
+	if len(d.FormattedMessage) > 0 {
+		return strings.TrimSpace(d.FormattedMessage)
+	}
+	if d.Location != nil {
+		return fmt.Sprintf("%s: %s: %s", d.Location, d.Type, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.Type, d.Message)
+}
+
+// SourceLocation points to a range in a source file, Start and End are byte offsets,
+// Line and Column are 1-based and set only if the file could be read.
+type SourceLocation struct {
+	File   string
+	Start  int
+	End    int
+	Line   int
+	Column int
+}
+
+func (l SourceLocation) String() string {
+	if l.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Column)
+	}
+	return l.File
+}
+
+// CompileError is returned when the compiler reported errors, it includes all
+// diagnostics of the compilation, warnings as well.
+type CompileError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *CompileError) Error() string {
+	var msgs []string
+	for _, d := range e.Diagnostics {
+		if d.Severity == "error" {
+			msgs = append(msgs, d.String())
+		}
+	}
+	return fmt.Sprintf("solc: compilation failed:\n%s", strings.Join(msgs, "\n"))
+}
+
+// Errors returns only the diagnostics with error severity.
+func (e *CompileError) Errors() []Diagnostic {
+	var errs []Diagnostic
+	for _, d := range e.Diagnostics {
+		if d.Severity == "error" {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// NewStandardJSONCompiler returns a Compiler that uses the 'solc --standard-json' interface,
+// the contracts it returns have all the output fields set. Compilation errors are
+// reported as *CompileError, warnings are set on each contract.
+func NewStandardJSONCompiler(solcPath string) (Compiler, error) {
+	// exec.Cmd doesn't search $PATH, resolve the executable once
+	solcPath, err := exec.LookPath(solcPath)
+	if err != nil {
+		err = fmt.Errorf("solc: failed to find executable: %v", err)
+		return nil, err
+	}
+	version, err := solcVersion(solcPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &standardCompiler{
+		solcPath: solcPath,
+		version:  version,
+	}
+	return s, nil
+}
+
+type standardCompiler struct {
+	solcPath   string
+	version    string
+	allowPaths []string
+	remappings []Remapping
+}
+
+func (s *standardCompiler) SetAllowPaths(paths []string) Compiler {
+	s.allowPaths = paths
+	return s
+}
+
+func (s *standardCompiler) SetRemappings(remappings []Remapping) Compiler {
+	s.remappings = remappings
+	return s
+}
+
+func (s *standardCompiler) compilerVersion(prefix, path string) (string, error) {
+	return "standard-json " + s.version, nil
+}
+
+// outputSelection lists all the outputs requested from solc for each contract.
+var outputSelection = []string{
+	"abi",
+	"metadata",
+	"devdoc",
+	"userdoc",
+	"storageLayout",
+	"evm.bytecode.object",
+	"evm.bytecode.sourceMap",
+	"evm.bytecode.linkReferences",
+	"evm.deployedBytecode.object",
+	"evm.deployedBytecode.sourceMap",
+	"evm.deployedBytecode.linkReferences",
+	"evm.methodIdentifiers",
+}
+
+type standardInput struct {
+	Language string                         `json:"language"`
+	Sources  map[string]standardInputSource `json:"sources"`
+	Settings standardSettings               `json:"settings"`
+}
+
+type standardInputSource struct {
+	Content string `json:"content"`
+}
+
+type standardSettings struct {
+	Remappings      []string                       `json:"remappings,omitempty"`
+	Optimizer       standardOptimizer              `json:"optimizer"`
+	OutputSelection map[string]map[string][]string `json:"outputSelection"`
+}
+
+type standardOptimizer struct {
+	Enabled bool `json:"enabled"`
+	Runs    int  `json:"runs,omitempty"`
+}
+
+type standardOutput struct {
+	Errors    []standardError                        `json:"errors"`
+	Contracts map[string]map[string]standardContract `json:"contracts"`
+}
+
+type standardError struct {
+	SourceLocation *struct {
+		File  string `json:"file"`
+		Start int    `json:"start"`
+		End   int    `json:"end"`
+	} `json:"sourceLocation"`
+	Type             string `json:"type"`
+	Component        string `json:"component"`
+	Severity         string `json:"severity"`
+	ErrorCode        string `json:"errorCode"`
+	Message          string `json:"message"`
+	FormattedMessage string `json:"formattedMessage"`
+}
+
+type standardContract struct {
+	ABI           json.RawMessage `json:"abi"`
+	Metadata      string          `json:"metadata"`
+	DevDoc        json.RawMessage `json:"devdoc"`
+	UserDoc       json.RawMessage `json:"userdoc"`
+	StorageLayout json.RawMessage `json:"storageLayout"`
+	EVM           struct {
+		Bytecode          standardBytecode  `json:"bytecode"`
+		DeployedBytecode  standardBytecode  `json:"deployedBytecode"`
+		MethodIdentifiers map[string]string `json:"methodIdentifiers"`
+	} `json:"evm"`
+}
+
+type standardBytecode struct {
+	Object         string                                `json:"object"`
+	SourceMap      string                                `json:"sourceMap"`
+	LinkReferences map[string]map[string][]LinkReference `json:"linkReferences"`
+}
+
+// linkReferences flattens the references, keying them by fully qualified library names.
+func (b standardBytecode) linkReferences() map[string][]LinkReference {
+	if len(b.LinkReferences) == 0 {
+		return nil
+	}
+	refs := make(map[string][]LinkReference)
+	for file, libs := range b.LinkReferences {
+		for lib, locations := range libs {
+			refs[file+":"+lib] = locations
+		}
+	}
+	return refs
+}
+
+func (s *standardCompiler) Compile(prefix, path string, optimize int) (map[string]*Contract, error) {
+	contracts, err := s.compileAll(prefix, path, optimize)
+	if err != nil {
+		return nil, err
+	}
+	return contractsByName(path, contracts), nil
+}
+
+func (s *standardCompiler) compileAll(prefix, path string, optimize int) ([]*Contract, error) {
+	src, err := ioutil.ReadFile(filepath.Join(prefix, path))
+	if err != nil {
+		err = fmt.Errorf("solc: failed to read source: %v", err)
+		return nil, err
+	}
+	input := standardInput{
+		Language: "Solidity",
+		Sources: map[string]standardInputSource{
+			path: {Content: string(src)},
+		},
+		Settings: standardSettings{
+			Remappings: remappingStrings(s.remappings),
+			Optimizer: standardOptimizer{
+				Enabled: optimize > 0,
+				Runs:    optimize,
+			},
+			OutputSelection: map[string]map[string][]string{
+				"*": {"*": outputSelection},
+			},
+		},
+	}
+	out, err := s.run(prefix, &input)
+	if err != nil {
+		return nil, err
+	}
+	var result standardOutput
+	if err := json.Unmarshal(out, &result); err != nil {
+		err = fmt.Errorf("solc: failed to unmarshal JSON output: %v", err)
+		return nil, err
+	}
+	diagnostics, failed := parseDiagnostics(prefix, result.Errors)
+	if failed {
+		return nil, &CompileError{
+			Diagnostics: diagnostics,
+		}
+	}
+	var contracts []*Contract
+	for sourcePath, sourceContracts := range result.Contracts {
+		for name, c := range sourceContracts {
+			contracts = append(contracts, &Contract{
+				Name:            name,
+				SourcePath:      sourcePath,
+				CompilerVersion: s.version,
+
+				ABI: []byte(c.ABI),
+				Bin: c.EVM.Bytecode.Object,
+
+				RuntimeBin: c.EVM.DeployedBytecode.Object,
+				Metadata:   c.Metadata,
+
+				LinkReferences:        c.EVM.Bytecode.linkReferences(),
+				RuntimeLinkReferences: c.EVM.DeployedBytecode.linkReferences(),
+
+				MethodIdentifiers: c.EVM.MethodIdentifiers,
+				SourceMap:         c.EVM.Bytecode.SourceMap,
+				RuntimeSourceMap:  c.EVM.DeployedBytecode.SourceMap,
+				StorageLayout:     c.StorageLayout,
+				DevDoc:            c.DevDoc,
+				UserDoc:           c.UserDoc,
+				Warnings:          warningsFor(sourcePath, diagnostics),
+			})
+		}
+	}
+	if len(contracts) == 0 {
+		return nil, ErrNoContracts
+	}
+	sortContracts(contracts)
+	return contracts, nil
+}
+
+func (s *standardCompiler) run(prefix string, input *standardInput) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		err = fmt.Errorf("solc: failed to marshal JSON input: %v", err)
+		return nil, err
+	}
+	args := []string{s.solcPath, "--standard-json"}
+	allowPaths := s.allowPaths
+	if len(prefix) > 0 {
+		allowPaths = append([]string{prefix}, allowPaths...)
+	}
+	if len(allowPaths) > 0 {
+		args = append(args, "--allow-paths", strings.Join(allowPaths, ","))
+	}
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd := exec.Cmd{
+		Path:   s.solcPath,
+		Args:   args,
+		Dir:    prefix,
+		Stdin:  bytes.NewReader(data),
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("solc: failed to compile contract: %v (stderr: %s)",
+			err, bytes.TrimSpace(stderr.Bytes()))
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseDiagnostics converts compiler errors into diagnostics, resolving line
+// and column numbers from the sources. Reports whether any of them is an error.
+func parseDiagnostics(prefix string, errs []standardError) ([]Diagnostic, bool) {
+	var failed bool
+	sources := make(map[string][]byte)
+	diagnostics := make([]Diagnostic, 0, len(errs))
+	for _, e := range errs {
+		d := Diagnostic{
+			Severity:         e.Severity,
+			Type:             e.Type,
+			Component:        e.Component,
+			Code:             e.ErrorCode,
+			Message:          e.Message,
+			FormattedMessage: e.FormattedMessage,
+		}
+		if e.Severity == "error" {
+			failed = true
+		}
+		if loc := e.SourceLocation; loc != nil {
+			d.Location = &SourceLocation{
+				File:  loc.File,
+				Start: loc.Start,
+				End:   loc.End,
+			}
+			src, ok := sources[loc.File]
+			if !ok {
+				src, _ = ioutil.ReadFile(filepath.Join(prefix, loc.File))
+				sources[loc.File] = src
+			}
+			if loc.Start >= 0 && loc.Start <= len(src) {
+				before := src[:loc.Start]
+				d.Location.Line = bytes.Count(before, []byte("\n")) + 1
+				d.Location.Column = loc.Start - (bytes.LastIndexByte(before, '\n') + 1) + 1
+			}
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics, failed
+}
+
+// warningsFor selects diagnostics related to the source file or to no file at all.
+func warningsFor(sourcePath string, diagnostics []Diagnostic) []Diagnostic {
+	var warnings []Diagnostic
+	for _, d := range diagnostics {
+		if d.Location == nil || d.Location.File == sourcePath {
+			warnings = append(warnings, d)
+		}
+	}
+	return warnings
+}
+
+func remappingStrings(remappings []Remapping) []string {
+	var strs []string
+	for _, r := range remappings {
+		strs = append(strs, r.String())
+	}
+	return strs
+}
+
+// solcVersion verifies the solc executable and returns its version string.
+func solcVersion(solcPath string) (string, error) {
+	out, err := exec.Command(solcPath, "--version").CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("solc verify: failed to exec solc: %v", err)
+		return "", err
+	}
+	if !strings.HasPrefix(string(out), "solc, the solidity compiler") {
+		err := fmt.Errorf("solc verify: executable output was unexpected (output: %s)", out)
+		return "", err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "Version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Version:")), nil
+		}
+	}
+	err = fmt.Errorf("solc verify: no version in output (output: %s)", out)
+	return "", err
+}
@@ -0,0 +1,107 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandardJSONCompile(t *testing.T) {
+	require := require.New(t)
+	inputPath, cleanup := fakeSolc("greeter_output.json")
+	defer cleanup()
+
+	c, err := NewStandardJSONCompiler(fakeSolcPath())
+	require.NoError(err)
+	contracts, err := c.Compile("testdata", "greeter.sol", 200)
+	require.NoError(err)
+	require.Len(contracts, 2)
+
+	greeter := contracts["Greeter"]
+	require.NotNil(greeter)
+	require.Equal("Greeter", greeter.Name)
+	require.Equal("greeter.sol", greeter.SourcePath)
+	require.Equal("0.5.9+commit.e560f70d.Linux.g++", greeter.CompilerVersion)
+	require.Equal("608060405234801561001057600080fd5b50", greeter.Bin)
+	require.Equal("608060405260043610603f57", greeter.RuntimeBin)
+	require.Equal("197:233:0:-;;;", greeter.SourceMap)
+	require.Equal(map[string]string{
+		"greet()": "cfae3217",
+		"kill()":  "41c0e1b5",
+	}, greeter.MethodIdentifiers)
+	require.Contains(greeter.Metadata, "0.5.9+commit.e560f70d")
+	require.Contains(string(greeter.UserDoc), "Returns the greeting.")
+	require.Contains(string(contracts["Mortal"].StorageLayout), "t_address_payable")
+
+	require.Len(greeter.Warnings, 1)
+	warning := greeter.Warnings[0]
+	require.Equal("warning", warning.Severity)
+	require.NotNil(warning.Location)
+	require.Equal("greeter.sol", warning.Location.File)
+	require.Equal(18, warning.Location.Line)
+	require.Equal(5, warning.Location.Column)
+
+	data, err := ioutil.ReadFile(inputPath)
+	require.NoError(err)
+	var input standardInput
+	require.NoError(json.Unmarshal(data, &input))
+	require.Equal("Solidity", input.Language)
+	require.Contains(input.Sources, "greeter.sol")
+	require.Contains(input.Sources["greeter.sol"].Content, "contract Greeter is Mortal")
+	require.True(input.Settings.Optimizer.Enabled)
+	require.Equal(200, input.Settings.Optimizer.Runs)
+	require.Equal(outputSelection, input.Settings.OutputSelection["*"]["*"])
+}
+
+func TestStandardJSONCompileErrors(t *testing.T) {
+	require := require.New(t)
+	_, cleanup := fakeSolc("greeter_errors.json")
+	defer cleanup()
+
+	c, err := NewStandardJSONCompiler(fakeSolcPath())
+	require.NoError(err)
+	_, err = c.Compile("testdata", "greeter.sol", 0)
+	require.Error(err)
+	compileErr, ok := err.(*CompileError)
+	require.True(ok)
+	require.Len(compileErr.Diagnostics, 2)
+	errs := compileErr.Errors()
+	require.Len(errs, 1)
+	require.Equal("TypeError", errs[0].Type)
+	require.Equal(8, errs[0].Location.Line)
+	require.Equal(5, errs[0].Location.Column)
+	require.Contains(err.Error(), "Undeclared identifier")
+	require.NotContains(err.Error(), "pre-release")
+}
+
+// fakeSolc sets up the fake solc script to output the given testdata file,
+// returns the path where the script saves its input.
+func fakeSolc(output string) (inputPath string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "fakesolc")
+	orPanic(err)
+	inputPath = filepath.Join(dir, "input.json")
+	outputPath, err := filepath.Abs(filepath.Join("testdata", output))
+	orPanic(err)
+	os.Setenv("FAKESOLC_INPUT", inputPath)
+	os.Setenv("FAKESOLC_OUTPUT", outputPath)
+	cleanup = func() {
+		os.Unsetenv("FAKESOLC_INPUT")
+		os.Unsetenv("FAKESOLC_OUTPUT")
+		os.RemoveAll(dir)
+	}
+	return inputPath, cleanup
+}
+
+func fakeSolcPath() string {
+	path, err := filepath.Abs(filepath.Join("testdata", "fakesolc.sh"))
+	orPanic(err)
+	return path
+}
@@ -0,0 +1,10 @@
+#!/bin/sh
+# A stand-in for solc used by tests: prints a canned JSON output from $FAKESOLC_OUTPUT
+# and saves the standard JSON input into $FAKESOLC_INPUT.
+if [ "$1" = "--version" ]; then
+	echo "solc, the solidity compiler commandline interface"
+	echo "Version: ${FAKESOLC_VERSION:-0.5.9+commit.e560f70d.Linux.g++}"
+	exit 0
+fi
+cat > "${FAKESOLC_INPUT:-/dev/null}"
+cat "$FAKESOLC_OUTPUT"
@@ -0,0 +1,21 @@
+pragma solidity ^0.5.0;
+
+contract Mortal {
+    address payable owner;
+
+    constructor() public { owner = msg.sender; }
+
+    function kill() public { if (msg.sender == owner) selfdestruct(owner); }
+}
+
+contract Greeter is Mortal {
+    string greeting;
+
+    constructor(string memory _greeting) public {
+        greeting = _greeting;
+    }
+
+    function greet() public view returns (string memory) {
+        return greeting;
+    }
+}
@@ -0,0 +1,238 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SolcInstall is a solc executable of a known version.
+type SolcInstall struct {
+	Version Version
+	Path    string
+}
+
+// FindSolcVersions lists the solc executables installed in dir, sorted by version.
+// Executables must be named by their version, e.g. "0.5.9", "solc-0.5.9" or
+// "solc-linux-amd64-v0.8.19+commit.7dd6d404", or be named "solc" in a directory
+// named by the version.
+func FindSolcVersions(dir string) ([]SolcInstall, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		err = fmt.Errorf("solc: failed to list compilers: %v", err)
+		return nil, err
+	}
+	var installs []SolcInstall
+	for _, info := range infos {
+		v, err := ParseVersion(info.Name())
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() {
+			path = filepath.Join(path, "solc")
+			if info, err = os.Stat(path); err != nil || info.IsDir() {
+				continue
+			}
+		}
+		if info.Mode()&0111 == 0 {
+			continue
+		}
+		installs = append(installs, SolcInstall{
+			Version: v,
+			Path:    path,
+		})
+	}
+	sort.SliceStable(installs, func(i, j int) bool {
+		return installs[i].Version.Compare(installs[j].Version) < 0
+	})
+	return installs, nil
+}
+
+// NoMatchingSolcError is returned when none of the installed compilers satisfies
+// the version constraints of a source file.
+type NoMatchingSolcError struct {
+	Source     string
+	Constraint *Constraint
+	Installed  []Version
+}
+
+func (e *NoMatchingSolcError) Error() string {
+	installed := make([]string, 0, len(e.Installed))
+	for _, v := range e.Installed {
+		installed = append(installed, v.String())
+	}
+	if len(installed) == 0 {
+		installed = append(installed, "none")
+	}
+	return fmt.Sprintf("solc: no installed compiler satisfies 'pragma solidity %s' of %s (installed: %s)",
+		e.Constraint, e.Source, strings.Join(installed, ", "))
+}
+
+// SelectSolc picks the latest installed compiler satisfying the constraint.
+func SelectSolc(installs []SolcInstall, c *Constraint) (SolcInstall, bool) {
+	for i := len(installs) - 1; i >= 0; i-- {
+		if c.Match(installs[i].Version) {
+			return installs[i], true
+		}
+	}
+	return SolcInstall{}, false
+}
+
+// SourceConstraint returns the intersection of the 'pragma solidity' constraints of
+// the source file and of all the files it imports.
+func SourceConstraint(prefix, path string, remappings []Remapping) (*Constraint, error) {
+	files, err := importClosure(prefix, path, remappings)
+	if err != nil {
+		err = fmt.Errorf("solc: failed to read source: %v", err)
+		return nil, err
+	}
+	var constraint *Constraint
+	for _, file := range files {
+		src, err := ioutil.ReadFile(filepath.Join(prefix, filepath.FromSlash(file)))
+		if err != nil {
+			err = fmt.Errorf("solc: failed to read source: %v", err)
+			return nil, err
+		}
+		c, err := PragmaConstraint(src)
+		if err != nil {
+			err = fmt.Errorf("solc: %s: %v", file, err)
+			return nil, err
+		}
+		constraint = constraint.Intersect(c)
+	}
+	return constraint, nil
+}
+
+// NewVersionedCompiler returns a Compiler that compiles each source file with the latest
+// compiler from dir satisfying its pragma, see FindSolcVersions for the naming of
+// executables. The newCompiler func creates a compiler for the selected executable,
+// if nil, NewSolCompiler is used.
+func NewVersionedCompiler(dir string, newCompiler func(solcPath string) (Compiler, error)) (Compiler, error) {
+	if newCompiler == nil {
+		newCompiler = NewSolCompiler
+	}
+	if _, err := FindSolcVersions(dir); err != nil {
+		return nil, err
+	}
+	v := &versionedCompiler{
+		dir:         dir,
+		newCompiler: newCompiler,
+		compilers:   make(map[string]Compiler),
+		mux:         new(sync.Mutex),
+	}
+	return v, nil
+}
+
+type versionedCompiler struct {
+	dir         string
+	newCompiler func(solcPath string) (Compiler, error)
+	allowPaths  []string
+	remappings  []Remapping
+
+	compilers map[string]Compiler
+	mux       *sync.Mutex
+}
+
+func (v *versionedCompiler) SetAllowPaths(paths []string) Compiler {
+	v.mux.Lock()
+	v.allowPaths = paths
+	for _, c := range v.compilers {
+		c.SetAllowPaths(paths)
+	}
+	v.mux.Unlock()
+	return v
+}
+
+func (v *versionedCompiler) SetRemappings(remappings []Remapping) Compiler {
+	v.mux.Lock()
+	v.remappings = remappings
+	for _, c := range v.compilers {
+		setRemappings(c, remappings)
+	}
+	v.mux.Unlock()
+	return v
+}
+
+// Resolve selects the compiler for the source file.
+func (v *versionedCompiler) Resolve(prefix, path string) (SolcInstall, error) {
+	v.mux.Lock()
+	remappings := v.remappings
+	v.mux.Unlock()
+	constraint, err := SourceConstraint(prefix, path, remappings)
+	if err != nil {
+		return SolcInstall{}, err
+	}
+	installs, err := FindSolcVersions(v.dir)
+	if err != nil {
+		return SolcInstall{}, err
+	}
+	install, ok := SelectSolc(installs, constraint)
+	if !ok {
+		err := &NoMatchingSolcError{
+			Source:     path,
+			Constraint: constraint,
+		}
+		for _, i := range installs {
+			err.Installed = append(err.Installed, i.Version)
+		}
+		return SolcInstall{}, err
+	}
+	return install, nil
+}
+
+func (v *versionedCompiler) Compile(prefix, path string, optimize int) (map[string]*Contract, error) {
+	c, err := v.compilerFor(prefix, path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Compile(prefix, path, optimize)
+}
+
+func (v *versionedCompiler) compileAll(prefix, path string, optimize int) ([]*Contract, error) {
+	c, err := v.compilerFor(prefix, path)
+	if err != nil {
+		return nil, err
+	}
+	return compileAll(c, prefix, path, optimize)
+}
+
+func (v *versionedCompiler) compilerVersion(prefix, path string) (string, error) {
+	c, err := v.compilerFor(prefix, path)
+	if err != nil {
+		return "", err
+	}
+	return compilerVersion(c, prefix, path)
+}
+
+// compilerFor returns the compiler for the selected executable, creating it if needed.
+func (v *versionedCompiler) compilerFor(prefix, path string) (Compiler, error) {
+	install, err := v.Resolve(prefix, path)
+	if err != nil {
+		return nil, err
+	}
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	c, ok := v.compilers[install.Path]
+	if !ok {
+		if c, err = v.newCompiler(install.Path); err != nil {
+			return nil, err
+		}
+		if len(v.allowPaths) > 0 {
+			c.SetAllowPaths(v.allowPaths)
+		}
+		if len(v.remappings) > 0 {
+			setRemappings(c, v.remappings)
+		}
+		v.compilers[install.Path] = c
+	}
+	return c, nil
+}
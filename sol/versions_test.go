@@ -0,0 +1,76 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package sol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionedCompiler(t *testing.T) {
+	require := require.New(t)
+	_, cleanup := fakeSolc("greeter_output.json")
+	defer cleanup()
+	dir := installFakeSolcs("solc-0.4.24", "solc-linux-amd64-v0.5.9+commit.e560f70d", "0.5.17/solc", "0.8.19", "README")
+	defer os.RemoveAll(dir)
+
+	installs, err := FindSolcVersions(dir)
+	require.NoError(err)
+	require.Len(installs, 4)
+	require.Equal(Version{0, 4, 24}, installs[0].Version)
+	require.Equal(Version{0, 8, 19}, installs[3].Version)
+
+	c, err := NewVersionedCompiler(dir, NewStandardJSONCompiler)
+	require.NoError(err)
+	install, err := c.(*versionedCompiler).Resolve("testdata", "greeter.sol")
+	require.NoError(err)
+	require.Equal(Version{0, 5, 17}, install.Version)
+	require.Equal(filepath.Join(dir, "0.5.17", "solc"), install.Path)
+
+	contracts, err := c.Compile("testdata", "greeter.sol", 0)
+	require.NoError(err)
+	require.Contains(contracts, "Greeter")
+	require.Equal("0.5.17", contracts["Greeter"].CompilerVersion)
+}
+
+func TestVersionedCompilerNoMatch(t *testing.T) {
+	require := require.New(t)
+	dir := installFakeSolcs("solc-0.4.24", "solc-0.8.19")
+	defer os.RemoveAll(dir)
+
+	c, err := NewVersionedCompiler(dir, NewStandardJSONCompiler)
+	require.NoError(err)
+	_, err = c.Compile("testdata", "greeter.sol", 0)
+	require.Error(err)
+	noMatch, ok := err.(*NoMatchingSolcError)
+	require.True(ok)
+	require.Equal("greeter.sol", noMatch.Source)
+	require.Equal([]Version{{0, 4, 24}, {0, 8, 19}}, noMatch.Installed)
+	require.Contains(err.Error(), "^0.5.0")
+}
+
+// installFakeSolcs creates a temporary directory with the fake solc installed
+// under the given names, each reporting the version from its name.
+func installFakeSolcs(names ...string) string {
+	dir, err := ioutil.TempDir("", "solcs")
+	orPanic(err)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		orPanic(os.MkdirAll(filepath.Dir(path), 0755))
+		v, err := ParseVersion(name)
+		if err != nil {
+			orPanic(ioutil.WriteFile(path, []byte("not a compiler"), 0644))
+			continue
+		}
+		script := fmt.Sprintf("#!/bin/sh\nFAKESOLC_VERSION=%s exec %s \"$@\"\n", v, fakeSolcPath())
+		orPanic(ioutil.WriteFile(path, []byte(script), 0755))
+	}
+	return dir
+}
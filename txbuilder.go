@@ -0,0 +1,482 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/holiman/uint256"
+)
+
+// TxBackend is the node filling the missing fields of the transactions built with TxBuilder.
+type TxBackend interface {
+	bind.ContractTransactor
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// TxBuilder builds the legacy, EIP-2930 access list, EIP-1559 dynamic fee and EIP-4844 blob
+// transactions. The setters are chained, their errors are returned by Build. The transaction
+// type is the one set with Type, or the one the fields set require: the blobs make a blob
+// transaction, the fee caps a dynamic fee one, the access list with the gas price an access
+// list one. Otherwise Fill picks the dynamic fee transaction if the chain has the base fee.
+type TxBuilder struct {
+	txType     int
+	from       common.Address
+	to         *common.Address
+	nonce      *uint64
+	value      *big.Int
+	data       []byte
+	gas        uint64
+	gasPrice   *big.Int
+	tipCap     *big.Int
+	feeCap     *big.Int
+	accessList types.AccessList
+	blobFeeCap *big.Int
+	sidecar    *types.BlobTxSidecar
+	chainID    *big.Int
+	err        error
+}
+
+// NewTxBuilder starts the transaction sent from the account.
+func NewTxBuilder(from common.Address) *TxBuilder {
+	return &TxBuilder{
+		txType: -1,
+		from:   from,
+	}
+}
+
+// Type sets the transaction type, e.g. types.DynamicFeeTxType.
+func (b *TxBuilder) Type(txType uint8) *TxBuilder {
+	switch txType {
+	case types.LegacyTxType, types.AccessListTxType, types.DynamicFeeTxType, types.BlobTxType:
+		b.txType = int(txType)
+	default:
+		b.fail(fmt.Errorf("unsupported transaction type %d", txType))
+	}
+	return b
+}
+
+// To sets the recipient, the transactions without one create contracts.
+func (b *TxBuilder) To(to common.Address) *TxBuilder {
+	b.to = &to
+	return b
+}
+
+func (b *TxBuilder) Nonce(nonce uint64) *TxBuilder {
+	b.nonce = &nonce
+	return b
+}
+
+func (b *TxBuilder) Value(value *big.Int) *TxBuilder {
+	b.value = value
+	return b
+}
+
+func (b *TxBuilder) Data(data []byte) *TxBuilder {
+	b.data = data
+	return b
+}
+
+// Gas sets the gas limit, estimated by Fill if not set.
+func (b *TxBuilder) Gas(gas uint64) *TxBuilder {
+	b.gas = gas
+	return b
+}
+
+// GasPrice sets the gas price of the legacy and access list transactions.
+func (b *TxBuilder) GasPrice(price *big.Int) *TxBuilder {
+	b.gasPrice = price
+	return b
+}
+
+// FeeCaps sets the priority fee and the max fee per gas of the dynamic fee and blob
+// transactions.
+func (b *TxBuilder) FeeCaps(tipCap, feeCap *big.Int) *TxBuilder {
+	b.tipCap = tipCap
+	b.feeCap = feeCap
+	return b
+}
+
+func (b *TxBuilder) AccessList(list types.AccessList) *TxBuilder {
+	b.accessList = list
+	return b
+}
+
+// BlobFeeCap sets the max fee per blob gas of the blob transaction.
+func (b *TxBuilder) BlobFeeCap(feeCap *big.Int) *TxBuilder {
+	b.blobFeeCap = feeCap
+	return b
+}
+
+// Blobs attaches the blobs to the transaction, computing their KZG commitments and proofs.
+func (b *TxBuilder) Blobs(blobs ...kzg4844.Blob) *TxBuilder {
+	sidecar := &types.BlobTxSidecar{
+		Blobs: blobs,
+	}
+	for i := range blobs {
+		commitment, err := kzg4844.BlobToCommitment(&blobs[i])
+		if err != nil {
+			b.fail(fmt.Errorf("failed to commit to blob %d: %v", i, err))
+			return b
+		}
+		proof, err := kzg4844.ComputeBlobProof(&blobs[i], commitment)
+		if err != nil {
+			b.fail(fmt.Errorf("failed to prove blob %d: %v", i, err))
+			return b
+		}
+		sidecar.Commitments = append(sidecar.Commitments, commitment)
+		sidecar.Proofs = append(sidecar.Proofs, proof)
+	}
+	return b.Sidecar(sidecar)
+}
+
+// Sidecar attaches the blobs with their precomputed commitments and proofs.
+func (b *TxBuilder) Sidecar(sidecar *types.BlobTxSidecar) *TxBuilder {
+	b.sidecar = sidecar
+	return b
+}
+
+// ChainID sets the chain the transaction is signed for. The legacy transactions without
+// one are not protected from replays on the other chains.
+func (b *TxBuilder) ChainID(chainID *big.Int) *TxBuilder {
+	b.chainID = chainID
+	return b
+}
+
+func (b *TxBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// kind returns the transaction type, -1 if it's not known until Fill.
+func (b *TxBuilder) kind() int {
+	switch {
+	case b.txType >= 0:
+		return b.txType
+	case b.sidecar != nil || b.blobFeeCap != nil:
+		return types.BlobTxType
+	case b.tipCap != nil || b.feeCap != nil:
+		return types.DynamicFeeTxType
+	case b.gasPrice != nil && b.accessList != nil:
+		return types.AccessListTxType
+	case b.gasPrice != nil:
+		return types.LegacyTxType
+	}
+	return -1
+}
+
+// Fill takes the missing chain ID, nonce, fees and gas limit from the node. The dynamic fee
+// transactions get the suggested priority fee and the max fee of twice the base fee above it,
+// the blob ones twice the blob base fee too.
+func (b *TxBuilder) Fill(ctx context.Context, client TxBackend) error {
+	if b.err != nil {
+		return b.err
+	}
+	var err error
+	if b.chainID == nil {
+		if b.chainID, err = client.ChainID(ctx); err != nil {
+			err = fmt.Errorf("failed to get chain ID: %v", err)
+			return err
+		}
+	}
+	if b.nonce == nil {
+		nonce, err := client.PendingNonceAt(ctx, b.from)
+		if err != nil {
+			err = fmt.Errorf("failed to retrieve account nonce: %v", err)
+			return err
+		}
+		b.nonce = &nonce
+	}
+	txType := b.kind()
+	var head *types.Header
+	if txType != types.LegacyTxType && txType != types.AccessListTxType {
+		if head, err = client.HeaderByNumber(ctx, nil); err != nil {
+			err = fmt.Errorf("failed to get the latest block: %v", err)
+			return err
+		}
+	}
+	if txType < 0 {
+		switch {
+		case head.BaseFee != nil:
+			txType = types.DynamicFeeTxType
+		case b.accessList != nil:
+			txType = types.AccessListTxType
+		default:
+			txType = types.LegacyTxType
+		}
+		b.txType = txType
+	}
+	switch txType {
+	case types.LegacyTxType, types.AccessListTxType:
+		if b.gasPrice == nil {
+			if b.gasPrice, err = client.SuggestGasPrice(ctx); err != nil {
+				err = fmt.Errorf("failed to suggest gas price: %v", err)
+				return err
+			}
+		}
+	case types.DynamicFeeTxType, types.BlobTxType:
+		if head.BaseFee == nil {
+			err = errors.New("the chain does not support dynamic fee transactions")
+			return err
+		}
+		if b.tipCap == nil {
+			if b.tipCap, err = client.SuggestGasTipCap(ctx); err != nil {
+				err = fmt.Errorf("failed to suggest gas tip cap: %v", err)
+				return err
+			}
+		}
+		if b.feeCap == nil {
+			b.feeCap = new(big.Int).Add(b.tipCap, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+		}
+	}
+	if txType == types.BlobTxType && b.blobFeeCap == nil {
+		if head.ExcessBlobGas == nil {
+			err = errors.New("the chain does not support blob transactions")
+			return err
+		}
+		blobFee := eip4844.CalcBlobFee(*head.ExcessBlobGas)
+		b.blobFeeCap = new(big.Int).Mul(blobFee, big.NewInt(2))
+	}
+	if b.gas == 0 {
+		msg := ethereum.CallMsg{
+			From:       b.from,
+			To:         b.to,
+			Value:      b.value,
+			Data:       b.data,
+			GasPrice:   b.gasPrice,
+			GasTipCap:  b.tipCap,
+			GasFeeCap:  b.feeCap,
+			AccessList: b.accessList,
+		}
+		if txType == types.BlobTxType {
+			msg.BlobGasFeeCap = b.blobFeeCap
+			if b.sidecar != nil {
+				msg.BlobHashes = b.sidecar.BlobHashes()
+			}
+		}
+		if b.gas, err = client.EstimateGas(ctx, msg); err != nil {
+			err = fmt.Errorf("failed to estimate gas needed: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Build returns the unsigned transaction, all its fields must be set or filled.
+func (b *TxBuilder) Build() (*types.Transaction, error) {
+	if b.err != nil {
+		return nil, b.err
+	} else if b.nonce == nil {
+		err := errors.New("transaction nonce is not set")
+		return nil, err
+	} else if b.gas == 0 {
+		err := errors.New("transaction gas limit is not set")
+		return nil, err
+	}
+	value := b.value
+	if value == nil {
+		value = new(big.Int)
+	}
+	txType := b.kind()
+	if txType < 0 {
+		txType = types.LegacyTxType
+		if b.accessList != nil {
+			txType = types.AccessListTxType
+		}
+	}
+	if txType != types.LegacyTxType && b.chainID == nil {
+		err := fmt.Errorf("chain ID is required for transaction type %d", txType)
+		return nil, err
+	}
+	switch txType {
+	case types.LegacyTxType, types.AccessListTxType:
+		if b.gasPrice == nil {
+			err := errors.New("transaction gas price is not set")
+			return nil, err
+		}
+	default:
+		if b.tipCap == nil || b.feeCap == nil {
+			err := errors.New("transaction fee caps are not set")
+			return nil, err
+		}
+	}
+
+	switch txType {
+	case types.LegacyTxType:
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    *b.nonce,
+			GasPrice: b.gasPrice,
+			Gas:      b.gas,
+			To:       b.to,
+			Value:    value,
+			Data:     b.data,
+		}), nil
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    b.chainID,
+			Nonce:      *b.nonce,
+			GasPrice:   b.gasPrice,
+			Gas:        b.gas,
+			To:         b.to,
+			Value:      value,
+			Data:       b.data,
+			AccessList: b.accessList,
+		}), nil
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    b.chainID,
+			Nonce:      *b.nonce,
+			GasTipCap:  b.tipCap,
+			GasFeeCap:  b.feeCap,
+			Gas:        b.gas,
+			To:         b.to,
+			Value:      value,
+			Data:       b.data,
+			AccessList: b.accessList,
+		}), nil
+	}
+
+	if b.to == nil {
+		err := errors.New("blob transaction must have a recipient")
+		return nil, err
+	} else if b.sidecar == nil || len(b.sidecar.Blobs) == 0 {
+		err := errors.New("blob transaction must have blobs")
+		return nil, err
+	} else if b.blobFeeCap == nil {
+		err := errors.New("transaction blob fee cap is not set")
+		return nil, err
+	}
+	blobTx := &types.BlobTx{
+		Nonce:      *b.nonce,
+		Gas:        b.gas,
+		To:         *b.to,
+		Data:       b.data,
+		AccessList: b.accessList,
+		BlobHashes: b.sidecar.BlobHashes(),
+		Sidecar:    b.sidecar,
+	}
+	for _, field := range []struct {
+		name string
+		dst  **uint256.Int
+		src  *big.Int
+	}{
+		{"chain ID", &blobTx.ChainID, b.chainID},
+		{"value", &blobTx.Value, value},
+		{"gas tip cap", &blobTx.GasTipCap, b.tipCap},
+		{"gas fee cap", &blobTx.GasFeeCap, b.feeCap},
+		{"blob fee cap", &blobTx.BlobFeeCap, b.blobFeeCap},
+	} {
+		v, overflow := uint256.FromBig(field.src)
+		if overflow || field.src.Sign() < 0 {
+			err := fmt.Errorf("transaction %s %s is out of range", field.name, field.src)
+			return nil, err
+		}
+		*field.dst = v
+	}
+	return types.NewTx(blobTx), nil
+}
+
+// Sign builds the transaction and signs it with the key of the sender from the cache.
+func (b *TxBuilder) Sign(keys KeyCache, password string) (*types.Transaction, error) {
+	tx, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys.PrivateKey(b.from, password)
+	if !ok {
+		err = fmt.Errorf("no key to sign the transaction of %s", b.from.Hex())
+		return nil, err
+	}
+	return types.SignTx(tx, types.LatestSignerForChainID(b.chainID), key)
+}
+
+// RawTx returns the signed transaction encoded for eth_sendRawTransaction, the blob
+// transactions are encoded with their blobs.
+func RawTx(tx *types.Transaction) ([]byte, error) {
+	return tx.MarshalBinary()
+}
+
+// DecodedTx is the readable form of a raw signed transaction, for the audit logs.
+type DecodedTx struct {
+	Type       string           `json:"type"`
+	Hash       common.Hash      `json:"hash"`
+	ChainID    *hexutil.Big     `json:"chainId,omitempty"`
+	From       common.Address   `json:"from"`
+	To         *common.Address  `json:"to"`
+	Nonce      hexutil.Uint64   `json:"nonce"`
+	Value      *hexutil.Big     `json:"value"`
+	Gas        hexutil.Uint64   `json:"gas"`
+	GasPrice   *hexutil.Big     `json:"gasPrice,omitempty"`
+	GasTipCap  *hexutil.Big     `json:"maxPriorityFeePerGas,omitempty"`
+	GasFeeCap  *hexutil.Big     `json:"maxFeePerGas,omitempty"`
+	AccessList types.AccessList `json:"accessList,omitempty"`
+	BlobFeeCap *hexutil.Big     `json:"maxFeePerBlobGas,omitempty"`
+	BlobHashes []common.Hash    `json:"blobVersionedHashes,omitempty"`
+	Blobs      int              `json:"blobs,omitempty"`
+	Data       hexutil.Bytes    `json:"data"`
+}
+
+var txTypeNames = map[uint8]string{
+	types.LegacyTxType:     "legacy",
+	types.AccessListTxType: "access list",
+	types.DynamicFeeTxType: "dynamic fee",
+	types.BlobTxType:       "blob",
+}
+
+// DecodeRawTx decodes the raw signed transaction, recovering its sender.
+func DecodeRawTx(raw []byte) (*DecodedTx, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		err = fmt.Errorf("failed to decode transaction: %v", err)
+		return nil, err
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		err = fmt.Errorf("failed to recover transaction sender: %v", err)
+		return nil, err
+	}
+	decoded := &DecodedTx{
+		Type:  txTypeNames[tx.Type()],
+		Hash:  tx.Hash(),
+		From:  from,
+		To:    tx.To(),
+		Nonce: hexutil.Uint64(tx.Nonce()),
+		Value: (*hexutil.Big)(tx.Value()),
+		Gas:   hexutil.Uint64(tx.Gas()),
+		Data:  tx.Data(),
+	}
+	if tx.Protected() {
+		decoded.ChainID = (*hexutil.Big)(tx.ChainId())
+	}
+	switch tx.Type() {
+	case types.LegacyTxType, types.AccessListTxType:
+		decoded.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	default:
+		decoded.GasTipCap = (*hexutil.Big)(tx.GasTipCap())
+		decoded.GasFeeCap = (*hexutil.Big)(tx.GasFeeCap())
+	}
+	if tx.Type() != types.LegacyTxType {
+		decoded.AccessList = tx.AccessList()
+	}
+	if tx.Type() == types.BlobTxType {
+		decoded.BlobFeeCap = (*hexutil.Big)(tx.BlobGasFeeCap())
+		decoded.BlobHashes = tx.BlobHashes()
+		if sidecar := tx.BlobTxSidecar(); sidecar != nil {
+			decoded.Blobs = len(sidecar.Blobs)
+		}
+	}
+	return decoded, nil
+}
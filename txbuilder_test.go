@@ -0,0 +1,174 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AtlantPlatform/ethfw/internal/rpctest"
+)
+
+func TestTxBuilder(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	sim := backends.NewSimulatedBackend(types.GenesisAlloc{
+		from: {Balance: big.NewInt(1e18)},
+	}, 8000000)
+	defer sim.Close()
+	ctx := context.Background()
+	to := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	gasPrice := big.NewInt(10e9)
+
+	// the chain has the base fee, so the dynamic fee transaction is built by default
+	b := NewTxBuilder(from).To(to).Value(big.NewInt(1))
+	require.NoError(b.Fill(ctx, sim))
+	tx, err := b.Sign(keys, "")
+	require.NoError(err)
+	require.Equal(uint8(types.DynamicFeeTxType), tx.Type())
+	require.Equal(big.NewInt(1337), tx.ChainId())
+	require.Equal(uint64(21000), tx.Gas())
+	require.NoError(sim.SendTransaction(ctx, tx))
+
+	_, err = NewTxBuilder(from).To(to).GasPrice(gasPrice).Sign(keys, "")
+	require.EqualError(err, "transaction nonce is not set")
+	b = NewTxBuilder(from).To(to).GasPrice(gasPrice)
+	require.NoError(b.Fill(ctx, sim))
+	tx, err = b.Sign(keys, "")
+	require.NoError(err)
+	require.Equal(uint8(types.LegacyTxType), tx.Type())
+	require.Equal(uint64(1), tx.Nonce())
+	require.True(tx.Protected())
+	require.NoError(sim.SendTransaction(ctx, tx))
+	raw, err := RawTx(tx)
+	require.NoError(err)
+	decoded, err := DecodeRawTx(raw)
+	require.NoError(err)
+	require.Equal("legacy", decoded.Type)
+	require.Equal(from, decoded.From)
+	require.Equal(&to, decoded.To)
+	require.Equal(big.NewInt(1337), decoded.ChainID.ToInt())
+	require.Equal(gasPrice, decoded.GasPrice.ToInt())
+	require.Nil(decoded.GasFeeCap)
+
+	accessList := types.AccessList{{Address: to, StorageKeys: []common.Hash{{}}}}
+	b = NewTxBuilder(from).To(to).GasPrice(gasPrice).AccessList(accessList)
+	require.NoError(b.Fill(ctx, sim))
+	tx, err = b.Sign(keys, "")
+	require.NoError(err)
+	require.Equal(uint8(types.AccessListTxType), tx.Type())
+	require.NoError(sim.SendTransaction(ctx, tx))
+	raw, err = RawTx(tx)
+	require.NoError(err)
+	decoded, err = DecodeRawTx(raw)
+	require.NoError(err)
+	require.Equal("access list", decoded.Type)
+	require.Equal(accessList, decoded.AccessList)
+	sim.Commit()
+	nonce, err := sim.NonceAt(ctx, from, nil)
+	require.NoError(err)
+	require.Equal(uint64(3), nonce)
+
+	// the offline transaction without the chain ID is not replay protected
+	tx, err = NewTxBuilder(from).To(to).Nonce(0).Gas(21000).GasPrice(big.NewInt(1)).Sign(keys, "")
+	require.NoError(err)
+	require.False(tx.Protected())
+	raw, err = RawTx(tx)
+	require.NoError(err)
+	decoded, err = DecodeRawTx(raw)
+	require.NoError(err)
+	require.Equal(from, decoded.From)
+	require.Nil(decoded.ChainID)
+
+	_, err = NewTxBuilder(from).Type(9).Nonce(0).Gas(21000).Build()
+	require.EqualError(err, "unsupported transaction type 9")
+	_, err = NewTxBuilder(from).Nonce(0).Gas(21000).FeeCaps(big.NewInt(1), big.NewInt(2)).Build()
+	require.EqualError(err, "chain ID is required for transaction type 2")
+	_, err = NewTxBuilder(common.HexToAddress("0x02")).Nonce(0).Gas(21000).GasPrice(gasPrice).Sign(keys, "")
+	require.EqualError(err, "no key to sign the transaction of 0x0000000000000000000000000000000000000002")
+}
+
+func TestTxBuilderFill(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	node := rpctest.NewMockNode()
+	node.SetNonce(from, 3)
+	client, closeFn := dial(t, node)
+	defer closeFn()
+	ctx := context.Background()
+	to := common.HexToAddress("0x1000000000000000000000000000000000000001")
+
+	// the blocks have no base fee, so the legacy transaction is built by default
+	b := NewTxBuilder(from).To(to)
+	require.NoError(b.Fill(ctx, client))
+	tx, err := b.Sign(keys, "")
+	require.NoError(err)
+	require.Equal(uint8(types.LegacyTxType), tx.Type())
+	require.Equal(big.NewInt(1337), tx.ChainId())
+	require.Equal(uint64(3), tx.Nonce())
+	require.Equal(big.NewInt(1e9), tx.GasPrice())
+	require.Equal(uint64(1000000), tx.Gas())
+	require.NoError(client.SendTransaction(ctx, tx))
+	require.Len(node.Transactions(), 1)
+
+	err = NewTxBuilder(from).To(to).FeeCaps(big.NewInt(1), big.NewInt(2)).Fill(ctx, client)
+	require.EqualError(err, "the chain does not support dynamic fee transactions")
+	node.Fail("eth_gasPrice", &rpctest.RPCError{Code: -32000, Message: "service unavailable"})
+	err = NewTxBuilder(from).To(to).Fill(ctx, client)
+	require.EqualError(err, "failed to suggest gas price: service unavailable")
+	node.Fail("eth_estimateGas", &rpctest.RPCError{Code: 3, Message: "execution reverted"})
+	err = NewTxBuilder(from).To(to).Fill(ctx, client)
+	require.EqualError(err, "failed to estimate gas needed: execution reverted")
+}
+
+func TestTxBuilderBlob(t *testing.T) {
+	require := require.New(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	keys := NewKeyCache()
+	keys.SetPrivateKey(from, key)
+	to := common.HexToAddress("0x01")
+	var blob kzg4844.Blob
+	copy(blob[:], "blob data")
+
+	b := NewTxBuilder(from).Nonce(0).Gas(21000).ChainID(big.NewInt(1)).
+		FeeCaps(big.NewInt(1e9), big.NewInt(2e9)).BlobFeeCap(big.NewInt(3)).Blobs(blob)
+	_, err = b.Build()
+	require.EqualError(err, "blob transaction must have a recipient")
+	tx, err := b.To(to).Sign(keys, "")
+	require.NoError(err)
+	require.Equal(uint8(types.BlobTxType), tx.Type())
+
+	raw, err := RawTx(tx)
+	require.NoError(err)
+	decoded, err := DecodeRawTx(raw)
+	require.NoError(err)
+	require.Equal("blob", decoded.Type)
+	require.Equal(from, decoded.From)
+	require.Equal(1, decoded.Blobs)
+	require.Equal(big.NewInt(3), decoded.BlobFeeCap.ToInt())
+	commitment, err := kzg4844.BlobToCommitment(&blob)
+	require.NoError(err)
+	hash := kzg4844.CalcBlobHashV1(sha256.New(), &commitment)
+	require.Equal([]common.Hash{hash}, decoded.BlobHashes)
+}
@@ -5,8 +5,12 @@
 package ethfw
 
 import (
+	"container/list"
+	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Uniquify is a type of advanced mutex. It allows to create named resource locks.
@@ -14,61 +18,261 @@ type Uniquify interface {
 	// Call executes only one callable with same id at a time.
 	// Multilpe asynchronous calls with same id will be executed sequentally.
 	Call(id string, callable func() error) error
+	// CallContext is like Call, but stops waiting for its turn once ctx is done,
+	// returning ctx.Err(). Waiters with the same id are served in FIFO order,
+	// the context is passed to the callable as is.
+	CallContext(ctx context.Context, id string, callable func(ctx context.Context) error) error
+	// Do is like CallContext, but the callable also returns a result. In shared mode
+	// the result of a single execution is returned to all callers that joined it.
+	Do(ctx context.Context, id string, callable func(ctx context.Context) (interface{}, error)) (interface{}, error)
+
+	// Stats returns a snapshot of busy ids, sorted by id.
+	Stats() []UniquifyStats
+	// SetHooks sets the callbacks used to report waits and executions, e.g. into metrics.
+	SetHooks(hooks UniquifyHooks)
+}
+
+// UniquifyStats describes the state of a single busy id.
+type UniquifyStats struct {
+	ID string
+	// InFlight is the number of executions holding the id, either 0 or 1.
+	InFlight int
+	// Queued is the number of callers waiting for their turn or for the shared result.
+	Queued int
+	// Held is the time the current execution holds the id.
+	Held time.Duration
+	// MaxWait is the wait time of the longest waiting caller.
+	MaxWait time.Duration
+}
+
+// UniquifyHooks are optional callbacks invoked by Uniquify, any of them may be nil.
+// Hooks are called outside of the internal locks, but must not block.
+type UniquifyHooks struct {
+	// Acquired is called when a caller got its turn to execute, after waiting for it.
+	Acquired func(id string, waited time.Duration)
+	// Released is called when an execution finishes and gives up the id.
+	Released func(id string, held time.Duration, err error)
+	// Shared is called when a caller receives the result of an execution it joined.
+	Shared func(id string, waited time.Duration)
 }
 
 // NewUniquify returns a new thread-safe uniquify object.
 func NewUniquify() Uniquify {
 	return &uniquify{
-		tasks: make(map[string]*sync.WaitGroup),
+		tasks: make(map[string]*uniquifyQueue),
+	}
+}
+
+// NewSharedUniquify returns a new thread-safe uniquify object in shared mode: a call
+// with an id that is already being executed doesn't get queued, but waits for that
+// execution to finish and shares its result, like a singleflight. The execution
+// runs with the context of the caller that started it.
+func NewSharedUniquify() Uniquify {
+	return &uniquify{
+		tasks:  make(map[string]*uniquifyQueue),
+		shared: true,
 	}
 }
 
 type uniquify struct {
-	lock  sync.Mutex
-	tasks map[string]*sync.WaitGroup
+	lock   sync.Mutex
+	tasks  map[string]*uniquifyQueue
+	hooks  UniquifyHooks
+	shared bool
+}
+
+// uniquifyQueue holds the waiters for a single id, the id is considered busy
+// for as long as its queue exists in the tasks map.
+type uniquifyQueue struct {
+	started time.Time
+	waiters list.List
+	// call is the in-flight execution, set in shared mode only.
+	call *uniquifyCall
+}
+
+// uniquifyWaiter gets its ready channel closed when the turn is handed over to it,
+// or when the shared result is available.
+type uniquifyWaiter struct {
+	ready chan struct{}
+	since time.Time
+}
+
+type uniquifyCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
 }
 
 func (u *uniquify) Call(id string, callable func() error) error {
-	var errC = make(chan error)
-	func() {
+	return u.CallContext(context.Background(), id, func(context.Context) error {
+		return callable()
+	})
+}
+
+func (u *uniquify) CallContext(ctx context.Context, id string, callable func(ctx context.Context) error) error {
+	_, err := u.Do(ctx, id, func(ctx context.Context) (interface{}, error) {
+		return nil, callable(ctx)
+	})
+	return err
+}
+
+func (u *uniquify) Do(ctx context.Context, id string,
+	callable func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	since := time.Now()
+	u.lock.Lock()
+	hooks := u.hooks
+	q, busy := u.tasks[id]
+	if !busy {
+		q = &uniquifyQueue{
+			started: since,
+		}
+		if u.shared {
+			q.call = &uniquifyCall{
+				done: make(chan struct{}),
+			}
+		}
+		u.tasks[id] = q
+		u.lock.Unlock()
+	} else if u.shared {
+		return u.join(ctx, id, q, since, hooks)
+	} else if err := u.wait(ctx, id, q, since); err != nil {
+		return nil, err
+	}
+	if hooks.Acquired != nil {
+		hooks.Acquired(id, time.Since(since))
+	}
+	started := time.Now()
+	val, err := callSafe(ctx, callable)
+	u.release(id, q, val, err)
+	if hooks.Released != nil {
+		hooks.Released(id, time.Since(started), err)
+	}
+	return val, err
+}
+
+// wait queues the caller behind the current execution, expects u.lock to be held
+// and releases it.
+func (u *uniquify) wait(ctx context.Context, id string, q *uniquifyQueue, since time.Time) error {
+	w := &uniquifyWaiter{
+		ready: make(chan struct{}),
+		since: since,
+	}
+	elem := q.waiters.PushBack(w)
+	u.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
 		u.lock.Lock()
-		defer u.lock.Unlock()
-		oldWg := u.tasks[id]
-		if oldWg != nil {
-			go func() {
-				oldWg.Wait()
-				go func() {
-					errC <- u.Call(id, callable)
-				}()
-			}()
-			return
+		select {
+		case <-w.ready:
+			// the turn has been handed over concurrently, pass it on
+			u.lock.Unlock()
+			u.release(id, q, nil, nil)
+		default:
+			q.waiters.Remove(elem)
+			u.lock.Unlock()
 		}
-		wg := new(sync.WaitGroup)
-		wg.Add(1)
-		u.tasks[id] = wg
-
-		go func() {
-			var err error
-			defer func() {
-				errC <- err
-
-				u.lock.Lock()
-				defer u.lock.Unlock()
-				delete(u.tasks, id)
-			}()
-			defer wg.Done()
-			defer func(err *error) {
-				if panicData := recover(); panicData != nil {
-					if e, ok := panicData.(error); ok {
-						*err = e
-						return
-					}
-					*err = fmt.Errorf("%+v", panicData)
-				}
-			}(&err)
-			err = callable()
-		}()
-	}()
+		return ctx.Err()
+	}
+}
+
+// join waits for the result of the in-flight shared execution, expects u.lock to be held
+// and releases it.
+func (u *uniquify) join(ctx context.Context, id string, q *uniquifyQueue,
+	since time.Time, hooks UniquifyHooks) (interface{}, error) {
+
+	call := q.call
+	elem := q.waiters.PushBack(&uniquifyWaiter{
+		ready: call.done,
+		since: since,
+	})
+	u.lock.Unlock()
 
-	return <-errC
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		u.lock.Lock()
+		select {
+		case <-call.done:
+			u.lock.Unlock()
+		default:
+			q.waiters.Remove(elem)
+			u.lock.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	if hooks.Shared != nil {
+		hooks.Shared(id, time.Since(since))
+	}
+	return call.val, call.err
+}
+
+func (u *uniquify) release(id string, q *uniquifyQueue, val interface{}, err error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if q.call != nil {
+		q.call.val = val
+		q.call.err = err
+		close(q.call.done)
+		delete(u.tasks, id)
+		return
+	}
+	if q.waiters.Len() == 0 {
+		delete(u.tasks, id)
+		return
+	}
+	w := q.waiters.Remove(q.waiters.Front()).(*uniquifyWaiter)
+	q.started = time.Now()
+	close(w.ready)
+}
+
+func (u *uniquify) Stats() []UniquifyStats {
+	now := time.Now()
+	u.lock.Lock()
+	stats := make([]UniquifyStats, 0, len(u.tasks))
+	for id, q := range u.tasks {
+		s := UniquifyStats{
+			ID:       id,
+			InFlight: 1,
+			Queued:   q.waiters.Len(),
+			Held:     now.Sub(q.started),
+		}
+		if front := q.waiters.Front(); front != nil {
+			s.MaxWait = now.Sub(front.Value.(*uniquifyWaiter).since)
+		}
+		stats = append(stats, s)
+	}
+	u.lock.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+func (u *uniquify) SetHooks(hooks UniquifyHooks) {
+	u.lock.Lock()
+	u.hooks = hooks
+	u.lock.Unlock()
+}
+
+// callSafe invokes the callable, converting a panic into an error.
+func callSafe(ctx context.Context,
+	callable func(ctx context.Context) (interface{}, error)) (val interface{}, err error) {
+
+	defer func() {
+		if panicData := recover(); panicData != nil {
+			if e, ok := panicData.(error); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("%+v", panicData)
+		}
+	}()
+	return callable(ctx)
 }
@@ -0,0 +1,153 @@
+// Copyright 2017-2019 Tensigma Ltd. All rights reserved.
+// Use of this source code is governed by Microsoft Reference Source
+// License (MS-RSL) that can be found in the LICENSE file.
+
+package ethfw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniquifyFIFO(t *testing.T) {
+	require := require.New(t)
+	u := NewUniquify()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go u.Call("id", func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	var (
+		order []int
+		mux   sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u.Call("id", func() error {
+				mux.Lock()
+				order = append(order, i)
+				mux.Unlock()
+				return nil
+			})
+		}(i)
+		waitQueued(t, u, "id", i+1)
+	}
+	close(release)
+	wg.Wait()
+	require.Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, order)
+}
+
+func TestUniquifyCallContext(t *testing.T) {
+	require := require.New(t)
+	u := NewUniquify()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go u.Call("id", func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := u.CallContext(ctx, "id", func(context.Context) error {
+		return errors.New("must not be called")
+	})
+	require.Equal(context.DeadlineExceeded, err)
+	close(release)
+
+	// the timed out waiter must not hold the id
+	err = u.CallContext(context.Background(), "id", func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	require.NoError(err)
+
+	err = u.Call("other", func() error {
+		panic("boom")
+	})
+	require.EqualError(err, "boom")
+}
+
+func TestUniquifyShared(t *testing.T) {
+	require := require.New(t)
+	u := NewSharedUniquify()
+
+	var (
+		calls int32
+		acq   int32
+		share int32
+	)
+	u.SetHooks(UniquifyHooks{
+		Acquired: func(string, time.Duration) { atomic.AddInt32(&acq, 1) },
+		Shared:   func(string, time.Duration) { atomic.AddInt32(&share, 1) },
+	})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "result", nil
+	}
+	var wg sync.WaitGroup
+	results := make([]interface{}, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = u.Do(context.Background(), "id", fn)
+	}()
+	<-started
+	for i := 1; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = u.Do(context.Background(), "id", fn)
+		}(i)
+	}
+	waitQueued(t, u, "id", 4)
+
+	stats := u.Stats()
+	require.Len(stats, 1)
+	require.Equal("id", stats[0].ID)
+	require.Equal(1, stats[0].InFlight)
+	require.Equal(4, stats[0].Queued)
+
+	close(release)
+	wg.Wait()
+	require.EqualValues(1, calls)
+	require.EqualValues(1, acq)
+	require.EqualValues(4, share)
+	for _, r := range results {
+		require.Equal("result", r)
+	}
+	require.Empty(u.Stats())
+}
+
+func waitQueued(t *testing.T, u Uniquify, id string, n int) {
+	for i := 0; i < 1000; i++ {
+		for _, s := range u.Stats() {
+			if s.ID == id && s.Queued == n {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d waiters for %s", n, id)
+}